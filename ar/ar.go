@@ -0,0 +1,209 @@
+package ar
+
+import (
+	"bytes"
+	"calgo/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/*
+静态库，采用GNU ar格式:
+!<arch>\n
+成员头(60字节) 成员内容 [\n填充到偶数偏移]
+...
+第一个成员"/"是符号索引: 符号数(大端4字节)、每个符号所在成员头的文件偏移(大端4字节)、以0结尾的符号名
+成员名超过15个字节时存放在成员"//"中，成员头里记为"/偏移"
+*/
+type Archive struct {
+	Members []*Member
+	Symbols []*Symbol //符号索引
+}
+
+type Member struct {
+	Name string
+	Data []byte
+}
+
+// 符号索引项
+type Symbol struct {
+	Name   string
+	Member int //定义符号的成员在Members中的下标
+}
+
+const Magic = "!<arch>\n"
+
+const hdrSize = 60
+
+func IsArchive(b []byte) bool {
+	return bytes.HasPrefix(b, []byte(Magic))
+}
+
+// 用可重定位文件创建静态库，符号索引包含每个成员定义的全局符号
+func Create(members []*Member) (*Archive, error) {
+	a := &Archive{Members: members}
+	for i, m := range members {
+		obj, err := elf.Read(m.Data)
+		if err != nil {
+			return nil, fmt.Errorf("ar: %s: %v", m.Name, err)
+		}
+		if obj.Type != elf.ET_REL {
+			return nil, fmt.Errorf("ar: %s不是可重定位文件", m.Name)
+		}
+		for _, s := range obj.Symbols {
+			if s.Bind != elf.STB_LOCAL && s.Defined() {
+				a.Symbols = append(a.Symbols, &Symbol{s.Name, i})
+			}
+		}
+	}
+	return a, nil
+}
+
+func Read(b []byte) (*Archive, error) {
+	if !IsArchive(b) {
+		return nil, errors.New("ar: 不是静态库")
+	}
+	a := &Archive{}
+	var index []byte
+	var longnames []byte
+	offidx := map[int]int{} //成员头偏移 -> 成员下标
+	off := len(Magic)
+	for off < len(b) {
+		if off+hdrSize > len(b) {
+			return nil, fmt.Errorf("ar: 偏移%d处的成员头不完整", off)
+		}
+		hdr := b[off : off+hdrSize]
+		if string(hdr[58:60]) != "`\n" {
+			return nil, fmt.Errorf("ar: 偏移%d处的成员头错误", off)
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(string(hdr[48:58])))
+		if err != nil || size < 0 || off+hdrSize+size > len(b) {
+			return nil, fmt.Errorf("ar: 偏移%d处的成员大小错误", off)
+		}
+		data := b[off+hdrSize : off+hdrSize+size]
+		name := strings.TrimRight(string(hdr[0:16]), " ")
+		switch {
+		case name == "/":
+			index = data
+		case name == "//":
+			longnames = data
+		case strings.HasPrefix(name, "/"):
+			n, err := strconv.Atoi(name[1:])
+			if err != nil || n >= len(longnames) {
+				return nil, fmt.Errorf("ar: 成员名%s错误", name)
+			}
+			end := bytes.Index(longnames[n:], []byte("/\n"))
+			if end < 0 {
+				return nil, fmt.Errorf("ar: 成员名%s错误", name)
+			}
+			name = string(longnames[n : n+end])
+			fallthrough
+		default:
+			offidx[off] = len(a.Members)
+			a.Members = append(a.Members, &Member{strings.TrimSuffix(name, "/"), data})
+		}
+		off += hdrSize + size + size%2
+	}
+	//符号索引
+	if index != nil {
+		if len(index) < 4 {
+			return nil, errors.New("ar: 符号索引不完整")
+		}
+		n := int(binary.BigEndian.Uint32(index))
+		if 4+4*n > len(index) {
+			return nil, errors.New("ar: 符号索引不完整")
+		}
+		names := index[4+4*n:]
+		for i := 0; i < n; i++ {
+			moff := int(binary.BigEndian.Uint32(index[4+4*i:]))
+			mi, ok := offidx[moff]
+			if !ok {
+				return nil, fmt.Errorf("ar: 符号索引中的成员偏移%d错误", moff)
+			}
+			end := bytes.IndexByte(names, 0)
+			if end < 0 {
+				return nil, errors.New("ar: 符号索引中的符号名没有以0结尾")
+			}
+			a.Symbols = append(a.Symbols, &Symbol{string(names[:end]), mi})
+			names = names[end+1:]
+		}
+	}
+	return a, nil
+}
+
+func (a *Archive) Write(w io.Writer) error {
+	//长成员名
+	longnames := []byte{}
+	names := []string{}
+	for _, m := range a.Members {
+		if strings.ContainsAny(m.Name, "/\n") || m.Name == "" {
+			return fmt.Errorf("ar: 成员名%q错误", m.Name)
+		}
+		if len(m.Name) < 16 {
+			names = append(names, m.Name+"/")
+		} else {
+			names = append(names, "/"+strconv.Itoa(len(longnames)))
+			longnames = append(append(longnames, m.Name...), "/\n"...)
+		}
+	}
+	//符号索引
+	index := &bytes.Buffer{}
+	binary.Write(index, binary.BigEndian, uint32(len(a.Symbols)))
+	symnames := []byte{}
+	for _, s := range a.Symbols {
+		symnames = append(append(symnames, s.Name...), 0)
+	}
+	//计算成员头的偏移
+	off := len(Magic) + hdrSize + even(4+4*len(a.Symbols)+len(symnames))
+	if len(longnames) != 0 {
+		off += hdrSize + even(len(longnames))
+	}
+	offs := []int{}
+	for _, m := range a.Members {
+		offs = append(offs, off)
+		off += hdrSize + even(len(m.Data))
+	}
+	for _, s := range a.Symbols {
+		binary.Write(index, binary.BigEndian, uint32(offs[s.Member]))
+	}
+	index.Write(symnames)
+
+	buf := &bytes.Buffer{}
+	buf.WriteString(Magic)
+	writeMember(buf, "/", index.Bytes())
+	if len(longnames) != 0 {
+		writeMember(buf, "//", longnames)
+	}
+	for i, m := range a.Members {
+		writeMember(buf, names[i], m.Data)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// 查找定义符号name的成员
+func (a *Archive) Lookup(name string) int {
+	for _, s := range a.Symbols {
+		if s.Name == name {
+			return s.Member
+		}
+	}
+	return -1
+}
+
+// 成员头: 名字、时间、用户、组、权限、大小、结束符。时间等字段写0，保证输出稳定
+func writeMember(buf *bytes.Buffer, name string, data []byte) {
+	fmt.Fprintf(buf, "%-16s%-12d%-6d%-6d%-8o%-10d`\n", name, 0, 0, 0, 0644, len(data))
+	buf.Write(data)
+	if len(data)%2 != 0 {
+		buf.WriteByte('\n')
+	}
+}
+
+func even(n int) int {
+	return n + n%2
+}
@@ -0,0 +1,281 @@
+package asm
+
+import (
+	"calgo/elf"
+	"strings"
+)
+
+/*
+表达式的值。
+Lb为nil时表达式是绝对值(常量)，否则表达式相对符号Lb，值为: Lb的地址 + Val。
+相对符号的值在写入目标文件时需要重定位，写入的是Val(重定位的加数)。
+HasSym表示表达式中引用了标签，两遍扫描中它的值可能不同，不能据此选择指令长度。
+RelType是符号后缀(@GOT、@GOTOFF、@PLT)或_GLOBAL_OFFSET_TABLE_指定的重定位类型，0表示由指令决定。
+*/
+type ExprVal struct {
+	Val     int
+	Lb      *Lb_Record
+	HasSym  bool
+	RelType int
+}
+
+func (v *ExprVal) IsAbs() bool {
+	return v.Lb == nil
+}
+
+// 符号相对段的偏移量，只对段内已定义的符号有意义
+func (v *ExprVal) offset() int {
+	return v.Lb.Addr + v.Val
+}
+
+/*
+expr -> <andexpr> <ortail>
+ortail -> | <andexpr> <ortail> | ^
+*/
+func (p *Parser) expr() *ExprVal {
+	return p.ortail(p.andexpr())
+}
+
+func (p *Parser) ortail(lval *ExprVal) *ExprVal {
+	if p.match(OR) {
+		return p.ortail(p.absOp(OR, lval, p.andexpr()))
+	}
+	return lval
+}
+
+/*
+andexpr -> <shexpr> <andtail>
+andtail -> & <shexpr> <andtail> | ^
+*/
+func (p *Parser) andexpr() *ExprVal {
+	return p.andtail(p.shexpr())
+}
+
+func (p *Parser) andtail(lval *ExprVal) *ExprVal {
+	if p.match(AND) {
+		return p.andtail(p.absOp(AND, lval, p.shexpr()))
+	}
+	return lval
+}
+
+/*
+shexpr -> <addexpr> <shtail>
+shtail -> << <addexpr> <shtail> | >> <addexpr> <shtail> | ^
+*/
+func (p *Parser) shexpr() *ExprVal {
+	return p.shtail(p.addexpr())
+}
+
+func (p *Parser) shtail(lval *ExprVal) *ExprVal {
+	op := p.tk.TokenTyp()
+	if p.match(SHL) || p.match(SHR) {
+		return p.shtail(p.absOp(op, lval, p.addexpr()))
+	}
+	return lval
+}
+
+/*
+addexpr -> <mulexpr> <addtail>
+addtail -> + <mulexpr> <addtail> | - <mulexpr> <addtail> | ^
+*/
+func (p *Parser) addexpr() *ExprVal {
+	return p.addtail(p.mulexpr())
+}
+
+func (p *Parser) addtail(lval *ExprVal) *ExprVal {
+	if p.match(ADD) {
+		return p.addtail(p.add(lval, p.mulexpr()))
+	} else if p.match(SUB) {
+		return p.addtail(p.sub(lval, p.mulexpr()))
+	}
+	return lval
+}
+
+/*
+mulexpr -> <unary> <multail>
+multail -> * <unary> <multail> | / <unary> <multail> | ^
+*/
+func (p *Parser) mulexpr() *ExprVal {
+	return p.multail(p.unary())
+}
+
+func (p *Parser) multail(lval *ExprVal) *ExprVal {
+	op := p.tk.TokenTyp()
+	if p.match(MUL) || p.match(DIV) {
+		return p.multail(p.absOp(op, lval, p.unary()))
+	}
+	return lval
+}
+
+// unary -> - <unary> | + <unary> | ~ <unary> | <primary>
+func (p *Parser) unary() *ExprVal {
+	if p.match(SUB) {
+		return p.sub(&ExprVal{}, p.unary())
+	} else if p.match(ADD) {
+		return p.unary()
+	} else if p.match(NOT) {
+		v := p.unary()
+		p.checkAbs(v, "~")
+		return &ExprVal{Val: ^v.Val, HasSym: v.HasSym}
+	}
+	return p.primary()
+}
+
+/*
+primary -> NUM | ID | $ | $$ | ( <expr> )
+$表示当前地址，$$表示当前段的起始地址，二者都相对于当前段。
+ID可以带@GOT、@GOTOFF、@PLT后缀，分别表示符号的GOT项、符号相对GOT的偏移和符号的PLT项。
+ID也可以是关键字，表达式里不会出现指令和伪指令。
+作为指令的操作数时寄存器名是寄存器，和寄存器同名的符号要写成$dx
+*/
+func (p *Parser) primary() *ExprVal {
+	if sym, ok := p.symName(); ok {
+		name, reltype := relSuffix(sym)
+		lb := Symtab.GetLb(name)
+		p.move()
+		if lb.IsEqu {
+			if reltype != 0 {
+				p.Error("primary err: 常量" + name + "不能带重定位后缀")
+			}
+			return &ExprVal{Val: lb.Addr, HasSym: true}
+		}
+		if name == GotSym { //GOT总是由链接器定义
+			lb.Global = true
+			reltype = elf.R_386_GOTPC
+		}
+		return &ExprVal{Lb: lb, HasSym: true, RelType: reltype}
+	}
+	switch p.tk.TokenTyp() {
+	case NUM:
+		v := &ExprVal{Val: int(p.tk.(*TNUM).Value)}
+		p.move()
+		return v
+	case DOLLAR:
+		p.move()
+		return &ExprVal{Val: CurAddr, Lb: Symtab.GetSecLb(CurSeg), HasSym: true}
+	case DDOLLAR:
+		p.move()
+		return &ExprVal{Lb: Symtab.GetSecLb(CurSeg), HasSym: true}
+	case LPAREN:
+		p.move()
+		v := p.expr()
+		if !p.match(RPAREN) {
+			p.Error("primary err:缺少)")
+		}
+		return v
+	}
+	p.Error("primary err: 表达式只能由数值、标识符、$、$$和括号组成")
+	return nil
+}
+
+// GOT的起始地址，对它的引用是相对当前位置的(R_386_GOTPC)
+const GotSym = "_GLOBAL_OFFSET_TABLE_"
+
+// 去掉符号名的重定位后缀，返回符号名和后缀对应的重定位类型
+func relSuffix(name string) (string, int) {
+	i := strings.LastIndexByte(name, '@')
+	if i <= 0 {
+		return name, 0
+	}
+	switch name[i:] {
+	case "@GOT":
+		return name[:i], elf.R_386_GOT32
+	case "@GOTOFF":
+		return name[:i], elf.R_386_GOTOFF
+	case "@PLT":
+		return name[:i], elf.R_386_PLT32
+	}
+	return name, 0
+}
+
+func (p *Parser) MatchExprFirst() bool {
+	switch p.tk.TokenTyp() {
+	case NUM, ID, DOLLAR, DDOLLAR, LPAREN, ADD, SUB, NOT:
+		return true
+	}
+	_, ok := p.tk.(*TKWORD)
+	return ok && !p.MatchRegFirst() && p.tk.TokenTyp() != KW_DWORD && p.tk.TokenTyp() != KW_QWORD
+}
+
+// 相对值 + 绝对值 = 相对值，两个相对值不能相加
+func (p *Parser) add(l, r *ExprVal) *ExprVal {
+	if !l.IsAbs() && !r.IsAbs() {
+		p.relError("两个符号不能相加")
+		return &ExprVal{HasSym: true}
+	}
+	v := &ExprVal{Val: l.Val + r.Val, Lb: l.Lb, HasSym: l.HasSym || r.HasSym, RelType: l.RelType}
+	if v.Lb == nil {
+		v.Lb = r.Lb
+		v.RelType = r.RelType
+	}
+	return v
+}
+
+// 相对值 - 绝对值 = 相对值；同一段内两个已定义符号之差是绝对值
+func (p *Parser) sub(l, r *ExprVal) *ExprVal {
+	hassym := l.HasSym || r.HasSym
+	if r.IsAbs() {
+		return &ExprVal{Val: l.Val - r.Val, Lb: l.Lb, HasSym: hassym, RelType: l.RelType}
+	}
+	if l.IsAbs() {
+		p.relError("不能用常量减去符号")
+		return &ExprVal{HasSym: hassym}
+	}
+	if l.Lb.Externed || r.Lb.Externed || l.Lb.SegName != r.Lb.SegName || l.RelType != 0 || r.RelType != 0 {
+		p.relError("只能对同一段内已定义的符号求差")
+		return &ExprVal{HasSym: hassym}
+	}
+	return &ExprVal{Val: l.offset() - r.offset(), HasSym: hassym}
+}
+
+// 运算符的写法，用于错误信息
+var opNames = map[TokenType]string{MUL: "*", DIV: "/", SHL: "<<", SHR: ">>", AND: "&", OR: "|"}
+
+// 只能作用于绝对值的运算
+func (p *Parser) absOp(op TokenType, l, r *ExprVal) *ExprVal {
+	p.checkAbs(l, opNames[op])
+	p.checkAbs(r, opNames[op])
+	v := &ExprVal{HasSym: l.HasSym || r.HasSym}
+	switch op {
+	case MUL:
+		v.Val = l.Val * r.Val
+	case DIV:
+		if r.Val == 0 {
+			if ScanNum > 1 || !r.HasSym {
+				p.Error("expr err: 除数为0")
+			}
+			return v
+		}
+		v.Val = l.Val / r.Val
+	case SHL:
+		v.Val = l.Val << uint(r.Val)
+	case SHR:
+		v.Val = l.Val >> uint(r.Val)
+	case AND:
+		v.Val = l.Val & r.Val
+	case OR:
+		v.Val = l.Val | r.Val
+	}
+	return v
+}
+
+func (p *Parser) checkAbs(v *ExprVal, op string) {
+	if !v.IsAbs() {
+		p.relError("运算" + op + "只能作用于常量")
+	}
+}
+
+// 第一遍扫描时前向引用的标签尚未定义，只在第二遍扫描时报告符号运算错误
+func (p *Parser) relError(info string) {
+	if ScanNum > 1 {
+		p.Error("expr err: " + info)
+	}
+}
+
+// 第二遍扫描时，相对符号的值需要重定位
+func (p *Parser) setRel(v *ExprVal) {
+	if ScanNum == 2 && !v.IsAbs() {
+		RelLb = v.Lb
+		RelType = v.RelType
+	}
+}
@@ -0,0 +1,262 @@
+package asm
+
+import (
+	delf "debug/elf"
+	"encoding/binary"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/*
+汇编器的状态是全局的，一个进程只能汇编一个文件。
+CALGO_ASM=1时测试程序把参数中的汇编文件汇编成可重定位文件
+*/
+func TestMain(m *testing.M) {
+	if os.Getenv("CALGO_ASM") == "1" {
+		NewParser(os.Args[1]).Parse()
+		Symtab.ExportSyms()
+		f, err := os.Create(os.Args[2])
+		if err != nil {
+			panic(err)
+		}
+		EXEFILE = f
+		ELFOBJ.WriteElf()
+		f.Close()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// 在子进程中汇编src，返回输出。出错时err不为nil
+func assembleSrc(t *testing.T, src string) (*delf.File, string, error) {
+	t.Helper()
+	dir := t.TempDir()
+	sfile := filepath.Join(dir, "a.s")
+	if err := os.WriteFile(sfile, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ofile := filepath.Join(dir, "a.o")
+	cmd := exec.Command(os.Args[0], sfile, ofile)
+	cmd.Env = append(os.Environ(), "CALGO_ASM=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, string(out), err
+	}
+	obj, err := delf.Open(ofile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { obj.Close() })
+	return obj, string(out), nil
+}
+
+// 重定位项，Sym是符号名，段符号用段名表示
+type rel struct {
+	Off  uint32
+	Type uint32
+	Sym  string
+}
+
+func rels(t *testing.T, obj *delf.File, sec string) []rel {
+	t.Helper()
+	s := obj.Section(sec)
+	if s == nil {
+		return nil
+	}
+	data, err := s.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	syms, err := obj.Symbols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := []rel{}
+	for i := 0; i+8 <= len(data); i += 8 {
+		info := binary.LittleEndian.Uint32(data[i+4:])
+		r := rel{Off: binary.LittleEndian.Uint32(data[i:]), Type: info & 0xff}
+		if idx := int(info >> 8); idx > 0 && idx <= len(syms) {
+			sym := syms[idx-1]
+			r.Sym = sym.Name
+			if delf.ST_TYPE(sym.Info) == delf.STT_SECTION && int(sym.Section) < len(obj.Sections) {
+				r.Sym = obj.Sections[sym.Section].Name
+			}
+		}
+		res = append(res, r)
+	}
+	return res
+}
+
+// 段sec中从off开始的n个双字
+func dwords(t *testing.T, obj *delf.File, sec string, off, n int) []int32 {
+	t.Helper()
+	data, err := obj.Section(sec).Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) < off+4*n {
+		t.Fatalf("%s只有%d字节", sec, len(data))
+	}
+	res := make([]int32, n)
+	for i := range res {
+		res[i] = int32(binary.LittleEndian.Uint32(data[off+4*i:]))
+	}
+	return res
+}
+
+func TestExprValues(t *testing.T) {
+	tests := []struct {
+		expr string
+		want int32
+	}{
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"10 - 2 - 3", 5},
+		{"100 / 10 / 5", 2},
+		{"7 / 2", 3},
+		{"1 + 2 << 3", 24}, //移位的优先级低于加减
+		{"1 << 2 + 1", 8},
+		{"12 & 10 | 1", 9}, //|的优先级低于&
+		{"1 | 12 & 10", 9},
+		{"6 & 3 << 1", 6},
+		{"-5", -5},
+		{"--5", 5},
+		{"+4", 4},
+		{"~0", -1},
+		{"~1 + 1", -1},
+		{"2 * -3", -6},
+		{"-8 >> 1", -4},
+		{"N * 2 + 1", 25},
+		{"M", -13},
+	}
+	src := &strings.Builder{}
+	src.WriteString("N equ 3 * 4\nM equ ~N\nsection .data\n")
+	for i, tt := range tests {
+		src.WriteString("v" + string(rune('a'+i)) + " dd " + tt.expr + "\n")
+	}
+	obj, out, err := assembleSrc(t, src.String())
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	got := dwords(t, obj, ".data", 0, len(tests))
+	for i, tt := range tests {
+		if got[i] != tt.want {
+			t.Errorf("%s的值是%d，应该是%d", tt.expr, got[i], tt.want)
+		}
+	}
+	if r := rels(t, obj, ".rel.data"); len(r) != 0 {
+		t.Errorf("常量表达式不需要重定位: %v", r)
+	}
+}
+
+// $是当前地址，$$是当前段的起始地址；同一段内的标签之差是常量，包括前向引用的标签
+func TestExprLabels(t *testing.T) {
+	src := `section .text
+f:
+    mov eax, g - f
+    ret
+g:
+    mov eax, $ - $$
+    mov eax, [d + 4]
+    ret
+section .data
+a db 1, 2, 3
+b dd $ - $$, $ - a, b - a
+c dd e - b, (e - a) * 2, -(e - a)
+d dd f + 8, $ + 2, $$
+e dd 0
+`
+	obj, out, err := assembleSrc(t, src)
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	data := dwords(t, obj, ".data", 3, 7)
+	if want := []int32{3, 3, 3, 36, 78, -39}; !equal(data[:6], want) {
+		t.Errorf("标签之差是%v，应该是%v", data[:6], want)
+	}
+	//d: f + 8相对f，$ + 2和$$相对.data，写入的是加数。数据定义中的$是这一行的起始地址
+	d := dwords(t, obj, ".data", 27, 3)
+	if want := []int32{8, 29, 0}; !equal(d, want) {
+		t.Errorf("需要重定位的数据是%v，应该是%v", d, want)
+	}
+	wantRels := []rel{{27, uint32(delf.R_386_32), "f"}, {31, uint32(delf.R_386_32), ".data"}, {35, uint32(delf.R_386_32), ".data"}}
+	if got := rels(t, obj, ".rel.data"); !equalRels(got, wantRels) {
+		t.Errorf(".rel.data是%v，应该是%v", got, wantRels)
+	}
+	//mov eax, 6; ret; mov eax, 6; mov eax, [d + 4]; ret
+	text, err := obj.Section(".text").Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0xb8, 6, 0, 0, 0, 0xc3, 0xb8, 6, 0, 0, 0, 0x8b, 0x05, 4, 0, 0, 0, 0xc3}
+	if string(text) != string(want) {
+		t.Errorf(".text是% x，应该是% x", text, want)
+	}
+	wantRels = []rel{{13, uint32(delf.R_386_32), "d"}}
+	if got := rels(t, obj, ".rel.text"); !equalRels(got, wantRels) {
+		t.Errorf(".rel.text是%v，应该是%v", got, wantRels)
+	}
+}
+
+// 符号不能参与常量运算，不同段的标签和外部符号不能求差。没有定义的ext是外部符号
+func TestExprErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"x dd x * 2", "运算*只能作用于常量"},
+		{"x dd 2 / x", "运算/只能作用于常量"},
+		{"x dd x << 1", "运算<<只能作用于常量"},
+		{"x dd x >> 1", "运算>>只能作用于常量"},
+		{"x dd x & 1", "运算&只能作用于常量"},
+		{"x dd 1 | x", "运算|只能作用于常量"},
+		{"x dd ~x", "运算~只能作用于常量"},
+		{"x dd 4 - x", "不能用常量减去符号"},
+		{"x dd -x", "不能用常量减去符号"},
+		{"x dd x + x", "两个符号不能相加"},
+		{"x dd y + 1 + x\ny dd 0", "两个符号不能相加"},
+		{"x dd x - f", "只能对同一段内已定义的符号求差"},
+		{"x dd ext - x", "只能对同一段内已定义的符号求差"},
+		{"x dd x - ext", "只能对同一段内已定义的符号求差"},
+		{"x dd 1 / 0", "除数为0"},
+		{"x dd 1 / (x - x)", "除数为0"},
+		{"x dd (1 + 2", "缺少)"},
+		{"x dd 1 + ]", "表达式只能由数值、标识符、$、$$和括号组成"},
+		{"z equ x\nx dd 0", "equ后必须是常量表达式"},
+		{"x dw x", "需要重定位的数据只能用dd定义"},
+	}
+	for _, tt := range tests {
+		src := "section .text\nf:\n    ret\nsection .data\n" + tt.src + "\n"
+		_, out, err := assembleSrc(t, src)
+		if err == nil || !strings.Contains(out, tt.err) {
+			t.Errorf("%q: 输出是%s，应该包含%q", tt.src, out, tt.err)
+		}
+	}
+}
+
+func equal(a, b []int32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalRels(a, b []rel) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
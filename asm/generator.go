@@ -1,9 +1,8 @@
 package asm
 
 import (
+	"calgo/elf"
 	"log"
-	"os"
-	"unsafe"
 )
 
 type OP_TYPE int
@@ -16,24 +15,44 @@ const (
 )
 
 // r,r | r,m | m,r | r,imm32
-var i_2opcode = [7][2][4]int{
+// mov, cmp, sub, add, and, or, xor, adc, sbb, lea
+// 16位的操作数使用32位的操作码，前面加上操作数大小前缀0x66
+var i_2opcode = [10][2][4]int{
 	{{0x8a, 0x8a, 0x88, 0xb0}, {0x8b, 0x8b, 0x89, 0xb8}},
 	{{0x3a, 0x3a, 0x38, 0x80}, {0x3b, 0x3b, 0x39, 0x81}},
 	{{0x2a, 0x2a, 0x28, 0x80}, {0x2b, 0x2b, 0x29, 0x81}},
 	{{0x02, 0x02, 0x00, 0x80}, {0x03, 0x03, 0x01, 0x81}},
 	{{0x22, 0x22, 0x20, 0x80}, {0x23, 0x23, 0x21, 0x81}},
 	{{0x0a, 0x0a, 0x08, 0x80}, {0x0b, 0x0b, 0x09, 0x81}},
+	{{0x32, 0x32, 0x30, 0x80}, {0x33, 0x33, 0x31, 0x81}},
+	{{0x12, 0x12, 0x10, 0x80}, {0x13, 0x13, 0x11, 0x81}},
+	{{0x1a, 0x1a, 0x18, 0x80}, {0x1b, 0x1b, 0x19, 0x81}},
 	{{0x00, 0x00, 0x00, 0x00}, {0x8d, 0x8d, 0x00, 0x00}},
 }
 
 func Gen2Op(tktyp TokenType, des_t OP_TYPE, src_t OP_TYPE, l int) {
+	if tktyp >= I_SHL && tktyp <= I_RCR {
+		GenShift(tktyp, des_t, src_t)
+		return
+	}
+	if tktyp == I_MOVZX || tktyp == I_MOVSX {
+		GenMovx(tktyp, des_t, src_t, l)
+		return
+	}
+	if tktyp >= I_MOVSS && tktyp <= I_XORPS {
+		GenSSE(tktyp, des_t, src_t)
+		return
+	}
+	if l == 2 {
+		WriteBytes(0x66, 1)
+	}
 	opcode := GetOpCode(tktyp, des_t, src_t, l)
 	switch MODRM.Mod {
 	case -1:
 		if tktyp == I_MOV {
 			opcode += MODRM.Reg
 		} else {
-			regcodes := []int{7, 5, 0, 4, 1}
+			regcodes := []int{7, 5, 0, 4, 1, 6, 2, 3}
 			MODRM.Mod = 3
 			MODRM.RM = MODRM.Reg //TODO:？？？
 			MODRM.Reg = regcodes[tktyp-I_CMP]
@@ -42,13 +61,13 @@ func Gen2Op(tktyp TokenType, des_t OP_TYPE, src_t OP_TYPE, l int) {
 		if tktyp != I_MOV {
 			WriteModRM()
 		}
-		ProcessRel(R_386_32) //TODO:???
+		ProcessRel(elf.R_386_32) //TODO:???
 		WriteBytes(Instr.Imm32, l)
 	case 0:
 		WriteBytes(opcode, 1)
 		WriteModRM()
 		if MODRM.RM == 5 {
-			ProcessRel(R_386_32)
+			ProcessRel(elf.R_386_32)
 			Instr.WriteDisp()
 		} else if MODRM.RM == 4 {
 			WriteSIB()
@@ -66,6 +85,7 @@ func Gen2Op(tktyp TokenType, des_t OP_TYPE, src_t OP_TYPE, l int) {
 		if MODRM.RM == 4 {
 			WriteSIB()
 		}
+		ProcessRel(elf.R_386_32)
 		Instr.WriteDisp()
 	case 3:
 		WriteBytes(opcode, 1)
@@ -73,31 +93,143 @@ func Gen2Op(tktyp TokenType, des_t OP_TYPE, src_t OP_TYPE, l int) {
 	}
 }
 
+/*
+移位只支持32位寄存器: shl/sar/shr/rcr r32, cl 和 shl/sar/shr/rcr r32, imm8。
+第一个操作数的寄存器编码在MODRM.Reg中，移位的种类由ModRM的reg字段区分(shl为4，sar为7，shr为5，rcr为3)
+*/
+func GenShift(tktyp TokenType, des_t OP_TYPE, src_t OP_TYPE) {
+	if des_t != REGISTER || src_t == MEMORY || src_t == REGISTER && MODRM.RM != 1 {
+		log.Fatal("GenShift err, 移位指令只支持: shl/sar/shr/rcr r32, cl 和 shl/sar/shr/rcr r32, imm8")
+	}
+	opcode := 0xd3
+	if src_t == IMMEDIATE {
+		opcode = 0xc1
+	}
+	regcodes := []int{4, 7, 5, 3}
+	MODRM.Mod = 3
+	MODRM.RM = MODRM.Reg
+	MODRM.Reg = regcodes[tktyp-I_SHL]
+	WriteBytes(opcode, 1)
+	WriteModRM()
+	if src_t == IMMEDIATE {
+		WriteBytes(Instr.Imm32, 1)
+	}
+}
+
+/*
+movzx/movsx r32, r8 和 movzx/movsx r32, r16。
+第一个操作数的寄存器编码在MODRM.Reg中，第二个在MODRM.RM中，l是第二个操作数的长度
+*/
+func GenMovx(tktyp TokenType, des_t OP_TYPE, src_t OP_TYPE, l int) {
+	if des_t != REGISTER || src_t != REGISTER || l == 4 {
+		log.Fatal("GenMovx err, 扩展指令只支持: movzx/movsx r32, r8 和 movzx/movsx r32, r16")
+	}
+	opcode := 0xb6
+	if tktyp == I_MOVSX {
+		opcode = 0xbe
+	}
+	if l == 2 {
+		opcode++
+	}
+	WriteBytes(0x0f, 1)
+	WriteBytes(opcode, 1)
+	WriteModRM()
+}
+
+// SSE2标量浮点指令的前缀和0x0f之后的操作码，前缀为0表示没有前缀
+var i_sseopcode = [...][2]int{
+	{0xf3, 0x10}, {0xf2, 0x10}, //movss, movsd
+	{0xf3, 0x58}, {0xf2, 0x58}, //addss, addsd
+	{0xf3, 0x5c}, {0xf2, 0x5c}, //subss, subsd
+	{0xf3, 0x59}, {0xf2, 0x59}, //mulss, mulsd
+	{0xf3, 0x5e}, {0xf2, 0x5e}, //divss, divsd
+	{0x00, 0x2e}, {0x66, 0x2e}, //ucomiss, ucomisd
+	{0xf3, 0x2a}, {0xf2, 0x2a}, //cvtsi2ss, cvtsi2sd
+	{0xf3, 0x2c}, {0xf2, 0x2c}, //cvttss2si, cvttsd2si
+	{0xf3, 0x5a}, {0xf2, 0x5a}, //cvtss2sd, cvtsd2ss
+	{0x00, 0x57}, //xorps
+}
+
+/*
+xmm, xmm/m 和 r32, xmm/m(cvttss2si, cvttsd2si)，xmm, r32/m(cvtsi2ss, cvtsi2sd)。
+第一个操作数在ModRM的reg字段中。只有movss/movsd可以保存到内存: movss/movsd m, xmm，操作码是0x11
+*/
+func GenSSE(tktyp TokenType, des_t OP_TYPE, src_t OP_TYPE) {
+	store := des_t == MEMORY && src_t == REGISTER && (tktyp == I_MOVSS || tktyp == I_MOVSD)
+	if src_t == IMMEDIATE || des_t != REGISTER && !store {
+		log.Fatal("GenSSE err, 浮点指令的第一个操作数只能是寄存器(movss/movsd m, xmm除外)，第二个操作数不能是立即数")
+	}
+	code := i_sseopcode[tktyp-I_MOVSS]
+	if code[0] != 0 {
+		WriteBytes(code[0], 1)
+	}
+	opcode := code[1]
+	if store {
+		opcode = 0x11
+	}
+	WriteBytes(0x0f, 1)
+	WriteBytes(opcode, 1)
+	WriteRM()
+}
+
+// x87指令的操作码和ModRM的reg字段: [fld, fstp, fild, fisttp, fadd][dword, qword]
+var i_x87opcode = [...][2][2]int{
+	{{0xd9, 0}, {0xdd, 0}},
+	{{0xd9, 3}, {0xdd, 3}},
+	{{0xdb, 0}, {0xdf, 5}},
+	{{0xdb, 1}, {0xdd, 1}},
+	{{0xd8, 0}, {0xdc, 0}},
+}
+
+// x87指令只支持带大小的内存操作数: fld/fstp/fild/fisttp/fadd dword/qword m
+func GenX87(tktyp TokenType, opt OP_TYPE, l int) {
+	if opt != MEMORY || l != 4 && l != 8 {
+		log.Fatal("GenX87 err, x87指令只支持: fld/fstp/fild/fisttp/fadd dword/qword m")
+	}
+	code := i_x87opcode[tktyp-I_FLD][l/8]
+	MODRM.Reg = code[1]
+	WriteBytes(code[0], 1)
+	WriteRM()
+}
+
 var i_1opcode = [...]int{
-	//call,int,imul,idiv,neg,inc,dec,jmp
-	0xe8, 0xcd, 0xf7, 0xf7, 0xf7, 0x40, 0x48, 0xe9,
-	//je, jne
-	0x84, 0x85,
-	//sete, setne, setg, setge, setl, setle
-	0x94, 0x95, 0x9f, 0x9d, 0x9c, 0x9e,
+	//call,int,imul,idiv,mul,div,neg,not,inc,dec,jmp
+	0xe8, 0xcd, 0xf7, 0xf7, 0xf7, 0xf7, 0xf7, 0xf7, 0x40, 0x48, 0xe9,
+	//je, jne, jb
+	0x84, 0x85, 0x82,
+	//sete, setne, setg, setge, setl, setle, seta, setae, setb, setbe, setp, setnp
+	0x94, 0x95, 0x9f, 0x9d, 0x9c, 0x9e, 0x97, 0x93, 0x92, 0x96, 0x9a, 0x9b,
 	//push, pop
 	0x50, 0x58,
 }
 
 func Gen1Op(tktyp TokenType, opt OP_TYPE, l int) {
+	if tktyp >= I_FLD && tktyp <= I_FADD {
+		GenX87(tktyp, opt, l)
+		return
+	}
 	opcode := i_1opcode[tktyp-I_CALL]
-	if tktyp == I_CALL || tktyp >= I_JMP && tktyp <= I_JNE {
+	if tktyp == I_CALL && opt == REGISTER { //间接调用call r32: FF /2
+		MODRM.Mod = 3
+		MODRM.RM = MODRM.Reg
+		MODRM.Reg = 2
+		WriteBytes(0xff, 1)
+		WriteModRM()
+	} else if tktyp == I_CALL || tktyp >= I_JMP && tktyp <= I_JB {
 		if tktyp != I_CALL && tktyp != I_JMP {
 			WriteBytes(0x0f, 1)
 		}
 		WriteBytes(opcode, 1)
 		addr := Instr.Imm32
-		if ProcessRel(R_386_PC32) {
-			addr = CurAddr
+		if RelLb != nil && RelType == 0 && !RelLb.Externed && !RelLb.Weak && RelLb.SegName == CurSeg { //段内跳转不需要重定位，弱符号可能被其他文件的定义覆盖；通过PLT的调用由链接器处理
+			addr += RelLb.Addr
+			RelLb = nil
+		} else if ProcessRel(elf.R_386_PC32) { //写入的是重定位的加数: 目标 - (重定位位置 + 4)
+			addr += CurAddr
 		}
 		pc := CurAddr + 4
 		WriteBytes(addr-pc, 4)
-	} else if tktyp >= I_SETE && tktyp <= I_SETLE {
+	} else if tktyp >= I_SETE && tktyp <= I_SETNP {
 		MODRM.Mod = 3
 		MODRM.RM = MODRM.Reg
 		MODRM.Reg = 0
@@ -115,6 +247,7 @@ func Gen1Op(tktyp TokenType, opt OP_TYPE, l int) {
 		}
 		WriteBytes(opcode, 1)
 		if opt == IMMEDIATE {
+			ProcessRel(elf.R_386_32)
 			WriteBytes(Instr.Imm32, 4)
 		}
 	} else if tktyp == I_INC || tktyp == I_DEC {
@@ -123,7 +256,7 @@ func Gen1Op(tktyp TokenType, opt OP_TYPE, l int) {
 			regcodes := []int{0, 1}
 			MODRM.Mod = 3
 			MODRM.RM = MODRM.Reg
-			MODRM.Reg = regcodes[tktyp-I_INT]
+			MODRM.Reg = regcodes[tktyp-I_INC]
 		} else { //r32
 			opcode += MODRM.Reg
 		}
@@ -131,20 +264,23 @@ func Gen1Op(tktyp TokenType, opt OP_TYPE, l int) {
 		if l == 1 {
 			WriteModRM()
 		}
-	} else if tktyp == I_NEG {
+	} else if tktyp == I_NEG || tktyp == I_NOT {
 		if l == 1 {
 			opcode = 0xf6
 		}
 		MODRM.Mod = 3
 		MODRM.RM = MODRM.Reg
 		MODRM.Reg = 3
+		if tktyp == I_NOT {
+			MODRM.Reg = 2
+		}
 		WriteBytes(opcode, 1)
 		WriteModRM()
 	} else if tktyp == I_POP {
 		opcode += MODRM.Reg
 		WriteBytes(opcode, 1)
-	} else if tktyp == I_IMUL || tktyp == I_IDIV {
-		regcodes := []int{5, 7}
+	} else if tktyp >= I_IMUL && tktyp <= I_DIV {
+		regcodes := []int{5, 7, 4, 6}
 		MODRM.Mod = 3
 		MODRM.RM = MODRM.Reg
 		MODRM.Reg = regcodes[tktyp-I_IMUL]
@@ -153,22 +289,21 @@ func Gen1Op(tktyp TokenType, opt OP_TYPE, l int) {
 	}
 }
 
-var i_0opcode = [...]int{0xc3}
+// ret, cdq
+var i_0opcode = [...]int{0xc3, 0x99}
 
 func Gen0Op(tktyp TokenType) {
 	opcode := i_0opcode[tktyp-I_RET]
 	WriteBytes(opcode, 1)
 }
 
+// 第一遍扫描只计算地址，第二遍扫描时所有标签的地址都已确定，才写入代码
 func WriteBytes(v int, l int) {
 	CurAddr += l
-	if ScanNum == 2 {
+	if ScanNum == 1 {
 		return
 	}
-	p := (*[4]byte)(unsafe.Pointer(&v))
-	b := make([]byte, l)
-	copy(b[0:], (*p)[0:])
-	TmpCodeSeg.Write(b[:])
+	ELFOBJ.GetSection(CurSeg).WriteBytes(v, l)
 }
 
 func WriteModRM() {
@@ -178,6 +313,18 @@ func WriteModRM() {
 	}
 }
 
+// 写入ModRM，操作数是内存时接着写入SIB和偏移
+func WriteRM() {
+	WriteModRM()
+	if MODRM.Mod != 3 && MODRM.RM == 4 {
+		WriteSIB()
+	}
+	if MODRM.Mod == 0 && MODRM.RM == 5 || MODRM.Mod == 2 {
+		ProcessRel(elf.R_386_32)
+	}
+	Instr.WriteDisp()
+}
+
 func WriteSIB() {
 	if SIBP.Scale != -1 {
 		b := (SIBP.Scale << 6) + (SIBP.Index << 3) + SIBP.Base
@@ -213,14 +360,3 @@ func GetOpCode(op TokenType, des_t, src_t OP_TYPE, l int) int {
 	}
 	return i_2opcode[i1][i2][i3]
 }
-
-var TmpCodeSeg *os.File
-
-func init() {
-	var err error
-	TmpCodeSeg, err = os.OpenFile("./out/tmp_code_seg.out", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
-	if err != nil {
-		log.Fatal(err)
-		panic("init err: open elf.out failed")
-	}
-}
@@ -10,55 +10,124 @@ import (
 )
 
 var kwords = map[string]TokenType{
-	"section": KW_SEC,
-	"global":  KW_GLB,
-	"equ":     KW_EQU,
-	"times":   KW_TIMES,
-	"db":      KW_DB,
-	"dw":      KW_DW,
-	"dd":      KW_DD,
-	"al":      BR_AL,
-	"cl":      BR_CL,
-	"dl":      BR_DL,
-	"bl":      BR_BL,
-	"ah":      BR_AH,
-	"ch":      BR_CH,
-	"dh":      BR_DH,
-	"bh":      BR_BH,
-	"eax":     DR_EAX,
-	"ecx":     DR_ECX,
-	"edx":     DR_EDX,
-	"ebx":     DR_EBX,
-	"esp":     DR_ESP,
-	"ebp":     DR_EBP,
-	"esi":     DR_ESI,
-	"edi":     DR_EDI,
-	"mov":     I_MOV,
-	"cmp":     I_CMP,
-	"sub":     I_SUB,
-	"add":     I_ADD,
-	"and":     I_AND,
-	"or":      I_OR,
-	"lea":     I_LEA,
-	"call":    I_CALL,
-	"int":     I_INT,
-	"imul":    I_IMUL,
-	"idiv":    I_IDIV,
-	"neg":     I_NEG,
-	"inc":     I_INC,
-	"dec":     I_DEC,
-	"jmp":     I_JMP,
-	"je":      I_JE,
-	"jne":     I_JNE,
-	"sete":    I_SETE,
-	"setne":   I_SETNE,
-	"setg":    I_SETG,
-	"setge":   I_SETGE,
-	"setl":    I_SETL,
-	"setle":   I_SETLE,
-	"push":    I_PUSH,
-	"pop":     I_POP,
-	"ret":     I_RET,
+	"section":   KW_SEC,
+	"global":    KW_GLB,
+	"weak":      KW_WEAK,
+	"equ":       KW_EQU,
+	"times":     KW_TIMES,
+	"db":        KW_DB,
+	"dw":        KW_DW,
+	"dd":        KW_DD,
+	"dword":     KW_DWORD,
+	"qword":     KW_QWORD,
+	"al":        BR_AL,
+	"cl":        BR_CL,
+	"dl":        BR_DL,
+	"bl":        BR_BL,
+	"ah":        BR_AH,
+	"ch":        BR_CH,
+	"dh":        BR_DH,
+	"bh":        BR_BH,
+	"eax":       DR_EAX,
+	"ecx":       DR_ECX,
+	"edx":       DR_EDX,
+	"ebx":       DR_EBX,
+	"esp":       DR_ESP,
+	"ebp":       DR_EBP,
+	"esi":       DR_ESI,
+	"edi":       DR_EDI,
+	"ax":        WR_AX,
+	"cx":        WR_CX,
+	"dx":        WR_DX,
+	"bx":        WR_BX,
+	"sp":        WR_SP,
+	"bp":        WR_BP,
+	"si":        WR_SI,
+	"di":        WR_DI,
+	"xmm0":      XR_XMM0,
+	"xmm1":      XR_XMM1,
+	"xmm2":      XR_XMM2,
+	"xmm3":      XR_XMM3,
+	"xmm4":      XR_XMM4,
+	"xmm5":      XR_XMM5,
+	"xmm6":      XR_XMM6,
+	"xmm7":      XR_XMM7,
+	"mov":       I_MOV,
+	"cmp":       I_CMP,
+	"sub":       I_SUB,
+	"add":       I_ADD,
+	"and":       I_AND,
+	"or":        I_OR,
+	"xor":       I_XOR,
+	"adc":       I_ADC,
+	"sbb":       I_SBB,
+	"lea":       I_LEA,
+	"shl":       I_SHL,
+	"sar":       I_SAR,
+	"shr":       I_SHR,
+	"rcr":       I_RCR,
+	"movzx":     I_MOVZX,
+	"movsx":     I_MOVSX,
+	"movss":     I_MOVSS,
+	"movsd":     I_MOVSD,
+	"addss":     I_ADDSS,
+	"addsd":     I_ADDSD,
+	"subss":     I_SUBSS,
+	"subsd":     I_SUBSD,
+	"mulss":     I_MULSS,
+	"mulsd":     I_MULSD,
+	"divss":     I_DIVSS,
+	"divsd":     I_DIVSD,
+	"ucomiss":   I_UCOMISS,
+	"ucomisd":   I_UCOMISD,
+	"cvtsi2ss":  I_CVTSI2SS,
+	"cvtsi2sd":  I_CVTSI2SD,
+	"cvttss2si": I_CVTTSS2SI,
+	"cvttsd2si": I_CVTTSD2SI,
+	"cvtss2sd":  I_CVTSS2SD,
+	"cvtsd2ss":  I_CVTSD2SS,
+	"xorps":     I_XORPS,
+	"call":      I_CALL,
+	"int":       I_INT,
+	"imul":      I_IMUL,
+	"idiv":      I_IDIV,
+	"mul":       I_MUL,
+	"div":       I_DIV,
+	"neg":       I_NEG,
+	"not":       I_NOT,
+	"inc":       I_INC,
+	"dec":       I_DEC,
+	"jmp":       I_JMP,
+	"je":        I_JE,
+	"jne":       I_JNE,
+	"jb":        I_JB,
+	"sete":      I_SETE,
+	"setne":     I_SETNE,
+	"setg":      I_SETG,
+	"setge":     I_SETGE,
+	"setl":      I_SETL,
+	"setle":     I_SETLE,
+	"seta":      I_SETA,
+	"setae":     I_SETAE,
+	"setb":      I_SETB,
+	"setbe":     I_SETBE,
+	"setp":      I_SETP,
+	"setnp":     I_SETNP,
+	"push":      I_PUSH,
+	"pop":       I_POP,
+	"fld":       I_FLD,
+	"fstp":      I_FSTP,
+	"fild":      I_FILD,
+	"fisttp":    I_FISTTP,
+	"fadd":      I_FADD,
+	"ret":       I_RET,
+	"cdq":       I_CDQ,
+}
+
+// name是关键字，作为符号名时可能要写成$name
+func IsKeyword(name string) bool {
+	_, ok := kwords[name]
+	return ok
 }
 
 var lexErrorTable = map[string]string{
@@ -108,10 +177,10 @@ func (l *Lexer) NextToken() Token {
 	}
 	builder := strings.Builder{}
 	for {
-		if l.ch == '@' || l.ch == '.' || isAlpha(l.ch) {
+		if l.ch == '@' || l.ch == '.' || l.ch == '_' || isAlpha(l.ch) {
 			builder.WriteByte(l.ch)
 			l.NextChar()
-			for l.ch == '@' || l.ch == '.' || isAlpha(l.ch) || isDigit(l.ch) {
+			for l.ch == '@' || l.ch == '.' || l.ch == '_' || isAlpha(l.ch) || isDigit(l.ch) {
 				builder.WriteByte(l.ch)
 				l.NextChar()
 			}
@@ -162,6 +231,55 @@ func (l *Lexer) NextToken() Token {
 			case ':':
 				l.NextChar()
 				return &TBOUND{Type: COLON, Name: ":"}
+			case '*':
+				l.NextChar()
+				return &TBOUND{Type: MUL, Name: "*"}
+			case '/':
+				l.NextChar()
+				return &TBOUND{Type: DIV, Name: "/"}
+			case '&':
+				l.NextChar()
+				return &TBOUND{Type: AND, Name: "&"}
+			case '|':
+				l.NextChar()
+				return &TBOUND{Type: OR, Name: "|"}
+			case '~':
+				l.NextChar()
+				return &TBOUND{Type: NOT, Name: "~"}
+			case '(':
+				l.NextChar()
+				return &TBOUND{Type: LPAREN, Name: "("}
+			case ')':
+				l.NextChar()
+				return &TBOUND{Type: RPAREN, Name: ")"}
+			case '<':
+				l.NextChar()
+				if l.ch != '<' {
+					l.Error()
+				}
+				l.NextChar()
+				return &TBOUND{Type: SHL, Name: "<<"}
+			case '>':
+				l.NextChar()
+				if l.ch != '>' {
+					l.Error()
+				}
+				l.NextChar()
+				return &TBOUND{Type: SHR, Name: ">>"}
+			case '$':
+				l.NextChar()
+				if l.ch == '$' {
+					l.NextChar()
+					return &TBOUND{Type: DDOLLAR, Name: "$$"}
+				}
+				if l.ch == '@' || l.ch == '.' || l.ch == '_' || isAlpha(l.ch) { //$dx是名为dx的标识符
+					for l.ch == '@' || l.ch == '.' || l.ch == '_' || isAlpha(l.ch) || isDigit(l.ch) {
+						builder.WriteByte(l.ch)
+						l.NextChar()
+					}
+					return &TID{Type: ID, Name: builder.String()}
+				}
+				return &TBOUND{Type: DOLLAR, Name: "$"}
 			default:
 				if l.ch == 0 {
 					return &TEOF{Type: EOF, Name: "EOF"}
@@ -1,12 +1,14 @@
 package asm
 
 import (
+	"calgo/elf"
 	"fmt"
 )
 
 type Parser struct {
 	lexer *Lexer
 	tk    Token
+	next  Token //向前看的记号，为nil时还没有读
 }
 
 func NewParser(filename string) *Parser {
@@ -37,32 +39,45 @@ func (p *Parser) Parse() {
 /*
 program -> section ID <program>
 program -> global ID <program>
+program -> weak ID <program>
 program -> ID <lbtail> program>
 program -> <inst> program
 program -> ^
+C的函数和全局变量可以和关键字同名(如div、dx、qword)，所以符号名也可以是关键字。
+关键字前面加$(如$dx)是标识符，编译器这样写和关键字同名的符号
 */
 func (p *Parser) program() {
-	if p.match(KW_SEC) {
-		if p.tk.TokenTyp() != ID {
+	if p.isLabel() { //定义数据
+		name, _ := p.symName()
+		p.move()
+		p.lbtail(name)
+		p.program()
+	} else if p.match(KW_SEC) {
+		name, ok := p.symName()
+		if !ok {
 			p.Error("section后面必须是标识符")
 		}
-		name := p.tk.(*TID).Name
 		SwitchSeg(name)
 		p.move()
 		p.program()
 	} else if p.match(KW_GLB) {
-		if p.tk.TokenTyp() != ID {
+		name, ok := p.symName()
+		if !ok {
 			p.Error("global后面必须是标识符")
 		}
-		name := p.tk.(*TID).Name
 		lb := Symtab.GetLb(name)
 		lb.Global = true
 		p.move()
 		p.program()
-	} else if p.tk.TokenTyp() == ID { //定义数据
-		name := p.tk.(*TID).Name
+	} else if p.match(KW_WEAK) {
+		name, ok := p.symName()
+		if !ok {
+			p.Error("weak后面必须是标识符")
+		}
+		lb := Symtab.GetLb(name)
+		lb.Global = true
+		lb.Weak = true
 		p.move()
-		p.lbtail(name)
 		p.program()
 	} else if p.tk.TokenTyp() == EOF {
 		SwitchSeg("")
@@ -73,30 +88,73 @@ func (p *Parser) program() {
 	}
 }
 
+// 当前记号是符号名: 标识符或者关键字
+func (p *Parser) symName() (string, bool) {
+	switch tk := p.tk.(type) {
+	case *TID:
+		return tk.Name, true
+	case *TKWORD:
+		return tk.Name, true
+	}
+	return "", false
+}
+
+func isInst(typ TokenType) bool {
+	return typ >= I_MOV && typ <= I_CDQ
+}
+
+/*
+当前记号开始一个标签或数据定义。
+寄存器等不能开始语句的关键字总是标签；
+指令助记符后面是:、equ、times、db、dw或dd时是标签，否则是指令；
+section、global和weak后面是:时是标签，否则是伪指令。和它们同名的数据要写成$weak
+*/
+func (p *Parser) isLabel() bool {
+	typ := p.tk.TokenTyp()
+	if typ == ID {
+		return true
+	}
+	if _, ok := p.tk.(*TKWORD); !ok {
+		return false
+	}
+	if isInst(typ) {
+		switch p.peek().TokenTyp() {
+		case COLON, KW_EQU, KW_TIMES, KW_DB, KW_DW, KW_DD:
+			return true
+		}
+		return false
+	}
+	if typ == KW_SEC || typ == KW_GLB || typ == KW_WEAK {
+		return p.peek().TokenTyp() == COLON
+	}
+	return true
+}
+
 /*
 lbtail -> :
--> equ NUM
--> times NUM <basetail>
+-> equ <expr>
+-> times <expr> <basetail>
 -> <basetail>
 */
 func (p *Parser) lbtail(name string) {
 	if p.match(COLON) { //标签
+		SplitSeg(name)
 		Symtab.AddLb(NewLabel(name, false))
 	} else if p.match(KW_EQU) { //宏
-		if p.tk.TokenTyp() != NUM {
-			p.Error("equ后必须是数值")
-
+		v := p.expr()
+		if !v.IsAbs() {
+			p.relError("equ后必须是常量表达式")
 		}
-		v := p.tk.(*TNUM).Value
-		p.move()
-		Symtab.AddLb(NewEquLb(name, int(v)))
+		Symtab.AddLb(NewEquLb(name, v.Val))
 	} else if p.match(KW_TIMES) { //数组
-		if p.tk.TokenTyp() != NUM {
-			p.Error("times后必须是数值")
+		SplitSeg(name)
+		t := p.expr()
+		if !t.IsAbs() {
+			p.Error("times后必须是常量表达式")
 		}
-		t := p.tk.(*TNUM).Value
-		p.basetail(name, int(t))
+		p.basetail(name, t.Val)
 	} else { //非数组
+		SplitSeg(name)
 		p.basetail(name, 1)
 	}
 }
@@ -128,8 +186,9 @@ func (p *Parser) inst() {
 		p.operand(&regnum, &opt, &l)
 		Gen1Op(tktyp, opt, l)
 	} else {
+		tktyp := p.tk.TokenTyp()
 		p.noneop()
-		Gen0Op(I_RET)
+		Gen0Op(tktyp)
 	}
 }
 
@@ -167,26 +226,32 @@ func (p *Parser) singleop() {
 	}
 }
 
-// noneop -> ret
+// noneop -> ret | cdq
 func (p *Parser) noneop() {
-	p.match(I_RET)
+	if !p.match(I_RET) && !p.match(I_CDQ) {
+		p.Error("noneop err: 不认识的指令")
+	}
 }
 
-// operand -> NUM | ID | <reg> | <mem>
+// operand -> <expr> | <reg> | <mem> | dword <mem> | qword <mem>
 func (p *Parser) operand(regnum *int, opt *OP_TYPE, l *int) {
 	tktyp := p.tk.TokenTyp()
-	if tktyp == NUM {
-		*opt = IMMEDIATE
-		Instr.Imm32 = int(p.tk.(*TNUM).Value)
-		p.move()
-	} else if tktyp == ID {
-		*opt = IMMEDIATE
-		lb := Symtab.GetLb(p.tk.(*TID).Name)
-		Instr.Imm32 = lb.Addr
-		if ScanNum == 2 && !lb.IsEqu {
-			RelLb = lb
+	if tktyp == KW_DWORD || tktyp == KW_QWORD { //内存操作数的大小，只有x87指令需要
+		*l = 4
+		if tktyp == KW_QWORD {
+			*l = 8
 		}
 		p.move()
+		if p.tk.TokenTyp() != LBRACK {
+			p.Error("operand err: dword和qword后面必须是内存操作数")
+		}
+		*opt = MEMORY
+		p.mem()
+	} else if p.MatchExprFirst() {
+		*opt = IMMEDIATE
+		v := p.expr()
+		Instr.Imm32 = v.Val
+		p.setRel(v)
 	} else if tktyp == LBRACK {
 		*opt = MEMORY
 		p.mem()
@@ -207,6 +272,10 @@ func (p *Parser) operand(regnum *int, opt *OP_TYPE, l *int) {
 func GetRegCode(reg TokenType, l int) int {
 	if l == 1 {
 		return int(reg - BR_AL)
+	} else if l == 2 {
+		return int(reg - WR_AX)
+	} else if l == 16 {
+		return int(reg - XR_XMM0)
 	}
 	return int(reg - DR_EAX)
 }
@@ -224,13 +293,37 @@ func (p *Parser) len() int {
 	return 0
 }
 
+// 数据中需要重定位的项: 相对数据起始位置的偏移和符号
+type dataRel struct {
+	off int
+	lb  *Lb_Record
+}
+
 // value -> <type> <valtail>
 func (p *Parser) value(name string, t int, l int) {
 	vs := []int{}
-	p.typ(&vs)
-	p.valtail(&vs)
+	rels := []dataRel{}
+	p.typ(&vs, &rels, l)
+	p.valtail(&vs, &rels, l)
+	if BaseSeg(CurSeg) == ".bss" { //.bss段只能预留空间
+		for _, v := range vs {
+			if v != 0 || len(rels) != 0 {
+				p.Error("value err: .bss段的数据只能是0")
+			}
+		}
+	}
+	//每一份重复的数据都需要重定位
+	for i := 0; i < t; i++ {
+		for _, r := range rels {
+			ELFOBJ.AddRel(CurSeg, CurAddr+i*l*len(vs)+r.off, r.lb.Name, elf.R_386_32)
+		}
+	}
 	//回溯
-	Symtab.AddLb(NewDataLb(name, t, l, vs))
+	lb := NewDataLb(name, t, l, vs)
+	if ScanNum == 2 && BaseSeg(CurSeg) != ".bss" { //.bss段的数据不占用文件空间
+		lb.Write(ELFOBJ.GetSection(CurSeg))
+	}
+	Symtab.AddLb(lb)
 }
 
 // reg -> ...
@@ -239,6 +332,10 @@ func (p *Parser) reg() (r TokenType, l int) {
 		l = 4
 		if p.tk.TokenTyp() <= BR_BH && p.tk.TokenTyp() >= BR_AL {
 			l = 1
+		} else if p.tk.TokenTyp() >= WR_AX && p.tk.TokenTyp() <= WR_DI {
+			l = 2
+		} else if p.tk.TokenTyp() >= XR_XMM0 {
+			l = 16
 		}
 		r = p.tk.TokenTyp()
 		p.move()
@@ -257,102 +354,66 @@ func (p *Parser) mem() {
 }
 
 /*
-type -> NUM
+type -> STR
 
-	-> <off> NUM
-	-> STR
-	-> ID
+	-> <expr>
 */
-func (p *Parser) typ(vs *[]int) {
-	switch p.tk.TokenTyp() {
-	case NUM:
-		v := p.tk.(*TNUM).Value
-		*vs = append(*vs, int(v))
-		p.move()
-	case STR:
+func (p *Parser) typ(vs *[]int, rels *[]dataRel, l int) {
+	if p.tk.TokenTyp() == STR {
 		v := p.tk.(*TSTR).Value
 		for _, b := range []byte(v) {
 			*vs = append(*vs, int(b))
 		}
 		p.move()
-	case ID:
-		l := Symtab.GetLb(p.tk.(*TID).Name)
-		*vs = append(*vs, l.Addr)
-		if ScanNum == 2 && !l.IsEqu {
-			ELFOBJ.AddRel(CurSeg, CurAddr, l.Name, R_386_32)
-		}
-		p.move()
-	default:
-		neg := p.off()
-		if p.tk.TokenTyp() != NUM {
-			p.Error("typ err: <off>后必须是数值")
+		return
+	}
+	v := p.expr()
+	if ScanNum == 2 && !v.IsAbs() {
+		if l != 4 {
+			p.Error("typ err: 需要重定位的数据只能用dd定义")
 		}
-		v := p.tk.(*TNUM).Value
-		if neg {
-			v = -v
+		if v.RelType != 0 {
+			p.Error("typ err: 数据不能使用@GOT、@GOTOFF、@PLT和_GLOBAL_OFFSET_TABLE_")
 		}
-		*vs = append(*vs, int(v))
-		p.move()
+		*rels = append(*rels, dataRel{off: len(*vs) * l, lb: v.Lb})
 	}
+	*vs = append(*vs, v.Val)
 }
 
 /*
 valtail -> , <type> <valtail> |  ^
 */
-func (p *Parser) valtail(vs *[]int) {
+func (p *Parser) valtail(vs *[]int, rels *[]dataRel, l int) {
 	if p.match(COMMA) {
-		p.typ(vs)
-		p.valtail(vs)
+		p.typ(vs, rels, l)
+		p.valtail(vs, rels, l)
 	}
 }
 
 /*
-addr -> NUM
+addr -> <expr>
 
-	-> ID
 	-> <reg> <regaddr>
 */
 func (p *Parser) addr() {
-	tktyp := p.tk.TokenTyp()
-	if tktyp == NUM { //直接寻址
-		MODRM.Mod = 0
-		MODRM.RM = 5
-		Instr.Disp = int(p.tk.(*TNUM).Value)
-		Instr.Displen = 4
-		p.move()
-	} else if tktyp == ID { //直接寻址
+	if p.MatchRegFirst() { //寄存器寻址
+		regtk, l := p.reg()
+		p.regaddr(regtk, l)
+	} else { //直接寻址
+		v := p.expr()
 		MODRM.Mod = 0
 		MODRM.RM = 5
-		lb := Symtab.GetLb(p.tk.(*TID).Name)
-		Instr.Disp = lb.Addr
+		Instr.Disp = v.Val
 		Instr.Displen = 4
-		if ScanNum == 2 && !lb.IsEqu {
-			RelLb = lb
-		}
-		p.move()
-	} else { //寄存器寻址
-		regtk, l := p.reg()
-		p.regaddr(regtk, l)
+		p.setRel(v)
 	}
 }
 
-// off -> + | -
-func (p *Parser) off() bool {
-	if p.match(ADD) {
-		return false
-	} else if p.match(SUB) {
-		return true
-	} else {
-		p.Error("off err: 只能是+或者-")
-	}
-	return false
-}
-
-// regaddr -> <off> <regaddrtail> | ^
+// regaddr -> + <regaddrtail> | - <regaddrtail> | ^
 func (p *Parser) regaddr(regtk TokenType, l int) {
 	if p.tk.TokenTyp() == ADD || p.tk.TokenTyp() == SUB { //
 		sign := p.tk.TokenTyp()
-		p.off()
+		p.move()
 		p.regaddrtail(regtk, l, sign)
 	} else { //寄存器间址
 		basereg := regtk
@@ -382,35 +443,41 @@ func (p *Parser) regaddr(regtk TokenType, l int) {
 	}
 }
 
-// regaddrtail -> NUM | <reg>
+/*
+regaddrtail -> <reg> | <offexpr>
+offexpr是基址后面的偏移表达式，它的第一项带有基址后的符号，例如[ebp - 4 + 2]
+*/
 func (p *Parser) regaddrtail(regtk TokenType, l int, sign TokenType) {
 	basereg := regtk
-	if p.tk.TokenTyp() == NUM { //寄存器基址 + 偏移
-		num := int(p.tk.(*TNUM).Value)
-		if sign == SUB {
-			num = -num
-		}
-		if num >= -128 && num <= 127 {
-			MODRM.Mod = 1
-			Instr.SetDisp(num, 1)
-		} else {
-			MODRM.Mod = 2
-			Instr.SetDisp(num, 4)
-		}
-		MODRM.RM = int(basereg-BR_AL) - (1-l%4)*8
-		if basereg == DR_ESP { //[esp + 0x...]
-			MODRM.RM = 4
-			SIBP.Base = 4
-			SIBP.Index = 4 //不存在变址寄存器
-			SIBP.Scale = 0
-		}
-		p.move()
-	} else { //基址寄存器 + 变址寄存器。无偏移，生成的汇编没有基址 + 变址 + 偏移这种。
+	if sign == ADD && p.MatchRegFirst() { //基址寄存器 + 变址寄存器。无偏移，生成的汇编没有基址 + 变址 + 偏移这种。
 		idxreg, il := p.reg()
 		MODRM.Mod = 0
 		MODRM.RM = 4
 		SIBP.Base = int(basereg-BR_AL) - (1-l%4)*8
 		SIBP.Index = int(idxreg-BR_AL) - (1-il%4)*8
+		return
+	}
+	//寄存器基址 + 偏移
+	v := p.mulexpr()
+	if sign == SUB {
+		v = p.sub(&ExprVal{}, v)
+	}
+	v = p.ortail(p.andtail(p.shtail(p.addtail(v))))
+	//引用了标签的偏移在两遍扫描中可能不同，固定使用32位偏移以保证指令长度不变
+	if v.IsAbs() && !v.HasSym && v.Val >= -128 && v.Val <= 127 {
+		MODRM.Mod = 1
+		Instr.SetDisp(v.Val, 1)
+	} else {
+		MODRM.Mod = 2
+		Instr.SetDisp(v.Val, 4)
+		p.setRel(v)
+	}
+	MODRM.RM = int(basereg-BR_AL) - (1-l%4)*8
+	if basereg == DR_ESP { //[esp + 0x...]
+		MODRM.RM = 4
+		SIBP.Base = 4
+		SIBP.Index = 4 //不存在变址寄存器
+		SIBP.Scale = 0
 	}
 }
 
@@ -422,53 +489,112 @@ func (p *Parser) MatchRegFirst() bool {
 }
 
 var doubleopfirst = map[TokenType]struct{}{
-	I_MOV: {},
-	I_CMP: {},
-	I_SUB: {},
-	I_ADD: {},
-	I_AND: {},
-	I_OR:  {},
-	I_LEA: {},
+	I_MOV:       {},
+	I_CMP:       {},
+	I_SUB:       {},
+	I_ADD:       {},
+	I_AND:       {},
+	I_OR:        {},
+	I_XOR:       {},
+	I_ADC:       {},
+	I_SBB:       {},
+	I_LEA:       {},
+	I_SHL:       {},
+	I_SAR:       {},
+	I_SHR:       {},
+	I_RCR:       {},
+	I_MOVZX:     {},
+	I_MOVSX:     {},
+	I_MOVSS:     {},
+	I_MOVSD:     {},
+	I_ADDSS:     {},
+	I_ADDSD:     {},
+	I_SUBSS:     {},
+	I_SUBSD:     {},
+	I_MULSS:     {},
+	I_MULSD:     {},
+	I_DIVSS:     {},
+	I_DIVSD:     {},
+	I_UCOMISS:   {},
+	I_UCOMISD:   {},
+	I_CVTSI2SS:  {},
+	I_CVTSI2SD:  {},
+	I_CVTTSS2SI: {},
+	I_CVTTSD2SI: {},
+	I_CVTSS2SD:  {},
+	I_CVTSD2SS:  {},
+	I_XORPS:     {},
 }
 
 var singleopfirst = map[TokenType]struct{}{
-	I_CALL:  {},
-	I_INT:   {},
-	I_IMUL:  {},
-	I_IDIV:  {},
-	I_NEG:   {},
-	I_INC:   {},
-	I_DEC:   {},
-	I_JMP:   {},
-	I_JE:    {},
-	I_JNE:   {},
-	I_SETE:  {},
-	I_SETNE: {},
-	I_SETG:  {},
-	I_SETGE: {},
-	I_SETL:  {},
-	I_SETLE: {},
-	I_PUSH:  {},
-	I_POP:   {},
+	I_CALL:   {},
+	I_INT:    {},
+	I_IMUL:   {},
+	I_IDIV:   {},
+	I_MUL:    {},
+	I_DIV:    {},
+	I_NEG:    {},
+	I_NOT:    {},
+	I_INC:    {},
+	I_DEC:    {},
+	I_JMP:    {},
+	I_JE:     {},
+	I_JNE:    {},
+	I_JB:     {},
+	I_SETE:   {},
+	I_SETNE:  {},
+	I_SETG:   {},
+	I_SETGE:  {},
+	I_SETL:   {},
+	I_SETLE:  {},
+	I_SETA:   {},
+	I_SETAE:  {},
+	I_SETB:   {},
+	I_SETBE:  {},
+	I_PUSH:   {},
+	I_POP:    {},
+	I_SETP:   {},
+	I_SETNP:  {},
+	I_FLD:    {},
+	I_FSTP:   {},
+	I_FILD:   {},
+	I_FISTTP: {},
+	I_FADD:   {},
 }
 
 var regfirst = map[TokenType]struct{}{
-	BR_AL:  {},
-	BR_CL:  {},
-	BR_DL:  {},
-	BR_BL:  {},
-	BR_AH:  {},
-	BR_CH:  {},
-	BR_DH:  {},
-	BR_BH:  {},
-	DR_EAX: {},
-	DR_ECX: {},
-	DR_EDX: {},
-	DR_EBX: {},
-	DR_ESP: {},
-	DR_EBP: {},
-	DR_ESI: {},
-	DR_EDI: {},
+	BR_AL:   {},
+	BR_CL:   {},
+	BR_DL:   {},
+	BR_BL:   {},
+	BR_AH:   {},
+	BR_CH:   {},
+	BR_DH:   {},
+	BR_BH:   {},
+	DR_EAX:  {},
+	DR_ECX:  {},
+	DR_EDX:  {},
+	DR_EBX:  {},
+	DR_ESP:  {},
+	DR_EBP:  {},
+	DR_ESI:  {},
+	DR_EDI:  {},
+	WR_AX:   {},
+	WR_CX:   {},
+	WR_DX:   {},
+	WR_BX:   {},
+	WR_SP:   {},
+	WR_BP:   {},
+	WR_SI:   {},
+	WR_DI:   {},
+	XR_XMM0: {},
+	XR_XMM1: {},
+	XR_XMM2: {},
+	XR_XMM3: {},
+	XR_XMM4: {},
+	XR_XMM5: {},
+	XR_XMM6: {},
+	XR_XMM7: {},
 }
 
 func (p *Parser) match(typ TokenType) bool {
@@ -480,11 +606,24 @@ func (p *Parser) match(typ TokenType) bool {
 }
 
 func (p *Parser) move() {
+	if p.next != nil {
+		p.tk, p.next = p.next, nil
+		return
+	}
 	p.tk = p.lexer.NextToken()
 }
 
+// 下一个记号，不前移
+func (p *Parser) peek() Token {
+	if p.next == nil {
+		p.next = p.lexer.NextToken()
+	}
+	return p.next
+}
+
 func (p *Parser) Reset() {
 	p.lexer.Reset()
 	p.tk = nil
+	p.next = nil
 	p.move()
 }
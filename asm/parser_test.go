@@ -0,0 +1,149 @@
+package asm
+
+import (
+	"bytes"
+	delf "debug/elf"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// C的函数和全局变量可以和指令同名，编译器生成的汇编里这些名字既是符号又是指令
+var instNames = []string{
+	"div", "mul", "shr", "adc", "sbb", "rcr", "movzx", "movsx", "cdq", "jb", "seta", "setb",
+	"xor", "not", "shl", "sar",
+	"movsd", "movss", "addsd", "fadd", "fld", "fstp", "xorps",
+}
+
+// 和寄存器同名的符号。作为指令的操作数时要写成$dx，否则是寄存器
+var regNames = []string{"ax", "bx", "cx", "dx", "sp", "bp", "si", "di", "xmm0", "xmm7"}
+
+// 和操作数大小同名的符号
+var sizeNames = []string{"dword", "qword"}
+
+// 和伪指令同名的符号。定义数据时要写成$weak，否则是伪指令
+var dirNames = []string{"weak", "global", "section"}
+
+func TestInstNameSymbols(t *testing.T) {
+	names := append(append(append(append([]string{}, instNames...), regNames...), sizeNames...), dirNames...)
+	src := &bytes.Buffer{}
+	src.WriteString("section .text\n")
+	for _, n := range names {
+		src.WriteString("global " + n + "\n")
+	}
+	src.WriteString("div:\n\tdiv ecx\n\tcdq\n\tret\n")
+	for _, n := range instNames[1:12] { //函数
+		src.WriteString(n + ":\n\tcall div\n\tcall " + n + "@PLT\n\tret\n")
+	}
+	src.WriteString("@start:\n\tcall fadd\n\tmov eax, [sar]\n\tmov eax, shl\n\tlea ebx, [xorps + 4]\n\tret\n")
+	src.WriteString("ax:\n\tcall $dx\n\tmov eax, [$sp]\n\tlea ebx, [$bp + 4]\n\tmov ecx, $si\n\tret\n")
+	src.WriteString("bx:\n\tcall $cx@PLT\n\tret\n")
+	src.WriteString("cx:\n$dx:\n\tmov ax, dx\n\tret\n")
+	src.WriteString("weak:\n\tcall global\n\tmov eax, [section]\n\tret\n")
+	src.WriteString("dword:\n\tfld qword [$qword]\n\tmovsd xmm0, [$xmm0]\n\tcall $dword\n\tret\n")
+	src.WriteString("section .data\n") //数据
+	src.WriteString("xor times 2 dd 0\n")
+	src.WriteString("not dd xor, shr\n")
+	src.WriteString("shl dd 1\n")
+	src.WriteString("sar dw 2\n")
+	for _, n := range instNames[16:] {
+		src.WriteString(n + " db 3\n")
+	}
+	src.WriteString("sp dd ax, $di\n")
+	src.WriteString("bp times 2 dd 0\n")
+	src.WriteString("si dw 1\n")
+	src.WriteString("$di db 2\n")
+	src.WriteString("qword dd dword, $xmm7\n")
+	src.WriteString("xmm0 times 2 dd 0\n")
+	src.WriteString("$xmm7 dd 0, 0\n")
+	src.WriteString("$global dd weak\n")
+	src.WriteString("$section times 2 db 1\n")
+
+	dir := t.TempDir()
+	sfile := filepath.Join(dir, "a.s")
+	if err := os.WriteFile(sfile, src.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	p := NewParser(sfile)
+	p.Parse()
+	Symtab.ExportSyms()
+	ofile := filepath.Join(dir, "a.o")
+	f, err := os.Create(ofile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	EXEFILE = f
+	ELFOBJ.WriteElf()
+	f.Close()
+
+	obj, err := delf.Open(ofile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer obj.Close()
+	syms, err := obj.Symbols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defined := map[string]delf.Symbol{}
+	for _, s := range syms {
+		defined[s.Name] = s
+	}
+	for _, n := range names {
+		s, ok := defined[n]
+		if !ok || s.Section == delf.SHN_UNDEF {
+			t.Errorf("符号%s没有定义", n)
+			continue
+		}
+		if delf.ST_BIND(s.Info) != delf.STB_GLOBAL {
+			t.Errorf("符号%s不是全局的", n)
+		}
+	}
+	text, err := obj.Section(".text").Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//div ecx; cdq; ret
+	if want := []byte{0xf7, 0xf1, 0x99, 0xc3}; !bytes.HasPrefix(text, want) {
+		t.Errorf("函数div的指令是% x，应该是% x", text[:len(want)], want)
+	}
+	//$dx等是符号，需要重定位；操作数中的dx是寄存器
+	relocs := map[string][]string{
+		".rel.text": {"sp", "bp", "si", "cx", "qword", "xmm0", "global", "section"},
+		".rel.data": {"ax", "di", "dword", "xmm7", "weak"},
+	}
+	for sec, want := range relocs {
+		got := relSyms(t, obj, syms, sec)
+		for _, n := range want {
+			if !got[n] {
+				t.Errorf("%s中没有对符号%s的重定位", sec, n)
+			}
+		}
+	}
+	//mov ax, dx; ret
+	cx := defined["cx"]
+	if want := []byte{0x66, 0x8b, 0xc2, 0xc3}; !bytes.Equal(text[cx.Value:cx.Value+4], want) {
+		t.Errorf("函数cx的指令是% x，应该是% x", text[cx.Value:cx.Value+4], want)
+	}
+}
+
+// 重定位段sec中引用的符号
+func relSyms(t *testing.T, obj *delf.File, syms []delf.Symbol, sec string) map[string]bool {
+	t.Helper()
+	rel := obj.Section(sec)
+	if rel == nil {
+		t.Fatalf("没有%s", sec)
+	}
+	data, err := rel.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for i := 0; i+8 <= len(data); i += 8 {
+		if idx := int(binary.LittleEndian.Uint32(data[i+4:]) >> 8); idx > 0 && idx <= len(syms) {
+			names[syms[idx-1].Name] = true
+		}
+	}
+	return names
+}
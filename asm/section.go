@@ -0,0 +1,21 @@
+package asm
+
+import "bytes"
+
+// 段的内容和重定位项，在第二遍扫描时生成
+type Section struct {
+	Name string
+	Data bytes.Buffer //.bss段没有内容
+	Rels []*RelItem
+}
+
+func NewSection(name string) *Section {
+	return &Section{Name: name}
+}
+
+// 按小端序写入v的低l个字节
+func (s *Section) WriteBytes(v int, l int) {
+	for i := 0; i < l; i++ {
+		s.Data.WriteByte(byte(v >> (8 * i)))
+	}
+}
@@ -0,0 +1,8 @@
+section .text
+global main
+global exit
+global @start
+@start:
+    call main
+    push eax
+    call exit
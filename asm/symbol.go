@@ -1,14 +1,14 @@
 package asm
 
-import "unsafe"
-
 type Lb_Record struct {
 	SegName  string
 	Name     string
 	IsEqu    bool
 	Externed bool
 	Global   bool
-	Addr     int //如果是宏，表示宏的值
+	Weak     bool //弱符号，可以被同名的全局符号覆盖
+	IsSec    bool //段符号，用于相对段起始地址的重定位
+	Addr     int  //如果是宏，表示宏的值
 	Times    int
 	Len      int
 	Cont     []int
@@ -43,6 +43,15 @@ func NewEquLb(name string, v int) *Lb_Record {
 	}
 }
 
+// 段符号: 地址为0，指代段的起始位置
+func NewSecLb(seg string) *Lb_Record {
+	return &Lb_Record{
+		Name:    seg,
+		SegName: seg,
+		IsSec:   true,
+	}
+}
+
 // 数据
 func NewDataLb(name string, t int, l int, v []int) *Lb_Record {
 	lb := &Lb_Record{
@@ -57,11 +66,11 @@ func NewDataLb(name string, t int, l int, v []int) *Lb_Record {
 	return lb
 }
 
-func (l *Lb_Record) Write() {
+// 把数据写入段s
+func (l *Lb_Record) Write(s *Section) {
 	for i := 0; i < l.Times; i++ {
 		for j := 0; j < len(l.Cont); j++ {
-			FWrite(unsafe.Pointer(&l.Cont[i]), uint32(l.Len), EXEFILE)
-			//WriteBytes(l.Cont[i], l.Len)
+			s.WriteBytes(l.Cont[j], l.Len)
 		}
 	}
 }
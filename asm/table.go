@@ -1,8 +1,12 @@
 package asm
 
+import (
+	"sort"
+	"strings"
+)
+
 type SymTable struct {
 	Lb_Map map[string]*Lb_Record
-	DefLbs []*Lb_Record
 }
 
 func NewSymTable() *SymTable {
@@ -12,16 +16,21 @@ func NewSymTable() *SymTable {
 }
 
 func (s *SymTable) AddLb(nlb *Lb_Record) { //nlb:new label
-	if ScanNum > 1 {
+	if ScanNum > 1 { //第二遍扫描时标签已经登记，只更新可能依赖前向引用的值
+		if olb, ok := s.Lb_Map[nlb.Name]; ok {
+			if olb.IsEqu {
+				olb.Addr = nlb.Addr
+			} else if olb.Times != 0 {
+				olb.Cont = nlb.Cont
+			}
+		}
 		return
 	}
 	if olb, ok := s.Lb_Map[nlb.Name]; ok && olb.Global { //olb:old label
 		nlb.Global = true
+		nlb.Weak = olb.Weak
 	}
 	s.Lb_Map[nlb.Name] = nlb
-	if nlb.Times != 0 && nlb.SegName == ".data" {
-		s.DefLbs = append(s.DefLbs, nlb)
-	}
 }
 
 func (s *SymTable) GetLb(name string) *Lb_Record {
@@ -33,32 +42,66 @@ func (s *SymTable) GetLb(name string) *Lb_Record {
 	return l
 }
 
+func (s *SymTable) GetSecLb(seg string) *Lb_Record {
+	if l, ok := s.Lb_Map[seg]; ok && l.IsSec {
+		return l
+	}
+	l := NewSecLb(seg)
+	s.Lb_Map[seg] = l
+	return l
+}
+
+// 按名字顺序导出符号，保证输出稳定
 func (s *SymTable) ExportSyms() {
-	for _, lb := range s.Lb_Map {
-		if !lb.IsEqu {
+	names := []string{}
+	for name := range s.Lb_Map {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if lb := s.Lb_Map[name]; !lb.IsEqu {
 			ELFOBJ.AddSym(lb)
 		}
 	}
 }
 
-func (s *SymTable) Write() {
-	for _, l := range s.DefLbs {
-		l.Write()
+// 段名去掉按函数分段时加的后缀: .text.main -> .text。不是这四种段时返回空串
+func BaseSeg(name string) string {
+	for _, b := range []string{".text", ".data", ".rodata", ".bss"} {
+		if name == b || strings.HasPrefix(name, b+".") {
+			return b
+		}
 	}
+	return ""
 }
 
-var datalen = 0 //上一个段的结束位置
+/*
+按函数分段时，全局符号name的定义开始一个新的段，例如.text.main、.data.k，链接器可以去掉没有被引用的段。
+第一遍扫描根据定义时符号是否是全局的决定是否分段，第二遍扫描和第一遍保持一致
+*/
+func SplitSeg(name string) {
+	base := BaseSeg(CurSeg)
+	if !FunctionSections || base == "" {
+		return
+	}
+	seg := base + "." + name
+	lb, ok := Symtab.Lb_Map[name]
+	if ScanNum == 1 && (!ok || !lb.Global) || ScanNum > 1 && lb.SegName != seg {
+		return
+	}
+	SwitchSeg(seg)
+}
 
 func SwitchSeg(name string) {
 	if ScanNum == 1 {
-		datalen += (4 - datalen%4) % 4
 		ELFOBJ.AddShdr(CurSeg, CurAddr)
-		datalen += CurAddr
 	}
 	CurSeg = name
 	CurAddr = 0
 }
 
-var ScanNum = 1 //开始第ScanNum遍扫描
+var ScanNum = 1              //开始第ScanNum遍扫描
+var FunctionSections = false //每个全局符号单独一个段
 var Symtab *SymTable = NewSymTable()
 var RelLb *Lb_Record
+var RelType int //RelLb的重定位类型，0表示由指令决定
@@ -89,7 +89,7 @@ func (T *TSTR) String() string {
 			builder.WriteByte(c)
 		}
 	}
-	return fmt.Sprintf("%d:%s", tokenTypeTable[T.Type], builder.String())
+	return fmt.Sprintf("%s:%s", tokenTypeTable[T.Type], builder.String())
 }
 
 func (T *TREG) String() string {
@@ -176,45 +176,119 @@ const (
 	DR_EBP
 	DR_ESI
 	DR_EDI
+	WR_AX
+	WR_CX
+	WR_DX
+	WR_BX
+	WR_SP
+	WR_BP
+	WR_SI
+	WR_DI
+	XR_XMM0
+	XR_XMM1
+	XR_XMM2
+	XR_XMM3
+	XR_XMM4
+	XR_XMM5
+	XR_XMM6
+	XR_XMM7
 	I_MOV
 	I_CMP
 	I_SUB
 	I_ADD
 	I_AND
 	I_OR
+	I_XOR
+	I_ADC
+	I_SBB
 	I_LEA
+	I_SHL
+	I_SAR
+	I_SHR
+	I_RCR
+	I_MOVZX
+	I_MOVSX
+	I_MOVSS
+	I_MOVSD
+	I_ADDSS
+	I_ADDSD
+	I_SUBSS
+	I_SUBSD
+	I_MULSS
+	I_MULSD
+	I_DIVSS
+	I_DIVSD
+	I_UCOMISS
+	I_UCOMISD
+	I_CVTSI2SS
+	I_CVTSI2SD
+	I_CVTTSS2SI
+	I_CVTTSD2SI
+	I_CVTSS2SD
+	I_CVTSD2SS
+	I_XORPS
 	I_CALL
 	I_INT
 	I_IMUL
 	I_IDIV
+	I_MUL
+	I_DIV
 	I_NEG
+	I_NOT
 	I_INC
 	I_DEC
 	I_JMP
 	I_JE
 	I_JNE
+	I_JB
 	I_SETE
 	I_SETNE
 	I_SETG
 	I_SETGE
 	I_SETL
 	I_SETLE
+	I_SETA
+	I_SETAE
+	I_SETB
+	I_SETBE
+	I_SETP
+	I_SETNP
 	I_PUSH
 	I_POP
+	I_FLD
+	I_FSTP
+	I_FILD
+	I_FISTTP
+	I_FADD
 	I_RET
+	I_CDQ
 	KW_SEC
 	KW_GLB
+	KW_WEAK
 	KW_EQU
 	KW_TIMES
 	KW_DB
 	KW_DW
 	KW_DD
+	KW_DWORD
+	KW_QWORD
 	ADD
 	SUB
 	COMMA
 	LBRACK
 	RBRACK
 	COLON
+	MUL
+	DIV
+	SHL
+	SHR
+	AND
+	OR
+	NOT
+	LPAREN
+	RPAREN
+	DOLLAR
+	DDOLLAR
 )
 
 var tokenTypeTable = []string{
@@ -240,43 +314,117 @@ var tokenTypeTable = []string{
 	"DR_EBP",
 	"DR_ESI",
 	"DR_EDI",
+	"WR_AX",
+	"WR_CX",
+	"WR_DX",
+	"WR_BX",
+	"WR_SP",
+	"WR_BP",
+	"WR_SI",
+	"WR_DI",
+	"XR_XMM0",
+	"XR_XMM1",
+	"XR_XMM2",
+	"XR_XMM3",
+	"XR_XMM4",
+	"XR_XMM5",
+	"XR_XMM6",
+	"XR_XMM7",
 	"I_MOV",
 	"I_CMP",
 	"I_SUB",
 	"I_ADD",
 	"I_AND",
 	"I_OR",
+	"I_XOR",
+	"I_ADC",
+	"I_SBB",
 	"I_LEA",
+	"I_SHL",
+	"I_SAR",
+	"I_SHR",
+	"I_RCR",
+	"I_MOVZX",
+	"I_MOVSX",
+	"I_MOVSS",
+	"I_MOVSD",
+	"I_ADDSS",
+	"I_ADDSD",
+	"I_SUBSS",
+	"I_SUBSD",
+	"I_MULSS",
+	"I_MULSD",
+	"I_DIVSS",
+	"I_DIVSD",
+	"I_UCOMISS",
+	"I_UCOMISD",
+	"I_CVTSI2SS",
+	"I_CVTSI2SD",
+	"I_CVTTSS2SI",
+	"I_CVTTSD2SI",
+	"I_CVTSS2SD",
+	"I_CVTSD2SS",
+	"I_XORPS",
 	"I_CALL",
 	"I_INT",
 	"I_IMUL",
 	"I_IDIV",
+	"I_MUL",
+	"I_DIV",
 	"I_NEG",
+	"I_NOT",
 	"I_INC",
 	"I_DEC",
 	"I_JMP",
 	"I_JE",
 	"I_JNE",
+	"I_JB",
 	"I_SETE",
 	"I_SETNE",
 	"I_SETG",
 	"I_SETGE",
 	"I_SETL",
 	"I_SETLE",
+	"I_SETA",
+	"I_SETAE",
+	"I_SETB",
+	"I_SETBE",
+	"I_SETP",
+	"I_SETNP",
 	"I_PUSH",
 	"I_POP",
+	"I_FLD",
+	"I_FSTP",
+	"I_FILD",
+	"I_FISTTP",
+	"I_FADD",
 	"I_RET",
+	"I_CDQ",
 	"KW_SEC",
 	"KW_GLB",
+	"KW_WEAK",
 	"KW_EQU",
 	"KW_TIMES",
 	"KW_DB",
 	"KW_DW",
 	"KW_DD",
+	"KW_DWORD",
+	"KW_QWORD",
 	"ADD",
 	"SUB",
 	"COMMA",
 	"LBRACK",
 	"RBRACK",
 	"COLON",
+	"MUL",
+	"DIV",
+	"SHL",
+	"SHR",
+	"AND",
+	"OR",
+	"NOT",
+	"LPAREN",
+	"RPAREN",
+	"DOLLAR",
+	"DDOLLAR",
 }
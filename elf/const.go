@@ -0,0 +1,140 @@
+package elf
+
+const (
+	ET_NONE = 0 /* Unknown type. */
+	ET_REL  = 1 /* Relocatable. */
+	ET_EXEC = 2 /* Executable. */
+	ET_DYN  = 3 /* Shared object. */
+)
+
+// E_Ident中的标识
+const (
+	ELFCLASS32  = 1 /* 32-bit objects. */
+	ELFDATA2LSB = 1 /* 2's complement little-endian. */
+	EV_CURRENT  = 1
+	EM_386      = 3
+)
+
+var ElfMagic = [4]byte{0x7f, 'E', 'L', 'F'}
+
+const (
+	SHT_NULL     = 0  /* inactive */
+	SHT_PROGBITS = 1  /* program defined information */
+	SHT_SYMTAB   = 2  /* symbol table section */
+	SHT_STRTAB   = 3  /* string table section */
+	SHT_HASH     = 5  /* symbol hash table section */
+	SHT_DYNAMIC  = 6  /* dynamic section */
+	SHT_NOBITS   = 8  /* no space section */
+	SHT_REL      = 9  /* relocation section - no addends */
+	SHT_DYNSYM   = 11 /* dynamic symbol table section */
+)
+
+const (
+	SHF_WRITE            = 0x1        /* Section contains writable data. */
+	SHF_ALLOC            = 0x2        /* Section occupies memory. */
+	SHF_EXECINSTR        = 0x4        /* Section contains instructions. */
+	SHF_MERGE            = 0x10       /* Section may be merged. */
+	SHF_STRINGS          = 0x20       /* Section contains strings. */
+	SHF_INFO_LINK        = 0x40       /* sh_info holds section index. */
+	SHF_LINK_ORDER       = 0x80       /* Special ordering requirements. */
+	SHF_OS_NONCONFORMING = 0x100      /* OS-specific processing required. */
+	SHF_GROUP            = 0x200      /* Member of section group. */
+	SHF_TLS              = 0x400      /* Section contains TLS data. */
+	SHF_COMPRESSED       = 0x800      /* Section is compressed. */
+	SHF_MASKOS           = 0x0ff00000 /* OS-specific semantics. */
+	SHF_MASKPROC         = 0xf0000000 /* Processor-specific semantics. */
+)
+
+const (
+	SHN_UNDEF     = 0      /* Undefined, missing, irrelevant. */
+	SHN_LORESERVE = 0xff00 /* First of reserved range. */
+	SHN_ABS       = 0xfff1 /* Absolute values. */
+	SHN_COMMON    = 0xfff2 /* Common data. */
+)
+
+const (
+	PF_X        = 0x1        /* Executable. */
+	PF_W        = 0x2        /* Writable. */
+	PF_R        = 0x4        /* Readable. */
+	PF_MASKOS   = 0x0ff00000 /* Operating system-specific. */
+	PF_MASKPROC = 0xf0000000 /* Processor-specific. */
+)
+
+const (
+	PT_NULL    = 0 /* Unused entry. */
+	PT_LOAD    = 1 /* Loadable segment. */
+	PT_DYNAMIC = 2 /* Dynamic linking information segment. */
+	PT_INTERP  = 3 /* Pathname of interpreter. */
+	PT_NOTE    = 4 /* Auxiliary information. */
+	PT_SHLIB   = 5 /* Reserved (not used). */
+	PT_PHDR    = 6 /* Location of program header itself. */
+	PT_TLS     = 7 /* Thread local storage segment */
+)
+
+// 符号绑定信息，ST_Info的高四位
+const (
+	STB_LOCAL  = 0
+	STB_GLOBAL = 1
+	STB_WEAK   = 2
+)
+
+// 符号类型，ST_Info的低四位
+const (
+	STT_NOTYPE  = 0
+	STT_OBJECT  = 1
+	STT_FUNC    = 2
+	STT_SECTION = 3
+	STT_FILE    = 4
+)
+
+// 符号可见性，ST_Other的低两位
+const (
+	STV_DEFAULT   = 0
+	STV_INTERNAL  = 1
+	STV_HIDDEN    = 2
+	STV_PROTECTED = 3
+)
+
+const (
+	R_386_NONE     = 0
+	R_386_32       = 1  /* S + A */
+	R_386_PC32     = 2  /* S + A - P */
+	R_386_GOT32    = 3  /* G + A */
+	R_386_PLT32    = 4  /* L + A - P */
+	R_386_COPY     = 5  /* 复制共享库中的数据 */
+	R_386_GLOB_DAT = 6  /* S，用于GOT项 */
+	R_386_JMP_SLOT = 7  /* S，用于PLT使用的GOT项 */
+	R_386_RELATIVE = 8  /* B + A */
+	R_386_GOTOFF   = 9  /* S + A - GOT */
+	R_386_GOTPC    = 10 /* GOT + A - P */
+)
+
+// .dynamic段的表项类型
+const (
+	DT_NULL     = 0
+	DT_NEEDED   = 1
+	DT_PLTRELSZ = 2
+	DT_PLTGOT   = 3
+	DT_HASH     = 4
+	DT_STRTAB   = 5
+	DT_SYMTAB   = 6
+	DT_STRSZ    = 10
+	DT_SYMENT   = 11
+	DT_SONAME   = 14
+	DT_REL      = 17
+	DT_RELSZ    = 18
+	DT_RELENT   = 19
+	DT_PLTREL   = 20
+	DT_DEBUG    = 21
+	DT_TEXTREL  = 22
+	DT_JMPREL   = 23
+	DT_FLAGS    = 30
+	DT_FLAGS_1  = 0x6ffffffb
+)
+
+// DT_FLAGS和DT_FLAGS_1
+const (
+	DF_TEXTREL  = 0x4
+	DF_BIND_NOW = 0x8
+	DF_1_NOW    = 0x1
+)
@@ -0,0 +1,450 @@
+package elf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+/*
+目标文件的对象模型。汇编器生成可重定位文件，链接器读入可重定位文件、合并后写出可执行文件。
+文件中的.shstrtab、.symtab、.strtab和.rel*段由Read解析为Sections、Symbols和Relocs，由Write重新生成，
+不出现在Sections中。
+*/
+type File struct {
+	Type     uint16 //ET_REL, ET_EXEC
+	Entry    uint32
+	Progs    []*Prog    //程序头，只有可执行文件有
+	Sections []*Section //不含空段
+	Symbols  []*Symbol  //不含空符号
+	NoShdrs  bool       //不写出段表和.shstrtab、.symtab等段，只用于可执行文件
+}
+
+// 程序头
+type Prog struct {
+	Type   uint32
+	Flags  uint32
+	Offset uint32
+	Vaddr  uint32
+	Filesz uint32
+	Memsz  uint32
+	Align  uint32
+}
+
+type Section struct {
+	Name    string
+	Type    uint32
+	Flags   uint32
+	Addr    uint32
+	Offset  uint32 //为0时由Write分配，否则必须在已写入的内容之后
+	Size    uint32 //SHT_NOBITS段没有内容，大小由Size给出；其他段的大小是len(Data)
+	Align   uint32
+	Link    uint32
+	Info    uint32
+	Entsize uint32
+	Data    []byte
+	Relocs  []*Reloc //作用于本段的重定位项
+}
+
+type Symbol struct {
+	Name       string
+	Value      uint32 //可重定位文件: 相对段基址的偏移；可执行文件: 线性地址
+	Size       uint32
+	Bind       uint8    //STB_LOCAL, STB_GLOBAL, STB_WEAK
+	Type       uint8    //STT_NOTYPE, STT_OBJECT, STT_FUNC, STT_SECTION ...
+	Visibility uint8    //STV_DEFAULT, STV_HIDDEN ...
+	Section    *Section //所在段
+	Shndx      uint16   //Section为nil时的段索引: SHN_UNDEF, SHN_ABS, SHN_COMMON
+}
+
+type Reloc struct {
+	Offset uint32 //相对段基址的偏移
+	Type   uint8  //R_386_32, R_386_PC32 ...
+	Sym    *Symbol
+}
+
+func NewFile(typ uint16) *File {
+	return &File{Type: typ}
+}
+
+func (f *File) AddSection(s *Section) *Section {
+	f.Sections = append(f.Sections, s)
+	return s
+}
+
+func (f *File) AddSymbol(s *Symbol) *Symbol {
+	f.Symbols = append(f.Symbols, s)
+	return s
+}
+
+func (f *File) Section(name string) *Section {
+	for _, s := range f.Sections {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// 查找非局部符号
+func (f *File) Lookup(name string) *Symbol {
+	for _, s := range f.Symbols {
+		if s.Bind != STB_LOCAL && s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func (s *Symbol) Defined() bool {
+	return s.Section != nil || s.Shndx == SHN_ABS
+}
+
+func (s *Section) FileSize() uint32 {
+	if s.Type == SHT_NOBITS {
+		return 0
+	}
+	return uint32(len(s.Data))
+}
+
+func (s *Section) MemSize() uint32 {
+	if s.Type == SHT_NOBITS {
+		return s.Size
+	}
+	return uint32(len(s.Data))
+}
+
+// 解析ELF文件
+func Read(b []byte) (*File, error) {
+	ehdr, err := ReadEhdr(b)
+	if err != nil {
+		return nil, err
+	}
+	f := NewFile(ehdr.E_Type)
+	f.Entry = ehdr.E_Entry
+	//程序头表
+	for i := uint32(0); i < uint32(ehdr.E_Phnum); i++ {
+		ph := &Elf32_Phdr{}
+		if err := ReadAt(b, ehdr.E_Phoff+i*PhdrSize, ph); err != nil {
+			return nil, err
+		}
+		f.Progs = append(f.Progs, &Prog{ph.P_Type, ph.P_Flags, ph.P_Offset, ph.P_Vaddr, ph.P_FileSZ, ph.P_MemSZ, ph.P_Align})
+	}
+	//段表
+	shdrs := []*Elf32_Shdr{}
+	for i := 0; i < int(ehdr.E_Shnum); i++ {
+		sh, err := ReadShdr(b, ehdr, i)
+		if err != nil {
+			return nil, err
+		}
+		shdrs = append(shdrs, sh)
+	}
+	if len(shdrs) == 0 {
+		return f, nil
+	}
+	shstrtab := SectionData(b, shdrs[ehdr.E_Shstrndx])
+	//需要重新生成的段
+	skip := map[int]bool{0: true, int(ehdr.E_Shstrndx): true}
+	symtabidx := -1
+	for i, sh := range shdrs {
+		if sh.SH_Type == SHT_SYMTAB && symtabidx == -1 {
+			symtabidx = i
+			if int(sh.SH_Link) >= len(shdrs) {
+				return nil, fmt.Errorf("elf: 符号表的串表索引%d错误", sh.SH_Link)
+			}
+			skip[i] = true
+			skip[int(sh.SH_Link)] = true
+		} else if sh.SH_Type == SHT_REL {
+			skip[i] = true
+		}
+	}
+	secs := map[int]*Section{}
+	for i, sh := range shdrs {
+		if skip[i] {
+			continue
+		}
+		name, err := cstr(shstrtab, sh.SH_Name)
+		if err != nil {
+			return nil, err
+		}
+		s := &Section{
+			Name:    name,
+			Type:    sh.SH_Type,
+			Flags:   sh.SH_Flags,
+			Addr:    sh.SH_Addr,
+			Offset:  sh.SH_Offset,
+			Size:    sh.SH_Size,
+			Align:   sh.SH_Addralign,
+			Link:    sh.SH_Link,
+			Info:    sh.SH_Info,
+			Entsize: sh.SH_Entsize,
+		}
+		if sh.SH_Type != SHT_NOBITS {
+			s.Data = append([]byte{}, SectionData(b, sh)...)
+		}
+		secs[i] = f.AddSection(s)
+	}
+	if symtabidx == -1 {
+		return f, nil
+	}
+	//符号表
+	symsh := shdrs[symtabidx]
+	strtab := SectionData(b, shdrs[symsh.SH_Link])
+	syms := []*Symbol{nil} //按索引排列，第0个是空符号
+	for i := uint32(1); i < symsh.SH_Size/symsh.SH_Entsize; i++ {
+		st := &Elf32_Sym{}
+		if err := ReadAt(b, symsh.SH_Offset+i*symsh.SH_Entsize, st); err != nil {
+			return nil, err
+		}
+		name, err := cstr(strtab, st.ST_Name)
+		if err != nil {
+			return nil, err
+		}
+		sym := &Symbol{
+			Name:       name,
+			Value:      st.ST_Value,
+			Size:       st.ST_Size,
+			Bind:       st.ST_Info >> 4,
+			Type:       st.ST_Info & 0xf,
+			Visibility: st.ST_Other & 0x3,
+		}
+		if st.ST_Shndx == SHN_UNDEF || st.ST_Shndx >= SHN_LORESERVE {
+			sym.Shndx = st.ST_Shndx
+		} else if s, ok := secs[int(st.ST_Shndx)]; ok {
+			sym.Section = s
+		} else {
+			return nil, fmt.Errorf("elf: 符号%s的段索引%d错误", name, st.ST_Shndx)
+		}
+		if sym.Type == STT_SECTION && sym.Section != nil {
+			sym.Name = sym.Section.Name
+		}
+		syms = append(syms, sym)
+		f.AddSymbol(sym)
+	}
+	//重定位表
+	for _, sh := range shdrs {
+		if sh.SH_Type != SHT_REL {
+			continue
+		}
+		target, ok := secs[int(sh.SH_Info)]
+		if !ok {
+			return nil, fmt.Errorf("elf: 重定位表的目标段索引%d错误", sh.SH_Info)
+		}
+		for j := uint32(0); j < sh.SH_Size/sh.SH_Entsize; j++ {
+			rel := &Elf32_Rel{}
+			if err := ReadAt(b, sh.SH_Offset+j*sh.SH_Entsize, rel); err != nil {
+				return nil, err
+			}
+			symi := rel.R_Info >> 8
+			if symi == 0 || int(symi) >= len(syms) {
+				return nil, fmt.Errorf("elf: 重定位项的符号索引%d错误", symi)
+			}
+			target.Relocs = append(target.Relocs, &Reloc{Offset: rel.R_Offset, Type: uint8(rel.R_Info), Sym: syms[symi]})
+		}
+	}
+	return f, nil
+}
+
+// 以0结尾的字符串
+func cstr(tab []byte, off uint32) (string, error) {
+	if int(off) >= len(tab) {
+		if off == 0 {
+			return "", nil
+		}
+		return "", fmt.Errorf("elf: 字符串偏移%d超出串表大小%d", off, len(tab))
+	}
+	end := bytes.IndexByte(tab[off:], 0)
+	if end < 0 {
+		return "", errors.New("elf: 字符串没有以0结尾")
+	}
+	return string(tab[off : off+uint32(end)]), nil
+}
+
+// 待写入的段
+type outSec struct {
+	name string
+	hdr  Elf32_Shdr
+	data []byte
+}
+
+/*
+写出ELF文件，依次是: 文件头、程序头表、f.Sections、.shstrtab、.symtab、.strtab、.rel*、段表
+没有符号的可执行文件不生成.symtab和.strtab。NoShdrs时只写出文件头、程序头表和f.Sections的内容
+*/
+func (f *File) Write(w io.Writer) error {
+	secidx := map[*Section]int{}
+	outs := []*outSec{{}}
+	for i, s := range f.Sections {
+		secidx[s] = i + 1
+		size := s.Size
+		if s.Type != SHT_NOBITS {
+			size = uint32(len(s.Data))
+		}
+		outs = append(outs, &outSec{name: s.Name, data: s.Data, hdr: Elf32_Shdr{
+			SH_Type: s.Type, SH_Flags: s.Flags, SH_Addr: s.Addr, SH_Offset: s.Offset, SH_Size: size,
+			SH_Addralign: s.Align, SH_Link: s.Link, SH_Info: s.Info, SH_Entsize: s.Entsize,
+		}})
+	}
+	if f.NoShdrs && (f.Type == ET_REL || len(f.Symbols) != 0) {
+		return errors.New("elf: 只有没有符号的可执行文件可以不写出段表")
+	}
+	shstrtab := &outSec{name: ".shstrtab", hdr: Elf32_Shdr{SH_Type: SHT_STRTAB, SH_Addralign: 1}}
+	if !f.NoShdrs {
+		outs = append(outs, shstrtab)
+	}
+	shstrndx := len(outs) - 1
+	//符号表: 先局部符号，后全局符号
+	if len(f.Symbols) != 0 || f.Type == ET_REL {
+		symtab := &outSec{name: ".symtab", hdr: Elf32_Shdr{SH_Type: SHT_SYMTAB, SH_Addralign: 4, SH_Entsize: SymSize}}
+		strtab := &outSec{name: ".strtab", hdr: Elf32_Shdr{SH_Type: SHT_STRTAB, SH_Addralign: 1}, data: []byte{0}}
+		outs = append(outs, symtab, strtab)
+		symtab.hdr.SH_Link = uint32(len(outs) - 1)
+		symidx := map[*Symbol]int{}
+		sorted := []*Symbol{}
+		for _, s := range f.Symbols {
+			if s.Bind == STB_LOCAL {
+				sorted = append(sorted, s)
+			}
+		}
+		symtab.hdr.SH_Info = uint32(len(sorted) + 1)
+		for _, s := range f.Symbols {
+			if s.Bind != STB_LOCAL {
+				sorted = append(sorted, s)
+			}
+		}
+		symbuf := &bytes.Buffer{}
+		Write(symbuf, &Elf32_Sym{})
+		for i, s := range sorted {
+			symidx[s] = i + 1
+			st := &Elf32_Sym{
+				ST_Value: s.Value,
+				ST_Size:  s.Size,
+				ST_Info:  s.Bind<<4 | s.Type&0xf,
+				ST_Other: s.Visibility & 0x3,
+				ST_Shndx: s.Shndx,
+			}
+			if s.Section != nil {
+				idx, ok := secidx[s.Section]
+				if !ok {
+					return fmt.Errorf("elf: 符号%s所在的段%s不在文件中", s.Name, s.Section.Name)
+				}
+				st.ST_Shndx = uint16(idx)
+			}
+			if s.Type != STT_SECTION && s.Name != "" {
+				st.ST_Name = uint32(len(strtab.data))
+				strtab.data = append(append(strtab.data, s.Name...), 0)
+			}
+			Write(symbuf, st)
+		}
+		symtab.data = symbuf.Bytes()
+		//重定位表
+		symtabndx := uint32(len(outs) - 2)
+		for _, s := range f.Sections {
+			if len(s.Relocs) == 0 {
+				continue
+			}
+			relbuf := &bytes.Buffer{}
+			for _, r := range s.Relocs {
+				idx, ok := symidx[r.Sym]
+				if !ok {
+					return fmt.Errorf("elf: 段%s的重定位符号%s不在符号表中", s.Name, r.Sym.Name)
+				}
+				Write(relbuf, &Elf32_Rel{R_Offset: r.Offset, R_Info: uint32(idx)<<8 | uint32(r.Type)})
+			}
+			outs = append(outs, &outSec{name: ".rel" + s.Name, data: relbuf.Bytes(), hdr: Elf32_Shdr{
+				SH_Type: SHT_REL, SH_Link: symtabndx, SH_Info: uint32(secidx[s]), SH_Addralign: 4, SH_Entsize: RelSize,
+			}})
+		}
+	} else {
+		for _, s := range f.Sections {
+			if len(s.Relocs) != 0 {
+				return fmt.Errorf("elf: 没有符号表的文件不能有重定位项")
+			}
+		}
+	}
+	//段表字符串表
+	shstrtab.data = []byte{0}
+	for _, o := range outs[1:] {
+		o.hdr.SH_Name = uint32(len(shstrtab.data))
+		shstrtab.data = append(append(shstrtab.data, o.name...), 0)
+	}
+	//分配文件偏移
+	off := uint32(EhdrSize + PhdrSize*len(f.Progs))
+	for i, o := range outs[1:] {
+		if o.hdr.SH_Type == SHT_NOBITS {
+			if o.hdr.SH_Offset == 0 {
+				o.hdr.SH_Offset = align(off, o.hdr.SH_Addralign)
+			}
+			continue
+		}
+		if o.hdr.SH_Offset == 0 {
+			o.hdr.SH_Offset = align(off, o.hdr.SH_Addralign)
+		} else if o.hdr.SH_Offset < off {
+			return fmt.Errorf("elf: 段%s的偏移0x%x与之前的内容重叠", o.name, o.hdr.SH_Offset)
+		}
+		o.hdr.SH_Size = uint32(len(o.data))
+		off = o.hdr.SH_Offset + o.hdr.SH_Size
+		if i < len(f.Sections) {
+			f.Sections[i].Offset = o.hdr.SH_Offset
+		}
+	}
+	shoff := align(off, 4)
+	ehdr := &Elf32_Ehdr{
+		E_Ident:     Ident(),
+		E_Type:      f.Type,
+		E_Machine:   EM_386,
+		E_Version:   EV_CURRENT,
+		E_Entry:     f.Entry,
+		E_Shoff:     shoff,
+		E_Ehsize:    EhdrSize,
+		E_Shentsize: ShdrSize,
+		E_Shnum:     uint16(len(outs)),
+		E_Shstrndx:  uint16(shstrndx),
+	}
+	if f.NoShdrs {
+		ehdr.E_Shoff = 0
+		ehdr.E_Shentsize = 0
+		ehdr.E_Shnum = 0
+		ehdr.E_Shstrndx = 0
+	}
+	if len(f.Progs) != 0 {
+		ehdr.E_Phoff = EhdrSize
+		ehdr.E_Phentsize = PhdrSize
+		ehdr.E_Phnum = uint16(len(f.Progs))
+	}
+	buf := &bytes.Buffer{}
+	Write(buf, ehdr)
+	for _, p := range f.Progs {
+		Write(buf, &Elf32_Phdr{p.Type, p.Offset, p.Vaddr, p.Vaddr, p.Filesz, p.Memsz, p.Flags, p.Align})
+	}
+	for _, o := range outs[1:] {
+		if o.hdr.SH_Type == SHT_NOBITS {
+			continue
+		}
+		pad(buf, o.hdr.SH_Offset)
+		buf.Write(o.data)
+	}
+	if !f.NoShdrs {
+		pad(buf, shoff)
+		for _, o := range outs {
+			Write(buf, &o.hdr)
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func align(off, a uint32) uint32 {
+	if a <= 1 {
+		return off
+	}
+	return (off + a - 1) / a * a
+}
+
+// 用0填充到偏移off处
+func pad(buf *bytes.Buffer, off uint32) {
+	if n := int(off) - buf.Len(); n > 0 {
+		buf.Write(make([]byte, n))
+	}
+}
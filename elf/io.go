@@ -0,0 +1,76 @@
+package elf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 按小端序写入一个或一组ELF结构，不依赖Go的内存布局和主机字节序
+func Write(w io.Writer, data any) error {
+	return binary.Write(w, binary.LittleEndian, data)
+}
+
+// 从b的偏移off处按小端序读出一个ELF结构，越界时返回错误
+func ReadAt(b []byte, off uint32, data any) error {
+	sz := binary.Size(data)
+	if sz < 0 {
+		return errors.New("elf: 不支持的结构")
+	}
+	if uint64(off)+uint64(sz) > uint64(len(b)) {
+		return fmt.Errorf("elf: 偏移0x%x处的%d字节超出文件大小%d", off, sz, len(b))
+	}
+	return binary.Read(bytes.NewReader(b[off:off+uint32(sz)]), binary.LittleEndian, data)
+}
+
+// 读出并检查文件头: 只支持32位小端的i386文件
+func ReadEhdr(b []byte) (*Elf32_Ehdr, error) {
+	h := &Elf32_Ehdr{}
+	if err := ReadAt(b, 0, h); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(h.E_Ident[:4], ElfMagic[:]) {
+		return nil, errors.New("elf: 不是ELF文件")
+	}
+	if h.E_Ident[4] != ELFCLASS32 || h.E_Ident[5] != ELFDATA2LSB {
+		return nil, errors.New("elf: 只支持32位小端的ELF文件")
+	}
+	if h.E_Machine != EM_386 {
+		return nil, fmt.Errorf("elf: 不支持的机器类型%d", h.E_Machine)
+	}
+	if h.E_Shnum != 0 && h.E_Shentsize != ShdrSize {
+		return nil, fmt.Errorf("elf: 段表项大小%d错误", h.E_Shentsize)
+	}
+	if h.E_Phnum != 0 && h.E_Phentsize != PhdrSize {
+		return nil, fmt.Errorf("elf: 程序头表项大小%d错误", h.E_Phentsize)
+	}
+	if h.E_Shnum != 0 && h.E_Shstrndx >= h.E_Shnum {
+		return nil, fmt.Errorf("elf: 段表字符串表索引%d超出段数%d", h.E_Shstrndx, h.E_Shnum)
+	}
+	return h, nil
+}
+
+// 读出第i个段表项并检查段的内容是否在文件内
+func ReadShdr(b []byte, h *Elf32_Ehdr, i int) (*Elf32_Shdr, error) {
+	sh := &Elf32_Shdr{}
+	if err := ReadAt(b, h.E_Shoff+uint32(i)*ShdrSize, sh); err != nil {
+		return nil, err
+	}
+	if sh.SH_Type != SHT_NOBITS && uint64(sh.SH_Offset)+uint64(sh.SH_Size) > uint64(len(b)) {
+		return nil, fmt.Errorf("elf: 第%d个段超出文件大小", i)
+	}
+	if (sh.SH_Type == SHT_SYMTAB || sh.SH_Type == SHT_REL) && sh.SH_Entsize == 0 {
+		return nil, fmt.Errorf("elf: 第%d个段的表项大小为0", i)
+	}
+	return sh, nil
+}
+
+// 段的内容
+func SectionData(b []byte, sh *Elf32_Shdr) []byte {
+	if sh.SH_Type == SHT_NOBITS {
+		return nil
+	}
+	return b[sh.SH_Offset : sh.SH_Offset+sh.SH_Size]
+}
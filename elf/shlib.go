@@ -0,0 +1,87 @@
+package elf
+
+import (
+	"errors"
+	"fmt"
+)
+
+/*
+链接时需要的共享库信息: DT_SONAME和动态符号表。
+共享库中的符号没有对应的Section，Shndx是原始的段索引，SHN_UNDEF表示共享库引用了其他模块的符号
+*/
+type Shlib struct {
+	Soname  string
+	Symbols []*Symbol //不含空符号
+}
+
+// 解析共享库的.dynsym和.dynamic，共享库必须有段表
+func ReadShlib(b []byte) (*Shlib, error) {
+	ehdr, err := ReadEhdr(b)
+	if err != nil {
+		return nil, err
+	}
+	if ehdr.E_Type != ET_DYN {
+		return nil, errors.New("elf: 不是共享库")
+	}
+	if ehdr.E_Shnum == 0 {
+		return nil, errors.New("elf: 共享库没有段表")
+	}
+	shdrs := []*Elf32_Shdr{}
+	for i := 0; i < int(ehdr.E_Shnum); i++ {
+		sh, err := ReadShdr(b, ehdr, i)
+		if err != nil {
+			return nil, err
+		}
+		shdrs = append(shdrs, sh)
+	}
+	lib := &Shlib{}
+	for _, sh := range shdrs {
+		if sh.SH_Type != SHT_DYNSYM && sh.SH_Type != SHT_DYNAMIC {
+			continue
+		}
+		if int(sh.SH_Link) >= len(shdrs) {
+			return nil, fmt.Errorf("elf: 动态符号表的串表索引%d错误", sh.SH_Link)
+		}
+		strtab := SectionData(b, shdrs[sh.SH_Link])
+		if sh.SH_Type == SHT_DYNAMIC {
+			for off := uint32(0); off+DynSize <= sh.SH_Size; off += DynSize {
+				d := &Elf32_Dyn{}
+				if err := ReadAt(b, sh.SH_Offset+off, d); err != nil {
+					return nil, err
+				}
+				if d.D_Tag == DT_NULL {
+					break
+				}
+				if d.D_Tag == DT_SONAME {
+					if lib.Soname, err = cstr(strtab, d.D_Val); err != nil {
+						return nil, err
+					}
+				}
+			}
+			continue
+		}
+		if sh.SH_Entsize == 0 {
+			return nil, errors.New("elf: 动态符号表的表项大小为0")
+		}
+		for i := uint32(1); i < sh.SH_Size/sh.SH_Entsize; i++ {
+			st := &Elf32_Sym{}
+			if err := ReadAt(b, sh.SH_Offset+i*sh.SH_Entsize, st); err != nil {
+				return nil, err
+			}
+			name, err := cstr(strtab, st.ST_Name)
+			if err != nil {
+				return nil, err
+			}
+			lib.Symbols = append(lib.Symbols, &Symbol{
+				Name:       name,
+				Value:      st.ST_Value,
+				Size:       st.ST_Size,
+				Bind:       st.ST_Info >> 4,
+				Type:       st.ST_Info & 0xf,
+				Visibility: st.ST_Other & 0x3,
+				Shndx:      st.ST_Shndx,
+			})
+		}
+	}
+	return lib, nil
+}
@@ -0,0 +1,84 @@
+package elf
+
+// ELF32文件中的结构，字段顺序和大小与文件格式一致，按小端序读写
+
+type Elf32_Ehdr struct {
+	E_Ident     [16]byte
+	E_Type      uint16
+	E_Machine   uint16
+	E_Version   uint32
+	E_Entry     uint32
+	E_Phoff     uint32
+	E_Shoff     uint32
+	E_Flags     uint32
+	E_Ehsize    uint16
+	E_Phentsize uint16
+	E_Phnum     uint16
+	E_Shentsize uint16
+	E_Shnum     uint16
+	E_Shstrndx  uint16
+}
+
+type Elf32_Phdr struct {
+	P_Type   uint32
+	P_Offset uint32
+	P_Vaddr  uint32
+	P_Paddr  uint32
+	P_FileSZ uint32
+	P_MemSZ  uint32
+	P_Flags  uint32
+	P_Align  uint32
+}
+
+type Elf32_Shdr struct {
+	SH_Name      uint32 //段名偏移量：相对于字符串表
+	SH_Type      uint32 //1:SHT_PROGBITS 2:SHT_SYMTAB 3:SHT_STRTAB 8:SHT_NOBITS 9:SHT_REL
+	SH_Flags     uint32 //1:SHF_WRITE 2:SHF_ALLOC 4:SHF_EXECINSTR
+	SH_Addr      uint32 //relocatable object file: 0, executable object file: 线性地址
+	SH_Offset    uint32 //段偏移量: 相对于ELF文件开始
+	SH_Size      uint32 //段的大小
+	SH_Link      uint32 //段的链接信息。对于符号表，link是串表的索引；info是第一个全局符号的的索引
+	SH_Info      uint32 //段的链接信息。对于重定位表，link是符号表的索引；info是代码段或数据段的索引
+	SH_Addralign uint32 //sh_offset%sh_addralign == 0
+	SH_Entsize   uint32 //表类型的段的一行的大小
+}
+
+type Elf32_Sym struct {
+	ST_Name  uint32
+	ST_Value uint32 //relocatable object file: 符号偏移量：相对段基址；executable object file: 符号线性地址
+	ST_Size  uint32 //符号大小: 以字节为单位
+	ST_Info  uint8  /* 低四位为符号类型。0:STT_NOTYPE 1:STT_OBJECT 2:STT_FUNC 3:STT:SECTION 4:STT_FILE。 高四位为符号绑定信息。0:STB_LOCAL 1:STB_GLOBAL 2:STB_WEAK */
+	ST_Other uint8  //无用
+	ST_Shndx uint16 //符号所在段。0:SHN_UNDEF 0xfff1:SHN_ABS 0xfff2:SHN_COMMON
+}
+
+type Elf32_Rel struct {
+	R_Offset uint32 //relocatable object file: 重定位位置偏移量，相对于段基址。executable object file: 重定位位置的线性地址，与动态链接相关
+	R_Info   uint32 //低8:重定位类型, 高24:重定位符号索引
+}
+
+// .dynamic段的表项
+type Elf32_Dyn struct {
+	D_Tag int32  //DT_*
+	D_Val uint32 //值或者地址
+}
+
+// 各结构在文件中的大小
+const (
+	EhdrSize = 52
+	PhdrSize = 32
+	ShdrSize = 40
+	SymSize  = 16
+	RelSize  = 8
+	DynSize  = 8
+)
+
+// 32位、小端、当前版本的E_Ident
+func Ident() [16]byte {
+	var id [16]byte
+	copy(id[:], ElfMagic[:])
+	id[4] = ELFCLASS32
+	id[5] = ELFDATA2LSB
+	id[6] = EV_CURRENT
+	return id
+}
@@ -2,8 +2,12 @@ package lexical
 
 import (
 	"bufio"
+	"bytes"
+	"calgo/preprocess"
 	"fmt"
+	"math"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -23,13 +27,34 @@ var kwords = map[string]TokenType{
 	"break":    KW_BREAK,
 	"continue": KW_CONINUE,
 	"return":   KW_RETURN,
+	"unsigned": KW_UNSIGNED,
+	"signed":   KW_SIGNED,
+	"short":    KW_SHORT,
+	"long":     KW_LONG,
+	"float":    KW_FLOAT,
+	"double":   KW_DOUBLE,
+	"va_list":  KW_VA_LIST,
+	"va_start": KW_VA_START,
+	"va_arg":   KW_VA_ARG,
+	"va_end":   KW_VA_END,
+	"typedef":  KW_TYPEDEF,
+	"enum":     KW_ENUM,
+	"const":    KW_CONST,
 }
 
 var TypeTable = map[TokenType]string{
-	0:       "NoType",
-	KW_INT:  "int",
-	KW_CHAR: "char",
-	KW_VOID: "void",
+	0:         "NoType",
+	KW_INT:    "int",
+	KW_CHAR:   "char",
+	KW_VOID:   "void",
+	KW_UCHAR:  "unsigned char",
+	KW_SHORT:  "short",
+	KW_USHORT: "unsigned short",
+	KW_UINT:   "unsigned int",
+	KW_LLONG:  "long long",
+	KW_ULLONG: "unsigned long long",
+	KW_FLOAT:  "float",
+	KW_DOUBLE: "double",
 }
 
 var lexErrorTable = map[string]string{
@@ -43,19 +68,24 @@ type Lexer struct {
 	colNum   int
 	filename string
 	newline  bool
+	src      *preprocess.Source //预处理的结果，用来把行号映射回源文件
 }
 
+// 当前位置在源文件中的文件名和行号，列号是在预处理之后的行中的位置
 func (l *Lexer) GetPosition() (string, int, int) {
-	return l.filename, l.lineNum, l.colNum
+	pos := l.src.Position(l.lineNum)
+	return pos.File, pos.Line, l.colNum
 }
 
 func NewLexer(filename string) *Lexer {
-	file, err := os.Open(filename)
+	src, err := preprocess.File(filename)
 	if err != nil {
-		return nil
+		fmt.Printf("预处理错误:%v\n", err)
+		os.Exit(1)
 	}
 	lexer := &Lexer{
-		scanner:  bufio.NewReader(file),
+		scanner:  bufio.NewReader(bytes.NewReader(src.Text)),
+		src:      src,
 		filename: filename,
 		lineNum:  0,
 		colNum:   0,
@@ -66,7 +96,8 @@ func NewLexer(filename string) *Lexer {
 }
 
 func (l *Lexer) Error(errtk Token) Token {
-	fmt.Printf("词法错误:%s in %s:<%d, %d>\n", errtk.String(), l.filename, l.lineNum, l.colNum)
+	filename, lineNum, colNum := l.GetPosition()
+	fmt.Printf("词法错误:%s in %s:<%d, %d>\n", errtk.String(), filename, lineNum, colNum)
 	os.Exit(0)
 	return errtk
 }
@@ -105,9 +136,9 @@ func (l *Lexer) NextToken() Token {
 						if isDigit(l.ch) {
 							v += int64(l.ch - '0')
 						} else if l.ch >= 'A' && l.ch <= 'F' {
-							v += int64(l.ch - 'A')
+							v += int64(l.ch-'A') + 10
 						} else {
-							v += int64(l.ch - 'a')
+							v += int64(l.ch-'a') + 10
 						}
 						l.NextChar()
 						for isDigit(l.ch) || l.ch >= 'A' && l.ch <= 'F' || l.ch >= 'a' && l.ch <= 'f' {
@@ -116,13 +147,13 @@ func (l *Lexer) NextToken() Token {
 							if isDigit(l.ch) {
 								v += int64(l.ch - '0')
 							} else if l.ch >= 'A' && l.ch <= 'F' {
-								v += int64(l.ch - 'A')
+								v += int64(l.ch-'A') + 10
 							} else {
-								v += int64(l.ch - 'a')
+								v += int64(l.ch-'a') + 10
 							}
 							l.NextChar()
 						}
-						return &TNUM{Type: NUM, Name: builder.String(), Value: v}
+						return l.number(builder.String(), v, false)
 
 					} else {
 						return l.Error(&TERR{Type: ERR, Name: "十六进制没有实体数据"})
@@ -139,7 +170,7 @@ func (l *Lexer) NextToken() Token {
 							v = v*2 + int64(l.ch-'0')
 							l.NextChar()
 						}
-						return &TNUM{Type: NUM, Name: builder.String(), Value: v}
+						return l.number(builder.String(), v, false)
 					} else {
 						return l.Error(&TERR{Type: ERR, Name: "二进制没有实体数据"})
 					}
@@ -149,9 +180,11 @@ func (l *Lexer) NextToken() Token {
 						v = v*8 + int64(l.ch-'0')
 						l.NextChar()
 					}
-					return &TNUM{Type: NUM, Name: builder.String(), Value: v}
+					return l.number(builder.String(), v, false)
+				} else if l.ch == '.' || l.ch == 'e' || l.ch == 'E' {
+					return l.fnumber(&builder)
 				} else {
-					return &TNUM{Type: NUM, Name: "0", Value: 0}
+					return l.number("0", 0, false)
 				}
 			} else { //十进制
 				builder.WriteByte(l.ch)
@@ -162,7 +195,10 @@ func (l *Lexer) NextToken() Token {
 					v = v*10 + int64(l.ch-'0')
 					l.NextChar()
 				}
-				return &TNUM{Type: NUM, Name: builder.String(), Value: v}
+				if l.ch == '.' || l.ch == 'e' || l.ch == 'E' {
+					return l.fnumber(&builder)
+				}
+				return l.number(builder.String(), v, true)
 			}
 		} else if c == '\'' {
 			var ch byte
@@ -232,8 +268,11 @@ func (l *Lexer) NextToken() Token {
 					} else if l.ch == '"' {
 						builder.WriteByte('"')
 						l.NextChar()
+					} else if l.ch == '\\' {
+						builder.WriteByte('\\')
+						l.NextChar()
 					} else {
-						return l.Error(&TERR{Type: ERR, Name: "不合法的转义字符: 只能转义:t,n,\""})
+						return l.Error(&TERR{Type: ERR, Name: "不合法的转义字符: 只能转义:t,n,\",\\"})
 					}
 				} else if l.ch == '"' {
 					l.NextChar()
@@ -250,6 +289,9 @@ func (l *Lexer) NextToken() Token {
 				if l.ch == '+' {
 					l.NextChar()
 					return &TINC{Type: INC, Name: "++"}
+				} else if l.ch == '=' {
+					l.NextChar()
+					return &TOPASSIGN{Type: ADD_ASSIGN, Name: "+="}
 				} else {
 					return &TADD{Type: ADD, Name: "+"}
 				}
@@ -258,38 +300,76 @@ func (l *Lexer) NextToken() Token {
 				if l.ch == '-' {
 					l.NextChar()
 					return &TDEC{Type: DEC, Name: "--"}
+				} else if l.ch == '=' {
+					l.NextChar()
+					return &TOPASSIGN{Type: SUB_ASSIGN, Name: "-="}
 				} else {
 					return &TSUB{Type: SUB, Name: "-"}
 				}
 			case '*':
 				l.NextChar()
+				if l.ch == '=' {
+					l.NextChar()
+					return &TOPASSIGN{Type: MUL_ASSIGN, Name: "*="}
+				}
 				return &TMUL{Type: MUL, Name: "*"}
 			case '/':
 				l.NextChar()
+				if l.ch == '=' {
+					l.NextChar()
+					return &TOPASSIGN{Type: DIV_ASSIGN, Name: "/="}
+				}
 				return &TDIV{Type: DIV, Name: "/"}
 			case '%':
 				l.NextChar()
+				if l.ch == '=' {
+					l.NextChar()
+					return &TOPASSIGN{Type: MOD_ASSIGN, Name: "%="}
+				}
 				return &TMOD{Type: MOD, Name: "%"}
-			case '&':
+			case '&': //单独的&既是取地址也是按位与，由语法分析区分
 				l.NextChar()
 				if l.ch == '&' {
 					l.NextChar()
 					return &TAND{Type: AND, Name: "&&"}
+				} else if l.ch == '=' {
+					l.NextChar()
+					return &TOPASSIGN{Type: AND_ASSIGN, Name: "&="}
 				} else {
 					return &TLEA{Type: LEA, Name: "&"}
 				}
 			case '|':
 				l.NextChar()
-				if l.ch != '|' {
-					return &TERR{Type: ERR, Name: "不存在词法记号: '|'"}
+				if l.ch == '|' {
+					l.NextChar()
+					return &TOR{Type: OR, Name: "||"}
+				} else if l.ch == '=' {
+					l.NextChar()
+					return &TOPASSIGN{Type: OR_ASSIGN, Name: "|="}
+				}
+				return &TBOR{Type: BOR, Name: "|"}
+			case '^':
+				l.NextChar()
+				if l.ch == '=' {
+					l.NextChar()
+					return &TOPASSIGN{Type: XOR_ASSIGN, Name: "^="}
 				}
+				return &TXOR{Type: XOR, Name: "^"}
+			case '~':
 				l.NextChar()
-				return &TOR{Type: OR, Name: "||"}
+				return &TBNOT{Type: BNOT, Name: "~"}
 			case '>':
 				l.NextChar()
 				if l.ch == '=' {
 					l.NextChar()
 					return &TGE{Type: GE, Name: ">="}
+				} else if l.ch == '>' {
+					l.NextChar()
+					if l.ch == '=' {
+						l.NextChar()
+						return &TOPASSIGN{Type: SHR_ASSIGN, Name: ">>="}
+					}
+					return &TSHR{Type: SHR, Name: ">>"}
 				} else {
 					return &TGT{Type: GT, Name: ">"}
 				}
@@ -298,6 +378,13 @@ func (l *Lexer) NextToken() Token {
 				if l.ch == '=' {
 					l.NextChar()
 					return &TLE{Type: LE, Name: "<="}
+				} else if l.ch == '<' {
+					l.NextChar()
+					if l.ch == '=' {
+						l.NextChar()
+						return &TOPASSIGN{Type: SHL_ASSIGN, Name: "<<="}
+					}
+					return &TSHL{Type: SHL, Name: "<<"}
 				} else {
 					return &TLT{Type: LT, Name: "<"}
 				}
@@ -323,6 +410,9 @@ func (l *Lexer) NextToken() Token {
 			case ':':
 				l.NextChar()
 				return &TCOLON{Type: COLON, Name: ":"}
+			case '?':
+				l.NextChar()
+				return &TQUESTION{Type: QUESTION, Name: "?"}
 			case ';':
 				l.NextChar()
 				return &TSEMICOLON{Type: SEMICOLON, Name: ";"}
@@ -338,6 +428,21 @@ func (l *Lexer) NextToken() Token {
 			case ']':
 				l.NextChar()
 				return &TRBRACK{Type: RBRACK, Name: "]"}
+			case '.': //.5这样的浮点数，或者...
+				builder.WriteByte(l.ch)
+				l.NextChar()
+				if l.ch == '.' {
+					l.NextChar()
+					if l.ch != '.' {
+						return l.Error(&TERR{Type: ERR, Name: "词法记号不存在"})
+					}
+					l.NextChar()
+					return &TELLIPSIS{Type: ELLIPSIS, Name: "..."}
+				}
+				if !isDigit(l.ch) {
+					return l.Error(&TERR{Type: ERR, Name: "词法记号不存在"})
+				}
+				return l.fnumber(&builder)
 			case '{':
 				l.NextChar()
 				return &TLBRACE{Type: LBRACE, Name: "{"}
@@ -354,6 +459,84 @@ func (l *Lexer) NextToken() Token {
 	}
 }
 
+/*
+整数字面量的后缀和类型。long和int一样是32位，所以l后缀和没有后缀相同:
+没有后缀: int, long long；八进制、十六进制和二进制还可以是unsigned int和unsigned long long
+u: unsigned int, unsigned long long
+ll: long long, unsigned long long(八进制、十六进制和二进制)
+ull: unsigned long long
+*/
+func (l *Lexer) number(name string, v int64, dec bool) Token {
+	suffix := ""
+	for l.ch == 'u' || l.ch == 'U' || l.ch == 'l' || l.ch == 'L' {
+		suffix += string(l.ch)
+		l.NextChar()
+	}
+	s := strings.ToLower(suffix)
+	switch s {
+	case "", "u", "l", "ul", "lu", "ll", "ull", "llu":
+	default:
+		return l.Error(&TERR{Type: ERR, Name: "非法的整数后缀" + suffix})
+	}
+	uns, ll := strings.Contains(s, "u"), strings.Contains(s, "ll")
+	typ := KW_ULLONG //超出int64的十六进制数是负数
+	if uns && !ll && v >= 0 && v <= math.MaxUint32 {
+		typ = KW_UINT
+	} else if !uns && !ll && v >= 0 && v <= math.MaxInt32 {
+		typ = KW_INT
+	} else if !uns && !ll && !dec && v >= 0 && v <= math.MaxUint32 {
+		typ = KW_UINT
+	} else if !uns && (v >= 0 || dec) {
+		typ = KW_LLONG
+	}
+	return &TNUM{Type: NUM, Name: name + suffix, Value: v, Typ: typ}
+}
+
+/*
+浮点数字面量: 整数部分后面跟小数部分或指数部分，例如1.5、.5、1.、1e10、2.5e-3。
+builder中是已经读入的整数部分或小数点。f后缀是float，没有后缀是double
+*/
+func (l *Lexer) fnumber(builder *strings.Builder) Token {
+	if l.ch == '.' {
+		builder.WriteByte(l.ch)
+		l.NextChar()
+	}
+	for isDigit(l.ch) {
+		builder.WriteByte(l.ch)
+		l.NextChar()
+	}
+	if l.ch == 'e' || l.ch == 'E' {
+		builder.WriteByte(l.ch)
+		l.NextChar()
+		if l.ch == '+' || l.ch == '-' {
+			builder.WriteByte(l.ch)
+			l.NextChar()
+		}
+		if !isDigit(l.ch) {
+			return l.Error(&TERR{Type: ERR, Name: "浮点数的指数部分没有数字"})
+		}
+		for isDigit(l.ch) {
+			builder.WriteByte(l.ch)
+			l.NextChar()
+		}
+	}
+	name := builder.String()
+	v, err := strconv.ParseFloat(name, 64)
+	if err != nil {
+		return l.Error(&TERR{Type: ERR, Name: "浮点数超出范围"})
+	}
+	typ := KW_DOUBLE
+	if l.ch == 'f' || l.ch == 'F' {
+		name += string(l.ch)
+		l.NextChar()
+		typ = KW_FLOAT
+		v = float64(float32(v))
+	} else if l.ch == 'l' || l.ch == 'L' {
+		return l.Error(&TERR{Type: ERR, Name: "不支持long double"})
+	}
+	return &TFNUM{Type: FNUM, Name: name, Value: v, Typ: typ}
+}
+
 func isAlpha(c byte) bool {
 	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
 }
@@ -22,6 +22,14 @@ type TNUM struct {
 	Type  TokenType
 	Name  string
 	Value int64
+	Typ   TokenType //字面量的类型: KW_INT, KW_UINT, KW_LLONG或KW_ULLONG
+}
+
+type TFNUM struct {
+	Type  TokenType
+	Name  string
+	Value float64
+	Typ   TokenType //字面量的类型: KW_FLOAT或KW_DOUBLE
 }
 
 type TCHAR struct {
@@ -198,6 +206,55 @@ type TRBRACE struct {
 	Value string
 }
 
+type TBOR struct {
+	Type  TokenType
+	Name  string
+	Value string
+}
+
+type TXOR struct {
+	Type  TokenType
+	Name  string
+	Value string
+}
+
+type TBNOT struct {
+	Type  TokenType
+	Name  string
+	Value string
+}
+
+type TSHL struct {
+	Type  TokenType
+	Name  string
+	Value string
+}
+
+type TSHR struct {
+	Type  TokenType
+	Name  string
+	Value string
+}
+
+// 复合赋值: += -= *= /= %= &= |= ^= <<= >>=，Type区分运算
+type TOPASSIGN struct {
+	Type  TokenType
+	Name  string
+	Value string
+}
+
+type TQUESTION struct {
+	Type  TokenType
+	Name  string
+	Value string
+}
+
+type TELLIPSIS struct {
+	Type  TokenType
+	Name  string
+	Value string
+}
+
 type TERR struct {
 	Type  TokenType
 	Name  string
@@ -218,6 +275,10 @@ func (T *TNUM) String() string {
 	return fmt.Sprintf("<%s, %s, %d>", tokenTypeTable[T.Type], T.Name, T.Value)
 }
 
+func (T *TFNUM) String() string {
+	return fmt.Sprintf("<%s, %s, %g>", tokenTypeTable[T.Type], T.Name, T.Value)
+}
+
 func (T *TCHAR) String() string {
 	return fmt.Sprintf("<%s, %s, %d>:", tokenTypeTable[T.Type], T.Name, T.Value)
 }
@@ -233,6 +294,8 @@ func (T *TSTR) String() string {
 			builder.WriteString("\\t")
 		case '"':
 			builder.WriteString("\\\"")
+		case '\\':
+			builder.WriteString("\\\\")
 		default:
 			builder.WriteByte(c)
 		}
@@ -356,6 +419,38 @@ func (T *TRBRACE) String() string {
 	return fmt.Sprintf("<%s, %s>:", tokenTypeTable[T.Type], T.Name)
 }
 
+func (T *TBOR) String() string {
+	return fmt.Sprintf("<%s, %s>:", tokenTypeTable[T.Type], T.Name)
+}
+
+func (T *TXOR) String() string {
+	return fmt.Sprintf("<%s, %s>:", tokenTypeTable[T.Type], T.Name)
+}
+
+func (T *TBNOT) String() string {
+	return fmt.Sprintf("<%s, %s>:", tokenTypeTable[T.Type], T.Name)
+}
+
+func (T *TSHL) String() string {
+	return fmt.Sprintf("<%s, %s>:", tokenTypeTable[T.Type], T.Name)
+}
+
+func (T *TSHR) String() string {
+	return fmt.Sprintf("<%s, %s>:", tokenTypeTable[T.Type], T.Name)
+}
+
+func (T *TQUESTION) String() string {
+	return fmt.Sprintf("<%s, %s>:", tokenTypeTable[T.Type], T.Name)
+}
+
+func (T *TELLIPSIS) String() string {
+	return fmt.Sprintf("<%s, %s>:", tokenTypeTable[T.Type], T.Name)
+}
+
+func (T *TOPASSIGN) String() string {
+	return fmt.Sprintf("<%s, %s>:", tokenTypeTable[T.Type], T.Name)
+}
+
 // 关键字和标识符
 func (T *TID) TokenTyp() TokenType {
 	return T.Type
@@ -365,6 +460,10 @@ func (T *TNUM) TokenTyp() TokenType {
 	return NUM
 }
 
+func (T *TFNUM) TokenTyp() TokenType {
+	return FNUM
+}
+
 func (T *TCHAR) TokenTyp() TokenType {
 	return CHAR
 }
@@ -489,6 +588,38 @@ func (T *TRBRACE) TokenTyp() TokenType {
 	return RBRACE
 }
 
+func (T *TBOR) TokenTyp() TokenType {
+	return BOR
+}
+
+func (T *TXOR) TokenTyp() TokenType {
+	return XOR
+}
+
+func (T *TBNOT) TokenTyp() TokenType {
+	return BNOT
+}
+
+func (T *TSHL) TokenTyp() TokenType {
+	return SHL
+}
+
+func (T *TSHR) TokenTyp() TokenType {
+	return SHR
+}
+
+func (T *TQUESTION) TokenTyp() TokenType {
+	return QUESTION
+}
+
+func (T *TELLIPSIS) TokenTyp() TokenType {
+	return ELLIPSIS
+}
+
+func (T *TOPASSIGN) TokenTyp() TokenType {
+	return T.Type
+}
+
 const (
 	_             = iota
 	ERR TokenType = iota
@@ -539,6 +670,47 @@ const (
 	RBRACK
 	LBRACE
 	RBRACE
+	BOR
+	XOR
+	BNOT
+	SHL
+	SHR
+	ADD_ASSIGN
+	SUB_ASSIGN
+	MUL_ASSIGN
+	DIV_ASSIGN
+	MOD_ASSIGN
+	AND_ASSIGN
+	OR_ASSIGN
+	XOR_ASSIGN
+	SHL_ASSIGN
+	SHR_ASSIGN
+	QUESTION
+	KW_UNSIGNED
+	KW_SIGNED
+	KW_SHORT
+	KW_LONG
+	KW_FLOAT
+	KW_DOUBLE
+	FNUM
+	ELLIPSIS
+	KW_VA_LIST
+	KW_VA_START
+	KW_VA_ARG
+	KW_VA_END
+	KW_TYPEDEF
+	KW_ENUM
+	KW_CONST
+)
+
+// 由类型说明符组合成的类型，不是单词，只作为变量和函数的类型。
+// signed char、short和int分别是KW_CHAR、KW_SHORT和KW_INT，long和int相同
+const (
+	KW_UCHAR TokenType = iota + 100
+	KW_USHORT
+	KW_UINT
+	KW_LLONG
+	KW_ULLONG
 )
 
 var tokenTypeTable = map[TokenType]string{
@@ -590,4 +762,35 @@ var tokenTypeTable = map[TokenType]string{
 	46: "RBRACK",
 	47: "LBRACE",
 	48: "RBRACE",
+	49: "BOR",
+	50: "XOR",
+	51: "BNOT",
+	52: "SHL",
+	53: "SHR",
+	54: "ADD_ASSIGN",
+	55: "SUB_ASSIGN",
+	56: "MUL_ASSIGN",
+	57: "DIV_ASSIGN",
+	58: "MOD_ASSIGN",
+	59: "AND_ASSIGN",
+	60: "OR_ASSIGN",
+	61: "XOR_ASSIGN",
+	62: "SHL_ASSIGN",
+	63: "SHR_ASSIGN",
+	64: "QUESTION",
+	65: "KW_UNSIGNED",
+	66: "KW_SIGNED",
+	67: "KW_SHORT",
+	68: "KW_LONG",
+	69: "KW_FLOAT",
+	70: "KW_DOUBLE",
+	71: "FloatNumber",
+	72: "ELLIPSIS",
+	73: "KW_VA_LIST",
+	74: "KW_VA_START",
+	75: "KW_VA_ARG",
+	76: "KW_VA_END",
+	77: "KW_TYPEDEF",
+	78: "KW_ENUM",
+	79: "KW_CONST",
 }
@@ -0,0 +1,707 @@
+package link
+
+import (
+	"bytes"
+	"calgo/elf"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 位置无关代码通过它得到GOT的地址
+const GotSym = "_GLOBAL_OFFSET_TABLE_"
+
+// .dynamic段的地址
+const DynamicSym = "_DYNAMIC"
+
+// GOT的前三项保留: 第一项是.dynamic的地址，后两项留给动态链接器
+const GotReserved = 3
+
+// 每个PLT项的大小
+const PltEntSize = 16
+
+// i386的动态链接器
+const DefaultInterp = "/lib/ld-linux.so.2"
+
+// 默认在这些目录中查找-needed声明的共享库
+var DefaultLibDirs = []string{"/lib/i386-linux-gnu", "/usr/lib/i386-linux-gnu", "/lib32", "/usr/lib32", "/lib", "/usr/lib"}
+
+/*
+动态链接用的段由链接器合成，放在一个虚拟的输入文件中，和其他输入段一样按链接脚本布局:
+.interp: 动态链接的可执行文件使用的动态链接器
+.hash、.dynsym、.dynstr: 动态符号表，包括共享库导出的符号和运行时才能解析的导入符号
+.rel.dyn: 装载时的重定位；.rel.plt: PLT使用的GOT项的重定位
+.plt: 调用导入函数的桩代码
+.got: 全局偏移表，依次是保留项、PLT使用的项和@GOT引用的符号的项
+.dynamic: 动态链接器需要的信息
+位置无关代码通过_GLOBAL_OFFSET_TABLE_定位数据，所以静态链接位置无关代码时也需要.got，此时只合成.got
+*/
+type DynInfo struct {
+	obj      *elf.File
+	secs     map[string]*elf.Section
+	dynamic  bool                   //生成共享库或动态链接的可执行文件
+	shared   bool                   //生成共享库，装载时所有的绝对地址都需要重定位
+	defs     map[string]*elf.Symbol //全局符号 -> 符号解析选出的定义
+	imports  []*elf.Symbol          //导入符号，按第一次引用的顺序
+	importof map[string]*elf.Symbol
+	exports  []*elf.Symbol //导出符号
+	got      []*elf.Symbol //@GOT引用的符号
+	gotidx   map[*elf.Symbol]int
+	plt      []*elf.Symbol //通过PLT调用的导入符号
+	pltidx   map[*elf.Symbol]int
+	nreldyn  int //.rel.dyn的项数
+	reldyn   *bytes.Buffer
+	textrel  bool //只读段中有装载时的重定位
+	dynstr   []byte
+	stroff   map[string]uint32
+}
+
+// 合成段的名字、类型、权限、对齐和表项大小，按这个顺序出现在默认的链接脚本中
+var dynSecs = []struct {
+	name    string
+	typ     uint32
+	flags   uint32
+	align   uint32
+	entsize uint32
+}{
+	{".interp", elf.SHT_PROGBITS, elf.SHF_ALLOC, 1, 0},
+	{".hash", elf.SHT_HASH, elf.SHF_ALLOC, 4, 4},
+	{".dynsym", elf.SHT_DYNSYM, elf.SHF_ALLOC, 4, elf.SymSize},
+	{".dynstr", elf.SHT_STRTAB, elf.SHF_ALLOC, 1, 0},
+	{".rel.dyn", elf.SHT_REL, elf.SHF_ALLOC, 4, elf.RelSize},
+	{".rel.plt", elf.SHT_REL, elf.SHF_ALLOC | elf.SHF_INFO_LINK, 4, elf.RelSize},
+	{".plt", elf.SHT_PROGBITS, elf.SHF_ALLOC | elf.SHF_EXECINSTR, 16, 0},
+	{".dynamic", elf.SHT_DYNAMIC, elf.SHF_ALLOC | elf.SHF_WRITE, 4, elf.DynSize},
+	{".got", elf.SHT_PROGBITS, elf.SHF_ALLOC | elf.SHF_WRITE, 4, 4},
+}
+
+// 输入的共享库: 它定义的符号解析可执行文件的未定义符号，它引用的符号由可执行文件导出
+type SharedLib struct {
+	name   string
+	soname string //DT_NEEDED使用的名字，共享库没有DT_SONAME时是文件名
+	defs   map[string]bool
+	refs   map[string]bool
+}
+
+// 读入共享库的动态符号表，链接时只检查符号，不复制内容
+func (l *Linker) AddShlib(f string, b []byte) {
+	so, err := elf.ReadShlib(b)
+	if err != nil {
+		log.Fatalf("AddShlib %s: %v", f, err)
+	}
+	lib := &SharedLib{name: f, soname: so.Soname, defs: map[string]bool{}, refs: map[string]bool{}}
+	if lib.soname == "" {
+		lib.soname = filepath.Base(f)
+	}
+	for _, sym := range so.Symbols {
+		if sym.Bind == elf.STB_LOCAL || sym.Name == "" {
+			continue
+		}
+		if sym.Shndx == elf.SHN_UNDEF {
+			lib.refs[sym.Name] = true
+		} else {
+			lib.defs[sym.Name] = true
+		}
+	}
+	l.shlibs = append(l.shlibs, lib)
+}
+
+// 输出动态链接的可执行文件: 输入了共享库或者声明了运行时依赖
+func (l *Linker) dynamicExe() bool {
+	return !l.Shared && (len(l.shlibs) != 0 || len(l.Needed) != 0)
+}
+
+/*
+没有定义的全局符号能否在运行时解析: 生成共享库，或者输入的共享库、-needed声明的共享库定义了它。
+-needed声明的共享库在LibDirs中查找，找不到时不能检查，警告之后当作能解析
+*/
+func (l *Linker) importable(name string) bool {
+	if l.Shared {
+		return true
+	}
+	for _, lib := range l.shlibs {
+		if lib.defs[name] {
+			return true
+		}
+	}
+	if l.neededDefs == nil {
+		l.loadNeeded()
+	}
+	if l.neededDefs[name] {
+		return true
+	}
+	if len(l.unchecked) != 0 {
+		if !l.warned[name] {
+			l.warned[name] = true
+			log.Printf("警告: 没有找到共享库%s，不检查符号%s能否在运行时解析", strings.Join(l.unchecked, ", "), name)
+		}
+		return true
+	}
+	return false
+}
+
+// 在LibDirs中查找并读入-needed声明的共享库的动态符号表，跳过不是i386共享库的同名文件
+func (l *Linker) loadNeeded() {
+	l.neededDefs = map[string]bool{}
+	l.warned = map[string]bool{}
+	for _, n := range l.Needed {
+		found := false
+		for _, dir := range l.LibDirs {
+			b, err := os.ReadFile(filepath.Join(dir, n))
+			if err != nil {
+				continue
+			}
+			so, err := elf.ReadShlib(b)
+			if err != nil {
+				continue
+			}
+			for _, sym := range so.Symbols {
+				if sym.Bind != elf.STB_LOCAL && sym.Name != "" && sym.Shndx != elf.SHN_UNDEF {
+					l.neededDefs[sym.Name] = true
+				}
+			}
+			found = true
+			break
+		}
+		if !found {
+			l.unchecked = append(l.unchecked, n)
+		}
+	}
+}
+
+// DT_NEEDED: 输入的共享库和声明的依赖，按出现的顺序，去掉重复的
+func (l *Linker) needed() []string {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, lib := range l.shlibs {
+		if !seen[lib.soname] {
+			seen[lib.soname] = true
+			names = append(names, lib.soname)
+		}
+	}
+	for _, n := range l.Needed {
+		if !seen[n] {
+			seen[n] = true
+			names = append(names, n)
+		}
+	}
+	return names
+}
+
+// 需要合成段时，生成虚拟的输入文件。它定义_GLOBAL_OFFSET_TABLE_和_DYNAMIC，这两个符号不导出
+func (l *Linker) DynObj() {
+	if !l.Shared && !l.dynamicExe() && !l.usesGot() {
+		return
+	}
+	d := &DynInfo{
+		obj:      elf.NewFile(elf.ET_REL),
+		secs:     map[string]*elf.Section{},
+		dynamic:  l.Shared || l.dynamicExe(),
+		shared:   l.Shared,
+		importof: map[string]*elf.Symbol{},
+		gotidx:   map[*elf.Symbol]int{},
+		pltidx:   map[*elf.Symbol]int{},
+		reldyn:   &bytes.Buffer{},
+		stroff:   map[string]uint32{},
+	}
+	for _, ds := range dynSecs {
+		if !d.dynamic && ds.name != ".got" || ds.name == ".interp" && d.shared {
+			continue
+		}
+		d.secs[ds.name] = d.obj.AddSection(&elf.Section{Name: ds.name, Type: ds.typ, Flags: ds.flags, Align: ds.align, Entsize: ds.entsize})
+	}
+	if s, ok := d.secs[".interp"]; ok {
+		s.Data = append([]byte(l.Interp), 0)
+	}
+	d.obj.AddSymbol(&elf.Symbol{Name: GotSym, Bind: elf.STB_GLOBAL, Type: elf.STT_OBJECT, Visibility: elf.STV_HIDDEN, Section: d.secs[".got"]})
+	if d.dynamic {
+		d.obj.AddSymbol(&elf.Symbol{Name: DynamicSym, Bind: elf.STB_GLOBAL, Type: elf.STT_OBJECT, Visibility: elf.STV_HIDDEN, Section: d.secs[".dynamic"]})
+	}
+	l.dyn = d
+	l.addObj("<dynamic>", d.obj)
+}
+
+// 输入文件中是否有需要GOT的重定位项
+func (l *Linker) usesGot() bool {
+	for _, obj := range l.elfs {
+		for _, sec := range obj.Sections {
+			for _, rel := range sec.Relocs {
+				switch rel.Type {
+				case elf.R_386_GOT32, elf.R_386_GOTOFF, elf.R_386_GOTPC:
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+/*
+导出到动态符号表的符号: 输入文件定义的非隐藏的全局符号，链接脚本定义的符号除外。
+共享库导出所有这样的符号，可执行文件只导出输入的共享库引用的符号
+*/
+func (l *Linker) exported(sd *SymLink) bool {
+	if sd.prov == l.scriptobj {
+		return false
+	}
+	sym := sd.prov.Lookup(sd.name)
+	if sym.Section == nil || sym.Visibility == elf.STV_HIDDEN || sym.Visibility == elf.STV_INTERNAL {
+		return false
+	}
+	if l.Shared {
+		return true
+	}
+	for _, lib := range l.shlibs {
+		if lib.refs[sd.name] {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+重定位项引用的符号: 局部符号是它本身，全局符号是符号解析选出的定义。
+动态链接时，没有定义的全局符号是导入符号，同名的引用使用第一次引用的符号
+*/
+func (d *DynInfo) resolve(sym *elf.Symbol) *elf.Symbol {
+	if sym.Bind == elf.STB_LOCAL {
+		return sym
+	}
+	if def, ok := d.defs[sym.Name]; ok {
+		return def
+	}
+	if !d.dynamic {
+		return sym
+	}
+	if imp, ok := d.importof[sym.Name]; ok {
+		return imp
+	}
+	d.importof[sym.Name] = sym
+	d.imports = append(d.imports, sym)
+	return sym
+}
+
+func (d *DynInfo) isImport(sym *elf.Symbol) bool {
+	return d.dynamic && !sym.Defined()
+}
+
+// 对符号的绝对引用是否需要装载时的重定位: 共享库的所有绝对地址，可执行文件中的导入符号
+func (d *DynInfo) needRel(sym *elf.Symbol) bool {
+	return d.shared || d.isImport(sym)
+}
+
+/*
+分配地址之前确定合成段的大小:
+1. 为@GOT引用的符号分配GOT项，为导入函数的调用分配PLT项
+2. 共享库的每个R_386_32和GOT项都需要一个装载时的重定位，可执行文件只有导入符号的需要
+3. 收集导出符号，生成.dynstr。没有内容的段不输出
+*/
+func (l *Linker) DynScan() {
+	d := l.dyn
+	if d == nil {
+		return
+	}
+	d.defs = map[string]*elf.Symbol{}
+	for _, sd := range l.symdefs {
+		d.defs[sd.name] = sd.prov.Lookup(sd.name)
+	}
+	//1. 2.
+	errs := []string{}
+	for _, obj := range l.elfs {
+		for _, sec := range obj.Sections {
+			if l.live != nil && !l.live[sec] {
+				continue
+			}
+			for _, rel := range sec.Relocs {
+				sym := d.resolve(rel.Sym)
+				switch rel.Type {
+				case elf.R_386_32:
+					if d.dynamic && d.needRel(sym) {
+						d.nreldyn++
+						d.textrel = d.textrel || sec.Flags&elf.SHF_WRITE == 0
+					}
+				case elf.R_386_PC32, elf.R_386_PLT32:
+					if d.isImport(sym) {
+						if _, ok := d.pltidx[sym]; !ok {
+							d.pltidx[sym] = len(d.plt)
+							d.plt = append(d.plt, sym)
+						}
+					}
+				case elf.R_386_GOT32:
+					if _, ok := d.gotidx[sym]; !ok {
+						d.gotidx[sym] = len(d.got)
+						d.got = append(d.got, sym)
+					}
+				case elf.R_386_GOTOFF:
+					if d.isImport(sym) {
+						errs = append(errs, fmt.Sprintf("%s(%s+0x%x): 不能用@GOTOFF引用导入符号%s", l.objnames[obj], sec.Name, rel.Offset, sym.Name))
+					}
+				}
+			}
+		}
+	}
+	if len(errs) != 0 {
+		log.Fatal("DynScan:\n" + strings.Join(errs, "\n"))
+	}
+	d.secs[".got"].Data = make([]byte, 4*(GotReserved+len(d.plt)+len(d.got)))
+	if d.dynamic {
+		for _, sym := range d.got {
+			if d.needRel(sym) {
+				d.nreldyn++
+			}
+		}
+		//3.
+		for _, sd := range l.symdefs {
+			if l.exported(sd) {
+				d.exports = append(d.exports, d.defs[sd.name])
+			}
+		}
+		d.dynstr = []byte{0}
+		for _, sym := range d.dynsyms() {
+			d.str(sym.Name)
+		}
+		for _, n := range l.needed() {
+			d.str(n)
+		}
+		if l.Soname != "" && d.shared {
+			d.str(l.Soname)
+		}
+		nsyms := len(d.dynsyms()) + 1
+		d.secs[".hash"].Data = make([]byte, 4*(2+hashBuckets(nsyms)+nsyms))
+		d.secs[".dynsym"].Data = make([]byte, elf.SymSize*nsyms)
+		d.secs[".dynstr"].Data = d.dynstr
+		d.secs[".rel.dyn"].Data = make([]byte, elf.RelSize*d.nreldyn)
+		d.secs[".rel.plt"].Data = make([]byte, elf.RelSize*len(d.plt))
+		d.secs[".plt"].Data = make([]byte, PltEntSize*len(d.plt))
+		d.secs[".dynamic"].Data = make([]byte, elf.DynSize*len(l.dynEntries()))
+	}
+	//没有内容的合成段不输出
+	for _, sec := range d.obj.Sections {
+		if len(sec.Data) != 0 {
+			continue
+		}
+		segs := l.seglists[l.OutputName(sec.Name)]
+		var blocks []*Block
+		for _, b := range segs.blocks {
+			if b.sec != sec {
+				blocks = append(blocks, b)
+			}
+		}
+		segs.blocks = blocks
+	}
+}
+
+// 动态符号表中的符号，不含第0个空符号: 先导入符号，后导出符号
+func (d *DynInfo) dynsyms() []*elf.Symbol {
+	return append(append([]*elf.Symbol{}, d.imports...), d.exports...)
+}
+
+// 把字符串加入.dynstr，返回它的偏移
+func (d *DynInfo) str(s string) uint32 {
+	if off, ok := d.stroff[s]; ok {
+		return off
+	}
+	off := uint32(len(d.dynstr))
+	d.dynstr = append(append(d.dynstr, s...), 0)
+	d.stroff[s] = off
+	return off
+}
+
+// 导入符号在.dynsym中的索引
+func (d *DynInfo) symIndex(sym *elf.Symbol) uint32 {
+	for i, s := range d.imports {
+		if s == sym {
+			return uint32(i + 1)
+		}
+	}
+	panic("symIndex panic: 符号" + sym.Name + "不是导入符号")
+}
+
+func (d *DynInfo) gotAddr() uint32 {
+	return d.secs[".got"].Addr
+}
+
+// 符号的GOT项相对GOT的偏移
+func (d *DynInfo) gotOffset(sym *elf.Symbol) uint32 {
+	return uint32(4 * (GotReserved + len(d.plt) + d.gotidx[sym]))
+}
+
+func (d *DynInfo) pltAddr(sym *elf.Symbol) uint32 {
+	return d.secs[".plt"].Addr + uint32(PltEntSize*d.pltidx[sym])
+}
+
+// 记录一个装载时的重定位: 导入符号由动态链接器解析，共享库中的其他符号加上装载的基址
+func (d *DynInfo) addRel(addr uint32, sym *elf.Symbol, typ uint32) {
+	if !d.dynamic || !d.needRel(sym) {
+		return
+	}
+	if d.isImport(sym) {
+		elf.Write(d.reldyn, &elf.Elf32_Rel{R_Offset: addr, R_Info: d.symIndex(sym)<<8 | typ})
+	} else {
+		elf.Write(d.reldyn, &elf.Elf32_Rel{R_Offset: addr, R_Info: elf.R_386_RELATIVE})
+	}
+}
+
+/*
+重定位项的值，返回按R_386_32(S + A)或R_386_PC32(S + A - P)的方式修改重定位位置时使用的类型和符号值:
+R_386_PLT32: 导入函数使用PLT项的地址，否则直接调用符号
+R_386_GOT32: GOT项相对GOT的偏移
+R_386_GOTOFF: 符号相对GOT的偏移
+R_386_GOTPC: GOT的地址，加数是重定位位置相对取得当前地址的指令的偏移
+调用导入函数的R_386_PC32也使用PLT项。动态链接时R_386_32还可能需要装载时的重定位，由Relocate记录。
+合成段的大小和GOT、PLT项已经在DynScan中确定，这里只读取，可以并行调用
+*/
+func (l *Linker) RelocValue(rel *elf.Reloc) (uint32, uint32) {
+	d := l.dyn
+	sym := rel.Sym
+	if d != nil {
+		sym = d.resolve(sym)
+	}
+	switch rel.Type {
+	case elf.R_386_32:
+		return elf.R_386_32, sym.Value
+	case elf.R_386_PC32, elf.R_386_PLT32:
+		if d != nil && d.isImport(sym) {
+			return elf.R_386_PC32, d.pltAddr(sym)
+		}
+		return elf.R_386_PC32, sym.Value
+	case elf.R_386_GOT32:
+		return elf.R_386_32, d.gotOffset(sym)
+	case elf.R_386_GOTOFF:
+		return elf.R_386_32, sym.Value - d.gotAddr()
+	case elf.R_386_GOTPC:
+		return elf.R_386_PC32, d.gotAddr()
+	}
+	log.Fatalf("Relocate:不支持符号%s的重定位类型%d", rel.Sym.Name, rel.Type)
+	return 0, 0
+}
+
+/*
+重定位之后填写合成段的内容:
+.got: 第一项是.dynamic的地址，PLT使用的项由动态链接器在装载时填写(DF_BIND_NOW)，其他项是符号的地址
+.plt: 每项是 call 1f; 1: pop ecx; jmp [ecx + GOT项 - 1b]，不依赖ebx保存GOT的地址
+*/
+func (l *Linker) DynFill() {
+	d := l.dyn
+	if d == nil {
+		return
+	}
+	got := d.secs[".got"]
+	if d.dynamic {
+		binary.LittleEndian.PutUint32(got.Data, d.secs[".dynamic"].Addr)
+	}
+	for _, sym := range d.got {
+		off := d.gotOffset(sym)
+		binary.LittleEndian.PutUint32(got.Data[off:], sym.Value)
+		d.addRel(got.Addr+off, sym, elf.R_386_GLOB_DAT)
+	}
+	if !d.dynamic {
+		return
+	}
+	if d.reldyn.Len() != len(d.secs[".rel.dyn"].Data) {
+		panic("DynFill panic: .rel.dyn的大小和DynScan计算的不同")
+	}
+	copy(d.secs[".rel.dyn"].Data, d.reldyn.Bytes())
+	plt := d.secs[".plt"]
+	relplt := &bytes.Buffer{}
+	for i, sym := range d.plt {
+		slot := got.Addr + uint32(4*(GotReserved+i))
+		ent := plt.Data[PltEntSize*i:]
+		pc := plt.Addr + uint32(PltEntSize*i) + 5
+		copy(ent, []byte{0xe8, 0, 0, 0, 0, 0x59, 0xff, 0xa1})
+		binary.LittleEndian.PutUint32(ent[8:], slot-pc)
+		copy(ent[12:PltEntSize], []byte{0x90, 0x90, 0x90, 0x90})
+		elf.Write(relplt, &elf.Elf32_Rel{R_Offset: slot, R_Info: d.symIndex(sym)<<8 | elf.R_386_JMP_SLOT})
+	}
+	copy(d.secs[".rel.plt"].Data, relplt.Bytes())
+	//.dynsym
+	dynsym := &bytes.Buffer{}
+	elf.Write(dynsym, &elf.Elf32_Sym{})
+	for _, sym := range d.dynsyms() {
+		st := &elf.Elf32_Sym{
+			ST_Name:  d.str(sym.Name),
+			ST_Info:  sym.Bind<<4 | sym.Type&0xf,
+			ST_Other: sym.Visibility & 0x3,
+		}
+		if sym.Section != nil {
+			st.ST_Value = sym.Value
+			st.ST_Size = sym.Size
+			st.ST_Shndx = uint16(l.secIndex(l.OutputName(sym.Section.Name)))
+		}
+		elf.Write(dynsym, st)
+	}
+	copy(d.secs[".dynsym"].Data, dynsym.Bytes())
+	//.hash
+	hash := d.secs[".hash"].Data
+	nsyms := len(d.dynsyms()) + 1
+	nbucket := hashBuckets(nsyms)
+	binary.LittleEndian.PutUint32(hash, uint32(nbucket))
+	binary.LittleEndian.PutUint32(hash[4:], uint32(nsyms))
+	buckets := hash[8:]
+	chains := hash[8+4*nbucket:]
+	for i, sym := range d.dynsyms() {
+		idx := uint32(i + 1)
+		b := 4 * (hashName(sym.Name) % uint32(nbucket))
+		binary.LittleEndian.PutUint32(chains[4*idx:], binary.LittleEndian.Uint32(buckets[b:]))
+		binary.LittleEndian.PutUint32(buckets[b:], idx)
+	}
+	//.dynamic
+	dynamic := &bytes.Buffer{}
+	elf.Write(dynamic, l.dynEntries())
+	copy(d.secs[".dynamic"].Data, dynamic.Bytes())
+}
+
+// .dynamic的内容，只依赖合成段的地址和大小。可执行文件的DT_DEBUG由动态链接器填写，供调试器使用
+func (l *Linker) dynEntries() []elf.Elf32_Dyn {
+	d := l.dyn
+	addr := func(name string) uint32 {
+		return d.secs[name].Addr
+	}
+	ents := []elf.Elf32_Dyn{}
+	for _, n := range l.needed() {
+		ents = append(ents, elf.Elf32_Dyn{D_Tag: elf.DT_NEEDED, D_Val: d.str(n)})
+	}
+	ents = append(ents, []elf.Elf32_Dyn{
+		{D_Tag: elf.DT_HASH, D_Val: addr(".hash")},
+		{D_Tag: elf.DT_STRTAB, D_Val: addr(".dynstr")},
+		{D_Tag: elf.DT_SYMTAB, D_Val: addr(".dynsym")},
+		{D_Tag: elf.DT_STRSZ, D_Val: uint32(len(d.dynstr))},
+		{D_Tag: elf.DT_SYMENT, D_Val: elf.SymSize},
+	}...)
+	if !d.shared {
+		ents = append(ents, elf.Elf32_Dyn{D_Tag: elf.DT_DEBUG})
+	}
+	if l.Soname != "" && d.shared {
+		ents = append(ents, elf.Elf32_Dyn{D_Tag: elf.DT_SONAME, D_Val: d.str(l.Soname)})
+	}
+	if d.nreldyn != 0 {
+		ents = append(ents,
+			elf.Elf32_Dyn{D_Tag: elf.DT_REL, D_Val: addr(".rel.dyn")},
+			elf.Elf32_Dyn{D_Tag: elf.DT_RELSZ, D_Val: uint32(elf.RelSize * d.nreldyn)},
+			elf.Elf32_Dyn{D_Tag: elf.DT_RELENT, D_Val: elf.RelSize})
+	}
+	ents = append(ents, elf.Elf32_Dyn{D_Tag: elf.DT_PLTGOT, D_Val: addr(".got")})
+	if len(d.plt) != 0 {
+		ents = append(ents,
+			elf.Elf32_Dyn{D_Tag: elf.DT_PLTRELSZ, D_Val: uint32(elf.RelSize * len(d.plt))},
+			elf.Elf32_Dyn{D_Tag: elf.DT_PLTREL, D_Val: elf.DT_REL},
+			elf.Elf32_Dyn{D_Tag: elf.DT_JMPREL, D_Val: addr(".rel.plt")})
+	}
+	flags := uint32(elf.DF_BIND_NOW)
+	if d.textrel {
+		ents = append(ents, elf.Elf32_Dyn{D_Tag: elf.DT_TEXTREL})
+		flags |= elf.DF_TEXTREL
+	}
+	return append(ents,
+		elf.Elf32_Dyn{D_Tag: elf.DT_FLAGS, D_Val: flags},
+		elf.Elf32_Dyn{D_Tag: elf.DT_FLAGS_1, D_Val: elf.DF_1_NOW},
+		elf.Elf32_Dyn{D_Tag: elf.DT_NULL})
+}
+
+// 输出段在可执行文件中的索引，和AssemObj添加段的顺序一致
+func (l *Linker) secIndex(name string) int {
+	for i, n := range l.segnames {
+		if n == name {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// 合成段的段表项: 段类型、表项大小和关联的段
+func (l *Linker) dynShdrs(secs map[string]*elf.Section) {
+	if l.dyn == nil {
+		return
+	}
+	for _, ds := range dynSecs {
+		if s, ok := secs[ds.name]; ok {
+			s.Type = ds.typ
+			s.Flags = ds.flags
+			s.Entsize = ds.entsize
+		}
+	}
+	link := func(name, to string) {
+		if s, ok := secs[name]; ok {
+			s.Link = uint32(l.secIndex(to))
+		}
+	}
+	link(".hash", ".dynsym")
+	link(".dynsym", ".dynstr")
+	link(".rel.dyn", ".dynsym")
+	link(".rel.plt", ".dynsym")
+	link(".dynamic", ".dynstr")
+	if s, ok := secs[".dynsym"]; ok {
+		s.Info = 1 //只有第0个符号是局部符号
+	}
+	if s, ok := secs[".rel.plt"]; ok {
+		s.Info = uint32(l.secIndex(".got"))
+	}
+}
+
+// 合成段需要的程序头: PT_PHDR、PT_INTERP和PT_DYNAMIC，放在可加载段之前
+func (l *Linker) dynProgs() []*elf.Prog {
+	if l.dyn == nil || !l.dyn.dynamic {
+		return nil
+	}
+	progs := []*elf.Prog{}
+	_, interp := l.dyn.secs[".interp"]
+	if interp && len(l.loadsegs) > 0 { //动态链接器通过AT_PHDR找到可执行文件的程序头，程序头在第一个可加载段中
+		size := uint32(elf.PhdrSize * (len(l.loadsegs) + 3)) //PT_PHDR、PT_INTERP、PT_DYNAMIC和可加载段
+		progs = append(progs, &elf.Prog{
+			Type:   elf.PT_PHDR,
+			Flags:  elf.PF_R,
+			Offset: elf.EhdrSize,
+			Vaddr:  l.loadsegs[0].vaddr + elf.EhdrSize,
+			Filesz: size,
+			Memsz:  size,
+			Align:  4,
+		})
+	}
+	prog := func(typ uint32, name string, flags uint32, align uint32) {
+		s := l.seglists[name]
+		progs = append(progs, &elf.Prog{
+			Type:   typ,
+			Flags:  flags,
+			Offset: s.offset,
+			Vaddr:  s.baseaddr,
+			Filesz: s.size,
+			Memsz:  s.size,
+			Align:  align,
+		})
+	}
+	if interp {
+		prog(elf.PT_INTERP, ".interp", elf.PF_R, 1)
+	}
+	prog(elf.PT_DYNAMIC, ".dynamic", elf.PF_R|elf.PF_W, 4)
+	return progs
+}
+
+// 散列表的桶数，和GNU ld一样从一组素数中按符号数选择
+func hashBuckets(nsyms int) int {
+	primes := []int{1, 3, 17, 37, 67, 97, 131, 197, 263, 521, 1031, 2053, 4099, 8209, 16411}
+	n := primes[0]
+	for _, p := range primes {
+		if p > nsyms {
+			break
+		}
+		n = p
+	}
+	return n
+}
+
+// System V ABI规定的符号名散列函数
+func hashName(name string) uint32 {
+	h := uint32(0)
+	for i := 0; i < len(name); i++ {
+		h = h<<4 + uint32(name[i])
+		g := h & 0xf0000000
+		if g != 0 {
+			h ^= g >> 24
+		}
+		h &^= g
+	}
+	return h
+}
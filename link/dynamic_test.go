@@ -0,0 +1,211 @@
+package link
+
+import (
+	"bytes"
+	"calgo/elf"
+	delf "debug/elf"
+	"encoding/binary"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 调用函数name: call rel32，重定位项的加数-4在指令中
+func callSym(sec *elf.Section, sym *elf.Symbol) {
+	sec.Relocs = append(sec.Relocs, &elf.Reloc{Offset: uint32(len(sec.Data) + 1), Type: elf.R_386_PC32, Sym: sym})
+	sec.Data = append(sec.Data, 0xe8, 0xfc, 0xff, 0xff, 0xff)
+}
+
+// 只定义了puts和exit的libc.so.6
+func stubLibc(t *testing.T, dir string) string {
+	t.Helper()
+	obj := elf.NewFile(elf.ET_REL)
+	text := obj.AddSection(&elf.Section{Name: ".text", Type: elf.SHT_PROGBITS, Flags: elf.SHF_ALLOC | elf.SHF_EXECINSTR, Align: 16, Data: []byte{0xc3, 0xc3}})
+	obj.AddSymbol(&elf.Symbol{Name: "puts", Bind: elf.STB_GLOBAL, Type: elf.STT_FUNC, Section: text})
+	obj.AddSymbol(&elf.Symbol{Name: "exit", Value: 1, Bind: elf.STB_GLOBAL, Type: elf.STT_FUNC, Section: text})
+	b := linkFiles(t, dir, func(l *Linker) {
+		l.Shared = true
+		l.Soname = "libc.so.6"
+	}, writeObj(t, dir, "libc.o", obj))
+	return writeFile(t, dir, "libc.so.6", b)
+}
+
+// .dynamic中标记为tag的项的值
+func dynValues(t *testing.T, f *delf.File, tag delf.DynTag) []uint32 {
+	t.Helper()
+	data, err := f.Section(".dynamic").Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	vals := []uint32{}
+	for i := 0; i+8 <= len(data); i += 8 {
+		if delf.DynTag(binary.LittleEndian.Uint32(data[i:])) == tag {
+			vals = append(vals, binary.LittleEndian.Uint32(data[i+4:]))
+		}
+	}
+	return vals
+}
+
+// 动态链接libc.so.6: 程序解释器、DT_NEEDED、.rel.plt和GOT、PLT的布局
+func TestDynamicExe(t *testing.T) {
+	dir := t.TempDir()
+	libc := stubLibc(t, dir)
+
+	obj := startObj()
+	text := obj.Sections[0]
+	puts := obj.AddSymbol(&elf.Symbol{Name: "puts", Bind: elf.STB_GLOBAL})
+	exit := obj.AddSymbol(&elf.Symbol{Name: "exit", Bind: elf.STB_GLOBAL})
+	callSym(text, puts)
+	callSym(text, exit)
+	callSym(text, puts)
+	b := linkFiles(t, dir, nil, writeObj(t, dir, "a.o", obj), libc)
+	f := parseExe(t, b)
+	checkLayout(t, f, MemAlign)
+
+	//PT_INTERP
+	var interp *delf.Prog
+	for _, p := range f.Progs {
+		if p.Type == delf.PT_INTERP {
+			interp = p
+		}
+	}
+	if interp == nil {
+		t.Fatal("没有PT_INTERP")
+	}
+	if got := b[interp.Off : interp.Off+interp.Filesz]; string(got) != DefaultInterp+"\x00" {
+		t.Errorf("程序解释器是%q", got)
+	}
+	//DT_NEEDED
+	needed, err := f.DynString(delf.DT_NEEDED)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(needed) != 1 || needed[0] != "libc.so.6" {
+		t.Errorf("DT_NEEDED是%v", needed)
+	}
+
+	got := f.Section(".got")
+	plt := f.Section(".plt")
+	relplt := f.Section(".rel.plt")
+	if got == nil || plt == nil || relplt == nil {
+		t.Fatal("缺少.got、.plt或.rel.plt")
+	}
+	if v := dynValues(t, f, delf.DT_PLTGOT); len(v) != 1 || v[0] != uint32(got.Addr) {
+		t.Errorf("DT_PLTGOT是%x，.got在0x%x", v, got.Addr)
+	}
+	if v := dynValues(t, f, delf.DT_JMPREL); len(v) != 1 || v[0] != uint32(relplt.Addr) {
+		t.Errorf("DT_JMPREL是%x，.rel.plt在0x%x", v, relplt.Addr)
+	}
+	//两个导入函数，按第一次调用的顺序各占一个PLT项和GOT项
+	imports := []string{"puts", "exit"}
+	if got.Size != uint64(4*(GotReserved+len(imports))) {
+		t.Errorf(".got有%d字节", got.Size)
+	}
+	if plt.Size != uint64(PltEntSize*len(imports)) {
+		t.Errorf(".plt有%d字节", plt.Size)
+	}
+	gotdata, _ := got.Data()
+	if v := binary.LittleEndian.Uint32(gotdata); v != uint32(f.Section(".dynamic").Addr) {
+		t.Errorf("GOT的第一项是0x%x，应该是.dynamic的地址", v)
+	}
+	dynsyms, err := f.DynamicSymbols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reldata, _ := relplt.Data()
+	if len(reldata) != elf.RelSize*len(imports) {
+		t.Fatalf(".rel.plt有%d字节", len(reldata))
+	}
+	pltdata, _ := plt.Data()
+	for i, name := range imports {
+		slot := uint32(got.Addr) + uint32(4*(GotReserved+i))
+		off := binary.LittleEndian.Uint32(reldata[8*i:])
+		info := binary.LittleEndian.Uint32(reldata[8*i+4:])
+		if delf.R_386(info&0xff) != delf.R_386_JMP_SLOT {
+			t.Errorf(".rel.plt的第%d项类型是%v", i, delf.R_386(info&0xff))
+		}
+		if off != slot {
+			t.Errorf(".rel.plt的第%d项修改0x%x，应该是GOT项0x%x", i, off, slot)
+		}
+		if idx := int(info >> 8); idx < 1 || idx > len(dynsyms) || dynsyms[idx-1].Name != name {
+			t.Errorf(".rel.plt的第%d项的符号索引是%d，应该是%s", i, idx, name)
+		}
+		//call 1f; 1: pop ecx; jmp [ecx + GOT项 - 1b]
+		ent := pltdata[PltEntSize*i : PltEntSize*(i+1)]
+		pc := uint32(plt.Addr) + uint32(PltEntSize*i) + 5
+		if !bytes.Equal(ent[:8], []byte{0xe8, 0, 0, 0, 0, 0x59, 0xff, 0xa1}) || binary.LittleEndian.Uint32(ent[8:]) != slot-pc {
+			t.Errorf("PLT的第%d项是% x", i, ent)
+		}
+	}
+	//.text中的调用跳到PLT项
+	textsec := f.Section(".text")
+	tdata, _ := textsec.Data()
+	for i, want := range []int{0, 1, 0} {
+		at := 1 + 5*i
+		next := uint32(textsec.Addr) + uint32(at+5)
+		if dst := next + binary.LittleEndian.Uint32(tdata[at+1:]); dst != uint32(plt.Addr)+uint32(PltEntSize*want) {
+			t.Errorf("第%d个调用跳到0x%x，应该是%s的PLT项", i, dst, imports[want])
+		}
+	}
+}
+
+// 调用name的@start
+func callerObj(name string) *elf.File {
+	obj := startObj()
+	callSym(obj.Sections[0], obj.AddSymbol(&elf.Symbol{Name: name, Bind: elf.STB_GLOBAL}))
+	return obj
+}
+
+/*
+-needed声明的共享库在LibDirs中找到时，用它的动态符号表检查导入的符号，没有定义的符号报错；
+找不到时警告每个不能检查的符号。报错会退出进程，在子进程中链接
+*/
+func TestNeededSymbols(t *testing.T) {
+	if dir := os.Getenv("CALGO_NEEDED_DIR"); dir != "" {
+		linkFiles(t, t.TempDir(), func(l *Linker) {
+			l.Needed = []string{"libc.so.6"}
+			l.LibDirs = []string{filepath.Join(dir, "none"), dir}
+		}, filepath.Join(dir, "a.o"))
+		return
+	}
+	dir := t.TempDir()
+	stubLibc(t, dir)
+	tests := []struct {
+		name string
+		call string
+		err  string
+	}{
+		{"defined", "puts", ""},
+		{"undefined", "prinft", "未定义的符号prinft"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeObj(t, dir, "a.o", callerObj(tt.call))
+			cmd := exec.Command(os.Args[0], "-test.run=^TestNeededSymbols$")
+			cmd.Env = append(os.Environ(), "CALGO_NEEDED_DIR="+dir)
+			out, err := cmd.CombinedOutput()
+			if tt.err == "" && err != nil {
+				t.Fatalf("链接失败: %v\n%s", err, out)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(string(out), tt.err)) {
+				t.Fatalf("输出\n%s\n应该包含%q", out, tt.err)
+			}
+		})
+	}
+
+	//找不到共享库
+	obj := writeObj(t, dir, "b.o", callerObj("prinft"))
+	logs := &strings.Builder{}
+	log.SetOutput(logs)
+	linkFiles(t, dir, func(l *Linker) {
+		l.Needed = []string{"libc.so.6"}
+		l.LibDirs = []string{filepath.Join(dir, "none")}
+	}, obj)
+	log.SetOutput(os.Stderr)
+	if !strings.Contains(logs.String(), "不检查符号prinft") {
+		t.Errorf("没有警告不能检查的符号: %q", logs.String())
+	}
+}
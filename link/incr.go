@@ -0,0 +1,339 @@
+package link
+
+import (
+	"calgo/ar"
+	"calgo/elf"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+)
+
+/*
+增量链接: 完整链接之后把链接状态保存到StateFile，包括输入文件的散列值、每个输入文件的数据块在输出文件中的位置、
+输出到符号表的符号和全局符号的地址。再次链接时如果只有一个可重定位文件改变了，并且:
+1. 它的可分配段和上次相同(名字、类型、权限)，每个段都放得下上次的位置(到下一个数据块为止)
+2. 它定义和引用的全局符号、输出到符号表的局部符号和上次相同，所在段和段内偏移也相同
+3. 它只使用R_386_32和R_386_PC32(PLT32)重定位
+就只把这个文件的段重新重定位后写到输出文件原来的位置，其余部分保持不变；否则做完整链接。
+只支持静态链接的可执行文件，回收段和输出链接映射文件时总是完整链接
+*/
+type LinkState struct {
+	Options string            //影响布局的选项
+	Inputs  []*InputState     //命令行上的输入文件
+	Output  [32]byte          //输出文件的散列值，输出文件在上次链接之后被修改过时完整链接
+	Objs    []*ObjState       //命令行上的可重定位文件
+	Globals map[string]uint32 //全局符号的地址
+}
+
+type InputState struct {
+	Path string
+	Hash [32]byte
+}
+
+type ObjState struct {
+	Name   string
+	Blocks []*BlockState //每个可分配段一个，按段在文件中的顺序
+	Syms   []*SymState
+}
+
+// 输入段在输出文件中的位置
+type BlockState struct {
+	Name   string
+	Type   uint32
+	Flags  uint32
+	Addr   uint32
+	Offset uint32 //文件偏移，.bss为0
+	Size   uint32
+	Slot   uint32 //可以使用的大小: 到同一个段中下一个数据块为止，最后一个数据块不能变大
+}
+
+// 全局符号和输出到符号表的局部符号
+type SymState struct {
+	Name       string
+	Sec        int    //第几个可分配段，-1表示没有所在段
+	Value      uint32 //相对段基址的偏移
+	Size       uint32
+	Bind       uint8
+	Type       uint8
+	Visibility uint8
+	Shndx      uint16
+}
+
+// 可以增量链接的选项
+func (l *Linker) canRelink() bool {
+	return l.StateFile != "" && !l.Shared && len(l.Needed) == 0 && !l.GCSections && l.MapFile == ""
+}
+
+func (l *Linker) options() string {
+	return fmt.Sprintf("script=%x strip=%v", l.script.sum, l.Strip)
+}
+
+// 文件的可分配段
+func allocSections(obj *elf.File) []*elf.Section {
+	secs := []*elf.Section{}
+	for _, sec := range obj.Sections {
+		if sec.Flags&elf.SHF_ALLOC != 0 {
+			secs = append(secs, sec)
+		}
+	}
+	return secs
+}
+
+// 符号的值是相对段基址的偏移时，文件中需要和上次相同的符号
+func symStates(obj *elf.File, secs []*elf.Section) []*SymState {
+	index := map[*elf.Section]int{}
+	for i, sec := range secs {
+		index[sec] = i
+	}
+	syms := []*SymState{}
+	for _, sym := range obj.Symbols {
+		if sym.Type == elf.STT_SECTION {
+			continue
+		}
+		if sym.Bind == elf.STB_LOCAL && (sym.Section == nil || strings.HasPrefix(sym.Name, ".L")) {
+			continue
+		}
+		i, ok := index[sym.Section]
+		if !ok {
+			i = -1
+		}
+		syms = append(syms, &SymState{
+			Name:       sym.Name,
+			Sec:        i,
+			Value:      sym.Value,
+			Size:       sym.Size,
+			Bind:       sym.Bind,
+			Type:       sym.Type,
+			Visibility: sym.Visibility,
+			Shndx:      sym.Shndx,
+		})
+	}
+	return syms
+}
+
+// 分配地址之后、解析符号之前记录命令行上每个可重定位文件的数据块和符号
+func (l *Linker) recordLayout() {
+	if !l.canRelink() || l.dynamicExe() {
+		return
+	}
+	blocks := map[*elf.Section]*BlockState{}
+	for _, n := range l.segnames {
+		s := l.seglists[n]
+		for i, b := range s.blocks {
+			bs := &BlockState{
+				Name:  b.sec.Name,
+				Type:  b.sec.Type,
+				Flags: b.sec.Flags,
+				Addr:  s.baseaddr + b.offset,
+				Size:  b.size,
+				Slot:  b.size,
+			}
+			if !s.nobits {
+				bs.Offset = s.offset + b.offset
+			}
+			if i+1 < len(s.blocks) {
+				bs.Slot = s.blocks[i+1].offset - b.offset
+			}
+			blocks[b.sec] = bs
+		}
+	}
+	st := &LinkState{Options: l.options(), Inputs: l.inputs, Globals: map[string]uint32{}}
+	for _, obj := range l.elfs {
+		name := l.objnames[obj]
+		if !l.isInput(name) {
+			continue
+		}
+		secs := allocSections(obj)
+		o := &ObjState{Name: name, Syms: symStates(obj, secs)}
+		for _, sec := range secs {
+			o.Blocks = append(o.Blocks, blocks[sec])
+		}
+		st.Objs = append(st.Objs, o)
+	}
+	l.state = st
+}
+
+func (l *Linker) isInput(name string) bool {
+	for _, in := range l.inputs {
+		if in.Path == name {
+			return true
+		}
+	}
+	return false
+}
+
+// 完整链接之后保存链接状态。不能增量链接时删除旧的链接状态
+func (l *Linker) SaveState() {
+	if l.StateFile == "" {
+		return
+	}
+	st := l.state
+	if st == nil {
+		os.Remove(l.StateFile)
+		return
+	}
+	for _, sd := range l.symdefs {
+		st.Globals[sd.name] = sd.prov.Lookup(sd.name).Value
+	}
+	out, err := os.ReadFile(EXEFILE.Name())
+	if err != nil {
+		log.Fatal("SaveState err! ", err)
+	}
+	st.Output = sha256.Sum256(out)
+	st.save(l.StateFile)
+}
+
+func (st *LinkState) save(filename string) {
+	f, err := os.Create(filename)
+	if err != nil {
+		log.Fatal("SaveState err! ", err)
+	}
+	defer f.Close()
+	if err := gob.NewEncoder(f).Encode(st); err != nil {
+		log.Fatal("SaveState err! ", err)
+	}
+}
+
+func loadState(filename string) (*LinkState, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st := &LinkState{}
+	if err := gob.NewDecoder(f).Decode(st); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+/*
+增量链接exefile，files是命令行上的输入文件。没有文件改变或者修补了改变的文件时返回true，
+需要完整链接时返回false并说明原因
+*/
+func (l *Linker) Relink(exefile string, files []string) bool {
+	if !l.canRelink() {
+		return false
+	}
+	st, err := loadState(l.StateFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("增量链接: 不能读取链接状态%s: %v, 完整链接", l.StateFile, err)
+		}
+		return false
+	}
+	fallback := func(format string, a ...any) bool {
+		log.Printf("增量链接: %s, 完整链接", fmt.Sprintf(format, a...))
+		return false
+	}
+	if st.Options != l.options() {
+		return fallback("链接选项改变了")
+	}
+	if len(files) != len(st.Inputs) {
+		return fallback("输入文件改变了")
+	}
+	out, err := os.ReadFile(exefile)
+	if err != nil || sha256.Sum256(out) != st.Output {
+		return fallback("%s在上次链接之后被修改了", exefile)
+	}
+	changed := -1
+	var data []byte
+	for i, f := range files {
+		if f != st.Inputs[i].Path {
+			return fallback("输入文件改变了")
+		}
+		b, err := os.ReadFile(f)
+		if err != nil {
+			log.Fatal("Relink err! ", err)
+		}
+		if sha256.Sum256(b) == st.Inputs[i].Hash {
+			continue
+		}
+		if changed >= 0 {
+			return fallback("%s和%s都改变了", files[changed], f)
+		}
+		changed, data = i, b
+	}
+	if changed < 0 { //没有文件改变
+		return true
+	}
+	name := files[changed]
+	var prev *ObjState //上次链接时的状态
+	for _, o := range st.Objs {
+		if o.Name == name {
+			prev = o
+		}
+	}
+	if h, err := elf.ReadEhdr(data); ar.IsArchive(data) || err != nil || h.E_Type != elf.ET_REL || prev == nil {
+		return fallback("%s不是可重定位文件", name)
+	}
+	obj := ParseElf(name, data)
+	secs := allocSections(obj)
+	if len(secs) != len(prev.Blocks) {
+		return fallback("%s的段改变了", name)
+	}
+	for i, sec := range secs {
+		bs := prev.Blocks[i]
+		align := sec.Align
+		if align == 0 {
+			align = 1
+		}
+		if sec.Name != bs.Name || sec.Type != bs.Type || sec.Flags != bs.Flags {
+			return fallback("%s的段改变了", name)
+		}
+		if sec.MemSize() > bs.Slot || bs.Addr%align != 0 {
+			return fallback("%s的段%s放不下", name, sec.Name)
+		}
+	}
+	if !reflect.DeepEqual(symStates(obj, secs), prev.Syms) {
+		return fallback("%s的符号改变了", name)
+	}
+	for i, sec := range secs {
+		sec.Addr = prev.Blocks[i].Addr
+	}
+	for _, sec := range secs {
+		for _, rel := range sec.Relocs {
+			sym := rel.Sym
+			symaddr := sym.Value
+			if v, ok := st.Globals[sym.Name]; ok && sym.Bind != elf.STB_LOCAL {
+				symaddr = v
+			} else if sym.Section != nil {
+				symaddr += sym.Section.Addr
+			}
+			reladdr := sec.Addr + rel.Offset
+			addend := binary.LittleEndian.Uint32(sec.Data[rel.Offset:])
+			switch rel.Type {
+			case elf.R_386_32:
+				binary.LittleEndian.PutUint32(sec.Data[rel.Offset:], symaddr+addend)
+			case elf.R_386_PC32, elf.R_386_PLT32:
+				binary.LittleEndian.PutUint32(sec.Data[rel.Offset:], symaddr+addend-reladdr)
+			default:
+				return fallback("%s使用了重定位类型%d", name, rel.Type)
+			}
+		}
+	}
+	for i, sec := range secs {
+		bs := prev.Blocks[i]
+		if sec.Type != elf.SHT_NOBITS {
+			//变小时用0填充原来的位置
+			old := out[bs.Offset : bs.Offset+bs.Size]
+			for j := range old {
+				old[j] = 0
+			}
+			copy(out[bs.Offset:], sec.Data)
+		}
+		bs.Size = sec.MemSize()
+	}
+	if err := os.WriteFile(exefile, out, 0755); err != nil {
+		log.Fatal("Relink err! ", err)
+	}
+	st.Inputs[changed].Hash = sha256.Sum256(data)
+	st.Output = sha256.Sum256(out)
+	st.save(l.StateFile)
+	return true
+}
@@ -0,0 +1,154 @@
+package link
+
+import (
+	"bytes"
+	"calgo/elf"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 和main中的--incremental相同: 先尝试增量链接，不行时完整链接并保存链接状态。返回是否增量链接
+func incrLink(t *testing.T, out string, files []string) bool {
+	t.Helper()
+	l := NewLinker()
+	l.StateFile = out + ".state"
+	if l.Relink(out, files) {
+		return true
+	}
+	l.AddFiles(files)
+	writeLink(t, out, l)
+	return false
+}
+
+func readFile(t *testing.T, f string) []byte {
+	t.Helper()
+	b, err := os.ReadFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// @start调用f，读取x；定义g
+func incrMain() *elf.File {
+	obj := elf.NewFile(elf.ET_REL)
+	text := obj.AddSection(&elf.Section{Name: ".text", Type: elf.SHT_PROGBITS, Flags: elf.SHF_ALLOC | elf.SHF_EXECINSTR, Align: 16})
+	data := obj.AddSection(&elf.Section{Name: ".data", Type: elf.SHT_PROGBITS, Flags: elf.SHF_ALLOC | elf.SHF_WRITE, Align: 4, Data: []byte{7, 0, 0, 0}})
+	obj.AddSymbol(&elf.Symbol{Name: Start, Bind: elf.STB_GLOBAL, Type: elf.STT_FUNC, Section: text})
+	obj.AddSymbol(&elf.Symbol{Name: "g", Bind: elf.STB_GLOBAL, Type: elf.STT_OBJECT, Size: 4, Section: data})
+	callSym(text, obj.AddSymbol(&elf.Symbol{Name: "f", Bind: elf.STB_GLOBAL}))
+	x := obj.AddSymbol(&elf.Symbol{Name: "x", Bind: elf.STB_GLOBAL})
+	text.Relocs = append(text.Relocs, &elf.Reloc{Offset: uint32(len(text.Data) + 1), Type: elf.R_386_32, Sym: x})
+	text.Data = append(text.Data, 0xa1, 0, 0, 0, 0, 0xc3) //mov eax, [x]; ret
+	return obj
+}
+
+// 被增量链接修改的文件
+type incrObj struct {
+	val    byte //f返回的值和x的初值
+	grow   bool //.text变大
+	newsym bool //多定义一个全局符号
+	gotoff bool //用R_386_GOTOFF引用g
+}
+
+// f: mov eax, val; mov ebx, [g]; ret。x: dd val, g
+func (o incrObj) build() *elf.File {
+	obj := elf.NewFile(elf.ET_REL)
+	text := obj.AddSection(&elf.Section{Name: ".text", Type: elf.SHT_PROGBITS, Flags: elf.SHF_ALLOC | elf.SHF_EXECINSTR, Align: 16})
+	data := obj.AddSection(&elf.Section{Name: ".data", Type: elf.SHT_PROGBITS, Flags: elf.SHF_ALLOC | elf.SHF_WRITE, Align: 4})
+	f := obj.AddSymbol(&elf.Symbol{Name: "f", Bind: elf.STB_GLOBAL, Type: elf.STT_FUNC, Section: text})
+	obj.AddSymbol(&elf.Symbol{Name: "x", Bind: elf.STB_GLOBAL, Type: elf.STT_OBJECT, Size: 8, Section: data})
+	if o.newsym {
+		obj.AddSymbol(&elf.Symbol{Name: "y", Value: 4, Bind: elf.STB_GLOBAL, Type: elf.STT_OBJECT, Size: 4, Section: data})
+	}
+	g := obj.AddSymbol(&elf.Symbol{Name: "g", Bind: elf.STB_GLOBAL})
+	typ := uint8(elf.R_386_32)
+	if o.gotoff {
+		typ = elf.R_386_GOTOFF
+	}
+	text.Data = []byte{0xb8, o.val, 0, 0, 0, 0x8b, 0x1d, 0, 0, 0, 0, 0xc3}
+	text.Relocs = []*elf.Reloc{{Offset: 7, Type: typ, Sym: g}}
+	if o.grow {
+		text.Data = append(text.Data, 0x90)
+	}
+	data.Data = []byte{o.val, 0, 0, 0, 0, 0, 0, 0}
+	data.Relocs = []*elf.Reloc{{Offset: 4, Type: elf.R_386_32, Sym: g}}
+	f.Size = uint32(len(text.Data))
+	return obj
+}
+
+// 修改了一个文件之后增量链接，输出和完整链接的相同
+func TestRelink(t *testing.T) {
+	dir := t.TempDir()
+	out := filepath.Join(dir, "a.out")
+	files := []string{writeObj(t, dir, "main.o", incrMain()), writeObj(t, dir, "b.o", incrObj{val: 1}.build())}
+	if incrLink(t, out, files) {
+		t.Fatal("没有链接状态时增量链接了")
+	}
+	before := readFile(t, out)
+	if !incrLink(t, out, files) {
+		t.Fatal("没有文件改变时完整链接了")
+	}
+	if !bytes.Equal(readFile(t, out), before) {
+		t.Error("没有文件改变时输出改变了")
+	}
+
+	writeObj(t, dir, "b.o", incrObj{val: 2}.build())
+	if !incrLink(t, out, files) {
+		t.Fatal("只改变了段的内容时完整链接了")
+	}
+	relinked := readFile(t, out)
+	if bytes.Equal(relinked, before) {
+		t.Error("增量链接没有修改输出")
+	}
+	if full := linkFiles(t, t.TempDir(), nil, files...); !bytes.Equal(relinked, full) {
+		t.Error("增量链接的输出和完整链接的不同")
+	}
+	//再次修改，增量链接使用更新后的链接状态
+	writeObj(t, dir, "b.o", incrObj{val: 3}.build())
+	if !incrLink(t, out, files) {
+		t.Fatal("第二次修改后完整链接了")
+	}
+	if full := linkFiles(t, t.TempDir(), nil, files...); !bytes.Equal(readFile(t, out), full) {
+		t.Error("第二次增量链接的输出和完整链接的不同")
+	}
+}
+
+// 不能修补时完整链接，输出和不使用增量链接时相同
+func TestRelinkFallback(t *testing.T) {
+	tests := []struct {
+		name   string
+		obj    incrObj
+		reason string
+	}{
+		{"grow", incrObj{val: 2, grow: true}, "放不下"},
+		{"symbols", incrObj{val: 2, newsym: true}, "符号改变了"},
+		{"reltype", incrObj{val: 2, gotoff: true}, "重定位类型"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			out := filepath.Join(dir, "a.out")
+			files := []string{writeObj(t, dir, "main.o", incrMain()), writeObj(t, dir, "b.o", incrObj{val: 1}.build())}
+			incrLink(t, out, files)
+			writeObj(t, dir, "b.o", tt.obj.build())
+
+			logs := &strings.Builder{}
+			log.SetOutput(logs)
+			relinked := incrLink(t, out, files)
+			log.SetOutput(os.Stderr)
+			if relinked {
+				t.Fatal("增量链接了")
+			}
+			if !strings.Contains(logs.String(), tt.reason) {
+				t.Errorf("完整链接的原因是%q，应该包含%q", logs.String(), tt.reason)
+			}
+			if full := linkFiles(t, t.TempDir(), nil, files...); !bytes.Equal(readFile(t, out), full) {
+				t.Error("完整链接的输出和不使用增量链接时不同")
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package link
+
+import (
+	"bytes"
+	"calgo/elf"
+	delf "debug/elf"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 写出可重定位文件，返回文件名
+func writeObj(t *testing.T, dir, name string, obj *elf.File) string {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	if err := obj.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+	return writeFile(t, dir, name, buf.Bytes())
+}
+
+func writeFile(t *testing.T, dir, name string, b []byte) string {
+	t.Helper()
+	f := filepath.Join(dir, name)
+	if err := os.WriteFile(f, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// 链接files，输出写到dir/out，返回输出的内容。setup在添加文件之前设置链接器
+func linkFiles(t *testing.T, dir string, setup func(l *Linker), files ...string) []byte {
+	t.Helper()
+	l := NewLinker()
+	if setup != nil {
+		setup(l)
+	}
+	l.AddFiles(files)
+	return writeLink(t, filepath.Join(dir, "out"), l)
+}
+
+// 链接器l的输出写到文件out，返回输出的内容
+func writeLink(t *testing.T, out string, l *Linker) []byte {
+	t.Helper()
+	f, err := os.Create(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	EXEFILE = f
+	l.Link()
+	f.Close()
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func parseExe(t *testing.T, b []byte) *delf.File {
+	t.Helper()
+	f, err := delf.NewFile(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func symValue(t *testing.T, f *delf.File, name string) uint32 {
+	t.Helper()
+	syms, err := f.Symbols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range syms {
+		if s.Name == name {
+			return uint32(s.Value)
+		}
+	}
+	t.Fatalf("没有符号%s", name)
+	return 0
+}
+
+// 定义入口@start的.text，ret
+func startObj() *elf.File {
+	obj := elf.NewFile(elf.ET_REL)
+	text := obj.AddSection(&elf.Section{Name: ".text", Type: elf.SHT_PROGBITS, Flags: elf.SHF_ALLOC | elf.SHF_EXECINSTR, Align: 16, Data: []byte{0xc3}})
+	obj.AddSymbol(&elf.Symbol{Name: Start, Bind: elf.STB_GLOBAL, Type: elf.STT_FUNC, Section: text})
+	return obj
+}
+
+// 3字节的.rodata之后是4字节对齐的.bss
+func rodataBssObj() *elf.File {
+	obj := startObj()
+	obj.AddSection(&elf.Section{Name: ".rodata", Type: elf.SHT_PROGBITS, Flags: elf.SHF_ALLOC, Align: 1, Data: []byte("ab\x00")})
+	bss := obj.AddSection(&elf.Section{Name: ".bss", Type: elf.SHT_NOBITS, Flags: elf.SHF_ALLOC | elf.SHF_WRITE, Align: 4, Size: 8})
+	obj.AddSymbol(&elf.Symbol{Name: "counter", Bind: elf.STB_GLOBAL, Type: elf.STT_OBJECT, Size: 8, Section: bss})
+	return obj
+}
+
+// 可加载段的虚拟地址和文件偏移模页大小同余，段按sh_addralign对齐
+func checkLayout(t *testing.T, f *delf.File, pagesize uint64) {
+	t.Helper()
+	for _, p := range f.Progs {
+		if p.Type == delf.PT_LOAD && p.Vaddr%pagesize != p.Off%pagesize {
+			t.Errorf("可加载段的地址0x%x和偏移0x%x模页大小不同余", p.Vaddr, p.Off)
+		}
+	}
+	for _, s := range f.Sections {
+		if s.Addralign > 1 && s.Addr%s.Addralign != 0 {
+			t.Errorf("段%s的地址0x%x没有按%d对齐", s.Name, s.Addr, s.Addralign)
+		}
+	}
+}
+
+// .bss开始新的可加载段时按自己的对齐分配地址，__bss_start和_end是.bss的开始和结束
+func TestBssSegment(t *testing.T) {
+	scripts := map[string]string{
+		"default": "",
+		"script":  "ENTRY(@start)\nSECTIONS\n{\n\t. = 0x200000;\n\t.text;\n\t.rodata;\n\t__bss_start = .;\n\t.bss;\n\t_end = .;\n}\n",
+	}
+	for name, script := range scripts {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			obj := writeObj(t, dir, "a.o", rodataBssObj())
+			b := linkFiles(t, dir, func(l *Linker) {
+				if script != "" {
+					l.LoadScript(writeFile(t, dir, "a.lds", []byte(script)))
+				}
+			}, obj)
+			f := parseExe(t, b)
+			checkLayout(t, f, MemAlign)
+			bss := f.Section(".bss")
+			if got := symValue(t, f, "__bss_start"); got != uint32(bss.Addr) {
+				t.Errorf("__bss_start = 0x%x, .bss在0x%x", got, bss.Addr)
+			}
+			if got := symValue(t, f, "_end"); got != uint32(bss.Addr+bss.Size) {
+				t.Errorf("_end = 0x%x, .bss结束于0x%x", got, bss.Addr+bss.Size)
+			}
+			if got := symValue(t, f, "counter"); got != uint32(bss.Addr) {
+				t.Errorf("counter = 0x%x, .bss在0x%x", got, bss.Addr)
+			}
+		})
+	}
+}
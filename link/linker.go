@@ -1,110 +1,508 @@
 package link
 
 import (
+	"calgo/ar"
+	"calgo/elf"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
 	"log"
-	"unsafe"
+	"os"
+	"sort"
+	"strings"
 )
 
 type Linker struct {
-	exe        *ELF
-	elfs       []*ELF
+	exe        *elf.File
+	elfs       []*elf.File
 	segnames   []string
 	symdefs    []*SymLink
 	symlinks   []*SymLink
-	startowner *ELF
+	startowner *elf.File
 	seglists   map[string]*SegList
+	loadsegs   []*LoadSeg
+	objnames   map[*elf.File]string //文件名，静态库成员是"静态库(成员)"
+	MapFile    string               //不为空时输出链接映射文件
+	script     *Script              //链接脚本
+	scriptname string
+	scriptobj  *elf.File             //链接脚本定义的符号
+	GCSections bool                  //去掉没有被引用的段
+	Strip      bool                  //可执行文件不包含符号表和段表
+	live       map[*elf.Section]bool //回收段时能从入口到达的段
+	discards   []*Block              //回收段时去掉的数据块
+	Shared     bool                  //生成共享库
+	Soname     string                //共享库的DT_SONAME
+	dyn        *DynInfo              //动态链接用的合成段
+	shlibs     []*SharedLib          //输入的共享库
+	Needed     []string              //运行时依赖、链接时不读入的共享库
+	LibDirs    []string              //查找Needed中的共享库的目录，用来检查导入的符号
+	neededDefs map[string]bool       //Needed中找到的共享库定义的符号，为nil时还没有读入
+	unchecked  []string              //Needed中没有找到的共享库
+	warned     map[string]bool       //已经警告过不能检查的符号
+	Interp     string                //动态链接的可执行文件使用的动态链接器
+	StateFile  string                //不为空时保存链接状态，用于增量链接，必须在添加文件之前设置
+	inputs     []*InputState         //命令行上的输入文件和内容的散列值
+	state      *LinkState            //完整链接之后保存的链接状态
+}
+
+// 可加载段，对应可执行文件的一个程序头。权限相同的相邻段合并到同一个可加载段
+type LoadSeg struct {
+	flags    uint32 //PF_R, PF_W, PF_X
+	segnames []string
+	offset   uint32 //文件偏移
+	vaddr    uint32 //虚拟地址
+	filesz   uint32 //占用的文件大小，不包括.bss
+	memsz    uint32 //占用的内存大小
 }
 
 type Block struct {
 	data   []byte
 	offset uint32 //相对段基址的偏移
 	size   uint32
+	owner  *elf.File
+	sec    *elf.Section //输入段
 }
 
 type SegList struct {
-	baseaddr  uint32   //基地址
-	begin     uint32   //对齐前偏移
-	offset    uint32   //对齐后偏移
-	size      uint32   //总大小
-	ownerlist []*ELF   //所有者文件
-	blocks    []*Block //数据块
+	align    uint32   //段的对齐
+	flags    uint32   //SHF_WRITE, SHF_EXECINSTR
+	nobits   bool     //不占文件空间，如.bss
+	baseaddr uint32   //基地址
+	begin    uint32   //对齐前偏移
+	offset   uint32   //对齐后偏移
+	size     uint32   //总大小
+	blocks   []*Block //数据块，每个输入段一个
 }
 
 type SymLink struct {
 	name string
-	recv *ELF
-	prov *ELF
+	recv *elf.File
+	prov *elf.File
 }
 
 func NewLinker() *Linker {
+	s, err := ParseScript(DefaultScript)
+	if err != nil {
+		panic(err)
+	}
 	l := &Linker{
-		seglists: map[string]*SegList{},
-		exe:      NewELF(),
+		seglists:   map[string]*SegList{},
+		exe:        elf.NewFile(elf.ET_EXEC),
+		objnames:   map[*elf.File]string{},
+		script:     s,
+		scriptname: "<default script>",
+		Interp:     DefaultInterp,
+		LibDirs:    DefaultLibDirs,
 	}
-	l.segnames = append(l.segnames, ".data")
-	l.segnames = append(l.segnames, ".text")
 	return l
 }
 
+// 使用链接脚本filename代替默认的布局，必须在添加文件之前调用
+func (l *Linker) LoadScript(filename string) {
+	l.script = LoadScript(filename)
+	l.scriptname = filename
+}
+
+// 段的权限，决定段所属的可加载段
+func (s *SegList) SegFlags() uint32 {
+	flags := uint32(elf.PF_R)
+	if s.flags&elf.SHF_WRITE != 0 {
+		flags |= elf.PF_W
+	}
+	if s.flags&elf.SHF_EXECINSTR != 0 {
+		flags |= elf.PF_X
+	}
+	return flags
+}
+
+// 脚本中用到的段和输入文件中脚本没有提到的可分配段，按放置的顺序排列。没有用到的段在UsedSegs中去掉
+func (l *Linker) layout() []*ScriptItem {
+	items := []*ScriptItem{}
+	placed := map[string]bool{}
+	for _, it := range l.script.items {
+		if it.kind == PlaceSec {
+			placed[it.name] = true
+			if _, ok := l.seglists[it.name]; !ok {
+				continue
+			}
+		}
+		items = append(items, it)
+	}
+	for _, n := range l.segnames {
+		if !placed[n] {
+			items = append(items, &ScriptItem{kind: PlaceSec, name: n})
+		}
+	}
+	return items
+}
+
+/*
+按权限把相邻的段分组为可加载段。.bss这样不占文件空间的段只能是可加载段的最后一个段，
+脚本中设置了当前地址的段总是开始新的可加载段
+*/
+func (l *Linker) GroupSegs(items []*ScriptItem) {
+	var last *LoadSeg
+	lastnobits := false
+	newseg := true
+	for _, it := range items {
+		switch it.kind {
+		case SetDot:
+			newseg = true
+		case PlaceSec:
+			s := l.seglists[it.name]
+			flags := s.SegFlags()
+			if newseg || last.flags != flags || lastnobits {
+				last = &LoadSeg{flags: flags}
+				l.loadsegs = append(l.loadsegs, last)
+			}
+			last.segnames = append(last.segnames, it.name)
+			lastnobits = s.nobits
+			newseg = false
+		}
+	}
+}
+
+/*
+按链接脚本分配地址: 当前地址从BaseAddr开始，依次放置段、定义符号。放置段之后当前地址不能向后移动。
+设置了当前地址的段使用这个地址，文件偏移调整为和它模页大小同余。符号定义在后面的段分配了地址之后求值
+*/
 func (l *Linker) AllocAddr() {
+	items := l.layout()
+	l.GroupSegs(items)
+	first := map[string]bool{}
+	for _, ls := range l.loadsegs {
+		first[ls.segnames[0]] = true
+	}
 	curAddr := uint32(BaseAddr)
-	curoff := uint32(52 + int(unsafe.Sizeof(Elf32_Phdr{}))*len(l.segnames)) //offset
-	for _, n := range l.segnames {
-		l.seglists[n].AllocAddr(n, &curAddr, &curoff)
+	if l.Shared { //共享库在装载时重定位，从0开始
+		curAddr = 0
+	}
+	curoff := uint32(elf.EhdrSize + elf.PhdrSize*(len(l.loadsegs)+len(l.dynProgs()))) //offset
+	fixed := false
+	placed := false
+	var pending []*ScriptItem //等待下一个段确定地址的符号定义
+	defsyms := func(dot uint32) {
+		for _, it := range pending {
+			if sym := l.scriptobj.Lookup(it.name); sym != nil {
+				sym.Value = it.value.Eval(dot)
+			}
+		}
+		pending = nil
+	}
+	for _, it := range items {
+		switch it.kind {
+		case SetDot:
+			defsyms(curAddr)
+			v := it.value.Eval(curAddr)
+			if placed && v < curAddr {
+				log.Fatalf("链接脚本%s: 当前地址不能从0x%08x向后移动到0x%08x", l.scriptname, curAddr, v)
+			}
+			curAddr = v
+			fixed = true
+		case DefSym: //段对齐、换页之后才知道符号的值，比如.bss前的__bss_start
+			pending = append(pending, it)
+		case PlaceSec:
+			s := l.seglists[it.name]
+			s.AllocAddr(it.name, &curAddr, &curoff, first[it.name], fixed, l.script.pagesize)
+			defsyms(s.baseaddr)
+			fixed = false
+			placed = true
+		}
+	}
+	defsyms(curAddr)
+	for _, ls := range l.loadsegs {
+		first := l.seglists[ls.segnames[0]]
+		ls.offset = first.offset
+		ls.vaddr = first.baseaddr
+		for _, n := range ls.segnames {
+			s := l.seglists[n]
+			if !s.nobits {
+				ls.filesz = s.offset + s.size - first.offset
+			}
+			ls.memsz = s.baseaddr + s.size - first.baseaddr
+		}
+	}
+	if l.dynamicExe() && len(l.loadsegs) > 0 { //第一个可加载段从文件头开始，包含程序头
+		ls := l.loadsegs[0]
+		if ls.vaddr < ls.offset {
+			log.Fatalf("链接脚本%s: 基地址0x%08x太小，放不下文件头和程序头", l.scriptname, ls.vaddr)
+		}
+		ls.vaddr -= ls.offset
+		ls.filesz += ls.offset
+		ls.memsz += ls.offset
+		ls.offset = 0
+	}
+}
+
+// 链接脚本定义的符号，输入文件已经定义的除外。符号的值在分配地址时确定
+func (l *Linker) ScriptSyms() {
+	defined := map[string]bool{}
+	for _, obj := range l.elfs {
+		for _, sym := range obj.Symbols {
+			if sym.Bind != elf.STB_LOCAL && sym.Defined() {
+				defined[sym.Name] = true
+			}
+		}
+	}
+	l.scriptobj = elf.NewFile(elf.ET_REL)
+	for _, it := range l.script.items {
+		if it.kind == DefSym && !defined[it.name] && l.scriptobj.Lookup(it.name) == nil {
+			l.scriptobj.AddSymbol(&elf.Symbol{Name: it.name, Bind: elf.STB_GLOBAL, Type: elf.STT_NOTYPE, Shndx: elf.SHN_ABS})
+		}
 	}
+	l.addObj(l.scriptname, l.scriptobj)
 }
 
 func (l *Linker) AddELF(f string) {
-	elf := NewELF()
-	elf.ReadElf(f)
-	l.elfs = append(l.elfs, elf)
+	l.addObj(f, ReadElf(f))
 }
 
-// 分配虚拟地址, 目前虚拟地址已分配到base, 可执行文件已分配到off。根据name确定对齐大小
-func (s *SegList) AllocAddr(name string, base, off *uint32) {
+func (l *Linker) addObj(name string, obj *elf.File) {
+	l.elfs = append(l.elfs, obj)
+	l.objnames[obj] = name
+}
+
+// 添加可重定位文件、静态库或共享库
+func (l *Linker) AddFile(f string) {
+	b, err := os.ReadFile(f)
+	if err != nil {
+		log.Fatal("AddFile err! ", err)
+	}
+	l.addInput(f, b, nil)
+}
+
+/*
+并行读入命令行上的文件并解析其中的可重定位文件，再按命令行上的顺序加入。
+静态库成员的选择依赖前面的文件，加入时按顺序处理。有多个文件出错时报告命令行上第一个出错的文件
+*/
+func (l *Linker) AddFiles(files []string) {
+	datas := make([][]byte, len(files))
+	objs := make([]*elf.File, len(files))
+	errs := make([]error, len(files))
+	parallel(len(files), func(i int) {
+		b, err := os.ReadFile(files[i])
+		if err != nil {
+			errs[i] = fmt.Errorf("AddFile err! %v", err)
+			return
+		}
+		datas[i] = b
+		if !ar.IsArchive(b) && !isShlib(b) {
+			objs[i], errs[i] = parseElf(files[i], b)
+		}
+	})
+	for _, err := range errs {
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	for i, f := range files {
+		l.addInput(f, datas[i], objs[i])
+	}
+}
+
+func isShlib(b []byte) bool {
+	h, err := elf.ReadEhdr(b)
+	return err == nil && h.E_Type == elf.ET_DYN
+}
+
+// 按内容加入文件b，obj不为nil时是已经解析的可重定位文件
+func (l *Linker) addInput(f string, b []byte, obj *elf.File) {
+	if l.StateFile != "" {
+		l.inputs = append(l.inputs, &InputState{Path: f, Hash: sha256.Sum256(b)})
+	}
+	switch {
+	case obj != nil:
+		l.addObj(f, obj)
+	case ar.IsArchive(b):
+		l.AddArchive(f, b)
+	case isShlib(b):
+		l.AddShlib(f, b)
+	default:
+		l.addObj(f, ParseElf(f, b))
+	}
+}
+
+/*
+和传统的Unix链接器一样，只链接静态库中定义了当前未定义符号的成员。
+新链接的成员可能引用新的未定义符号，所以反复扫描符号索引，直到没有成员可以加入。
+静态库只能解析在它之前出现的文件中的未定义符号。
+*/
+func (l *Linker) AddArchive(f string, b []byte) {
+	a, err := ar.Read(b)
+	if err != nil {
+		log.Fatalf("AddArchive %s: %v", f, err)
+	}
+	loaded := map[int]bool{}
+	for {
+		undef := l.Undefined()
+		added := false
+		for _, s := range a.Symbols {
+			if undef[s.Name] && !loaded[s.Member] {
+				m := a.Members[s.Member]
+				loaded[s.Member] = true
+				added = true
+				name := f + "(" + m.Name + ")"
+				l.addObj(name, ParseElf(name, m.Data))
+				undef = l.Undefined()
+			}
+		}
+		if !added {
+			break
+		}
+	}
+}
+
+// 已加入的文件引用了但还没有定义的全局符号，弱符号的引用不算。可执行文件的入口符号总是需要定义，链接脚本和已加入的共享库定义的符号不需要
+func (l *Linker) Undefined() map[string]bool {
+	undef := map[string]bool{}
+	if !l.Shared {
+		undef[l.script.entry] = true
+	}
+	for _, obj := range l.elfs {
+		for _, sym := range obj.Symbols {
+			if sym.Bind == elf.STB_GLOBAL && !sym.Defined() {
+				undef[sym.Name] = true
+			}
+		}
+	}
+	for _, obj := range l.elfs {
+		for _, sym := range obj.Symbols {
+			if sym.Bind != elf.STB_LOCAL && sym.Defined() {
+				delete(undef, sym.Name)
+			}
+		}
+	}
+	for _, it := range l.script.items {
+		if it.kind == DefSym {
+			delete(undef, it.name)
+		}
+	}
+	for _, lib := range l.shlibs {
+		for name := range lib.defs {
+			delete(undef, name)
+		}
+	}
+	return undef
+}
+
+/*
+分配虚拟地址, 目前虚拟地址已分配到base, 可执行文件已分配到off。
+newpage表示段是可加载段的第一个段：权限不同的可加载段不能共用一个页，所以从新的页开始，且虚拟地址和文件偏移模页大小同余。
+fixed表示链接脚本指定了段的地址base，此时只调整文件偏移
+.bss段不占用文件空间，只分配虚拟地址
+*/
+func (s *SegList) AllocAddr(name string, base, off *uint32, newpage, fixed bool, pagesize uint32) {
 	s.begin = *off //对齐前偏移
-	align := uint32(DiscAlign)
-	if name == ".text" {
-		align = 16
+	align := s.align
+	nobits := s.nobits
+	if nobits || fixed {
+		*base += (align - (*base)%align) % align
+	} else {
+		pad := (align - (*off)%align) % align
+		*off += pad //对齐后的段文件偏移
+		*base += pad
+	}
+	if newpage {
+		if fixed {
+			*off += (*base%pagesize + pagesize - *off%pagesize) % pagesize
+		} else {
+			if nobits { //.bss没有对齐过偏移，地址由偏移得到，偏移要先对齐
+				*off += (align - (*off)%align) % align
+			}
+			*base += (pagesize-(*base)%pagesize)%pagesize + (*off)%pagesize
+		}
 	}
-	*off += (align - (*off)%align) % align //对齐后的段文件偏移
-	*base += (MemAlign-(*base)%MemAlign)%MemAlign + (*off)%MemAlign
 
 	s.baseaddr = *base
 	s.offset = *off
 	s.size = 0 //s.size最终是seglist的所有段的大小之和。在计算的过程中，s.size是当前段在seglist内的偏移量。
 
-	for _, elf := range s.ownerlist {
-		shdr := elf.ShdrTab[name]
-		shalign := shdr.sh_addralign
+	for _, b := range s.blocks {
+		sec := b.sec
+		shalign := sec.Align
+		if shalign == 0 {
+			shalign = 1
+		}
 		s.size += (shalign - s.size%shalign) % shalign
 
-		sb := make([]byte, shdr.sh_size)
-		elf.GetData(sb, shdr.sh_offset)
-		s.blocks = append(s.blocks, &Block{sb, s.size, shdr.sh_size})
-		shdr.sh_addr = *base + s.size
-		s.size += shdr.sh_size
+		//数据块和段共用内容，重定位时直接修改段的内容
+		b.data = sec.Data
+		b.offset = s.size
+		b.size = sec.MemSize()
+		sec.Addr = *base + s.size
+		s.size += sec.MemSize()
 	}
 	*base += s.size
-	*off += s.size
+	if !nobits {
+		*off += s.size
+	}
+}
+
+// 输入段合并到的输出段: 脚本中的段名，或者以脚本中的段名加.开头，例如.text.main合并到.text
+func (l *Linker) OutputName(name string) string {
+	out := name
+	for _, it := range l.script.items {
+		if it.kind != PlaceSec {
+			continue
+		}
+		if it.name == name {
+			return name
+		}
+		if strings.HasPrefix(name, it.name+".") && (out == name || len(it.name) > len(out)) {
+			out = it.name
+		}
+	}
+	return out
 }
 
+/*
+收集输入文件的可分配段和全局符号。段按链接脚本中的顺序排列，脚本没有提到的段按出现的顺序放在最后。
+段的对齐由脚本指定，否则使用默认对齐；段的类型和权限取自输入文件。
+并行计算每个输入段合并到的输出段，再按文件的顺序合并
+*/
 func (l *Linker) ColletInfo() {
-	for _, elf := range l.elfs {
-		for _, seg := range l.segnames {
-			if _, ok := elf.ShdrTab[seg]; ok {
-				l.seglists[seg].ownerlist = append(l.seglists[seg].ownerlist, elf)
+	aligns := map[string]uint32{}
+	for _, it := range l.script.items {
+		if it.kind == PlaceSec {
+			l.segnames = append(l.segnames, it.name)
+			aligns[it.name] = it.align
+		}
+	}
+	outs := make([][]string, len(l.elfs))
+	parallel(len(l.elfs), func(i int) {
+		for _, sec := range l.elfs[i].Sections {
+			outs[i] = append(outs[i], l.OutputName(sec.Name))
+		}
+	})
+	for i, obj := range l.elfs {
+		for j, sec := range obj.Sections {
+			if sec.Flags&elf.SHF_ALLOC == 0 {
+				continue
 			}
+			out := outs[i][j]
+			s, ok := l.seglists[out]
+			if !ok {
+				align := aligns[out]
+				if align == 0 {
+					align = DefaultAlign(out)
+				}
+				s = &SegList{align: align, flags: sec.Flags, nobits: sec.Type == elf.SHT_NOBITS}
+				l.seglists[out] = s
+				if _, ok := aligns[out]; !ok {
+					l.segnames = append(l.segnames, out)
+				}
+			}
+			s.blocks = append(s.blocks, &Block{owner: obj, sec: sec})
 		}
-		for name, sym := range elf.SymTab {
-			if (sym.ST_Info >> 4) == STB_GLOBAL {
+		for _, sym := range obj.Symbols {
+			if sym.Bind != elf.STB_LOCAL {
 				symlink := &SymLink{}
-				symlink.name = name
-				if sym.ST_Shndx == STN_UNDEF { //导入符号
-					symlink.recv = elf
+				symlink.name = sym.Name
+				if !sym.Defined() { //导入符号
+					symlink.recv = obj
 					l.symlinks = append(l.symlinks, symlink)
 				} else {
-					symlink.prov = elf
+					symlink.prov = obj
 					l.symdefs = append(l.symdefs, symlink)
 				}
 			}
@@ -112,109 +510,283 @@ func (l *Linker) ColletInfo() {
 	}
 }
 
-func (l *Linker) SymValid() bool {
-	for i := 0; i < len(l.symdefs); i++ {
-		if l.symdefs[i].name == "@start" {
-			l.startowner = l.symdefs[i].prov
+// 去掉没有任何文件提供的段
+func (l *Linker) UsedSegs() {
+	var used []string
+	for _, n := range l.segnames {
+		if s, ok := l.seglists[n]; ok && len(s.blocks) != 0 {
+			used = append(used, n)
+		}
+	}
+	l.segnames = used
+}
+
+/*
+符号解析，所有错误一起报告:
+1. 为每个全局符号选出唯一的定义。同名的全局符号只能定义一次，弱符号会被全局符号覆盖，都是弱符号时使用第一个，没有被选中的定义当作符号引用
+2. 为每个符号引用设置定义它的文件
+3. 重定位项引用的符号必须有定义，只有弱符号的引用可以没有定义，此时符号的值是0。
+生成共享库时没有定义的全局符号在运行时解析，入口符号可以没有定义；动态链接的可执行文件可以引用共享库定义的符号
+*/
+func (l *Linker) SymValid() {
+	errs := []string{}
+	//1.
+	defs := map[string]*SymLink{}
+	var symdefs []*SymLink
+	for _, sd := range l.symdefs {
+		old, ok := defs[sd.name]
+		if !ok {
+			defs[sd.name] = sd
+			symdefs = append(symdefs, sd)
+			continue
+		}
+		osym := old.prov.Lookup(sd.name)
+		nsym := sd.prov.Lookup(sd.name)
+		if nsym.Bind == elf.STB_WEAK {
+			l.symlinks = append(l.symlinks, &SymLink{name: sd.name, recv: sd.prov})
+			continue
+		}
+		if osym.Bind == elf.STB_WEAK {
+			l.symlinks = append(l.symlinks, &SymLink{name: sd.name, recv: old.prov})
+			*old = *sd
+			continue
 		}
-		for j := i + 1; j < len(l.symdefs); j++ {
-			if l.symdefs[i].name == l.symdefs[j].name {
-				log.Fatal("SymValid:符号重定义")
+		errs = append(errs, fmt.Sprintf("符号%s重定义: 第一次定义在%s, 又定义在%s", sd.name, l.where(old.prov, osym), l.where(sd.prov, nsym)))
+	}
+	l.symdefs = symdefs
+	if sd, ok := defs[l.script.entry]; ok {
+		l.startowner = sd.prov
+	} else if !l.Shared {
+		errs = append(errs, fmt.Sprintf("找不到入口点%s", l.script.entry))
+	}
+	//2.
+	for _, sl := range l.symlinks {
+		if sd, ok := defs[sl.name]; ok {
+			sl.prov = sd.prov
+		}
+	}
+	//3.
+	for _, obj := range l.elfs {
+		for _, sec := range obj.Sections {
+			for _, rel := range sec.Relocs {
+				sym := rel.Sym
+				if sym.Defined() || sym.Bind == elf.STB_WEAK || sym.Bind == elf.STB_GLOBAL && (defs[sym.Name] != nil || l.importable(sym.Name)) {
+					continue
+				}
+				errs = append(errs, fmt.Sprintf("%s(%s+0x%x): 未定义的符号%s", l.objnames[obj], sec.Name, rel.Offset, sym.Name))
 			}
 		}
 	}
-	if l.startowner == nil {
-		log.Fatal("SymValid:找不到入口点")
+	if len(errs) != 0 {
+		log.Fatal("SymValid:\n" + strings.Join(errs, "\n"))
+	}
+}
+
+// 符号的定义位置: 文件(段)
+func (l *Linker) where(obj *elf.File, sym *elf.Symbol) string {
+	sec := "*ABS*"
+	if sym.Section != nil {
+		sec = sym.Section.Name
+	}
+	return l.objnames[obj] + "(" + sec + ")"
+}
+
+/*
+回收没有被引用的段: 从入口符号所在的段开始，沿着重定位项标记所有能到达的段，去掉没有标记的数据块。
+共享库还要从所有导出符号所在的段开始。合成段总是保留，没有内容时在DynScan中去掉。
+全局符号的引用指向符号解析选出的定义。定义在被去掉的段中的符号不再输出
+*/
+func (l *Linker) GCSecs() {
+	defs := map[string]*elf.Symbol{}
+	for _, sd := range l.symdefs {
+		defs[sd.name] = sd.prov.Lookup(sd.name)
+	}
+	l.live = map[*elf.Section]bool{}
+	work := []*elf.Section{}
+	mark := func(sym *elf.Symbol) {
+		if d, ok := defs[sym.Name]; ok && sym.Bind != elf.STB_LOCAL {
+			sym = d
+		}
+		if sec := sym.Section; sec != nil && !l.live[sec] {
+			l.live[sec] = true
+			work = append(work, sec)
+		}
+	}
+	if sym, ok := defs[l.script.entry]; ok {
+		mark(sym)
+	}
+	for _, sd := range l.symdefs {
+		if l.exported(sd) {
+			mark(defs[sd.name])
+		}
+	}
+	if l.dyn != nil {
+		for _, sec := range l.dyn.obj.Sections {
+			l.live[sec] = true
+		}
+	}
+	for len(work) != 0 {
+		sec := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, rel := range sec.Relocs {
+			mark(rel.Sym)
+		}
 	}
-	for _, syml := range l.symdefs {
-		for _, symd := range l.symlinks {
-			if syml.name == symd.name {
-				syml.prov = symd.prov
+	for _, n := range l.segnames {
+		s, ok := l.seglists[n]
+		if !ok {
+			continue
+		}
+		var blocks []*Block
+		for _, b := range s.blocks {
+			if l.live[b.sec] {
+				blocks = append(blocks, b)
+			} else {
+				l.discards = append(l.discards, b)
 			}
 		}
-		if syml.prov == nil {
-			log.Fatal("SymValid:符号为未定义")
+		s.blocks = blocks
+	}
+	var symdefs []*SymLink
+	for _, sd := range l.symdefs {
+		if sec := defs[sd.name].Section; sec == nil || l.live[sec] {
+			symdefs = append(symdefs, sd)
 		}
 	}
-	return true
+	l.symdefs = symdefs
 }
 
 /*
 1. 段加载的基址已经确定，将基址加上符号相对段的偏移得到符号的虚拟地址
-2. 对于每个符号引用，设置符号引用的prov。
+2. 对于每个符号引用，从定义它的文件复制符号的地址。没有定义的弱符号保持为0
+两步都按文件或符号引用并行，每个符号只被一个goroutine修改
 */
 func (l *Linker) SymParse() {
 	//1.
-	for _, elf := range l.elfs {
-		for _, sym := range elf.SymTab {
-			segname := elf.ShdrNames[sym.ST_Shndx]
-			sym.ST_Value += elf.ShdrTab[segname].sh_offset
+	parallel(len(l.elfs), func(i int) {
+		for _, sym := range l.elfs[i].Symbols {
+			if sym.Section != nil {
+				sym.Value += sym.Section.Addr
+			}
 		}
-	}
+	})
 	//2.
-	for _, sl := range l.symlinks {
+	parallel(len(l.symlinks), func(i int) {
+		sl := l.symlinks[i]
+		if sl.prov == nil {
+			return
+		}
 		name := sl.name
-		sl.recv.SymTab[name].ST_Value = sl.prov.SymTab[name].ST_Value
-	}
+		sl.recv.Lookup(name).Value = sl.prov.Lookup(name).Value
+	})
 }
 
-func (l *Linker) Relocate() {
-	for _, elf := range l.elfs {
-		reltab := elf.RelTab
-		for _, rel := range reltab {
-			//符号
-			symname := rel.Name
-			sym := elf.SymTab[symname]
-			//段
-			segname := rel.Segname
-			shdr := elf.ShdrTab[segname]
-			//位置
-			addr := shdr.sh_addr + rel.Rel.r_offset //addr是符号的虚拟地址(绝对)
-			typ := rel.Rel.r_info & 0xff
+// 一个输入段的重定位，dynrels是需要装载时重定位的R_386_32的位置和符号
+type relocJob struct {
+	sec     *elf.Section
+	segs    *SegList
+	dynrels []dynRel
+}
 
-			l.seglists[segname].RelocAddr(addr, typ, sym.ST_Value)
+type dynRel struct {
+	addr uint32
+	sym  *elf.Symbol
+}
+
+/*
+按输出段并行重定位: 每个输出段的输入段由一个goroutine按文件的顺序处理，不同的输入段修改不同的数据块。
+装载时的重定位项最后按文件和段的顺序写入.rel.dyn，所以输出和顺序重定位时相同
+*/
+func (l *Linker) Relocate() {
+	var jobs []*relocJob
+	byseg := map[*SegList][]*relocJob{}
+	for _, obj := range l.elfs {
+		for _, sec := range obj.Sections {
+			segs, ok := l.seglists[l.OutputName(sec.Name)]
+			if !ok && len(sec.Relocs) != 0 {
+				log.Fatalf("Relocate:不支持段%s的重定位", sec.Name)
+			}
+			if l.live != nil && !l.live[sec] || len(sec.Relocs) == 0 { //已经回收的段和没有重定位项的段
+				continue
+			}
+			j := &relocJob{sec: sec, segs: segs}
+			jobs = append(jobs, j)
+			byseg[segs] = append(byseg[segs], j)
+		}
+	}
+	var segjobs [][]*relocJob
+	for _, n := range l.segnames {
+		if js, ok := byseg[l.seglists[n]]; ok {
+			segjobs = append(segjobs, js)
+		}
+	}
+	parallel(len(segjobs), func(i int) {
+		for _, j := range segjobs[i] {
+			for _, rel := range j.sec.Relocs {
+				//位置
+				addr := j.sec.Addr + rel.Offset //addr是重定位位置的虚拟地址(绝对)
+				typ, symaddr := l.RelocValue(rel)
+				j.segs.RelocAddr(addr, typ, symaddr)
+				if rel.Type == elf.R_386_32 && l.dyn != nil {
+					j.dynrels = append(j.dynrels, dynRel{addr: addr, sym: l.dyn.resolve(rel.Sym)})
+				}
+			}
+		}
+	})
+	for _, j := range jobs {
+		for _, r := range j.dynrels {
+			l.dyn.addRel(r.addr, r.sym, elf.R_386_32)
 		}
 	}
 }
 
+// 修改重定位位置。数据块在AllocAddr中按偏移递增的顺序放置，用二分查找找到重定位位置所在的数据块
 func (s *SegList) RelocAddr(reladdr, typ, symaddr uint32) {
 	reloff := reladdr - s.baseaddr
 	block := (*Block)(nil)
-	for _, b := range s.blocks {
-		st := b.offset
-		ed := b.size
-		if reloff >= st && reloff <= ed {
+	//最后一个起始偏移不大于reloff的数据块，大小为0的数据块和下一个数据块的起始偏移相同，不会被选中
+	if i := sort.Search(len(s.blocks), func(i int) bool { return s.blocks[i].offset > reloff }) - 1; i >= 0 {
+		if b := s.blocks[i]; reloff < b.offset+b.size {
 			block = b
-			break
 		}
 	}
+	if block == nil || block.data == nil {
+		log.Fatalf("RelocAddr:重定位地址0x%08x不在任何数据块内", reladdr)
+	}
 	paddr := reloff - block.offset //从这个block的第paddr个字节开始的四个字节将被修改
-	ab := *(*[4]byte)(unsafe.Pointer(&symaddr))
+	//重定位位置原来的值是加数
+	addend := binary.LittleEndian.Uint32(block.data[paddr:])
 
-	if typ == R_386_32 {
-		for i := uint32(0); i < 4; i++ {
-			block.data[paddr+i] = ab[i]
-		}
-	} else if typ == R_386_PC32 {
-		v := uint32(0)
-		for i := uint32(0); i < 4; i++ {
-			v = v<<8 + uint32(block.data[paddr+i])
-		}
-		symaddr = symaddr - reladdr + v
-		ab = *(*[4]byte)(unsafe.Pointer(&symaddr))
-		for i := uint32(0); i < 4; i++ {
-			block.data[paddr+i] = ab[i]
-		}
+	if typ == elf.R_386_32 { //S + A
+		binary.LittleEndian.PutUint32(block.data[paddr:], symaddr+addend)
+	} else if typ == elf.R_386_PC32 { //S + A - P
+		binary.LittleEndian.PutUint32(block.data[paddr:], symaddr+addend-reladdr)
 	}
 }
 
 func (l *Linker) Link() {
+	if l.Shared {
+		l.exe.Type = elf.ET_DYN
+	}
+	l.ScriptSyms()
+	l.DynObj()
 	l.ColletInfo()
 	l.SymValid()
+	if l.GCSections {
+		l.GCSecs()
+	}
+	l.DynScan()
+	l.UsedSegs()
 	l.AllocAddr()
+	l.recordLayout()
 	l.SymParse()
+	if l.MapFile != "" {
+		l.WriteMap(l.MapFile)
+	}
 	l.Relocate()
-	l.exe.WriteElf(l)
+	l.DynFill()
+	l.WriteElf()
+	l.SaveState()
 }
 
 const BaseAddr = 0x08048000
@@ -222,16 +794,4 @@ const MemAlign = 4096
 const DiscAlign = 4
 const TextAlign = 16
 
-const (
-	STB_GLOBAL = 1
-	STN_UNDEF  = 0
-)
-
-const (
-	R_386_NONE = 0
-	R_386_32   = 1
-	R_386_PC32 = 2
-	SHN_UNDEF  = 0
-)
-
 const Start = "@start"
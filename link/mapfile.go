@@ -0,0 +1,76 @@
+package link
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+/*
+链接映射文件，在分配地址、解析符号之后生成:
+1. 每个输出段的基址、文件偏移和大小，以及每个输入文件在段中所占的位置。输入段和输出段不同名时写出输入段名
+2. 回收段时去掉的非空输入段
+3. 每个全局符号的地址和定义它的文件，按地址排序
+*/
+func (l *Linker) WriteMap(filename string) {
+	f, err := os.Create(filename)
+	if err != nil {
+		log.Fatal("WriteMap err! ", err)
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	defer w.Flush()
+
+	fmt.Fprintf(w, "Segments\n")
+	fmt.Fprintf(w, "%-10s %-10s %-10s %-10s %s\n", "Name", "Addr", "Offset", "Size", "File")
+	for _, n := range l.segnames {
+		s := l.seglists[n]
+		fmt.Fprintf(w, "%-10s 0x%08x 0x%08x 0x%08x\n", n, s.baseaddr, s.offset, s.size)
+		for _, b := range s.blocks {
+			off := s.offset + b.offset
+			if s.nobits { //.bss不占用文件空间
+				off = s.offset
+			}
+			fmt.Fprintf(w, "%-10s 0x%08x 0x%08x 0x%08x %s\n", "", s.baseaddr+b.offset, off, b.size, l.blockName(n, b))
+		}
+	}
+	if len(l.discards) != 0 {
+		fmt.Fprintf(w, "\nDiscarded\n")
+		fmt.Fprintf(w, "%-10s %s\n", "Size", "File")
+		for _, b := range l.discards {
+			if b.sec.MemSize() == 0 {
+				continue
+			}
+			fmt.Fprintf(w, "0x%08x %s(%s)\n", b.sec.MemSize(), l.objnames[b.owner], b.sec.Name)
+		}
+	}
+
+	syms := []*SymLink{}
+	for _, sl := range l.symdefs {
+		syms = append(syms, sl)
+	}
+	addr := func(sl *SymLink) uint32 {
+		return sl.prov.Lookup(sl.name).Value
+	}
+	sort.SliceStable(syms, func(i, j int) bool {
+		if addr(syms[i]) != addr(syms[j]) {
+			return addr(syms[i]) < addr(syms[j])
+		}
+		return syms[i].name < syms[j].name
+	})
+	fmt.Fprintf(w, "\nSymbols\n")
+	fmt.Fprintf(w, "%-10s %-20s %s\n", "Addr", "Name", "File")
+	for _, sl := range syms {
+		fmt.Fprintf(w, "0x%08x %-20s %s\n", addr(sl), sl.name, l.objnames[sl.prov])
+	}
+}
+
+// 数据块的来源: 文件名，输入段和输出段不同名时加上输入段名
+func (l *Linker) blockName(out string, b *Block) string {
+	if b.sec.Name == out {
+		return l.objnames[b.owner]
+	}
+	return l.objnames[b.owner] + "(" + b.sec.Name + ")"
+}
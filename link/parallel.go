@@ -0,0 +1,34 @@
+package link
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+用不超过GOMAXPROCS个goroutine执行f(0), f(1), ..., f(n-1)，全部完成后返回。
+f(i)只能写第i项自己的数据，结果按下标保存，所以输出和顺序执行时相同
+*/
+func parallel(n int, f func(i int)) {
+	workers := runtime.GOMAXPROCS(0)
+	if workers > n {
+		workers = n
+	}
+	var wg sync.WaitGroup
+	next := int64(-1)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= n {
+					return
+				}
+				f(i)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,81 @@
+package link
+
+import (
+	"bytes"
+	"calgo/elf"
+	"fmt"
+	"runtime"
+	"testing"
+)
+
+// n个互相引用的文件: 第i个文件的fi调用下一个文件的函数和puts，读取di；di指向上一个文件的函数
+func manyObjs(t *testing.T, dir string, n int) []string {
+	t.Helper()
+	files := []string{}
+	name := func(p string, i int) string {
+		return fmt.Sprintf("%s%d", p, (i+n)%n)
+	}
+	for i := 0; i < n; i++ {
+		obj := elf.NewFile(elf.ET_REL)
+		text := obj.AddSection(&elf.Section{Name: ".text", Type: elf.SHT_PROGBITS, Flags: elf.SHF_ALLOC | elf.SHF_EXECINSTR, Align: 16})
+		rodata := obj.AddSection(&elf.Section{Name: ".rodata", Type: elf.SHT_PROGBITS, Flags: elf.SHF_ALLOC, Align: 1, Data: []byte(name("s", i) + "\x00")})
+		data := obj.AddSection(&elf.Section{Name: ".data", Type: elf.SHT_PROGBITS, Flags: elf.SHF_ALLOC | elf.SHF_WRITE, Align: 4, Data: make([]byte, 8)})
+		bss := obj.AddSection(&elf.Section{Name: ".bss", Type: elf.SHT_NOBITS, Flags: elf.SHF_ALLOC | elf.SHF_WRITE, Align: 4, Size: uint32(4 * (i + 1))})
+		if i == 0 {
+			obj.AddSymbol(&elf.Symbol{Name: Start, Bind: elf.STB_GLOBAL, Type: elf.STT_FUNC, Section: text})
+		}
+		obj.AddSymbol(&elf.Symbol{Name: name("f", i), Bind: elf.STB_GLOBAL, Type: elf.STT_FUNC, Section: text})
+		d := obj.AddSymbol(&elf.Symbol{Name: name("d", i), Bind: elf.STB_GLOBAL, Type: elf.STT_OBJECT, Size: 8, Section: data})
+		obj.AddSymbol(&elf.Symbol{Name: name("b", i), Bind: elf.STB_GLOBAL, Type: elf.STT_OBJECT, Section: bss})
+		s := obj.AddSymbol(&elf.Symbol{Name: ".Ls", Bind: elf.STB_LOCAL, Section: rodata})
+		callSym(text, obj.AddSymbol(&elf.Symbol{Name: name("f", i+1), Bind: elf.STB_GLOBAL}))
+		callSym(text, obj.AddSymbol(&elf.Symbol{Name: "puts", Bind: elf.STB_GLOBAL}))
+		text.Relocs = append(text.Relocs, &elf.Reloc{Offset: uint32(len(text.Data) + 1), Type: elf.R_386_32, Sym: d})
+		text.Data = append(text.Data, 0xa1, 0, 0, 0, 0, 0xc3) //mov eax, [di]; ret
+		prev := obj.AddSymbol(&elf.Symbol{Name: name("f", i-1), Bind: elf.STB_GLOBAL})
+		data.Relocs = []*elf.Reloc{{Offset: 0, Type: elf.R_386_32, Sym: prev}, {Offset: 4, Type: elf.R_386_32, Sym: s}}
+		files = append(files, writeObj(t, dir, fmt.Sprintf("o%d.o", i), obj))
+	}
+	return files
+}
+
+// 定义puts的文件，静态链接和生成共享库时使用
+func putsObj(t *testing.T, dir string) string {
+	t.Helper()
+	obj := elf.NewFile(elf.ET_REL)
+	text := obj.AddSection(&elf.Section{Name: ".text", Type: elf.SHT_PROGBITS, Flags: elf.SHF_ALLOC | elf.SHF_EXECINSTR, Align: 16, Data: []byte{0xc3}})
+	obj.AddSymbol(&elf.Symbol{Name: "puts", Bind: elf.STB_GLOBAL, Type: elf.STT_FUNC, Section: text})
+	return writeObj(t, dir, "puts.o", obj)
+}
+
+// 并行链接的输出和顺序链接(GOMAXPROCS为1)的相同
+func TestParallelLink(t *testing.T) {
+	dir := t.TempDir()
+	files := manyObjs(t, dir, 400)
+	libc := stubLibc(t, dir)
+	puts := putsObj(t, dir)
+	tests := []struct {
+		name  string
+		setup func(l *Linker)
+		files []string
+	}{
+		{"static", nil, append([]string{puts}, files...)},
+		{"gc-sections", func(l *Linker) { l.GCSections = true }, append([]string{puts}, files...)},
+		{"shared", func(l *Linker) { l.Shared = true }, append([]string{puts}, files...)},
+		{"dynamic", nil, append(files, libc)},
+	}
+	procs := runtime.GOMAXPROCS(0)
+	defer runtime.GOMAXPROCS(procs)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runtime.GOMAXPROCS(1)
+			serial := linkFiles(t, t.TempDir(), tt.setup, tt.files...)
+			runtime.GOMAXPROCS(16)
+			for i := 0; i < 4; i++ {
+				if b := linkFiles(t, t.TempDir(), tt.setup, tt.files...); !bytes.Equal(b, serial) {
+					t.Fatalf("第%d次并行链接的输出和顺序链接的不同", i+1)
+				}
+			}
+		})
+	}
+}
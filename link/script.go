@@ -0,0 +1,345 @@
+package link
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+链接脚本，语法是GNU ld脚本的一个子集:
+
+	ENTRY(@start)
+	PAGESIZE(4096)
+	SECTIONS
+	{
+		. = 0x100000;
+		.text ALIGN(16);
+		.rodata;
+		.data;
+		__bss_start = .;
+		.bss;
+		_end = .;
+	}
+
+script  -> <command> <script> | ^
+command -> ENTRY ( ID ) | PAGESIZE ( NUM ) | SECTIONS { <items> }
+items   -> <item> <items> | ^
+item    -> . = <value> ; | ID = <value> ; | SECNAME <align> ;
+align   -> ALIGN ( NUM ) | ^
+value   -> NUM | . | ALIGN ( NUM )
+
+SECNAME是以.开头的段名，ALIGN(n)作为value时表示把当前地址按n对齐。注释和C语言的块注释相同。
+脚本中定义的符号只在输入文件没有定义它们时才生效。
+*/
+type Script struct {
+	entry    string
+	pagesize uint32
+	items    []*ScriptItem
+	sum      [32]byte //脚本内容的散列值，增量链接时比较
+}
+
+type ItemKind int
+
+const (
+	SetDot   ItemKind = iota //设置当前地址
+	DefSym                   //定义符号为当前地址
+	PlaceSec                 //放置段
+)
+
+type ScriptItem struct {
+	kind  ItemKind
+	name  string //符号名或段名
+	value *ScriptValue
+	align uint32 //段的对齐，0表示默认对齐
+}
+
+// 当前地址、常量或按对齐后的当前地址
+type ScriptValue struct {
+	dot   bool
+	num   uint32
+	align uint32
+}
+
+func (v *ScriptValue) Eval(dot uint32) uint32 {
+	if v.align != 0 {
+		return (dot + v.align - 1) / v.align * v.align
+	}
+	if v.dot {
+		return dot
+	}
+	return v.num
+}
+
+// 段的默认对齐
+func DefaultAlign(name string) uint32 {
+	if name == ".text" {
+		return TextAlign
+	}
+	return DiscAlign
+}
+
+// 默认的布局：先只读的动态链接信息、.text、.rodata，后可写的.dynamic、.got、.data、.bss。没有用到的段不输出
+const DefaultScript = `
+ENTRY(@start)
+PAGESIZE(4096)
+SECTIONS
+{
+	.interp;
+	.hash;
+	.dynsym;
+	.dynstr;
+	.rel.dyn;
+	.rel.plt;
+	.text;
+	.plt;
+	.rodata;
+	.dynamic;
+	.got;
+	.data;
+	__bss_start = .;
+	.bss;
+	_end = .;
+}
+`
+
+func LoadScript(filename string) *Script {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		log.Fatal("LoadScript err! ", err)
+	}
+	s, err := ParseScript(string(b))
+	if err != nil {
+		log.Fatalf("LoadScript %s: %v", filename, err)
+	}
+	return s
+}
+
+type scriptParser struct {
+	toks  []string
+	lines []int
+	pos   int
+}
+
+func ParseScript(src string) (*Script, error) {
+	p := &scriptParser{}
+	if err := p.scan(src); err != nil {
+		return nil, err
+	}
+	s := &Script{entry: Start, pagesize: MemAlign, sum: sha256.Sum256([]byte(src))}
+	if err := p.script(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// 词法分析: 名字(段名、符号名、关键字、数值、.)由字母、数字和_.@$组成，其余是单字符的符号
+func (p *scriptParser) scan(src string) error {
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return fmt.Errorf("line %d: 注释没有结束", line)
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+		case strings.ContainsRune("(){}=;", rune(c)):
+			p.toks = append(p.toks, string(c))
+			p.lines = append(p.lines, line)
+			i++
+		case isNameChar(c):
+			j := i
+			for j < len(src) && isNameChar(src[j]) {
+				j++
+			}
+			p.toks = append(p.toks, src[i:j])
+			p.lines = append(p.lines, line)
+			i = j
+		default:
+			return fmt.Errorf("line %d: 非法字符%q", line, c)
+		}
+	}
+	return nil
+}
+
+func isNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("_.@$", c) >= 0
+}
+
+func (p *scriptParser) peek() string {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return ""
+}
+
+func (p *scriptParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *scriptParser) errorf(format string, a ...any) error {
+	line := 0
+	if p.pos < len(p.lines) {
+		line = p.lines[p.pos]
+	} else if len(p.lines) != 0 {
+		line = p.lines[len(p.lines)-1]
+	}
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, a...))
+}
+
+func (p *scriptParser) expect(t string) error {
+	if p.peek() != t {
+		return p.errorf("缺少%s, 遇到%q", t, p.peek())
+	}
+	p.pos++
+	return nil
+}
+
+func (p *scriptParser) script(s *Script) error {
+	for p.peek() != "" {
+		switch p.next() {
+		case "ENTRY":
+			if err := p.expect("("); err != nil {
+				return err
+			}
+			s.entry = p.next()
+			if !isName(s.entry) {
+				return p.errorf("ENTRY的参数必须是符号名")
+			}
+			if err := p.expect(")"); err != nil {
+				return err
+			}
+		case "PAGESIZE":
+			n, err := p.paren()
+			if err != nil {
+				return err
+			}
+			if n == 0 || n&(n-1) != 0 {
+				return p.errorf("页大小必须是2的幂")
+			}
+			s.pagesize = n
+		case "SECTIONS":
+			if err := p.expect("{"); err != nil {
+				return err
+			}
+			placed := map[string]bool{}
+			for _, it := range s.items {
+				if it.kind == PlaceSec {
+					placed[it.name] = true
+				}
+			}
+			for p.peek() != "}" {
+				if p.peek() == "" {
+					return p.errorf("SECTIONS缺少}")
+				}
+				it, err := p.item()
+				if err != nil {
+					return err
+				}
+				if it.kind == PlaceSec {
+					if placed[it.name] {
+						p.pos--
+						return p.errorf("段%s被放置了两次", it.name)
+					}
+					placed[it.name] = true
+				}
+				s.items = append(s.items, it)
+			}
+			p.pos++
+		default:
+			p.pos--
+			return p.errorf("未知的命令%q", p.peek())
+		}
+	}
+	return nil
+}
+
+func (p *scriptParser) item() (*ScriptItem, error) {
+	name := p.next()
+	it := &ScriptItem{name: name}
+	if p.peek() == "=" {
+		p.pos++
+		if name == "." {
+			it.kind = SetDot
+		} else if isName(name) {
+			it.kind = DefSym
+		} else {
+			return nil, p.errorf("不能给%q赋值", name)
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		it.value = v
+	} else if strings.HasPrefix(name, ".") && len(name) > 1 {
+		it.kind = PlaceSec
+		if p.peek() == "ALIGN" {
+			p.pos++
+			n, err := p.paren()
+			if err != nil {
+				return nil, err
+			}
+			if n == 0 {
+				return nil, p.errorf("对齐不能是0")
+			}
+			it.align = n
+		}
+	} else {
+		return nil, p.errorf("SECTIONS中只能放置段或者赋值, 遇到%q", name)
+	}
+	return it, p.expect(";")
+}
+
+func (p *scriptParser) value() (*ScriptValue, error) {
+	t := p.next()
+	switch {
+	case t == ".":
+		return &ScriptValue{dot: true}, nil
+	case t == "ALIGN":
+		n, err := p.paren()
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, p.errorf("对齐不能是0")
+		}
+		return &ScriptValue{align: n}, nil
+	}
+	n, err := strconv.ParseUint(t, 0, 32)
+	if err != nil {
+		p.pos--
+		return nil, p.errorf("%q不是数值", t)
+	}
+	return &ScriptValue{num: uint32(n)}, nil
+}
+
+// ( NUM )
+func (p *scriptParser) paren() (uint32, error) {
+	if err := p.expect("("); err != nil {
+		return 0, err
+	}
+	t := p.next()
+	n, err := strconv.ParseUint(t, 0, 32)
+	if err != nil {
+		p.pos--
+		return 0, p.errorf("%q不是数值", t)
+	}
+	return uint32(n), p.expect(")")
+}
+
+func isName(t string) bool {
+	return t != "" && t != "." && !strings.HasPrefix(t, ".") && !(t[0] >= '0' && t[0] <= '9')
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"calgo/ar"
 	"calgo/asm"
+	"calgo/link"
+	"calgo/preprocess"
 	"calgo/syntax"
 	"calgo/table"
 	"flag"
@@ -46,14 +49,137 @@ func create_file(fpath string) {
 	defer file.Close()
 }
 
+/*
+calgo ar <archive.a> <file.o>...
+用可重定位文件创建带符号索引的静态库
+*/
+func arMain(args []string) {
+	if len(args) < 2 {
+		fmt.Println("usage: calgo ar <archive.a> <file.o>...")
+		os.Exit(2)
+	}
+	members := []*ar.Member{}
+	for _, f := range args[1:] {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			log.Fatal(err)
+		}
+		members = append(members, &ar.Member{Name: filepath.Base(f), Data: b})
+	}
+	a, err := ar.Create(members)
+	if err != nil {
+		log.Fatal(err)
+	}
+	create_file(args[0])
+	file, err := os.OpenFile(args[0], os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	if err = a.Write(file); err != nil {
+		log.Fatal(err)
+	}
+}
+
+/*
+calgo link [-o exefile] [--map=file] [-T script] [--gc-sections] [--strip] [-shared [-soname name]] [-needed soname]... [-L dir]... [-dynamic-linker path] [--incremental] <file.o|archive.a|lib.so>...
+链接可重定位文件、静态库和共享库，静态库只能解析在它之前出现的文件中的未定义符号。-T指定链接脚本，--gc-sections去掉没有被引用的段，
+--strip去掉可执行文件的符号表和段表，-shared生成共享库，没有定义的符号在运行时解析。
+输入了共享库或者用-needed声明了运行时依赖的共享库(例如libc.so.6)时生成动态链接的可执行文件。
+-needed声明的共享库在-L指定的目录和系统目录中查找，用它的动态符号表检查导入的符号，找不到时只警告。
+--incremental把链接状态保存在exefile.state中，下次链接时只修补改变了的可重定位文件
+*/
+func linkMain(args []string) {
+	flags := flag.NewFlagSet("link", flag.ExitOnError)
+	exefile := flags.String("o", "./out/exe.out", "executable file")
+	mapfile := flags.String("map", "", "write a link map to this file")
+	script := flags.String("T", "", "linker script")
+	gcsections := flags.Bool("gc-sections", false, "remove sections unreachable from the entry symbol")
+	strip := flags.Bool("strip", false, "omit the symbol table and section headers")
+	shared := flags.Bool("shared", false, "produce a shared object")
+	soname := flags.String("soname", "", "set DT_SONAME of the shared object")
+	interp := flags.String("dynamic-linker", link.DefaultInterp, "program interpreter of a dynamically linked executable")
+	needed := []string{}
+	flags.Func("needed", "add a DT_NEEDED entry for a shared library not read at link time", func(s string) error {
+		needed = append(needed, s)
+		return nil
+	})
+	libdirs := []string{}
+	flags.Func("L", "search this directory for -needed libraries before the system directories", func(s string) error {
+		libdirs = append(libdirs, s)
+		return nil
+	})
+	incremental := flags.Bool("incremental", false, "keep the link state in exefile.state and patch only the changed object on relink")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		fmt.Println("usage: calgo link [-o exefile] [--map=file] [-T script] [--gc-sections] [--strip] [-shared [-soname name]] [-needed soname]... [-L dir]... [-dynamic-linker path] [--incremental] <file.o|archive.a|lib.so>...")
+		os.Exit(2)
+	}
+	linker := link.NewLinker()
+	linker.MapFile = *mapfile
+	linker.GCSections = *gcsections
+	linker.Strip = *strip
+	linker.Shared = *shared
+	linker.Soname = *soname
+	linker.Needed = needed
+	linker.LibDirs = append(libdirs, link.DefaultLibDirs...)
+	linker.Interp = *interp
+	if *script != "" {
+		linker.LoadScript(*script)
+	}
+	if *incremental {
+		linker.StateFile = *exefile + ".state"
+		if linker.Relink(*exefile, flags.Args()) {
+			return
+		}
+	}
+	linker.AddFiles(flags.Args())
+	create_file(*exefile)
+	var err error
+	link.EXEFILE, err = os.OpenFile(*exefile, os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer link.EXEFILE.Close()
+	linker.Link()
+	os.Chmod(*exefile, 0755)
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "ar":
+			arMain(os.Args[2:])
+			return
+		case "link":
+			linkMain(os.Args[2:])
+			return
+		}
+	}
 	var err error
 	var intercode_spec InterCodeSpec
 	sourcefile := flag.String("sourcefile", "./demo/intercode.demo", "source file")
 	asmfile := flag.String("asmfile", "./out/code.asm", "assembly file")
 	exefile := flag.String("exefile", "./out/elf_reloc.o", "relocatable object file")
 	flag.Var(&intercode_spec, "print_intercode", "print intercode")
+	flag.BoolVar(&asm.FunctionSections, "function-sections", false, "place each function and global variable in its own section")
+	flag.BoolVar(&table.PIC, "fPIC", false, "generate position-independent code")
+	flag.Func("I", "add a directory to the #include search path", func(s string) error {
+		preprocess.IncludeDirs = append(preprocess.IncludeDirs, s)
+		return nil
+	})
+	onlyPreprocess := flag.Bool("E", false, "write the preprocessed source to stdout and stop")
 	flag.Parse()
+	if *onlyPreprocess {
+		src, err := preprocess.File(*sourcefile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err = src.Dump(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	/* make sure the output files exists(sourcefile is user's duty)  */
 	create_file(*asmfile)
 	create_file(*exefile)
@@ -85,11 +211,5 @@ func main() {
 	}
 	asm.ELFOBJ.WriteElf()
 
-	/* 链接阶段（未完成）*/
-	//linker := link.NewLinker()
-	//args := os.Args
-	//for _, arg := range args[1:] {
-	//	linker.AddELF(arg)
-	//}
-	//linker.Link()
+	/* 链接阶段: calgo link */
 }
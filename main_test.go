@@ -0,0 +1,224 @@
+package main
+
+import (
+	"calgo/asm"
+	"calgo/link"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/*
+编译器、汇编器和链接器的状态都是全局的，每次编译、汇编和链接都在子进程中进行:
+CALGO_MAIN=1时测试程序就是calgo，CALGO_AS=1时把参数中的汇编文件汇编成可重定位文件
+*/
+func TestMain(m *testing.M) {
+	if os.Getenv("CALGO_MAIN") == "1" {
+		main()
+		os.Exit(0)
+	}
+	if os.Getenv("CALGO_AS") == "1" {
+		asm.NewParser(os.Args[1]).Parse()
+		asm.Symtab.ExportSyms()
+		f, err := os.Create(os.Args[2])
+		if err != nil {
+			panic(err)
+		}
+		asm.EXEFILE = f
+		asm.ELFOBJ.WriteElf()
+		f.Close()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// 在子进程中运行测试程序，env是CALGO_MAIN或CALGO_AS，返回输出
+func run(t *testing.T, env string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), env+"=1")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return string(out)
+}
+
+// 把C源程序src编译成dir/name.o，flags是编译选项
+func compile(t *testing.T, dir, name, src string, flags ...string) string {
+	t.Helper()
+	c := filepath.Join(dir, name+".c")
+	if err := os.WriteFile(c, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	o := filepath.Join(dir, name+".o")
+	args := append(flags, "-sourcefile", c, "-asmfile", filepath.Join(dir, name+".s"), "-exefile", o)
+	if out := run(t, "CALGO_MAIN", args...); !strings.Contains(out, "语法分析通过") {
+		t.Fatalf("编译失败:\n%s", out)
+	}
+	return o
+}
+
+// 把汇编程序src汇编成dir/name.o
+func assemble(t *testing.T, dir, name, src string) string {
+	t.Helper()
+	s := filepath.Join(dir, name+".s")
+	if err := os.WriteFile(s, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	o := filepath.Join(dir, name+".o")
+	run(t, "CALGO_AS", s, o)
+	return o
+}
+
+// 调用f之前设置ebx、esi和edi，f返回后它们不变时以f的返回值退出，否则以99退出
+const checkRegs = `section .text
+global f
+global @start
+@start:
+    mov ebx, 17
+    mov esi, 34
+    mov edi, 51
+    call f
+    mov ecx, eax
+    mov eax, 1
+    cmp ebx, 17
+    jne bad
+    cmp esi, 34
+    jne bad
+    cmp edi, 51
+    jne bad
+    mov ebx, ecx
+    int 128
+bad:
+    mov ebx, 99
+    int 128
+`
+
+// 生成的函数保存和恢复它用到的ebx、esi和edi
+func TestCalleeSaved(t *testing.T) {
+	src := `
+long long q;
+int f() {
+	long long a;
+	long long b;
+	char c;
+	int x;
+	a = 1000000000000;
+	b = 7;
+	q = a / b;
+	c = 'a';
+	x = c - 92;
+	return x * 3 + 2;
+}
+`
+	for _, flags := range [][]string{nil, {"-fPIC"}} {
+		dir := t.TempDir()
+		start := assemble(t, dir, "start", checkRegs)
+		f := compile(t, dir, "f", src, flags...)
+		exe := filepath.Join(dir, "prog")
+		run(t, "CALGO_MAIN", "link", "-o", exe, start, f)
+		err := exec.Command(exe).Run()
+		if e, ok := err.(*exec.ExitError); !ok || e.ExitCode() != 17 {
+			t.Errorf("%v: 程序的退出状态是%v，应该是17", flags, err)
+		}
+	}
+}
+
+// 指向数组的指针int (*p)[4]按所指数组的大小移动，可以作为参数和全局变量
+func TestArrayPointer(t *testing.T) {
+	src := `
+int m[3][4];
+int (*gp)[4];
+int sum(int (*rows)[4], int n) {
+	int i, j, s;
+	s = 0;
+	for (i = 0; i < n; i++) {
+		for (j = 0; j < 4; j++) {
+			s += rows[i][j];
+		}
+	}
+	return s;
+}
+int f() {
+	int (*p)[4] = m;
+	int (*q)[4];
+	int a, b;
+	for (a = 0; a < 3; a++) {
+		for (b = 0; b < 4; b++) {
+			m[a][b] = a * 10 + b;
+		}
+	}
+	q = p + 1;
+	q++;
+	gp = m;
+	gp[1][1] = 99;
+	if (p[2][3] != 23 || (*q)[1] != 21 || m[1][1] != 99 || *q[0] != 20) {
+		return 1;
+	}
+	return sum(p + 2, 1) - sum(gp, 1) - 63;
+}
+`
+	for _, flags := range [][]string{nil, {"-fPIC"}} {
+		dir := t.TempDir()
+		start := assemble(t, dir, "start", checkRegs)
+		f := compile(t, dir, "f", src, flags...)
+		exe := filepath.Join(dir, "prog")
+		run(t, "CALGO_MAIN", "link", "-o", exe, start, f)
+		err := exec.Command(exe).Run()
+		if e, ok := err.(*exec.ExitError); !ok || e.ExitCode() != 17 {
+			t.Errorf("%v: 程序的退出状态是%v，应该是17", flags, err)
+		}
+	}
+}
+
+// 预处理出错时以非0状态退出
+func TestPreprocessError(t *testing.T) {
+	dir := t.TempDir()
+	c := filepath.Join(dir, "e.c")
+	if err := os.WriteFile(c, []byte("#error stop\nint main() { return 0; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(os.Args[0], "-sourcefile", c, "-asmfile", filepath.Join(dir, "e.s"), "-exefile", filepath.Join(dir, "e.o"))
+	cmd.Env = append(os.Environ(), "CALGO_MAIN=1")
+	out, err := cmd.CombinedOutput()
+	if e, ok := err.(*exec.ExitError); !ok || e.ExitCode() == 0 {
+		t.Errorf("退出状态是%v，应该不是0\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "预处理错误") || !strings.Contains(string(out), "stop") {
+		t.Errorf("输出是%s", out)
+	}
+}
+
+// 动态链接系统的libc，调用printf。没有32位的动态链接器时只检查能否链接
+func TestLibcPrintf(t *testing.T) {
+	src := `
+extern int printf(char *fmt, ...);
+int main() {
+	int n;
+	n = 42;
+	printf("%d %s %c\n", n, "ok", 'x');
+	return 0;
+}
+`
+	dir := t.TempDir()
+	start, err := os.ReadFile("asm/start_libc.asm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := compile(t, dir, "main", src)
+	exe := filepath.Join(dir, "prog")
+	run(t, "CALGO_MAIN", "link", "-needed", "libc.so.6", "-o", exe, assemble(t, dir, "start", string(start)), obj)
+	if _, err := os.Stat(link.DefaultInterp); err != nil {
+		t.Skipf("没有%s: %v", link.DefaultInterp, err)
+	}
+	out, err := exec.Command(exe).Output()
+	if err != nil {
+		t.Fatalf("运行失败: %v", err)
+	}
+	if string(out) != "42 ok x\n" {
+		t.Errorf("输出是%q", out)
+	}
+}
@@ -0,0 +1,279 @@
+package preprocess
+
+import (
+	"strconv"
+	"strings"
+)
+
+/*
+#if和#elif的条件: 先把defined X和defined(X)替换为1或0，再展开宏，剩下的标识符当作0，然后按C语言的整数常量表达式求值:
+cond   -> lor ? cond : cond | lor
+lor    -> land { || land }
+land   -> bor { && bor }
+bor    -> bxor { | bxor }
+bxor   -> band { ^ band }
+band   -> equ { & equ }
+equ    -> rel { == rel | != rel }
+rel    -> shift { < shift | > shift | <= shift | >= shift }
+shift  -> add { << add | >> add }
+add    -> mul { + mul | - mul }
+mul    -> unary { * unary | / unary | % unary }
+unary  -> + unary | - unary | ! unary | ~ unary | primary
+primary-> NUM | CHAR | ID | ( cond )
+*/
+func (p *Preprocessor) eval(rest string) (bool, error) {
+	toks := tokenize(rest)
+	var ts []*token
+	for i := 0; i < len(toks); i++ {
+		t := toks[i]
+		if t.text != "defined" {
+			ts = append(ts, t)
+			continue
+		}
+		name := ""
+		if i+1 < len(toks) && toks[i+1].kind == tIdent {
+			name = toks[i+1].text
+			i++
+		} else if i+3 < len(toks) && toks[i+1].text == "(" && toks[i+2].kind == tIdent && toks[i+3].text == ")" {
+			name = toks[i+2].text
+			i += 3
+		} else {
+			return false, p.errorf("defined后面必须是宏名")
+		}
+		v := "0"
+		if p.defined(name) {
+			v = "1"
+		}
+		ts = append(ts, &token{kind: tNumber, text: v, space: t.space})
+	}
+	ts, err := p.expand(ts, nil)
+	if err != nil {
+		return false, err
+	}
+	if len(ts) == 0 {
+		return false, p.errorf("#if后面缺少表达式")
+	}
+	e := &exprParser{p: p, toks: ts}
+	v, err := e.cond()
+	if err != nil {
+		return false, err
+	}
+	if e.pos < len(ts) {
+		return false, p.errorf("#if的表达式中多余的%s", ts[e.pos].text)
+	}
+	return v != 0, nil
+}
+
+type exprParser struct {
+	p      *Preprocessor
+	toks   []*token
+	pos    int
+	noeval int //不求值的部分，例如0 && x中的x，其中的除数可以是0
+}
+
+// 二元运算符的优先级，从低到高
+var binops = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<", ">", "<=", ">="},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (e *exprParser) peek() string {
+	if e.pos < len(e.toks) {
+		return e.toks[e.pos].text
+	}
+	return ""
+}
+
+// cond -> lor ? cond : cond | lor
+func (e *exprParser) cond() (int64, error) {
+	c, err := e.binary(0)
+	if err != nil || e.peek() != "?" {
+		return c, err
+	}
+	e.pos++
+	if c == 0 {
+		e.noeval++
+	}
+	a, err := e.cond()
+	if c == 0 {
+		e.noeval--
+	}
+	if err != nil {
+		return 0, err
+	}
+	if e.peek() != ":" {
+		return 0, e.p.errorf("#if的表达式中?后面缺少:")
+	}
+	e.pos++
+	if c != 0 {
+		e.noeval++
+	}
+	b, err := e.cond()
+	if c != 0 {
+		e.noeval--
+	}
+	if c != 0 {
+		return a, err
+	}
+	return b, err
+}
+
+// 第level级的二元运算，左结合。&&和||的右边在左边已经决定结果时不求值
+func (e *exprParser) binary(level int) (int64, error) {
+	if level == len(binops) {
+		return e.unary()
+	}
+	x, err := e.binary(level + 1)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := e.peek()
+		found := false
+		for _, o := range binops[level] {
+			found = found || o == op
+		}
+		if !found {
+			return x, nil
+		}
+		e.pos++
+		skip := op == "&&" && x == 0 || op == "||" && x != 0
+		if skip {
+			e.noeval++
+		}
+		y, err := e.binary(level + 1)
+		if skip {
+			e.noeval--
+		}
+		if err != nil {
+			return 0, err
+		}
+		if x, err = e.apply(op, x, y); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (e *exprParser) apply(op string, x, y int64) (int64, error) {
+	b := func(v bool) int64 {
+		if v {
+			return 1
+		}
+		return 0
+	}
+	switch op {
+	case "||":
+		return b(x != 0 || y != 0), nil
+	case "&&":
+		return b(x != 0 && y != 0), nil
+	case "|":
+		return x | y, nil
+	case "^":
+		return x ^ y, nil
+	case "&":
+		return x & y, nil
+	case "==":
+		return b(x == y), nil
+	case "!=":
+		return b(x != y), nil
+	case "<":
+		return b(x < y), nil
+	case ">":
+		return b(x > y), nil
+	case "<=":
+		return b(x <= y), nil
+	case ">=":
+		return b(x >= y), nil
+	case "<<":
+		return x << uint64(y&63), nil
+	case ">>":
+		return x >> uint64(y&63), nil
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	}
+	if y == 0 {
+		if e.noeval > 0 {
+			return 0, nil
+		}
+		return 0, e.p.errorf("#if的表达式中除数为0")
+	}
+	if op == "/" {
+		return x / y, nil
+	}
+	return x % y, nil
+}
+
+// unary -> + unary | - unary | ! unary | ~ unary | primary
+func (e *exprParser) unary() (int64, error) {
+	op := e.peek()
+	if op != "+" && op != "-" && op != "!" && op != "~" {
+		return e.primary()
+	}
+	e.pos++
+	x, err := e.unary()
+	switch op {
+	case "-":
+		x = -x
+	case "!":
+		if x == 0 {
+			x = 1
+		} else {
+			x = 0
+		}
+	case "~":
+		x = ^x
+	}
+	return x, err
+}
+
+// primary -> NUM | CHAR | ID | ( cond )，展开之后剩下的标识符是0
+func (e *exprParser) primary() (int64, error) {
+	if e.pos >= len(e.toks) {
+		return 0, e.p.errorf("#if的表达式不完整")
+	}
+	t := e.toks[e.pos]
+	e.pos++
+	switch t.kind {
+	case tIdent:
+		return 0, nil
+	case tNumber:
+		s := strings.TrimRight(t.text, "uUlL")
+		if v, err := strconv.ParseInt(s, 0, 64); err == nil {
+			return v, nil
+		}
+		if v, err := strconv.ParseUint(s, 0, 64); err == nil {
+			return int64(v), nil
+		}
+		return 0, e.p.errorf("#if的表达式中非法的数%s", t.text)
+	case tChar:
+		v, _, tail, err := strconv.UnquoteChar(strings.TrimSuffix(strings.TrimPrefix(t.text, "'"), "'"), '\'')
+		if err != nil || tail != "" {
+			return 0, e.p.errorf("#if的表达式中非法的字符%s", t.text)
+		}
+		return int64(v), nil
+	}
+	if t.text == "(" {
+		v, err := e.cond()
+		if err != nil {
+			return 0, err
+		}
+		if e.peek() != ")" {
+			return 0, e.p.errorf("#if的表达式中缺少)")
+		}
+		e.pos++
+		return v, nil
+	}
+	return 0, e.p.errorf("#if的表达式中非法的%s", t.text)
+}
@@ -0,0 +1,479 @@
+package preprocess
+
+import (
+	"strconv"
+	"strings"
+)
+
+// 预处理记号的种类
+const (
+	tIdent = iota
+	tNumber
+	tString
+	tChar
+	tPunct
+)
+
+type token struct {
+	kind  int
+	text  string
+	space bool     //前面有空白
+	hide  []string //展开这个记号时不再展开的宏，防止宏递归展开
+}
+
+/*
+宏定义:
+#define 名字 替换列表
+#define 名字(参数, ...) 替换列表
+函数式宏的名字和(之间不能有空白。最后一个参数是...时，多余的实参由__VA_ARGS__引用
+*/
+type Macro struct {
+	name     string
+	function bool
+	params   []string
+	variadic bool
+	body     []*token
+}
+
+// 多字符的运算符，长的在前
+var puncts = []string{"...", "<<=", ">>=", "##", "<<", ">>", "<=", ">=", "==", "!=", "&&", "||", "++", "--", "->",
+	"+=", "-=", "*=", "/=", "%=", "&=", "|=", "^="}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || isDigit(c)
+}
+
+// 把一行分成预处理记号。没有结束的字符串和字符常量到行尾为止，由词法分析报错
+func tokenize(line string) []*token {
+	var toks []*token
+	space := false
+	for i := 0; i < len(line); {
+		c := line[i]
+		j := i + 1
+		kind := tPunct
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+			space = true
+			i++
+			continue
+		case isIdentChar(c) && !isDigit(c):
+			kind = tIdent
+			for j < len(line) && isIdentChar(line[j]) {
+				j++
+			}
+		case isDigit(c) || c == '.' && i+1 < len(line) && isDigit(line[i+1]):
+			kind = tNumber
+			for j < len(line) {
+				if isIdentChar(line[j]) || line[j] == '.' {
+					j++
+				} else if (line[j] == '+' || line[j] == '-') && strings.IndexByte("eEpP", line[j-1]) >= 0 {
+					j++
+				} else {
+					break
+				}
+			}
+		case c == '"' || c == '\'':
+			kind = tString
+			if c == '\'' {
+				kind = tChar
+			}
+			for j < len(line) && line[j] != c {
+				if line[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j < len(line) {
+				j++
+			} else {
+				j = len(line)
+			}
+		default:
+			for _, p := range puncts {
+				if strings.HasPrefix(line[i:], p) {
+					j = i + len(p)
+					break
+				}
+			}
+		}
+		toks = append(toks, &token{kind: kind, text: line[i:j], space: space})
+		space = false
+		i = j
+	}
+	return toks
+}
+
+// 输出展开后的记号。原来有空白的地方输出一个空格，连在一起会变成别的记号时也加一个空格
+func render(toks []*token) string {
+	b := strings.Builder{}
+	for i, t := range toks {
+		if i > 0 && (t.space || joins(toks[i-1], t)) {
+			b.WriteByte(' ')
+		}
+		b.WriteString(t.text)
+	}
+	return b.String()
+}
+
+func joins(a, b *token) bool {
+	toks := tokenize(a.text + b.text)
+	return len(toks) != 2 || toks[0].text != a.text
+}
+
+var escaper = strings.NewReplacer("\\", "\\\\", "\"", "\\\"")
+
+// 字符串常量，转义\和"
+func quote(s string) string {
+	return "\"" + escaper.Replace(s) + "\""
+}
+
+func hidden(t *token, name string) bool {
+	for _, h := range t.hide {
+		if h == name {
+			return true
+		}
+	}
+	return false
+}
+
+// 两个集合的并集，总是返回新的切片
+func union(a, b []string) []string {
+	s := append([]string{}, a...)
+	for _, n := range b {
+		found := false
+		for _, m := range a {
+			found = found || m == n
+		}
+		if !found {
+			s = append(s, n)
+		}
+	}
+	return s
+}
+
+func intersect(a, b []string) []string {
+	var s []string
+	for _, n := range a {
+		for _, m := range b {
+			if n == m {
+				s = append(s, n)
+				break
+			}
+		}
+	}
+	return s
+}
+
+func copyToks(toks []*token) []*token {
+	cp := make([]*token, len(toks))
+	for i, t := range toks {
+		c := *t
+		cp[i] = &c
+	}
+	return cp
+}
+
+func (p *Preprocessor) defined(name string) bool {
+	_, ok := p.macros[name]
+	return ok || name == "__FILE__" || name == "__LINE__"
+}
+
+func (p *Preprocessor) hasMacro(toks []*token) bool {
+	for _, t := range toks {
+		if t.kind == tIdent && p.defined(t.text) {
+			return true
+		}
+	}
+	return false
+}
+
+// #define
+func (p *Preprocessor) define(rest string) error {
+	toks := tokenize(rest)
+	if len(toks) == 0 || toks[0].kind != tIdent {
+		return p.errorf("#define后面必须是宏名")
+	}
+	name := toks[0].text
+	if name == "defined" || name == "__FILE__" || name == "__LINE__" {
+		return p.errorf("不能定义宏%s", name)
+	}
+	m := &Macro{name: name}
+	body := toks[1:]
+	if len(body) != 0 && body[0].text == "(" && !body[0].space {
+		m.function = true
+		i := 1
+		if i < len(body) && body[i].text == ")" {
+			i++
+		} else {
+			for {
+				if i >= len(body) {
+					return p.errorf("宏%s的参数表缺少)", name)
+				}
+				t := body[i]
+				if t.text == "..." {
+					m.variadic = true
+					m.params = append(m.params, "__VA_ARGS__")
+				} else if t.kind == tIdent && t.text != "__VA_ARGS__" && m.param(t.text) < 0 {
+					m.params = append(m.params, t.text)
+				} else {
+					return p.errorf("宏%s的参数表中有非法的%s", name, t.text)
+				}
+				i++
+				if i < len(body) && body[i].text == ")" {
+					i++
+					break
+				}
+				if m.variadic || i >= len(body) || body[i].text != "," {
+					return p.errorf("宏%s的参数表缺少)", name)
+				}
+				i++
+			}
+		}
+		body = body[i:]
+	}
+	for i, t := range body {
+		if t.text == "##" && (i == 0 || i == len(body)-1) {
+			return p.errorf("##不能在宏%s的替换列表的两端", name)
+		}
+		if m.function && t.text == "#" && (i == len(body)-1 || m.param(body[i+1].text) < 0) {
+			return p.errorf("宏%s中#后面必须是参数", name)
+		}
+	}
+	if len(body) != 0 {
+		body[0].space = false
+	}
+	m.body = body
+	if old, ok := p.macros[name]; ok && !old.same(m) {
+		return p.errorf("宏%s重定义", name)
+	}
+	p.macros[name] = m
+	return nil
+}
+
+// 参数的序号，不是参数时返回-1
+func (m *Macro) param(name string) int {
+	for i, n := range m.params {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// 相同的宏可以重复定义
+func (m *Macro) same(o *Macro) bool {
+	if m.function != o.function || m.variadic != o.variadic || len(m.params) != len(o.params) || len(m.body) != len(o.body) {
+		return false
+	}
+	for i := range m.params {
+		if m.params[i] != o.params[i] {
+			return false
+		}
+	}
+	for i := range m.body {
+		if m.body[i].text != o.body[i].text || m.body[i].space != o.body[i].space {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+展开记号序列中的宏。每个记号带有不再展开的宏的集合(hide set):
+对象式宏的替换结果加入宏名；函数式宏的替换结果加入宏名和宏名、右括号两者集合的交集。
+替换结果放回输入的开头继续展开。more不为nil时函数式宏的实参可以延续到后面的行
+*/
+func (p *Preprocessor) expand(toks []*token, more func() ([]*token, bool)) ([]*token, error) {
+	in := copyToks(toks)
+	var out []*token
+	for len(in) != 0 {
+		t := in[0]
+		in = in[1:]
+		if t.kind != tIdent || hidden(t, t.text) {
+			out = append(out, t)
+			continue
+		}
+		switch t.text {
+		case "__FILE__":
+			out = append(out, &token{kind: tString, text: quote(p.cur.File), space: t.space})
+			continue
+		case "__LINE__":
+			out = append(out, &token{kind: tNumber, text: strconv.Itoa(p.cur.Line), space: t.space})
+			continue
+		}
+		m, ok := p.macros[t.text]
+		if !ok {
+			out = append(out, t)
+			continue
+		}
+		if !m.function {
+			repl, err := p.subst(m, nil, union(t.hide, []string{m.name}))
+			if err != nil {
+				return nil, err
+			}
+			if len(repl) != 0 {
+				repl[0].space = t.space
+			}
+			in = append(repl, in...)
+			continue
+		}
+		for len(in) == 0 && more != nil {
+			next, ok := more()
+			if !ok {
+				break
+			}
+			in = append(in, next...)
+		}
+		if len(in) == 0 || in[0].text != "(" { //函数式宏的名字后面没有(时不展开
+			out = append(out, t)
+			continue
+		}
+		args, rparen, rest, err := p.args(m, in[1:], more)
+		if err != nil {
+			return nil, err
+		}
+		repl, err := p.subst(m, args, union(intersect(t.hide, rparen.hide), []string{m.name}))
+		if err != nil {
+			return nil, err
+		}
+		if len(repl) != 0 {
+			repl[0].space = t.space
+		}
+		in = append(repl, rest...)
+	}
+	return out, nil
+}
+
+// 收集函数式宏的实参，返回实参、右括号和右括号之后的记号。括号中的逗号不分隔实参
+func (p *Preprocessor) args(m *Macro, in []*token, more func() ([]*token, bool)) ([][]*token, *token, []*token, error) {
+	var args [][]*token
+	cur := []*token{}
+	depth := 0
+	for i := 0; ; i++ {
+		for i >= len(in) {
+			if more == nil {
+				return nil, nil, nil, p.errorf("宏%s的实参表缺少)", m.name)
+			}
+			next, ok := more()
+			if !ok {
+				return nil, nil, nil, p.errorf("宏%s的实参表缺少)", m.name)
+			}
+			in = append(in, next...)
+		}
+		t := in[i]
+		switch {
+		case t.text == "(":
+			depth++
+		case t.text == ")" && depth == 0:
+			args = append(args, cur)
+			if len(m.params) == 0 && len(args) == 1 && len(args[0]) == 0 {
+				args = nil
+			}
+			if m.variadic && len(args) == len(m.params)-1 {
+				args = append(args, []*token{})
+			}
+			if len(args) != len(m.params) {
+				return nil, nil, nil, p.errorf("宏%s需要%d个实参，实际有%d个", m.name, len(m.params), len(args))
+			}
+			return args, t, in[i+1:], nil
+		case t.text == ")":
+			depth--
+		case t.text == "," && depth == 0 && !(m.variadic && len(args) == len(m.params)-1):
+			args = append(args, cur)
+			cur = []*token{}
+			continue
+		}
+		cur = append(cur, t)
+	}
+}
+
+/*
+用实参替换宏的替换列表，结果中的每个记号都加入hs:
+#参数: 实参的字符串形式
+##两边的参数: 不展开的实参，##把左右两个记号连接成一个记号，空的实参不参与连接
+其他参数: 完全展开之后的实参
+*/
+func (p *Preprocessor) subst(m *Macro, args [][]*token, hs []string) ([]*token, error) {
+	var out []*token
+	empty := false //##左边的实参为空
+	body := m.body
+	for i := 0; i < len(body); i++ {
+		t := body[i]
+		if m.function && t.text == "#" {
+			i++
+			s := stringize(args[m.param(body[i].text)])
+			s.space = t.space
+			out = append(out, s)
+			continue
+		}
+		if t.text == "##" {
+			i++
+			rhs := []*token{body[i]}
+			if a := m.param(body[i].text); m.function && a >= 0 {
+				rhs = args[a]
+			}
+			rhs = copyToks(rhs)
+			if len(rhs) == 0 {
+				continue
+			}
+			if empty || len(out) == 0 {
+				empty = false
+				out = append(out, rhs...)
+				continue
+			}
+			lhs := out[len(out)-1]
+			toks := tokenize(lhs.text + rhs[0].text)
+			if len(toks) != 1 {
+				return nil, p.errorf("##连接%s和%s得到的不是一个记号", lhs.text, rhs[0].text)
+			}
+			toks[0].space = lhs.space
+			out[len(out)-1] = toks[0]
+			out = append(out, rhs[1:]...)
+			continue
+		}
+		if a := m.param(t.text); m.function && a >= 0 {
+			arg := args[a]
+			if i+1 < len(body) && body[i+1].text == "##" {
+				empty = len(arg) == 0
+			} else {
+				var err error
+				if arg, err = p.expand(arg, nil); err != nil {
+					return nil, err
+				}
+			}
+			arg = copyToks(arg)
+			if len(arg) != 0 {
+				arg[0].space = t.space
+			}
+			out = append(out, arg...)
+			continue
+		}
+		out = append(out, copyToks([]*token{t})...)
+	}
+	for _, t := range out {
+		t.hide = union(t.hide, hs)
+	}
+	return out, nil
+}
+
+// 实参的字符串形式: 记号之间有空白时用一个空格分隔，字符串和字符常量中的\和"被转义
+func stringize(arg []*token) *token {
+	b := strings.Builder{}
+	b.WriteByte('"')
+	for i, t := range arg {
+		if i > 0 && t.space {
+			b.WriteByte(' ')
+		}
+		if t.kind == tString || t.kind == tChar {
+			b.WriteString(escaper.Replace(t.text))
+		} else {
+			b.WriteString(t.text)
+		}
+	}
+	b.WriteByte('"')
+	return &token{kind: tString, text: b.String()}
+}
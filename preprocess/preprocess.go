@@ -0,0 +1,459 @@
+package preprocess
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/*
+C预处理器，在词法分析之前处理源文件:
+1. 删除注释(注释中的换行保留)，把以反斜杠结尾的行和下一行合并
+2. 执行预处理指令: #define #undef #include #if #ifdef #ifndef #elif #else #endif #line #error
+3. 展开正文中的宏，预定义的__FILE__和__LINE__是当前的文件名和行号
+输出的每一行都记录它来自的文件和行号，词法分析器用它报告原始的位置
+*/
+
+// 源文件中的位置
+type Pos struct {
+	File string
+	Line int
+}
+
+// 预处理的结果
+type Source struct {
+	Text  []byte
+	Lines []Pos //输出的第i+1行来自的位置
+}
+
+// #include查找头文件的目录，由-I指定。#include "..."先在当前文件所在的目录中查找
+var IncludeDirs []string
+
+const maxIncludeDepth = 200
+
+type Preprocessor struct {
+	macros map[string]*Macro
+	conds  []*cond //嵌套的条件编译
+	out    bytes.Buffer
+	lines  []Pos
+	cur    Pos //正在处理的行
+}
+
+// 一组#if ... #elif ... #else ... #endif
+type cond struct {
+	pos     Pos  //#if的位置
+	active  bool //当前分支有效
+	taken   bool //已经有分支有效或者外层无效，后面的#elif和#else都无效
+	sawElse bool
+}
+
+// 读入的源文件
+type srcFile struct {
+	path  string   //打开文件的路径
+	name  string   //__FILE__和报错使用的文件名，可以由#line修改
+	lines []string //删除注释、合并续行之后的行
+	nums  []int    //每行在文件中的行号
+	delta int      //#line指定的行号和文件中的行号的差
+	next  int      //下一个要处理的行
+	base  int      //进入文件时条件编译的层数，文件中的#endif不能结束外层文件的#if
+}
+
+func (f *srcFile) pos(i int) Pos {
+	return Pos{File: f.name, Line: f.nums[i] + f.delta}
+}
+
+// 预处理文件filename
+func File(filename string) (*Source, error) {
+	p := &Preprocessor{macros: map[string]*Macro{}}
+	if err := p.include(filename, 0); err != nil {
+		return nil, err
+	}
+	return &Source{Text: p.out.Bytes(), Lines: p.lines}, nil
+}
+
+// 预处理后第line行(从1开始)在源文件中的位置，超出最后一行时按最后一行顺延
+func (s *Source) Position(line int) Pos {
+	if len(s.Lines) == 0 {
+		return Pos{Line: line}
+	}
+	if line < 1 {
+		return s.Lines[0]
+	}
+	if line > len(s.Lines) {
+		last := s.Lines[len(s.Lines)-1]
+		return Pos{File: last.File, Line: last.Line + line - len(s.Lines)}
+	}
+	return s.Lines[line-1]
+}
+
+// -E: 输出预处理的结果。行的来源不连续时先输出行标记 # 行号 "文件名"，再次预处理时由行标记恢复原来的位置
+func (s *Source) Dump(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	lines := strings.SplitAfter(string(s.Text), "\n")
+	for i, pos := range s.Lines {
+		if i == 0 || pos.File != s.Lines[i-1].File || pos.Line != s.Lines[i-1].Line+1 {
+			fmt.Fprintf(bw, "# %d %s\n", pos.Line, quote(pos.File))
+		}
+		bw.WriteString(lines[i])
+	}
+	return bw.Flush()
+}
+
+func (p *Preprocessor) errorf(format string, a ...any) error {
+	return fmt.Errorf("%s:%d: %s", p.cur.File, p.cur.Line, fmt.Sprintf(format, a...))
+}
+
+func (p *Preprocessor) skipping() bool {
+	return len(p.conds) != 0 && !p.conds[len(p.conds)-1].active
+}
+
+func (p *Preprocessor) emit(line string) {
+	p.out.WriteString(line)
+	p.out.WriteByte('\n')
+	p.lines = append(p.lines, p.cur)
+}
+
+// 读入文件，删除注释，合并续行
+func readFile(path string) (*srcFile, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	text, err := stripComments(strings.ReplaceAll(string(b), "\r\n", "\n"))
+	if err != nil {
+		return nil, fmt.Errorf("%s:%v", path, err)
+	}
+	f := &srcFile{path: path, name: path}
+	phys := strings.Split(text, "\n")
+	if phys[len(phys)-1] == "" {
+		phys = phys[:len(phys)-1]
+	}
+	for i := 0; i < len(phys); i++ {
+		num := i + 1
+		line := phys[i]
+		for strings.HasSuffix(line, "\\") && i+1 < len(phys) {
+			i++
+			line = line[:len(line)-1] + phys[i]
+		}
+		f.lines = append(f.lines, line)
+		f.nums = append(f.nums, num)
+	}
+	return f, nil
+}
+
+// 把注释替换为一个空格，块注释中的换行保留。字符串和字符常量中的/*和//不是注释
+func stripComments(text string) (string, error) {
+	b := strings.Builder{}
+	line := 1
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(text) && text[j] != c && text[j] != '\n' {
+				if text[j] == '\\' && j+1 < len(text) && text[j+1] != '\n' {
+					j++
+				}
+				j++
+			}
+			if j < len(text) && text[j] == c {
+				j++
+			}
+			b.WriteString(text[i:j])
+			i = j
+		case strings.HasPrefix(text[i:], "//"):
+			end := strings.IndexByte(text[i:], '\n')
+			if end < 0 {
+				end = len(text) - i
+			}
+			b.WriteByte(' ')
+			i += end
+		case strings.HasPrefix(text[i:], "/*"):
+			end := strings.Index(text[i+2:], "*/")
+			if end < 0 {
+				return "", fmt.Errorf("%d: 注释没有结束", line)
+			}
+			n := strings.Count(text[i:i+2+end], "\n")
+			b.WriteByte(' ')
+			b.WriteString(strings.Repeat("\n", n))
+			line += n
+			i += end + 4
+		default:
+			if c == '\n' {
+				line++
+			}
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return b.String(), nil
+}
+
+// 预处理指令的名字和其余部分。# 数字 "文件名" 是行标记，名字是数字
+func directive(line string) (string, string, bool) {
+	s := strings.TrimLeft(line, " \t")
+	if !strings.HasPrefix(s, "#") {
+		return "", "", false
+	}
+	s = strings.TrimLeft(s[1:], " \t")
+	i := 0
+	for i < len(s) && isIdentChar(s[i]) {
+		i++
+	}
+	if i > 0 && isDigit(s[0]) { //行标记
+		return s[:i], s, true
+	}
+	return s[:i], s[i:], true
+}
+
+// 处理源文件path，depth是#include的嵌套层数
+func (p *Preprocessor) include(path string, depth int) error {
+	f, err := readFile(path)
+	if err != nil {
+		return err
+	}
+	f.base = len(p.conds)
+	for f.next < len(f.lines) {
+		i := f.next
+		f.next++
+		line := f.lines[i]
+		p.cur = f.pos(i)
+		if name, rest, ok := directive(line); ok {
+			if err := p.directive(f, name, rest, depth); err != nil {
+				return err
+			}
+			continue
+		}
+		if p.skipping() {
+			continue
+		}
+		if err := p.text(f, line); err != nil {
+			return err
+		}
+	}
+	if len(p.conds) > f.base {
+		c := p.conds[len(p.conds)-1]
+		return fmt.Errorf("%s:%d: #if没有对应的#endif", c.pos.File, c.pos.Line)
+	}
+	return nil
+}
+
+/*
+正文行: 没有宏时原样输出，否则输出展开之后的记号。
+函数式宏的参数可以延续到后面的行，这些行合并为一行输出，位置是第一行的位置
+*/
+func (p *Preprocessor) text(f *srcFile, line string) error {
+	toks := tokenize(line)
+	if !p.hasMacro(toks) {
+		p.emit(line)
+		return nil
+	}
+	more := func() ([]*token, bool) {
+		if f.next >= len(f.lines) {
+			return nil, false
+		}
+		if _, _, ok := directive(f.lines[f.next]); ok {
+			return nil, false
+		}
+		toks := tokenize(f.lines[f.next])
+		f.next++
+		if len(toks) != 0 {
+			toks[0].space = true
+		}
+		return toks, true
+	}
+	out, err := p.expand(toks, more)
+	if err != nil {
+		return err
+	}
+	indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+	p.emit(indent + render(out))
+	return nil
+}
+
+/*
+条件编译指令在跳过的部分中也要处理，以便找到对应的#endif；其他指令只在有效的部分中执行
+*/
+func (p *Preprocessor) directive(f *srcFile, name, rest string, depth int) error {
+	var top *cond
+	if len(p.conds) > f.base {
+		top = p.conds[len(p.conds)-1]
+	}
+	switch name {
+	case "if", "ifdef", "ifndef":
+		if p.skipping() {
+			p.conds = append(p.conds, &cond{pos: p.cur, taken: true})
+			return nil
+		}
+		v, err := p.condition(name, rest)
+		if err != nil {
+			return err
+		}
+		p.conds = append(p.conds, &cond{pos: p.cur, active: v, taken: v})
+		return nil
+	case "elif":
+		if top == nil {
+			return p.errorf("#elif没有对应的#if")
+		}
+		if top.sawElse {
+			return p.errorf("#else之后不能有#elif")
+		}
+		if top.taken {
+			top.active = false
+			return nil
+		}
+		v, err := p.condition(name, rest)
+		if err != nil {
+			return err
+		}
+		top.active, top.taken = v, v
+		return nil
+	case "else":
+		if top == nil {
+			return p.errorf("#else没有对应的#if")
+		}
+		if top.sawElse {
+			return p.errorf("重复的#else")
+		}
+		top.sawElse = true
+		top.active = !top.taken
+		top.taken = true
+		return nil
+	case "endif":
+		if top == nil {
+			return p.errorf("#endif没有对应的#if")
+		}
+		p.conds = p.conds[:len(p.conds)-1]
+		return nil
+	}
+	if p.skipping() {
+		return nil
+	}
+	switch name {
+	case "": //空指令
+		return nil
+	case "define":
+		return p.define(rest)
+	case "undef":
+		toks := tokenize(rest)
+		if len(toks) == 0 || toks[0].kind != tIdent {
+			return p.errorf("#undef后面必须是宏名")
+		}
+		delete(p.macros, toks[0].text)
+		return nil
+	case "include":
+		return p.includeFile(f, rest, depth)
+	case "line":
+		return p.line(f, rest)
+	case "error":
+		return p.errorf("#error%s", rest)
+	}
+	if isDigit(name[0]) { //-E输出的行标记
+		return p.line(f, rest)
+	}
+	return p.errorf("不支持的预处理指令#%s", name)
+}
+
+// #if的条件，#ifdef和#ifndef后面是宏名
+func (p *Preprocessor) condition(name, rest string) (bool, error) {
+	if name == "if" || name == "elif" {
+		return p.eval(rest)
+	}
+	toks := tokenize(rest)
+	if len(toks) == 0 || toks[0].kind != tIdent {
+		return false, p.errorf("#%s后面必须是宏名", name)
+	}
+	return p.defined(toks[0].text) == (name == "ifdef"), nil
+}
+
+// #include "文件名"或<文件名>，后面也可以是展开后得到这两种形式的宏
+func (p *Preprocessor) includeFile(f *srcFile, rest string, depth int) error {
+	rest = strings.TrimSpace(rest)
+	if !strings.HasPrefix(rest, "\"") && !strings.HasPrefix(rest, "<") {
+		toks, err := p.expand(tokenize(rest), nil)
+		if err != nil {
+			return err
+		}
+		rest = render(toks)
+	}
+	var name string
+	local := strings.HasPrefix(rest, "\"")
+	closer := ">"
+	if local {
+		closer = "\""
+	}
+	if local || strings.HasPrefix(rest, "<") {
+		if end := strings.Index(rest[1:], closer); end >= 0 {
+			name = rest[1 : 1+end]
+		}
+	}
+	if name == "" {
+		return p.errorf("#include后面必须是\"文件名\"或<文件名>")
+	}
+	if depth >= maxIncludeDepth {
+		return p.errorf("#include嵌套超过了%d层", maxIncludeDepth)
+	}
+	path := search(name, local, f.path)
+	if path == "" {
+		return p.errorf("找不到头文件%s", name)
+	}
+	return p.include(path, depth+1)
+}
+
+// 查找头文件: "..."先在当前文件所在的目录中查找，然后依次在IncludeDirs中查找
+func search(name string, local bool, from string) string {
+	exists := func(path string) bool {
+		st, err := os.Stat(path)
+		return err == nil && !st.IsDir()
+	}
+	if filepath.IsAbs(name) {
+		if exists(name) {
+			return name
+		}
+		return ""
+	}
+	dirs := IncludeDirs
+	if local {
+		dirs = append([]string{filepath.Dir(from)}, dirs...)
+	}
+	for _, dir := range dirs {
+		if path := filepath.Join(dir, name); exists(path) {
+			return path
+		}
+	}
+	return ""
+}
+
+// #line 行号 ["文件名"]: 下一行的行号和文件名
+func (p *Preprocessor) line(f *srcFile, rest string) error {
+	toks, err := p.expand(tokenize(rest), nil)
+	if err != nil {
+		return err
+	}
+	if len(toks) == 0 || toks[0].kind != tNumber {
+		return p.errorf("#line后面必须是行号")
+	}
+	n, err := strconv.Atoi(toks[0].text)
+	if err != nil || n <= 0 {
+		return p.errorf("非法的行号%s", toks[0].text)
+	}
+	if len(toks) > 1 {
+		if toks[1].kind != tString {
+			return p.errorf("#line的文件名必须是字符串")
+		}
+		name, err := strconv.Unquote(toks[1].text)
+		if err != nil {
+			return p.errorf("非法的文件名%s", toks[1].text)
+		}
+		f.name = name
+	}
+	if f.next < len(f.nums) {
+		f.delta = n - f.nums[f.next]
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+package preprocess
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 把files写到临时目录中，预处理其中的main.c。-I目录是inc
+func preprocess(t *testing.T, files map[string]string) (*Source, string, error) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, text := range files {
+		f := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(f, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	IncludeDirs = []string{filepath.Join(dir, "inc")}
+	defer func() { IncludeDirs = nil }()
+	src, err := File(filepath.Join(dir, "main.c"))
+	return src, dir, err
+}
+
+// 预处理之后的非空行，去掉行首的空白
+func lines(src *Source) []string {
+	res := []string{}
+	for _, l := range strings.Split(string(src.Text), "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			res = append(res, l)
+		}
+	}
+	return res
+}
+
+type ppTest struct {
+	name string
+	src  string
+	want string //预处理之后的非空行，用|分隔
+}
+
+func runTests(t *testing.T, tests []ppTest) {
+	t.Helper()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, _, err := preprocess(t, map[string]string{"main.c": tt.src})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(lines(src), "|"); got != tt.want {
+				t.Errorf("预处理的结果是\n%s\n应该是\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestObjectMacros(t *testing.T) {
+	runTests(t, []ppTest{
+		{"simple", "#define N 10\nint a[N];", "int a[10];"},
+		{"nested", "#define A B + 1\n#define B 3\nA", "3 + 1"},
+		{"later definition", "#define A B\nA\n#define B 2\nA", "B|2"},
+		{"self reference", "#define x x + 1\nx", "x + 1"},
+		{"mutual reference", "#define f g\n#define g f\nf g", "f g"},
+		{"undef", "#define N 1\n#undef N\nN", "N"},
+		{"empty", "#define E\na E b", "a b"},
+		{"not in string", "#define N 1\n\"N\" 'N' N", "\"N\" 'N' 1"},
+		{"same redefinition", "#define N (1 + 2)\n#define N (1 + 2)\nN", "(1 + 2)"},
+		{"file and line", "\n__LINE__\n#line 100 \"x.c\"\n__FILE__ __LINE__", "2|\"x.c\" 100"},
+	})
+}
+
+func TestFunctionMacros(t *testing.T) {
+	runTests(t, []ppTest{
+		{"simple", "#define MAX(a, b) ((a) > (b) ? (a) : (b))\nMAX(1, x + 2)", "((1) > (x + 2) ? (1) : (x + 2))"},
+		{"no arguments", "#define F() 7\nF()", "7"},
+		{"without parens", "#define F(x) x\nF + F (1)", "F + 1"},
+		{"space before paren is object-like", "#define F (x)\nF(1)", "(x)(1)"},
+		{"nested parens and commas", "#define FIRST(a, b) a\nFIRST((1, 2), 3)", "(1, 2)"},
+		{"argument expanded first", "#define N 4\n#define SQ(x) x * x\nSQ(N)", "4 * 4"},
+		{"nested calls", "#define INC(x) x + 1\nINC(INC(1))", "1 + 1 + 1"},
+		{"recursive", "#define f(x) x + f(x)\nf(1)", "1 + f(1)"},
+		{"arguments on later lines", "#define ADD(a, b) a + b\nADD(1,\n2)\nend", "1 + 2|end"},
+		{"variadic", "#define CALL(f, ...) f(__VA_ARGS__)\nCALL(g, 1, 2)\nCALL(h)", "g(1, 2)|h()"},
+		{"name from expansion", "#define ID(x) x\n#define G ID\nG(5)", "5"},
+	})
+}
+
+func TestStringizeAndPaste(t *testing.T) {
+	runTests(t, []ppTest{
+		{"stringize", "#define S(x) #x\nS(a  +   b)", "\"a + b\""},
+		{"stringize quotes", "#define S(x) #x\nS(\"a\\n\" 'b')", "\"\\\"a\\\\n\\\" 'b'\""},
+		{"stringize not expanded", "#define N 1\n#define S(x) #x\nS(N)", "\"N\""},
+		{"stringize after expansion", "#define N 1\n#define S(x) #x\n#define XS(x) S(x)\nXS(N)", "\"1\""},
+		{"paste", "#define CAT(a, b) a##b\nCAT(x, 1) CAT(<, <=)", "x1 <<="},
+		{"paste not expanded", "#define N 1\n#define CAT(a, b) a##b\nCAT(N, 2)", "N2"},
+		{"paste then expand", "#define x1 100\n#define CAT(a, b) a##b\nCAT(x, 1)", "100"},
+		{"paste empty", "#define CAT(a, b) a##b\nCAT(, y) CAT(z, )", "y z"},
+		{"paste in object macro", "#define AB x ## y\nAB", "xy"},
+	})
+}
+
+func TestConditionals(t *testing.T) {
+	runTests(t, []ppTest{
+		{"ifdef", "#define A\n#ifdef A\na\n#else\nb\n#endif\n#ifndef A\nc\n#endif", "a"},
+		{"elif", "#if 0\na\n#elif 0\nb\n#elif 1\nc\n#elif 1\nd\n#else\ne\n#endif", "c"},
+		{"else", "#if 0\na\n#elif 0\nb\n#else\nc\n#endif", "c"},
+		{"nested", "#if 1\na\n#if 0\nb\n#else\nc\n#endif\n#endif", "a|c"},
+		{"nested in skipped", "#if 0\n#if 1\na\n#else\nb\n#endif\n#elif 1\nc\n#endif", "c"},
+		{"skipped directives", "#if 0\n#define N 1\n#error no\n#include \"none.h\"\n#endif\nN", "N"},
+	})
+}
+
+func TestIfExpressions(t *testing.T) {
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"1 + 2 * 3 == 7", true},
+		{"(1 + 2) * 3 == 9", true},
+		{"10 - 2 - 3 == 5", true},
+		{"7 / 2 == 3 && 7 % 2 == 1", true},
+		{"1 << 4 == 16 && 256 >> 4 == 16", true},
+		{"(6 & 3) == 2 && (6 | 3) == 7 && (6 ^ 3) == 5", true},
+		{"-1 < 0 && ~0 == -1 && !0 && +1", true},
+		{"1 > 2 || 2 >= 2 && 1 <= 0", false},
+		{"1 ? 2 : 0", true},
+		{"0 ? 1 : 0", false},
+		{"1 || 1 / 0", true},
+		{"0 && 1 / 0", false},
+		{"0x10 == 16 && 010 == 8 && 'a' == 97", true},
+		{"defined(N) && defined N && !defined(M)", true},
+		{"N == 3 && TWICE(N) == 6", true},
+		{"UNDEFINED == 0", true},
+	}
+	for _, tt := range tests {
+		src := "#define N 3\n#define TWICE(x) ((x) * 2)\n#if " + tt.expr + "\nyes\n#else\nno\n#endif"
+		p, _, err := preprocess(t, map[string]string{"main.c": src})
+		if err != nil {
+			t.Errorf("#if %s: %v", tt.expr, err)
+			continue
+		}
+		if got := strings.Join(lines(p), "|"); got != map[bool]string{true: "yes", false: "no"}[tt.want] {
+			t.Errorf("#if %s 的结果是%s", tt.expr, got)
+		}
+	}
+}
+
+// "..."先在当前文件所在的目录中查找，然后在-I目录中查找；<...>只在-I目录中查找
+func TestIncludeSearch(t *testing.T) {
+	src, dir, err := preprocess(t, map[string]string{
+		"main.c":       "#include \"a.h\"\n#include <a.h>\n#include \"sub/b.h\"\n#define H <c.h>\n#include H\nend",
+		"a.h":          "local_a",
+		"inc/a.h":      "inc_a",
+		"sub/b.h":      "#include \"a.h\"\nb",
+		"sub/a.h":      "sub_a",
+		"inc/c.h":      "#ifndef C_H\n#define C_H\ninc_c\n#include <c.h>\n#endif",
+		"inc/unused.h": "unused",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(lines(src), "|"), "local_a|inc_a|sub_a|b|inc_c|end"; got != want {
+		t.Errorf("预处理的结果是%s，应该是%s", got, want)
+	}
+	//每一行都来自它所在的文件
+	want := map[string]Pos{
+		"local_a": {filepath.Join(dir, "a.h"), 1},
+		"inc_a":   {filepath.Join(dir, "inc", "a.h"), 1},
+		"sub_a":   {filepath.Join(dir, "sub", "a.h"), 1},
+		"b":       {filepath.Join(dir, "sub", "b.h"), 2},
+		"inc_c":   {filepath.Join(dir, "inc", "c.h"), 3},
+		"end":     {filepath.Join(dir, "main.c"), 6},
+	}
+	for i, l := range strings.Split(string(src.Text), "\n") {
+		if pos, ok := want[strings.TrimSpace(l)]; ok && src.Position(i+1) != pos {
+			t.Errorf("%s的位置是%v，应该是%v", l, src.Position(i+1), pos)
+		}
+	}
+}
+
+// 预处理之后的行映射回源文件中的行: 注释和续行合并的行、#line、被跳过的行
+func TestPosition(t *testing.T) {
+	src, dir, err := preprocess(t, map[string]string{
+		"main.c": "a /* 1\n2 */ b\nc \\\nd\n#if 0\nskipped\n#endif\ne\n#line 50 \"other.c\"\nf\ng",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	main := filepath.Join(dir, "main.c")
+	want := map[string]Pos{
+		"a":   {main, 1},
+		"b":   {main, 2},
+		"c d": {main, 3},
+		"e":   {main, 8},
+		"f":   {"other.c", 50},
+		"g":   {"other.c", 51},
+	}
+	got := map[string]Pos{}
+	for i, l := range strings.Split(strings.TrimSuffix(string(src.Text), "\n"), "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			got[l] = src.Position(i + 1)
+		}
+	}
+	for l, pos := range want {
+		if got[l] != pos {
+			t.Errorf("%s的位置是%v，应该是%v", l, got[l], pos)
+		}
+	}
+	//超出最后一行时按最后一行顺延
+	if pos := src.Position(len(src.Lines) + 2); pos != (Pos{"other.c", 53}) {
+		t.Errorf("最后一行之后第2行的位置是%v", pos)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"#include \"nope.h\"", "找不到头文件nope.h"},
+		{"#include <main.c>", "找不到头文件main.c"},
+		{"#if 1\na", "#if没有对应的#endif"},
+		{"#endif", "#endif没有对应的#if"},
+		{"#if 1\n#else\n#elif 1\n#endif", "#else之后不能有#elif"},
+		{"#error stop here", "#error stop here"},
+		{"#if 1 / 0\n#endif", "除数为0"},
+		{"#if\n#endif", "#if后面缺少表达式"},
+		{"#define N 1\n#define N 2", "宏N重定义"},
+		{"#define CAT(a) ##a", "##不能在宏CAT的替换列表的两端"},
+		{"#define S(a) #b", "宏S中#后面必须是参数"},
+		{"#define F(a, b) a\nF(1)", "F"},
+		{"/* open", "注释没有结束"},
+		{"#pragma once", "不支持的预处理指令#pragma"},
+	}
+	for _, tt := range tests {
+		_, _, err := preprocess(t, map[string]string{"main.c": tt.src})
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%q: 错误是%v，应该包含%q", tt.src, err, tt.err)
+		}
+	}
+}
@@ -43,61 +43,504 @@ func (p *Parser) program() {
 	p.program()
 }
 
-// <segment> -> extern <type> <def> | <type> <def>
+// <segment> -> <typedef> | extern <type> <def> | <type> <def> | <type> semicon
 func (p *Parser) segment() {
-	if p.match(lexical.KW_EXTERN) {
+	if p.match(lexical.KW_TYPEDEF) {
+		p.typedef()
+	} else if p.match(lexical.KW_EXTERN) {
 		p.move()
-		t := p.typedec()
-		p.def(true, t)
+		t, cst, td := p.typedec()
+		p.def(true, t, cst, td)
 	} else {
-		t := p.typedec()
-		p.def(false, t)
+		t, cst, td := p.typedec()
+		if p.match(lexical.SEMICOLON) { //只定义枚举常量，例如enum color { RED, GREEN };
+			p.move()
+			return
+		}
+		p.def(false, t, cst, td)
 	}
 }
 
-// <type> ->	int | char | void
-func (p *Parser) typedec() lexical.TokenType {
-	switch p.tk.TokenTyp() {
-	case lexical.KW_INT:
+// <typedef> -> typedef <type> <typename> <typenames>
+// <typenames> -> comma <typename> <typenames> | semicon
+// <typename> -> mul <ptrdecl> <dims> | id <dims>
+// 类型名可以是基本类型、指针和数组的别名，如typedef char *string, names[8]。不支持函数指针
+func (p *Parser) typedef() {
+	p.move()
+	typ, cst, td := p.typedec()
+	for {
+		isPtr, tdims, pc := tdDecl(td)
+		var name string
+		if p.match(lexical.MUL) {
+			p.ptrType(td)
+			p.move()
+			isPtr = true
+			name, pc = p.ptrdecl()
+		} else if p.match(lexical.ID) {
+			name = p.tk.(*lexical.TID).Name
+			p.move()
+		} else if p.match(lexical.LPAREN) {
+			p.Error("typedef err: 不支持函数指针的类型名")
+		} else {
+			p.Error(fmt.Sprintf("typedef err: expected ID, but got %s", p.tk.String()))
+		}
+		dims := append(p.dims(false), tdims...)
+		if pc && len(dims) != 0 {
+			p.Error("typedef err: 不支持const指针的数组")
+		}
+		tv := table.NewTypeVar(table.Symtab.ScopePath, typ, cst, isPtr, name, dims)
+		tv.Const = pc
+		table.Symtab.AddName(tv)
+		if p.match(lexical.SEMICOLON) {
+			p.move()
+			return
+		}
+		if !p.match(lexical.COMMA) {
+			p.Error(fmt.Sprintf("typedef err: expected ',' or ';', but got %s", p.tk.String()))
+		}
 		p.move()
-		return lexical.KW_INT
-	case lexical.KW_CHAR:
+	}
+}
+
+/*
+<type> -> <quals> <basetype> <quals>
+<quals> -> const <quals> | ^
+<basetype> -> void | float | double | va_list | <enumspec> | typename | <intspec> <intspecs>
+<intspecs> -> <intspec> <intspecs> | ^
+<intspec> -> int | char | short | long | signed | unsigned
+整型的说明符可以任意顺序组合，例如unsigned long long int、short unsigned。
+const可以出现在说明符之间，返回的bool表示类型带const限定。
+类型是typedef类型名时还返回它，声明符由它得到指针和数组维度，否则返回nil
+*/
+func (p *Parser) typedec() (lexical.TokenType, bool, *table.Var) {
+	cst := p.quals()
+	var typ lexical.TokenType
+	var td *table.Var
+	if p.matchSoleType() {
+		typ = p.tk.TokenTyp()
 		p.move()
-		return lexical.KW_CHAR
-	case lexical.KW_VOID:
+	} else if p.match(lexical.KW_ENUM) {
+		typ = p.enumspec()
+	} else if p.matchTypeName() {
+		td = table.Symtab.FindType(p.tk.(*lexical.TID).Name)
+		typ, cst = td.Typ, cst || td.ConstTyp
 		p.move()
-		return lexical.KW_VOID
-	default:
-		p.Error(fmt.Sprintf("typedec err: expected 'int', 'char' or 'void', but got %s", p.tk.String()))
+	} else {
+		typ = p.intspecs(&cst)
 	}
-	return lexical.ERR
+	if p.quals() {
+		cst = true
+	}
+	if p.matchTypeKw() {
+		p.Error("typedec err: 非法的类型说明符组合")
+	}
+	if td != nil && !td.IsPtr && len(td.Dims) == 0 { //基本类型的别名
+		td = nil
+	}
+	return typ, cst, td
 }
 
-// <def> -> mul id <init> <deflist> | id <idtail>
-func (p *Parser) def(ext bool, typ lexical.TokenType) {
-	if p.match(lexical.MUL) {
+// typedef类型名td代表的指针、数组各维的长度和指针本身的const限定，td为nil时都没有
+func tdDecl(td *table.Var) (bool, []int64, bool) {
+	if td == nil {
+		return false, nil, false
+	}
+	return td.IsPtr, td.Dims, td.Const
+}
+
+// 类型名是指针或数组时不能再声明指针，如string *p
+func (p *Parser) ptrType(td *table.Var) {
+	if td != nil {
+		p.Error("ptrType err: 不支持多级指针和指向数组的指针")
+	}
+}
+
+// <quals> -> const <quals> | ^
+func (p *Parser) quals() bool {
+	cst := false
+	for p.match(lexical.KW_CONST) {
+		cst = true
 		p.move()
-		if p.match(lexical.ID) {
-			varname := p.tk.(*lexical.TID).Name
+	}
+	return cst
+}
+
+// 整型说明符的组合，中间的const记录在cst中
+func (p *Parser) intspecs(cst *bool) lexical.TokenType {
+	if !p.matchIntSpec() {
+		p.Error(fmt.Sprintf("typedec err: expected type, but got %s", p.tk.String()))
+	}
+	specs := map[lexical.TokenType]int{}
+	for p.matchIntSpec() || p.match(lexical.KW_CONST) {
+		if p.match(lexical.KW_CONST) {
+			*cst = true
+		} else {
+			specs[p.tk.TokenTyp()]++
+		}
+		p.move()
+	}
+	ints, chars, shorts, longs := specs[lexical.KW_INT], specs[lexical.KW_CHAR], specs[lexical.KW_SHORT], specs[lexical.KW_LONG]
+	signeds, unsigneds := specs[lexical.KW_SIGNED], specs[lexical.KW_UNSIGNED]
+	if ints > 1 || chars > 1 || shorts > 1 || longs > 2 || signeds+unsigneds > 1 ||
+		chars > 0 && ints+shorts+longs > 0 || shorts > 0 && longs > 0 {
+		p.Error("typedec err: 非法的类型说明符组合")
+	}
+	typ := lexical.KW_INT
+	if chars > 0 {
+		typ = lexical.KW_CHAR
+	} else if shorts > 0 {
+		typ = lexical.KW_SHORT
+	} else if longs == 2 {
+		typ = lexical.KW_LLONG
+	}
+	if unsigneds > 0 {
+		typ = table.UnsignedType(typ)
+	}
+	return typ
+}
+
+/*
+<enumspec> -> enum id lbrace <enumlist> rbrace | enum lbrace <enumlist> rbrace | enum id
+<enumlist> -> <enumerator> comma <enumlist> | <enumerator> comma | <enumerator>
+<enumerator> -> id | id assign <enumval>
+枚举类型就是int。枚举名记录为类型名"enum id"，和变量名不会冲突
+*/
+func (p *Parser) enumspec() lexical.TokenType {
+	p.move()
+	tag := ""
+	if p.match(lexical.ID) {
+		tag = "enum " + p.tk.(*lexical.TID).Name
+		p.move()
+	}
+	if !p.match(lexical.LBRACE) {
+		if tag == "" {
+			p.Error(fmt.Sprintf("enumspec err: expected '{', but got %s", p.tk.String()))
+		}
+		if table.Symtab.FindType(tag) == nil {
+			p.Error(fmt.Sprintf("enumspec err: %s未定义", tag))
+		}
+		return lexical.KW_INT
+	}
+	p.move()
+	if tag != "" {
+		table.Symtab.AddName(table.NewTypeVar(table.Symtab.ScopePath, lexical.KW_INT, false, false, tag, nil))
+	}
+	val := int64(0)
+	for !p.match(lexical.RBRACE) {
+		if !p.match(lexical.ID) {
+			p.Error(fmt.Sprintf("enumspec err: expected ID, but got %s", p.tk.String()))
+		}
+		name := p.tk.(*lexical.TID).Name
+		p.move()
+		if p.match(lexical.ASSIGN) {
 			p.move()
-			varr := p.init(ext, typ, true, varname)
-			table.Symtab.AddVar(varr)
-			p.deflist(ext, typ)
+			val = p.enumval()
+		}
+		table.Symtab.AddName(table.NewEnumVar(table.Symtab.ScopePath, name, val))
+		val++
+		if !p.match(lexical.COMMA) {
+			break
+		}
+		p.move()
+	}
+	if !p.match(lexical.RBRACE) {
+		p.Error(fmt.Sprintf("enumspec err: expected '}', but got %s", p.tk.String()))
+	}
+	p.move()
+	return lexical.KW_INT
+}
+
+/*
+<enumval> -> <constexpr>
+<constexpr> -> <constexpr> <binop> <constexpr> | <unaryop> <constexpr> | lparen <constexpr> rparen | number | character | id
+枚举常量的值是整数常量表达式，操作数是整数、字符和前面定义的枚举常量，如B = A * 2。
+二元运算符的优先级和C相同，按int计算，结果超出int时截断
+*/
+func (p *Parser) enumval() int64 {
+	return p.constexpr(0)
+}
+
+// 常量表达式的二元运算符，按优先级从低到高
+var constOps = [][]lexical.TokenType{
+	{lexical.OR},
+	{lexical.AND},
+	{lexical.BOR},
+	{lexical.XOR},
+	{lexical.LEA},
+	{lexical.EQU, lexical.NEQU},
+	{lexical.GT, lexical.GE, lexical.LT, lexical.LE},
+	{lexical.SHL, lexical.SHR},
+	{lexical.ADD, lexical.SUB},
+	{lexical.MUL, lexical.DIV, lexical.MOD},
+}
+
+// 优先级不低于constOps[level]的常量表达式
+func (p *Parser) constexpr(level int) int64 {
+	if level == len(constOps) {
+		return p.constunary()
+	}
+	v := p.constexpr(level + 1)
+	for {
+		op := p.tk.TokenTyp()
+		found := false
+		for _, o := range constOps[level] {
+			found = found || o == op
+		}
+		if !found {
+			return v
+		}
+		p.move()
+		v = p.constop(op, v, p.constexpr(level+1))
+	}
+}
+
+// <unaryop> <constexpr> | lparen <constexpr> rparen | number | character | id
+func (p *Parser) constunary() int64 {
+	switch p.tk.TokenTyp() {
+	case lexical.SUB, lexical.ADD, lexical.BNOT, lexical.NOT:
+		op := p.tk.TokenTyp()
+		p.move()
+		v := p.constunary()
+		switch op {
+		case lexical.SUB:
+			v = -v
+		case lexical.BNOT:
+			v = ^v
+		case lexical.NOT:
+			v = boolVal(v == 0)
+		}
+		return table.ConvConst(v, lexical.KW_INT)
+	case lexical.LPAREN:
+		p.move()
+		v := p.constexpr(0)
+		if !p.match(lexical.RPAREN) {
+			p.Error(fmt.Sprintf("constexpr err: expected ')', but got %s", p.tk.String()))
+		}
+		p.move()
+		return v
+	}
+	var v *table.Var
+	if p.match(lexical.ID) {
+		v = table.Symtab.GetVar(p.tk.(*lexical.TID).Name)
+		if !v.Literal {
+			p.Error(fmt.Sprintf("constexpr err: <%s>不是常量", v.Name))
+		}
+	} else if p.match(lexical.NUM) || p.match(lexical.CHAR) {
+		v = table.NewLiteralVar(p.tk)
+	} else {
+		p.Error(fmt.Sprintf("constexpr err: expected NUM, CHAR or ID, but got %s", p.tk.String()))
+	}
+	p.move()
+	return table.ConvConst(v.GetVal(), lexical.KW_INT)
+}
+
+// 计算l op r，按int截断
+func (p *Parser) constop(op lexical.TokenType, l, r int64) int64 {
+	var v int64
+	switch op {
+	case lexical.OR:
+		v = boolVal(l != 0 || r != 0)
+	case lexical.AND:
+		v = boolVal(l != 0 && r != 0)
+	case lexical.BOR:
+		v = l | r
+	case lexical.XOR:
+		v = l ^ r
+	case lexical.LEA:
+		v = l & r
+	case lexical.EQU:
+		v = boolVal(l == r)
+	case lexical.NEQU:
+		v = boolVal(l != r)
+	case lexical.GT:
+		v = boolVal(l > r)
+	case lexical.GE:
+		v = boolVal(l >= r)
+	case lexical.LT:
+		v = boolVal(l < r)
+	case lexical.LE:
+		v = boolVal(l <= r)
+	case lexical.SHL:
+		v = l << (r & 31)
+	case lexical.SHR:
+		v = l >> (r & 31)
+	case lexical.ADD:
+		v = l + r
+	case lexical.SUB:
+		v = l - r
+	case lexical.MUL:
+		v = l * r
+	case lexical.DIV, lexical.MOD:
+		if r == 0 {
+			p.Error("constexpr err: 除数为0")
+		}
+		if op == lexical.DIV {
+			v = l / r
 		} else {
-			p.Error(fmt.Sprintf("def err: expected ID, but got %s", p.tk.String()))
+			v = l % r
 		}
+	}
+	return table.ConvConst(v, lexical.KW_INT)
+}
+
+func boolVal(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// 类型的开始
+func (p *Parser) matchType() bool {
+	return p.matchTypeKw() || p.match(lexical.KW_CONST) || p.matchTypeName()
+}
+
+// 类型说明符
+func (p *Parser) matchTypeKw() bool {
+	switch p.tk.TokenTyp() {
+	case lexical.KW_INT, lexical.KW_CHAR, lexical.KW_VOID, lexical.KW_SHORT, lexical.KW_LONG,
+		lexical.KW_SIGNED, lexical.KW_UNSIGNED, lexical.KW_FLOAT, lexical.KW_DOUBLE, lexical.KW_VA_LIST, lexical.KW_ENUM:
+		return true
+	}
+	return false
+}
+
+// 可以组合的整型说明符
+func (p *Parser) matchIntSpec() bool {
+	return p.matchTypeKw() && !p.matchSoleType() && !p.match(lexical.KW_ENUM)
+}
+
+// typedef定义的类型名。被同名变量屏蔽时是变量名
+func (p *Parser) matchTypeName() bool {
+	return p.match(lexical.ID) && table.Symtab.FindType(p.tk.(*lexical.TID).Name) != nil
+}
+
+// 不能和其他说明符组合的类型
+func (p *Parser) matchSoleType() bool {
+	return p.match(lexical.KW_VOID) || p.match(lexical.KW_FLOAT) || p.match(lexical.KW_DOUBLE) || p.match(lexical.KW_VA_LIST)
+}
+
+// <def> -> mul <ptrdecl> <varrdef> <deflist> | id <idtail> | <funptr> <init> <deflist>
+// cst表示类型带const限定，td是指针或数组的typedef类型名
+func (p *Parser) def(ext bool, typ lexical.TokenType, cst bool, td *table.Var) {
+	if p.match(lexical.MUL) {
+		p.addVar(p.defdata(ext, typ, td), cst)
+		p.deflist(ext, typ, cst, td)
 	} else if p.match(lexical.ID) {
 		name := p.tk.(*lexical.TID).Name
 		p.move()
-		p.idtail(ext, typ, false, name)
+		p.idtail(ext, typ, cst, td, name)
+	} else if p.match(lexical.LPAREN) { //函数指针
+		p.addVar(p.defdata(ext, typ, td), cst)
+		p.deflist(ext, typ, cst, td)
 	} else {
 		p.Error(fmt.Sprintf("def err: expected *ID or ID, but got %s", p.tk.String()))
 	}
 }
 
+// <ptrdecl> -> const id | id
+// *后面的变量名，const限定指针本身，例如char *const p中p不能赋值，返回true
+func (p *Parser) ptrdecl() (string, bool) {
+	pc := p.quals()
+	if !p.match(lexical.ID) {
+		p.Error(fmt.Sprintf("ptrdecl err: expected ID, but got %s", p.tk.String()))
+	}
+	name := p.tk.(*lexical.TID).Name
+	p.move()
+	if pc && p.match(lexical.LBRACK) {
+		p.Error("ptrdecl err: 不支持const指针的数组")
+	}
+	return name, pc
+}
+
+// 声明的变量加入符号表，cst表示类型带const限定。const变量只能在初始化时赋值
+func (p *Parser) addVar(v *table.Var, cst bool) {
+	v.ConstTyp = cst
+	table.Symtab.AddVar(v)
+}
+
+// <funptr> -> lparen mul id rparen lparen <sigparas> rparen | lparen mul id rparen <dims>
+// 括号中的声明符是函数指针，如int (*fp)(int)，或者指向数组的指针，如int (*p)[4]。
+// 返回变量名，以及函数指针的签名或者所指数组的各维长度
+// 函数指针的声明符，例如int (*fp)(int, char *)，返回变量名和指向的函数
+func (p *Parser) funptr(typ lexical.TokenType) (string, *table.Fun, []int64) {
+	p.move()
+	if !p.match(lexical.MUL) {
+		p.Error(fmt.Sprintf("funptr err: expected '*', but got %s", p.tk.String()))
+	}
+	p.move()
+	if !p.match(lexical.ID) {
+		p.Error(fmt.Sprintf("funptr err: expected ID, but got %s", p.tk.String()))
+	}
+	name := p.tk.(*lexical.TID).Name
+	p.move()
+	if !p.match(lexical.RPAREN) {
+		p.Error(fmt.Sprintf("funptr err: expected ')', but got %s", p.tk.String()))
+	}
+	p.move()
+	if p.match(lexical.LBRACK) { //指向数组的指针
+		return name, nil, p.dims(false)
+	}
+	if !p.match(lexical.LPAREN) {
+		p.Error(fmt.Sprintf("funptr err: expected '(' or '[', but got %s", p.tk.String()))
+	}
+	p.move()
+	paras, variadic := p.sigparas()
+	if !p.match(lexical.RPAREN) {
+		p.Error(fmt.Sprintf("funptr err: expected ')', but got %s", p.tk.String()))
+	}
+	p.move()
+	sig := table.NewFun(false, typ, "", paras)
+	sig.Variadic = variadic
+	return name, sig, nil
+}
+
+/*
+<sigparas> -> <type> <sigpara> <sigparalist> | ^
+<sigparalist> -> comma <type> <sigpara> <sigparalist> | comma ellipsis | ^
+<sigpara> -> mul id | mul | id | ^
+函数指针的参数只有类型，参数名可以省略。参数表以...结束时返回true
+*/
+func (p *Parser) sigparas() ([]*table.Var, bool) {
+	var paras []*table.Var
+	if !p.matchType() {
+		return paras, false
+	}
+	for {
+		typ, _, td := p.typedec()
+		isPtr, tdims, _ := tdDecl(td)
+		if p.match(lexical.MUL) {
+			p.ptrType(td)
+			isPtr = true
+			p.move()
+		}
+		if p.match(lexical.ID) {
+			p.move()
+		}
+		if len(tdims) != 0 {
+			paras = append(paras, table.NewArrayParaVar(table.Symtab.ScopePath, typ, isPtr, "", tdims))
+		} else {
+			paras = append(paras, table.NewVar(table.Symtab.ScopePath, false, typ, isPtr, "", nil))
+		}
+		if !p.match(lexical.COMMA) {
+			return paras, false
+		}
+		p.move()
+		if p.match(lexical.ELLIPSIS) {
+			p.move()
+			return paras, true
+		}
+	}
+}
+
 // <init> -> assign <expr> | ^
 // 如果某个变量的InitData为default，说明没有显示初始化
 func (p *Parser) init(ext bool, typ lexical.TokenType, isPtr bool, varname string) *table.Var {
+	return table.NewVar(table.Symtab.ScopePath, ext, typ, isPtr, varname, p.initval(isPtr || typ == lexical.KW_VA_LIST)) //va_list是指针
+}
+
+// 初始化表达式的值
+func (p *Parser) initval(isPtr bool) *table.Var {
 	initval := &table.Var{
 		Name:    "nil",
 		Literal: true, //常量
@@ -105,17 +548,19 @@ func (p *Parser) init(ext bool, typ lexical.TokenType, isPtr bool, varname strin
 	if p.match(lexical.ASSIGN) {
 		p.move()
 		initval = p.expr()
+	} else if isPtr { //缺省的初值是整数，和指针不兼容，指针没有显式初始化时不记录初值
+		initval = nil
 	}
-	return table.NewVar(table.Symtab.ScopePath, ext, typ, isPtr, varname, initval)
+	return initval
 }
 
 // <deflist> -> comma <defdata> <deflist> | semicon
-func (p *Parser) deflist(ext bool, typ lexical.TokenType) {
+func (p *Parser) deflist(ext bool, typ lexical.TokenType, cst bool, td *table.Var) {
 	if p.match(lexical.COMMA) {
 		p.move()
-		varr := p.defdata(ext, typ)
-		table.Symtab.AddVar(varr)
-		p.deflist(ext, typ)
+		varr := p.defdata(ext, typ, td)
+		p.addVar(varr, cst)
+		p.deflist(ext, typ, cst, td)
 	} else if p.match(lexical.SEMICOLON) {
 		p.move()
 	} else {
@@ -125,47 +570,78 @@ func (p *Parser) deflist(ext bool, typ lexical.TokenType) {
 
 // <idtail> ->	<varrdef> <deflist> | lparen <para> rparen <funtail>
 // <idtail> 区分函数和变量
-func (p *Parser) idtail(ext bool, typ lexical.TokenType, isPtr bool, name string) {
+func (p *Parser) idtail(ext bool, typ lexical.TokenType, cst bool, td *table.Var, name string) {
 	if p.match(lexical.LPAREN) { //函数
+		if td != nil {
+			p.Error("idtail err: 函数不能返回指针或数组")
+		}
 		p.move()
 		_, lnum, cnum := p.lexer.GetPosition()
 		table.Symtab.Enter(fmt.Sprintf("line:%d, col:%d token:%s", lnum, cnum, p.tk.String()))
 		var paralist []*table.Var
-		p.para(&paralist)
+		variadic := p.para(&paralist)
 		if !p.match(lexical.RPAREN) {
 			p.Error(fmt.Sprintf("idtail err: expected ')', but got %s", p.tk.String()))
 		}
 		p.move()
 		fun := table.NewFun(ext, typ, name, paralist)
+		fun.Variadic = variadic
 		p.funtail(fun)
 		_, lnum, cnum = p.lexer.GetPosition()
 		table.Symtab.Leave(fmt.Sprintf("line:%d, col:%d token:%s", lnum, cnum, p.tk.String()))
 	} else { //变量
-		table.Symtab.AddVar(p.varrdef(ext, typ, isPtr, name))
-		p.deflist(ext, typ)
+		p.addVar(p.tdvar(ext, typ, td, name), cst)
+		p.deflist(ext, typ, cst, td)
 	}
 }
 
 // <expr> -> <assexpr>
+// &&和||的结果是跳转形式，作为值使用时转换为0或1
 func (p *Parser) expr() *table.Var {
-	return p.assexpr()
+	return table.GenBool(p.assexpr())
+}
+
+// <condexpr> -> <assexpr> <commatail>
+// 逗号表达式。作为if、while、for和do-while的条件时，&&、||和!直接翻译为条件跳转
+func (p *Parser) condexpr() *table.Var {
+	lval := p.assexpr()
+	return p.commatail(lval)
+}
+
+// <commatail> -> comma <assexpr> <commatail> | ^
+func (p *Parser) commatail(lval *table.Var) *table.Var {
+	if p.match(lexical.COMMA) {
+		p.move()
+		table.GenCommaLeft(lval)
+		val := table.GenComma(p.assexpr())
+		return p.commatail(val)
+	}
+	return lval
 }
 
-// <defdata> -> id <varrdef> | mul id <init>
+// <defdata> -> id <varrdef> | mul <ptrdecl> <varrdef> | <funptr> <init>
 // 区分指针变量和非指针变量
-func (p *Parser) defdata(ext bool, typ lexical.TokenType) *table.Var {
-	if p.match(lexical.ID) { //非指针
+func (p *Parser) defdata(ext bool, typ lexical.TokenType, td *table.Var) *table.Var {
+	if p.match(lexical.ID) { //非指针，或者指针、数组类型名
 		varname := p.tk.(*lexical.TID).Name
 		p.move()
-		return p.varrdef(ext, typ, false, varname)
+		return p.tdvar(ext, typ, td, varname)
 	} else if p.match(lexical.MUL) { //指针
+		p.ptrType(td)
 		p.move()
-		if !p.match(lexical.ID) {
-			p.Error(fmt.Sprintf("defdata err: expected ID, but got %s", p.tk.String()))
+		varname, pc := p.ptrdecl()
+		v := p.varrdef(ext, typ, true, varname, nil)
+		v.Const = pc
+		return v
+	} else if p.match(lexical.LPAREN) { //函数指针，或者指向数组的指针
+		if td != nil {
+			p.Error("defdata err: 不支持指针或数组类型名的函数指针和指向数组的指针")
 		}
-		varname := p.tk.(*lexical.TID).Name
-		p.move()
-		return p.init(ext, typ, false, varname)
+		name, sig, dims := p.funptr(typ)
+		if sig == nil {
+			return table.NewArrayPtrVar(table.Symtab.ScopePath, ext, typ, false, name, dims, p.initval(true))
+		}
+		return table.NewFunPtrVar(table.Symtab.ScopePath, ext, sig, name, p.initval(true))
 	} else {
 		p.Error(fmt.Sprintf("defdata err: expected ID or MUL, but got %s", p.tk.String()))
 	}
@@ -173,14 +649,16 @@ func (p *Parser) defdata(ext bool, typ lexical.TokenType) *table.Var {
 }
 
 // <para> ->	<type> <paradata> <paralist> | ^
-func (p *Parser) para(paralist *[]*table.Var) {
-	if p.match(lexical.KW_INT) || p.match(lexical.KW_CHAR) || p.match(lexical.KW_VOID) {
-		typ := p.typedec()
-		v := p.paradata(typ)
+// 参数表以...结束时返回true
+func (p *Parser) para(paralist *[]*table.Var) bool {
+	if p.matchType() {
+		typ, cst, td := p.typedec()
+		v := p.paradata(typ, td)
 		(*paralist) = append((*paralist), v)
-		table.Symtab.AddVar(v)
-		p.paralist(paralist)
+		p.addVar(v, cst)
+		return p.paralist(paralist)
 	}
+	return false
 }
 
 // <funtail> -> <block> | semicon
@@ -195,80 +673,214 @@ func (p *Parser) funtail(fun *table.Fun) {
 	}
 }
 
-// <varrdef> -> lbrack num rbrack | <init>
-// 区分数组和非数组
-func (p *Parser) varrdef(ext bool, typ lexical.TokenType, isPtr bool, varname string) *table.Var {
-	if p.match(lexical.LBRACK) { //数组，不允许初始化
+// <varrdef> -> <dims> | <init>
+// 区分数组和非数组，isPtr表示数组的元素是指针，tdims是数组类型名的各维长度，接在声明的维数之后
+func (p *Parser) varrdef(ext bool, typ lexical.TokenType, isPtr bool, varname string, tdims []int64) *table.Var {
+	if p.match(lexical.LBRACK) || len(tdims) != 0 { //数组，不允许初始化
+		return table.NewArrayVar(table.Symtab.ScopePath, ext, typ, isPtr, varname, append(p.dims(false), tdims...))
+	} else { //非数组，允许初始化
+		return p.init(ext, typ, isPtr, varname)
+	}
+	return nil
+}
+
+// 声明符是id的变量，类型名td是指针或数组时变量也是
+func (p *Parser) tdvar(ext bool, typ lexical.TokenType, td *table.Var, varname string) *table.Var {
+	isPtr, tdims, pc := tdDecl(td)
+	v := p.varrdef(ext, typ, isPtr, varname, tdims)
+	if pc && v.IsArray {
+		p.Error("tdvar err: 不支持const指针的数组")
+	}
+	v.Const = pc
+	return v
+}
+
+// <dims> -> lbrack num rbrack <dims> | lbrack num rbrack
+// 数组各维的长度。omitFirst为true时可以省略第一维的长度，如参数int a[][4]，省略的长度记为0
+func (p *Parser) dims(omitFirst bool) []int64 {
+	var dims []int64
+	for p.match(lexical.LBRACK) {
 		p.move()
-		if !p.match(lexical.NUM) {
-			p.Error(fmt.Sprintf("varrdef err: expected NUM, but got %s", p.tk.String()))
+		if omitFirst && len(dims) == 0 && p.match(lexical.RBRACK) {
+			dims = append(dims, 0)
+		} else if p.match(lexical.NUM) {
+			dims = append(dims, p.tk.(*lexical.TNUM).Value)
+			p.move()
+		} else {
+			p.Error(fmt.Sprintf("dims err: expected NUM, but got %s", p.tk.String()))
 		}
-		arrlen := p.tk.(*lexical.TNUM).Value
-		p.move()
 		if !p.match(lexical.RBRACK) {
-			p.Error(fmt.Sprintf("varrdef err: expected ']', but got %s", p.tk.String()))
+			p.Error(fmt.Sprintf("dims err: expected ']', but got %s", p.tk.String()))
 		}
 		p.move()
-		return table.NewArrayVar(table.Symtab.ScopePath, ext, typ, varname, arrlen)
-	} else { //非数组，允许初始化
-		return p.init(ext, typ, isPtr, varname)
 	}
-	return nil
+	return dims
 }
 
-// <assexpr> ->	<orexpr> <asstail>
+// <assexpr> ->	<ternexpr> <asstail>
 func (p *Parser) assexpr() *table.Var {
-	lval := p.orexpr()
+	lval := p.ternexpr()
 	return p.asstail(lval)
 }
 
+// <ternexpr> -> <orexpr> <terntail>
+func (p *Parser) ternexpr() *table.Var {
+	cond := p.orexpr()
+	return p.terntail(cond)
+}
+
+// <terntail> -> question <condexpr> colon <ternexpr> | ^
+func (p *Parser) terntail(cond *table.Var) *table.Var {
+	if p.match(lexical.QUESTION) {
+		p.move()
+		_else, _exit := table.NewLabelInst(), table.NewLabelInst()
+		table.GenCondHead(cond, _else)
+		result := table.GenCondTrue(p.condexpr(), _else, _exit)
+		if !p.match(lexical.COLON) {
+			p.Error(fmt.Sprintf("terntail err: expected ':', but got %s", p.tk.String()))
+		}
+		p.move()
+		return table.GenCondFalse(result, p.ternexpr(), _exit)
+	}
+	return cond
+}
+
 // <orexpr> -> 	<andexpr> <ortail>
 func (p *Parser) orexpr() *table.Var {
 	lval := p.andexpr()
 	return p.ortail(lval)
 }
 
-// <asstail>	->	assign <orexpr> <asstail> | ^
+// <asstail>	->	<assops> <ternexpr> <asstail> | ^
 func (p *Parser) asstail(lval *table.Var) *table.Var {
-	if p.match(lexical.ASSIGN) {
+	if p.matchAssign() {
+		lval = table.GenBool(lval) //右边的计算不能被左边的跳转跳过
+		op := p.tk.TokenTyp()
 		p.move()
-		val := p.orexpr()
+		val := p.ternexpr()
 		rval := p.asstail(val)
-		result := table.GenTwoOp(lexical.ASSIGN, lval, rval)
+		result := table.GenTwoOp(op, lval, rval)
 		return result
 	}
 	return lval
 }
 
-// <andexpr> -> <cmpexpr> <andtail>
+// <assops> -> assign | add_assign | sub_assign | mul_assign | div_assign | mod_assign | and_assign | or_assign | xor_assign | shl_assign | shr_assign
+func (p *Parser) matchAssign() bool {
+	switch p.tk.TokenTyp() {
+	case lexical.ASSIGN, lexical.ADD_ASSIGN, lexical.SUB_ASSIGN, lexical.MUL_ASSIGN, lexical.DIV_ASSIGN,
+		lexical.MOD_ASSIGN, lexical.AND_ASSIGN, lexical.OR_ASSIGN, lexical.XOR_ASSIGN, lexical.SHL_ASSIGN,
+		lexical.SHR_ASSIGN:
+		return true
+	}
+	return false
+}
+
+// <andexpr> -> <bitorexpr> <andtail>
 func (p *Parser) andexpr() *table.Var {
-	lval := p.cmpexpr()
+	lval := p.bitorexpr()
 	return p.andtail(lval)
 }
 
+// <bitorexpr> -> <bitxorexpr> <bitortail>
+func (p *Parser) bitorexpr() *table.Var {
+	lval := p.bitxorexpr()
+	return p.bitortail(lval)
+}
+
+// <bitortail> -> bor <bitxorexpr> <bitortail> | ^
+func (p *Parser) bitortail(lval *table.Var) *table.Var {
+	if p.match(lexical.BOR) {
+		lval = table.GenBool(lval)
+		p.move()
+		val := p.bitxorexpr()
+		result := table.GenTwoOp(lexical.BOR, lval, val)
+		return p.bitortail(result)
+	}
+	return lval
+}
+
+// <bitxorexpr> -> <bitandexpr> <bitxortail>
+func (p *Parser) bitxorexpr() *table.Var {
+	lval := p.bitandexpr()
+	return p.bitxortail(lval)
+}
+
+// <bitxortail> -> xor <bitandexpr> <bitxortail> | ^
+func (p *Parser) bitxortail(lval *table.Var) *table.Var {
+	if p.match(lexical.XOR) {
+		lval = table.GenBool(lval)
+		p.move()
+		val := p.bitandexpr()
+		result := table.GenTwoOp(lexical.XOR, lval, val)
+		return p.bitxortail(result)
+	}
+	return lval
+}
+
+// <bitandexpr> -> <cmpexpr> <bitandtail>
+func (p *Parser) bitandexpr() *table.Var {
+	lval := p.cmpexpr()
+	return p.bitandtail(lval)
+}
+
+// <bitandtail> -> lea <cmpexpr> <bitandtail> | ^
+// 双目的&是按位与
+func (p *Parser) bitandtail(lval *table.Var) *table.Var {
+	if p.match(lexical.LEA) {
+		lval = table.GenBool(lval)
+		p.move()
+		val := p.cmpexpr()
+		result := table.GenTwoOp(lexical.LEA, lval, val)
+		return p.bitandtail(result)
+	}
+	return lval
+}
+
 // <ortail> 	-> 	or <andexpr> <ortail> | ^
 func (p *Parser) ortail(lval *table.Var) *table.Var {
 	if p.match(lexical.OR) {
 		p.move()
+		lval = table.GenOrLeft(lval)
 		val := p.andexpr()
-		result := table.GenTwoOp(lexical.OR, lval, val)
+		result := table.GenOr(lval, val)
 		return p.ortail(result)
 	}
 	return lval
 }
 
-// <cmpexpr>	->	<aloexpr><cmptail>
+// <cmpexpr>	->	<shiftexpr><cmptail>
 func (p *Parser) cmpexpr() *table.Var {
-	lval := p.aloexpr()
+	lval := p.shiftexpr()
 	return p.cmptail(lval)
 }
 
-// <andtail> -> 	and <cmpexpr> <andtail> | ^
+// <shiftexpr> -> <aloexpr> <shifttail>
+func (p *Parser) shiftexpr() *table.Var {
+	lval := p.aloexpr()
+	return p.shifttail(lval)
+}
+
+// <shifttail> -> shl <aloexpr> <shifttail> | shr <aloexpr> <shifttail> | ^
+func (p *Parser) shifttail(lval *table.Var) *table.Var {
+	if p.match(lexical.SHL) || p.match(lexical.SHR) {
+		lval = table.GenBool(lval)
+		op := p.tk.TokenTyp()
+		p.move()
+		val := p.aloexpr()
+		result := table.GenTwoOp(op, lval, val)
+		return p.shifttail(result)
+	}
+	return lval
+}
+
+// <andtail> -> 	and <bitorexpr> <andtail> | ^
 func (p *Parser) andtail(lval *table.Var) *table.Var {
 	if p.match(lexical.AND) {
 		p.move()
-		val := p.cmpexpr()
-		result := table.GenTwoOp(lexical.AND, lval, val)
+		lval = table.GenAndLeft(lval)
+		val := p.bitorexpr()
+		result := table.GenAnd(lval, val)
 		return p.andtail(result)
 	}
 	return lval
@@ -281,12 +893,13 @@ func (p *Parser) aloexpr() *table.Var {
 	return p.alotail(lval)
 }
 
-// <cmptail> ->	<cmps> <aloexpr> <cmptail> | ^
+// <cmptail> ->	<cmps> <shiftexpr> <cmptail> | ^
 func (p *Parser) cmptail(lval *table.Var) *table.Var {
 	if p.match(lexical.LT) || p.match(lexical.LE) || p.match(lexical.GT) || p.match(lexical.GE) ||
 		p.match(lexical.EQU) || p.match(lexical.NEQU) {
+		lval = table.GenBool(lval)
 		op := p.cmps()
-		val := p.aloexpr()
+		val := p.shiftexpr()
 		result := table.GenTwoOp(op, lval, val)
 		return p.cmptail(result)
 	}
@@ -302,6 +915,7 @@ func (p *Parser) item() *table.Var {
 // <alotail> ->	<adds> <item> <alotail> | ^
 func (p *Parser) alotail(lval *table.Var) *table.Var {
 	if p.match(lexical.ADD) || p.match(lexical.SUB) {
+		lval = table.GenBool(lval)
 		op := p.adds()
 		val := p.item()
 		result := table.GenTwoOp(op, lval, val)
@@ -325,7 +939,7 @@ func (p *Parser) cmps() lexical.TokenType {
 // <factor> -> 	<lop> <factor> | <val>
 func (p *Parser) factor() *table.Var {
 	if p.match(lexical.NOT) || p.match(lexical.SUB) || p.match(lexical.LEA) ||
-		p.match(lexical.MUL) || p.match(lexical.INC) || p.match(lexical.DEC) {
+		p.match(lexical.MUL) || p.match(lexical.INC) || p.match(lexical.DEC) || p.match(lexical.BNOT) {
 		op := p.lop()
 		val := p.factor()
 		return table.GenOneOpLeft(op, val)
@@ -337,6 +951,7 @@ func (p *Parser) factor() *table.Var {
 // <itemtail> -> <muls> <factor> <itemtail> | ^
 func (p *Parser) itemtail(lval *table.Var) *table.Var {
 	if p.match(lexical.MUL) || p.match(lexical.DIV) || p.match(lexical.MOD) {
+		lval = table.GenBool(lval)
 		op := p.muls()
 		val := p.factor()
 		result := table.GenTwoOp(op, lval, val)
@@ -366,11 +981,11 @@ func (p *Parser) val() *table.Var {
 	return lval
 }
 
-// <lop> ->  not|sub|lea|mul|inc|dec
+// <lop> ->  not|sub|lea|mul|inc|dec|bnot
 func (p *Parser) lop() lexical.TokenType {
-	if !p.match(lexical.NOT) && !p.match(lexical.SUB) && !p.match(lexical.ADD) &&
-		!p.match(lexical.MUL) && !p.match(lexical.INC) && !p.match(lexical.DEC) {
-		p.Error(fmt.Sprintf("lop err: expected '!', '-', '+', '*', '++', '--', but got %s", p.tk.String()))
+	if !p.match(lexical.NOT) && !p.match(lexical.SUB) && !p.match(lexical.LEA) &&
+		!p.match(lexical.MUL) && !p.match(lexical.INC) && !p.match(lexical.DEC) && !p.match(lexical.BNOT) {
+		p.Error(fmt.Sprintf("lop err: expected '!', '-', '&', '*', '++', '--', '~', but got %s", p.tk.String()))
 	}
 	tk := p.tk
 	p.move()
@@ -380,33 +995,89 @@ func (p *Parser) lop() lexical.TokenType {
 // <muls> -> mul | div | mod
 func (p *Parser) muls() lexical.TokenType {
 	if !p.match(lexical.MUL) && !p.match(lexical.DIV) && !p.match(lexical.MOD) {
-		p.Error(fmt.Sprintf("muls err: expected '*', '/', '%', but got %s", p.tk.String()))
+		p.Error(fmt.Sprintf("muls err: expected '*', '/', '%%', but got %s", p.tk.String()))
 	}
 	tk := p.tk
 	p.move()
 	return tk.TokenTyp()
 }
 
-// <elem> ->	id <idexpr> | lparen <expr> rparen | <literal>
+// <elem> ->	id <idexpr> | lparen <condexpr> rparen <idxtail> | lparen <condexpr> rparen <callargs> | <vaexpr> | <literal>
 func (p *Parser) elem() *table.Var {
 	var rs *table.Var
 	if p.match(lexical.ID) { //变量、数组索引、函数调用
 		name := p.tk.(*lexical.TID).Name
 		p.move()
 		rs = p.idexpr(name)
-	} else if p.match(lexical.LPAREN) { //括号表达式
+	} else if p.match(lexical.LPAREN) { //括号表达式，保持跳转形式，例如!(a && b)
 		p.move()
-		rs = p.expr()
+		rs = p.condexpr()
 		if !p.match(lexical.RPAREN) {
 			p.Error(fmt.Sprintf("elem err: expected ')', but got %s", p.tk.String()))
 		}
 		p.move()
+		if p.match(lexical.LPAREN) { //通过函数指针调用，例如(*fp)(1, 2)
+			rs = table.GenCallPtr(rs, p.callargs())
+		} else if p.match(lexical.LBRACK) { //例如(*pp)[i]
+			rs = p.idxtail(table.GenBool(rs))
+		}
+	} else if p.match(lexical.KW_VA_START) || p.match(lexical.KW_VA_ARG) || p.match(lexical.KW_VA_END) {
+		rs = p.vaexpr()
 	} else { //字面量
 		rs = p.literal()
 	}
 	return rs
 }
 
+/*
+<vaexpr> -> va_start lparen <expr> comma <expr> rparen
+
+	| va_arg lparen <expr> comma <type> rparen
+	| va_arg lparen <expr> comma <type> mul rparen
+	| va_end lparen <expr> rparen
+*/
+func (p *Parser) vaexpr() *table.Var {
+	op := p.tk.TokenTyp()
+	p.move()
+	if !p.match(lexical.LPAREN) {
+		p.Error(fmt.Sprintf("vaexpr err: expected '(', but got %s", p.tk.String()))
+	}
+	p.move()
+	ap := p.expr()
+	var rs *table.Var
+	if op == lexical.KW_VA_END {
+		rs = table.GenVaEnd(ap)
+	} else {
+		if !p.match(lexical.COMMA) {
+			p.Error(fmt.Sprintf("vaexpr err: expected ',', but got %s", p.tk.String()))
+		}
+		p.move()
+		if op == lexical.KW_VA_START {
+			rs = table.GenVaStart(ap, p.expr())
+		} else {
+			typ, _, td := p.typedec()
+			isPtr, tdims, _ := tdDecl(td)
+			if len(tdims) != 0 {
+				p.Error("vaexpr err: va_arg的类型不能是数组")
+			}
+			if p.match(lexical.MUL) {
+				p.ptrType(td)
+				isPtr = true
+				p.move()
+			}
+			if typ == lexical.KW_VA_LIST { //va_list是char *
+				typ, isPtr = lexical.KW_CHAR, true
+			}
+			rs = table.GenVaArg(ap, typ, isPtr)
+		}
+	}
+	if !p.match(lexical.RPAREN) {
+		p.Error(fmt.Sprintf("vaexpr err: expected ')', but got %s", p.tk.String()))
+	}
+	p.move()
+	return rs
+}
+
 // <rop>	-> inc | dec | ^
 func (p *Parser) rop() lexical.TokenType {
 	if p.match(lexical.INC) || p.match(lexical.DEC) {
@@ -417,32 +1088,50 @@ func (p *Parser) rop() lexical.TokenType {
 	return 0
 }
 
-// <idexpr>	->	lbrack <expr> rbrack | lparen <realarg> rparen | ^
+// <idexpr>	->	<idxtail> | <callargs> | ^
 func (p *Parser) idexpr(name string) *table.Var {
 	var rs *table.Var
 	if p.match(lexical.LBRACK) { //数组索引
+		rs = p.idxtail(table.Symtab.GetVar(name))
+	} else if p.match(lexical.LPAREN) { //函数调用
+		args := p.callargs()
+		if fp := table.Symtab.FindVar(name); fp != nil { //变量屏蔽同名的函数，通过函数指针调用
+			rs = table.GenCallPtr(fp, args)
+		} else {
+			fun := table.Symtab.GetFun(name, args)
+			rs = table.GenCall(fun, args)
+		}
+	} else { //标识符表达式，函数名是函数的地址
+		rs = table.Symtab.GetIdent(name)
+	}
+	return rs
+}
+
+// <idxtail> -> lbrack <expr> rbrack <idxtail> | ^
+// 连续的下标，如m[i][j]是(m[i])[j]
+func (p *Parser) idxtail(arr *table.Var) *table.Var {
+	if p.match(lexical.LBRACK) {
 		p.move()
 		idx := p.expr()
 		if !p.match(lexical.RBRACK) {
-			p.Error(fmt.Sprintf("idexpr err: expected ']', but got %s", p.tk.String()))
+			p.Error(fmt.Sprintf("idxtail err: expected ']', but got %s", p.tk.String()))
 		}
 		p.move()
-		arr := table.Symtab.GetVar(name)
-		rs = table.GenArray(arr, idx)
-	} else if p.match(lexical.LPAREN) { //函数调用
-		var args []*table.Var
-		p.move()
-		p.realarg(&args)
-		if !p.match(lexical.RPAREN) {
-			p.Error(fmt.Sprintf("idexpr err: expected ')', but got %s", p.tk.String()))
-		}
-		p.move()
-		fun := table.Symtab.GetFun(name, args)
-		rs = table.GenCall(fun, args)
-	} else { //标识符表达式
-		rs = table.Symtab.GetVar(name)
+		return p.idxtail(table.GenArray(arr, idx))
 	}
-	return rs
+	return arr
+}
+
+// <callargs> -> lparen <realarg> rparen
+func (p *Parser) callargs() []*table.Var {
+	var args []*table.Var
+	p.move()
+	p.realarg(&args)
+	if !p.match(lexical.RPAREN) {
+		p.Error(fmt.Sprintf("callargs err: expected ')', but got %s", p.tk.String()))
+	}
+	p.move()
+	return args
 }
 
 // <realarg> ->	<arg> <arglist> | ^
@@ -467,37 +1156,53 @@ func (p *Parser) arglist(args *[]*table.Var) {
 	}
 }
 
-// <paradata> -> mul id | id <paradatatail>
-// 参数：指针、非指针（普通变量和数组）
-func (p *Parser) paradata(typ lexical.TokenType) *table.Var {
-	if p.match(lexical.MUL) { //指针
+// <paradata> -> mul <ptrdecl> <paradatatail> | id <paradatatail> | <funptr>
+// 参数：指针、非指针（普通变量和数组）、函数指针
+func (p *Parser) paradata(typ lexical.TokenType, td *table.Var) *table.Var {
+	if p.match(lexical.MUL) { //指针和指针数组
+		p.ptrType(td)
 		p.move()
-		if !p.match(lexical.ID) {
-			p.Error(fmt.Sprintf("paradata err: expected ID, but got %s", p.tk.String()))
-		}
+		name, pc := p.ptrdecl()
+		v := p.paradatatail(typ, true, name, nil)
+		v.Const = pc
+		return v
+	} else if p.match(lexical.ID) { //普通变量和数组，或者指针、数组类型名
 		name := p.tk.(*lexical.TID).Name
 		p.move()
-		return table.NewVar(table.Symtab.ScopePath, false, typ, true, name, nil)
-	} else if p.match(lexical.ID) { //普通变量和数组
-		name := p.tk.(*lexical.TID).Name
-		p.move()
-		return p.paradatatail(typ, name)
+		isPtr, tdims, pc := tdDecl(td)
+		v := p.paradatatail(typ, isPtr, name, tdims)
+		v.Const = pc
+		return v
+	} else if p.match(lexical.LPAREN) { //函数指针，或者指向数组的指针
+		if td != nil {
+			p.Error("paradata err: 不支持指针或数组类型名的函数指针和指向数组的指针")
+		}
+		name, sig, dims := p.funptr(typ)
+		if sig == nil {
+			return table.NewArrayPtrVar(table.Symtab.ScopePath, false, typ, false, name, dims, nil)
+		}
+		return table.NewFunPtrVar(table.Symtab.ScopePath, false, sig, name, nil)
 	} else {
 		p.Error(fmt.Sprintf("paradata err: expected ID or *ID, but got %s", p.tk.String()))
 	}
 	return nil
 }
 
-// <paralist>	-> comma <type> <paradata> <paralist> | ^
-func (p *Parser) paralist(plist *[]*table.Var) {
+// <paralist>	-> comma <type> <paradata> <paralist> | comma ellipsis | ^
+func (p *Parser) paralist(plist *[]*table.Var) bool {
 	if p.match(lexical.COMMA) {
 		p.move()
-		typ := p.typedec()
-		v := p.paradata(typ)
+		if p.match(lexical.ELLIPSIS) {
+			p.move()
+			return true
+		}
+		typ, cst, td := p.typedec()
+		v := p.paradata(typ, td)
 		*plist = append((*plist), v)
-		table.Symtab.AddVar(v)
-		p.paralist(plist)
+		p.addVar(v, cst)
+		return p.paralist(plist)
 	}
+	return false
 }
 
 // <block> -> lbrace <subprogram> rbrace
@@ -514,20 +1219,13 @@ func (p *Parser) block() {
 	}
 }
 
-// <paradatatail> -> lbrack num rbrack | ^
-func (p *Parser) paradatatail(typ lexical.TokenType, name string) *table.Var {
-	if p.match(lexical.LBRACK) {
-		p.move()
-		if !p.match(lexical.NUM) {
-			p.Error(fmt.Sprintf("paradatatail err: expected NUM, but got %s", p.tk.String()))
-		}
-		p.move()
-		if !p.match(lexical.RBRACK) {
-			p.Error(fmt.Sprintf("paradata err: expected ']', but got %s", p.tk.String()))
-		}
-		p.move()
+// <paradatatail> -> <dims> | ^
+// 数组参数退化为指针，如int a[][4]是指向int[4]的指针，char *argv[]是char **。tdims是数组类型名的各维长度
+func (p *Parser) paradatatail(typ lexical.TokenType, isPtr bool, name string, tdims []int64) *table.Var {
+	if p.match(lexical.LBRACK) || len(tdims) != 0 {
+		return table.NewArrayParaVar(table.Symtab.ScopePath, typ, isPtr, name, append(p.dims(true), tdims...))
 	}
-	return table.NewVar(table.Symtab.ScopePath, false, typ, false, name, nil)
+	return table.NewVar(table.Symtab.ScopePath, false, typ, isPtr, name, nil)
 }
 
 /*
@@ -540,12 +1238,12 @@ func (p *Parser) paradatatail(typ lexical.TokenType, name string) *table.Var {
 	| rsv_continue semicon
 	| rsv_return <altexpr> semicon
 
-<localdef> -> <type> <defdata> <deflist>
+<localdef> -> <typedef> | <type> <defdata> <deflist> | <type> semicon
 
 <subprogram> -> <localdef> <subprogram> | <statement> <subprogram> | ^
 */
 func (p *Parser) subprogram() {
-	if p.match(lexical.KW_INT) || p.match(lexical.KW_CHAR) || p.match(lexical.KW_VOID) {
+	if p.matchType() || p.match(lexical.KW_TYPEDEF) {
 		p.localdef()
 		p.subprogram()
 	} else if p.matchStatFirst() {
@@ -561,16 +1259,25 @@ func (p *Parser) matchStatFirst() bool {
 }
 
 func (p *Parser) matchExprFirst() bool {
-	return p.match(lexical.LPAREN) || p.match(lexical.NUM) || p.match(lexical.CHAR) || p.match(lexical.STR) ||
+	return p.match(lexical.LPAREN) || p.match(lexical.NUM) || p.match(lexical.FNUM) || p.match(lexical.CHAR) || p.match(lexical.STR) ||
 		p.match(lexical.ID) || p.match(lexical.NOT) || p.match(lexical.SUB) || p.match(lexical.LEA) ||
-		p.match(lexical.MUL) || p.match(lexical.INC) || p.match(lexical.DEC)
+		p.match(lexical.MUL) || p.match(lexical.INC) || p.match(lexical.DEC) || p.match(lexical.BNOT) ||
+		p.match(lexical.KW_VA_START) || p.match(lexical.KW_VA_ARG) || p.match(lexical.KW_VA_END)
 }
 
-// <localdef> -> <type> <defdata> <deflist>
+// <localdef> -> <typedef> | <type> <defdata> <deflist> | <type> semicon
 func (p *Parser) localdef() {
-	typ := p.typedec()
-	table.Symtab.AddVar(p.defdata(false, typ))
-	p.deflist(false, typ)
+	if p.match(lexical.KW_TYPEDEF) {
+		p.typedef()
+		return
+	}
+	typ, cst, td := p.typedec()
+	if p.match(lexical.SEMICOLON) { //只定义枚举常量
+		p.move()
+		return
+	}
+	p.addVar(p.defdata(false, typ, td), cst)
+	p.deflist(false, typ, cst, td)
 }
 
 // Statement
@@ -649,7 +1356,7 @@ func (p *Parser) whilestat() {
 	   altexpr会创建一个临时变量，将其添加到符号表。这个临时变量用于存储表达式的值。所以返回这个临时变量（临时符号）在符号表的索引，
 	   后续过程就可以使用到这个表达式的值了。
 	*/
-	cond := p.altexpr()
+	cond := p.altcond()
 	table.GenWhileCond(cond, _exit)
 	if !p.match(lexical.RPAREN) {
 		p.Error(fmt.Sprintf("whilestat err: expected ')', but got %s", p.tk.String()))
@@ -677,7 +1384,7 @@ func (p *Parser) forstat() {
 	_for, _block, _step, _exit := table.NewLabelInst(), table.NewLabelInst(), table.NewLabelInst(), table.NewLabelInst()
 	table.GenForHead(_for)
 	table.Push(_step, _exit)
-	cond := p.altexpr() //TODO:思考
+	cond := p.altcond() //TODO:思考
 	table.GenForCondBegin(_exit, _block, _step, cond)
 	if !p.match(lexical.SEMICOLON) {
 		p.Error(fmt.Sprintf("forstat err: expected ';', but got %s", p.tk.String()))
@@ -716,7 +1423,7 @@ func (p *Parser) dowhilestat() {
 		p.Error(fmt.Sprintf("dowhilestat err: expected '(', but got %s", p.tk.String()))
 	}
 	p.move()
-	cond := p.altexpr()
+	cond := p.altcond()
 	if !p.match(lexical.RPAREN) {
 		p.Error(fmt.Sprintf("dowhilestat err: expected '(', but got %s", p.tk.String()))
 	}
@@ -743,7 +1450,7 @@ func (p *Parser) ifstat() {
 	}
 	p.move()
 	_else, _exit := table.NewLabelInst(), table.NewLabelInst()
-	cond := p.expr()
+	cond := p.condexpr()
 	table.GenIfHead(cond, _else)
 	if !p.match(lexical.RPAREN) {
 		p.Error(fmt.Sprintf("dowhilestat err: expected ')', but got %s", p.tk.String()))
@@ -794,12 +1501,16 @@ func (p *Parser) switchstat() {
 	table.GenSwitchTail(_exit) //put _exit and Pop()
 }
 
-// <forinit>  ->  <localdef> | <altexpr>
+// <forinit>  ->  <localdef> | <altexpr> semicon
 func (p *Parser) forinit() {
-	if p.match(lexical.KW_INT) || p.match(lexical.KW_CHAR) || p.match(lexical.KW_VOID) {
+	if p.matchType() {
 		p.localdef()
 	} else {
 		p.altexpr()
+		if !p.match(lexical.SEMICOLON) {
+			p.Error(fmt.Sprintf("forinit err: expected ';', but got %s", p.tk.String()))
+		}
+		p.move()
 	}
 }
 
@@ -837,18 +1548,31 @@ func (p *Parser) casestat(cond *table.Var) {
 	}
 }
 
-// <caselabel> -> <literal>
+// <caselabel> -> <literal> | id
+// id必须是枚举常量
 func (p *Parser) caselabel() *table.Var {
+	if p.match(lexical.FNUM) {
+		p.Error("caselabel err: case的值不能是浮点数")
+	}
+	if p.match(lexical.ID) {
+		v := table.Symtab.GetVar(p.tk.(*lexical.TID).Name)
+		if !v.Literal {
+			p.Error(fmt.Sprintf("caselabel err: <%s>不是常量", v.Name))
+		}
+		p.move()
+		return v
+	}
 	return p.literal()
 }
 
-// <literal>	->	number | string | character
+// <literal>	->	number | fnumber | string | character
+// 字符串和浮点数放在.rodata段
 func (p *Parser) literal() *table.Var {
-	if !p.match(lexical.NUM) && !p.match(lexical.STR) && !p.match(lexical.CHAR) {
-		p.Error(fmt.Sprintf("literal err: expected NUM, STR or CHAR, but got %s", p.tk.String()))
+	if !p.match(lexical.NUM) && !p.match(lexical.FNUM) && !p.match(lexical.STR) && !p.match(lexical.CHAR) {
+		p.Error(fmt.Sprintf("literal err: expected NUM, FNUM, STR or CHAR, but got %s", p.tk.String()))
 	}
 	v := table.NewLiteralVar(p.tk)
-	if p.tk.TokenTyp() == lexical.STR {
+	if p.tk.TokenTyp() == lexical.STR || p.tk.TokenTyp() == lexical.FNUM {
 		table.Symtab.AddStr(v)
 	} else {
 		table.Symtab.AddVar(v)
@@ -857,10 +1581,18 @@ func (p *Parser) literal() *table.Var {
 	return v
 }
 
-// <altexpr> ->	<expr> | ^
+// <altexpr> ->	<condexpr> | ^
 func (p *Parser) altexpr() *table.Var {
 	if p.matchExprFirst() {
-		return p.expr()
+		return table.GenBool(p.condexpr())
+	}
+	return table.Void
+}
+
+// <altcond> -> <condexpr> | ^
+func (p *Parser) altcond() *table.Var {
+	if p.matchExprFirst() {
+		return p.condexpr()
 	}
 	return table.Void
 }
@@ -0,0 +1,141 @@
+package syntax
+
+import (
+	"calgo/table"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// 语法分析src，符号表是全局的，每个进程只能分析一次
+func parseSrc(t *testing.T, src string) {
+	t.Helper()
+	f := filepath.Join(t.TempDir(), "a.c")
+	if err := os.WriteFile(f, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	NewParser(f).Parse()
+}
+
+// 指针和数组的类型名，常量表达式定义的枚举常量
+func TestTypedefEnum(t *testing.T) {
+	parseSrc(t, `
+typedef char *string;
+typedef int vec[4];
+typedef vec mat[3];
+typedef string names[2];
+typedef const char *cstr;
+typedef char *const fixedstr;
+enum { A = 1, B = A * 2, C = (B + 1) << 2, D = ~0 & 0xff, E = -B, F = C % 5 + B / 2, G = A == 1 && !0, H = 'a' + 1 };
+string gs;
+vec gv;
+mat gm;
+names gn;
+vec gp[2];
+cstr gc;
+fixedstr gf = "x";
+int count(string s, vec v, mat m) { return 0; }
+`)
+	enums := map[string]int64{"A": 1, "B": 2, "C": 12, "D": 255, "E": -2, "F": 3, "G": 1, "H": 'b'}
+	for name, want := range enums {
+		if got := table.Symtab.FindVar(name).GetVal(); got != want {
+			t.Errorf("%s = %d，应该是%d", name, got, want)
+		}
+	}
+	vars := []struct {
+		name    string
+		isPtr   bool
+		ptrElem bool
+		dims    []int64
+		cst     bool
+	}{
+		{"gs", true, false, nil, false},
+		{"gv", false, false, []int64{4}, false},
+		{"gm", false, false, []int64{3, 4}, false},
+		{"gn", false, true, []int64{2}, false},
+		{"gp", false, false, []int64{2, 4}, false},
+		{"gc", true, false, nil, false},
+		{"gf", true, false, nil, true},
+		{"s", true, false, nil, false},
+		{"v", true, false, nil, false},
+		{"m", true, false, []int64{4}, false},
+	}
+	for _, vt := range vars {
+		var v *table.Var
+		for _, c := range table.Symtab.Vartab[vt.name] {
+			if !c.IsType {
+				v = c
+			}
+		}
+		if v == nil {
+			t.Errorf("没有变量%s", vt.name)
+			continue
+		}
+		if v.IsPtr != vt.isPtr || v.PtrElem != vt.ptrElem || fmt.Sprint(v.Dims) != fmt.Sprint(vt.dims) || v.Const != vt.cst {
+			t.Errorf("%s: IsPtr=%v PtrElem=%v Dims=%v Const=%v", vt.name, v.IsPtr, v.PtrElem, v.Dims, v.Const)
+		}
+	}
+	if gc := table.Symtab.FindVar("gc"); !gc.ConstTyp {
+		t.Error("gc指向的char应该是const")
+	}
+}
+
+// 不支持的类型名和不是常量的枚举值报错。语法错误会退出进程，在子进程中分析
+func TestTypedefEnumErrors(t *testing.T) {
+	if src := os.Getenv("CALGO_PARSE_SRC"); src != "" {
+		parseSrc(t, src)
+		return
+	}
+	tests := []struct {
+		src string
+		err string
+	}{
+		{"typedef int (*fn)(int);", "不支持函数指针的类型名"},
+		{"typedef char *string; string *pp;", "不支持多级指针和指向数组的指针"},
+		{"typedef int vec[4]; vec *pv;", "不支持多级指针和指向数组的指针"},
+		{"typedef char *string; string f() { return 0; }", "函数不能返回指针或数组"},
+		{"typedef char *const cp; cp arr[2];", "不支持const指针的数组"},
+		{"typedef int vec[4]; vec v = 3;", "expected ',' or ';'"},
+		{"int y; enum { X = y };", "<y>不是常量"},
+		{"enum { Z = 1 / 0 };", "除数为0"},
+	}
+	for _, tt := range tests {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestTypedefEnumErrors$")
+		cmd.Env = append(os.Environ(), "CALGO_PARSE_SRC="+tt.src)
+		out, _ := cmd.CombinedOutput()
+		if !strings.Contains(string(out), tt.err) {
+			t.Errorf("%s: 输出\n%s\n应该包含%q", tt.src, out, tt.err)
+		}
+	}
+}
+
+// 指针的赋值、初始化和传参不能丢掉所指类型的const限定，加上const限定可以
+func TestConstPointers(t *testing.T) {
+	if src := os.Getenv("CALGO_PARSE_SRC"); src != "" {
+		parseSrc(t, src)
+		return
+	}
+	tests := []struct {
+		src string
+		out string
+	}{
+		{"const int k; int *q = &k;", "初始化丢弃了指针所指类型的const限定"},
+		{"int f() { const int k = 1; int *q = &k; return 0; }", "丢弃了指针所指类型的const限定"},
+		{"int f() { const char *s; char *t; t = s; return 0; }", "赋值丢弃了指针所指类型的const限定"},
+		{"int g(char *p); int f() { const char *s; s = \"a\"; return g(s); }", "实参丢弃了指针所指类型的const限定"},
+		{"typedef const char *cstr; cstr s; int f() { char *t; t = s; return 0; }", "赋值丢弃了指针所指类型的const限定"},
+		{"int f() { const int k = 1; const int *q = &k; char *t; const char *s; s = t; s = \"a\"; return *q; }", "语法分析通过"},
+		{"int g(const char *p); int f() { char *const t = \"a\"; char *u = t; return g(u); }", "语法分析通过"},
+	}
+	for _, tt := range tests {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestConstPointers$")
+		cmd.Env = append(os.Environ(), "CALGO_PARSE_SRC="+tt.src)
+		out, _ := cmd.CombinedOutput()
+		if !strings.Contains(string(out), tt.out) {
+			t.Errorf("%s: 输出\n%s\n应该包含%q", tt.src, out, tt.out)
+		}
+	}
+}
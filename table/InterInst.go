@@ -58,18 +58,32 @@ func NewRetvInst(retv *Var, retp *InterInst) *InterInst {
 	}
 }
 
-func NewProcInst(f *Fun) *InterInst {
+// 函数调用的Arg2是实参占的字节数，调用返回后从栈中弹出
+func NewProcInst(f *Fun, argSize int) *InterInst {
 	return &InterInst{
-		Op:  OP_PROC,
-		Fun: f,
+		Op:   OP_PROC,
+		Fun:  f,
+		Arg2: NewIntVar(argSize),
 	}
 }
 
-func NewCallInst(f *Fun, res *Var) *InterInst {
+func NewCallInst(f *Fun, res *Var, argSize int) *InterInst {
 	return &InterInst{
 		Op:     OP_CALL,
 		Fun:    f,
 		Result: res,
+		Arg2:   NewIntVar(argSize),
+	}
+}
+
+// 通过函数指针fp调用，sig是fp指向的函数。返回void时res是nil
+func NewICallInst(fp *Var, sig *Fun, res *Var, argSize int) *InterInst {
+	return &InterInst{
+		Op:     OP_ICALL,
+		Arg1:   fp,
+		Fun:    sig,
+		Result: res,
+		Arg2:   NewIntVar(argSize),
 	}
 }
 
@@ -143,14 +157,26 @@ func (i *InterInst) ToX86Asm() {
 		Emit(fmt.Sprintf("%s:", i.Label))
 		return
 	}
+	if i.toX86AsmFloat() || i.toX86Asm64() {
+		return
+	}
 	switch i.Op {
 	case OP_DEC:
 		InitVar(i.Arg1)
-	case OP_ENTRY:
+	case OP_ENTRY: //被调用者保存的寄存器放在局部变量的下面
 		Emit("push ebp")
 		Emit("mov ebp, esp")
 		Emit(fmt.Sprintf("sub esp, %d", i.Fun.MaxDepth))
+		for _, r := range i.Fun.saved {
+			Emit(fmt.Sprintf("push %s", r))
+		}
 	case OP_EXIT:
+		if n := len(i.Fun.saved); n > 0 {
+			Emit(fmt.Sprintf("lea esp, [ebp-%d]", i.Fun.MaxDepth+4*n))
+			for k := n - 1; k >= 0; k-- {
+				Emit(fmt.Sprintf("pop %s", i.Fun.saved[k]))
+			}
+		}
 		Emit("mov esp, ebp")
 		Emit("pop ebp")
 		Emit("ret")
@@ -175,12 +201,12 @@ func (i *InterInst) ToX86Asm() {
 	case OP_DIV:
 		LoadVar("eax", "al", i.Arg1)
 		LoadVar("ebx", "bl", i.Arg2)
-		Emit("idiv ebx")
+		i.div()
 		StoreVar("eax", "al", i.Result)
 	case OP_MOD:
 		LoadVar("eax", "al", i.Arg1)
 		LoadVar("ebx", "bl", i.Arg2)
-		Emit("idiv ebx")
+		i.div()
 		StoreVar("edx", "dl", i.Result)
 	case OP_NEG:
 		LoadVar("eax", "al", i.Arg1)
@@ -191,28 +217,28 @@ func (i *InterInst) ToX86Asm() {
 		LoadVar("ebx", "bl", i.Arg2)
 		Emit("mov ecx, 0")
 		Emit("cmp eax, ebx")
-		Emit("setg cl")
+		Emit(i.cmpInst("setg", "seta") + " cl")
 		StoreVar("ecx", "cl", i.Result)
 	case OP_GE:
 		LoadVar("eax", "al", i.Arg1)
 		LoadVar("ebx", "bl", i.Arg2)
 		Emit("mov ecx, 0")
 		Emit("cmp eax, ebx")
-		Emit("setge cl")
+		Emit(i.cmpInst("setge", "setae") + " cl")
 		StoreVar("ecx", "cl", i.Result)
 	case OP_LT:
 		LoadVar("eax", "al", i.Arg1)
 		LoadVar("ebx", "bl", i.Arg2)
 		Emit("mov ecx, 0")
 		Emit("cmp eax, ebx")
-		Emit("setl cl")
+		Emit(i.cmpInst("setl", "setb") + " cl")
 		StoreVar("ecx", "cl", i.Result)
 	case OP_LE:
 		LoadVar("eax", "al", i.Arg1)
 		LoadVar("ebx", "bl", i.Arg2)
 		Emit("mov ecx, 0")
 		Emit("cmp eax, ebx")
-		Emit("setle cl")
+		Emit(i.cmpInst("setle", "setbe") + " cl")
 		StoreVar("ecx", "cl", i.Result)
 	case OP_EQU:
 		LoadVar("eax", "al", i.Arg1)
@@ -234,23 +260,38 @@ func (i *InterInst) ToX86Asm() {
 		Emit("cmp eax, 0")
 		Emit("sete bl")
 		StoreVar("ebx", "bl", i.Result)
-	case OP_AND:
+	case OP_BAND:
 		LoadVar("eax", "al", i.Arg1)
-		Emit("cmp, eax, 0")
-		Emit("setne cl")
 		LoadVar("ebx", "bl", i.Arg2)
-		Emit("cmp ebx, 0")
-		Emit("setne bl")
-		Emit("and al, bl")
+		Emit("and eax, ebx")
 		StoreVar("eax", "al", i.Result)
-	case OP_OR:
+	case OP_BOR:
+		LoadVar("eax", "al", i.Arg1)
+		LoadVar("ebx", "bl", i.Arg2)
+		Emit("or eax, ebx")
+		StoreVar("eax", "al", i.Result)
+	case OP_BXOR:
 		LoadVar("eax", "al", i.Arg1)
-		Emit("cmp eax, 0")
-		Emit("setne al")
 		LoadVar("ebx", "bl", i.Arg2)
-		Emit("cmp ebx, 0")
-		Emit("setne bl")
-		Emit("or al, bl")
+		Emit("xor eax, ebx")
+		StoreVar("eax", "al", i.Result)
+	case OP_BNOT:
+		LoadVar("eax", "al", i.Arg1)
+		Emit("not eax")
+		StoreVar("eax", "al", i.Result)
+	case OP_SHL: //移位的位数在cl中
+		LoadVar("eax", "al", i.Arg1)
+		LoadVar("ecx", "cl", i.Arg2)
+		Emit("shl eax, cl")
+		StoreVar("eax", "al", i.Result)
+	case OP_SHR:
+		LoadVar("eax", "al", i.Arg1)
+		LoadVar("ecx", "cl", i.Arg2)
+		if i.Result.IsUnsigned() {
+			Emit("shr eax, cl")
+		} else {
+			Emit("sar eax, cl")
+		}
 		StoreVar("eax", "al", i.Result)
 	case OP_JMP:
 		Emit(fmt.Sprintf("jmp %s", i.Target.Label))
@@ -271,12 +312,15 @@ func (i *InterInst) ToX86Asm() {
 		LoadVar("eax", "al", i.Arg1)
 		Emit("push eax")
 	case OP_PROC:
-		Emit(fmt.Sprintf("call %s", i.Fun.Name))
-		Emit(fmt.Sprintf("add esp, %d", len(i.Fun.ParaVar)*4))
+		i.call()
 	case OP_CALL:
-		Emit(fmt.Sprintf("call %s", i.Fun.Name))
-		Emit(fmt.Sprintf("add esp, %d", len(i.Fun.ParaVar)*4))
+		i.call()
 		StoreVar("eax", "al", i.Result)
+	case OP_ICALL:
+		i.call()
+		if i.Result != nil {
+			StoreVar("eax", "al", i.Result)
+		}
 	case OP_RET:
 		Emit(fmt.Sprintf("jmp %s", i.Target.Label))
 	case OP_RETV:
@@ -285,17 +329,47 @@ func (i *InterInst) ToX86Asm() {
 	case OP_LEA:
 		LeaVar("eax", i.Arg1)
 		StoreVar("eax", "al", i.Result) //TODO:??
-	case OP_SET:
+	case OP_SET: //按指针指向的类型保存
 		LoadVar("eax", "al", i.Result)
 		LoadVar("ebx", "bl", i.Arg1)
-		Emit("mov [ebx], eax")
-	case OP_GET:
+		Emit(fmt.Sprintf("mov [ebx], %s", subReg("eax", "al", i.Arg1.elemSize())))
+	case OP_GET: //结果的类型就是指针指向的类型
 		LoadVar("eax", "al", i.Arg1)
-		Emit("mov eax, [eax]")
+		Emit(fmt.Sprintf("mov %s, [eax]", subReg("eax", "al", valSize(i.Result))))
 		StoreVar("eax", "al", i.Result)
 	}
 }
 
+// 调用函数并弹出实参。OP_ICALL把函数指针装入eax，间接调用
+func (i *InterInst) call() {
+	if i.Op == OP_ICALL {
+		LoadVar("eax", "al", i.Arg1)
+		Emit("call eax")
+	} else {
+		CallFun(asmName(i.Fun.Name))
+	}
+	Emit(fmt.Sprintf("add esp, %d", i.Arg2.IntVal))
+}
+
+// eax除以ebx，商在eax中，余数在edx中。有符号数用cdq把eax扩展到edx，无符号数edx清0
+func (i *InterInst) div() {
+	if i.Result.IsUnsigned() {
+		Emit("mov edx, 0")
+		Emit("div ebx")
+	} else {
+		Emit("cdq")
+		Emit("idiv ebx")
+	}
+}
+
+// 比较的结果: 有符号数用signed，无符号数用unsigned
+func (i *InterInst) cmpInst(signed, unsigned string) string {
+	if i.Arg1.IsUnsigned() {
+		return unsigned
+	}
+	return signed
+}
+
 const (
 	OP_NOP Operator = iota
 	OP_DEC
@@ -315,8 +389,12 @@ const (
 	OP_EQU
 	OP_NEQU
 	OP_NOT
-	OP_AND
-	OP_OR
+	OP_BAND
+	OP_BOR
+	OP_BXOR
+	OP_BNOT
+	OP_SHL
+	OP_SHR
 	OP_LEA
 	OP_SET
 	OP_GET
@@ -329,6 +407,7 @@ const (
 	OP_CALL
 	OP_RET
 	OP_RETV
+	OP_ICALL
 )
 
 var OpType = []string{
@@ -350,8 +429,12 @@ var OpType = []string{
 	"OP_EQU",
 	"OP_NEQU",
 	"OP_NOT",
-	"OP_AND",
-	"OP_OR",
+	"OP_BAND",
+	"OP_BOR",
+	"OP_BXOR",
+	"OP_BNOT",
+	"OP_SHL",
+	"OP_SHR",
 	"OP_LEA",
 	"OP_SET",
 	"OP_GET",
@@ -364,4 +447,5 @@ var OpType = []string{
 	"OP_CALL",
 	"OP_RET",
 	"OP_RETV",
+	"OP_ICALL",
 }
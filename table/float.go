@@ -0,0 +1,242 @@
+package table
+
+import (
+	"calgo/lexical"
+	"fmt"
+)
+
+/*
+float和double的运算: 第一个操作数在xmm0中，第二个在xmm1中，结果在xmm0中。
+按cdecl的约定，返回值在x87的st0中。i不涉及浮点数时返回false
+*/
+func (i *InterInst) toX86AsmFloat() bool {
+	switch i.Op {
+	case OP_AS:
+		if !i.Result.IsFloat() && !i.Arg1.IsFloat() {
+			return false
+		}
+	case OP_ADD, OP_SUB, OP_MUL, OP_DIV, OP_NEG, OP_CALL, OP_GET:
+		if !i.Result.IsFloat() {
+			return false
+		}
+	case OP_GT, OP_GE, OP_LT, OP_LE, OP_EQU, OP_NEQU:
+		if !i.Arg1.IsFloat() && !i.Arg2.IsFloat() {
+			return false
+		}
+	case OP_NOT, OP_JT, OP_JF, OP_ARG, OP_RETV:
+		if !i.Arg1.IsFloat() {
+			return false
+		}
+	case OP_ICALL:
+		if i.Result == nil || !i.Result.IsFloat() {
+			return false
+		}
+	case OP_SET: //元素是指针时按指针保存
+		if !i.Arg1.elemIsBase() || !IsFloatType(i.Arg1.Typ) {
+			return false
+		}
+	default:
+		return false
+	}
+	switch i.Op {
+	case OP_AS: //浮点数和整数之间的转换在装入和保存时完成
+		typ := i.Result.Typ
+		if !i.Result.IsFloat() {
+			typ = i.Arg1.Typ
+		}
+		LoadFloat("xmm0", typ, i.Arg1)
+		StoreFloat("xmm0", typ, i.Result)
+	case OP_ADD, OP_SUB, OP_MUL, OP_DIV:
+		typ := i.Result.Typ
+		LoadFloat("xmm0", typ, i.Arg1)
+		LoadFloat("xmm1", typ, i.Arg2)
+		Emit(fmt.Sprintf("%s%s xmm0, xmm1", floatOps[i.Op], sfx(typ)))
+		StoreFloat("xmm0", typ, i.Result)
+	case OP_NEG: //乘以-1，保证0的相反数是-0
+		typ := i.Result.Typ
+		LoadFloat("xmm0", typ, i.Arg1)
+		Emit("mov eax, -1")
+		Emit(fmt.Sprintf("cvtsi2%s xmm1, eax", sfx(typ)))
+		Emit(fmt.Sprintf("mul%s xmm0, xmm1", sfx(typ)))
+		StoreFloat("xmm0", typ, i.Result)
+	case OP_GT, OP_GE, OP_LT, OP_LE, OP_EQU, OP_NEQU:
+		i.cmpFloat()
+		StoreVar("ecx", "cl", i.Result)
+	case OP_NOT, OP_JT, OP_JF:
+		isNonZero(i.Arg1)
+		if i.Op == OP_NOT {
+			Emit("xor ecx, 1")
+			StoreVar("ecx", "cl", i.Result)
+		} else if i.Op == OP_JT {
+			Emit("cmp ecx, 0")
+			Emit(fmt.Sprintf("jne %s", i.Target.Label))
+		} else {
+			Emit("cmp ecx, 0")
+			Emit(fmt.Sprintf("je %s", i.Target.Label))
+		}
+	case OP_ARG:
+		typ := i.Arg1.Typ
+		LoadFloat("xmm0", typ, i.Arg1)
+		Emit(fmt.Sprintf("sub esp, %d", TypeSize(typ)))
+		Emit(fmt.Sprintf("mov%s [esp], xmm0", sfx(typ)))
+	case OP_RETV:
+		typ := i.Arg1.Typ
+		LoadFloat("xmm0", typ, i.Arg1)
+		Emit("sub esp, 8")
+		Emit(fmt.Sprintf("mov%s [esp], xmm0", sfx(typ)))
+		Emit(fmt.Sprintf("fld %s [esp]", memWidth(typ)))
+		Emit("add esp, 8")
+		Emit(fmt.Sprintf("jmp %s", i.Target.Label))
+	case OP_CALL, OP_ICALL:
+		typ := i.Fun.Typ
+		i.call()
+		Emit("sub esp, 8")
+		Emit(fmt.Sprintf("fstp %s [esp]", memWidth(typ)))
+		Emit(fmt.Sprintf("mov%s xmm0, [esp]", sfx(typ)))
+		Emit("add esp, 8")
+		StoreFloat("xmm0", typ, i.Result)
+	case OP_SET:
+		typ := i.Arg1.Typ
+		LoadFloat("xmm0", typ, i.Result)
+		LoadVar("ebx", "bl", i.Arg1)
+		Emit(fmt.Sprintf("mov%s [ebx], xmm0", sfx(typ)))
+	case OP_GET:
+		typ := i.Result.Typ
+		LoadVar("eax", "al", i.Arg1)
+		Emit(fmt.Sprintf("mov%s xmm0, [eax]", sfx(typ)))
+		StoreFloat("xmm0", typ, i.Result)
+	}
+	return true
+}
+
+var floatOps = map[Operator]string{
+	OP_ADD: "add",
+	OP_SUB: "sub",
+	OP_MUL: "mul",
+	OP_DIV: "div",
+}
+
+// 标量浮点指令的后缀: float是ss，double是sd
+func sfx(typ lexical.TokenType) string {
+	if typ == lexical.KW_FLOAT {
+		return "ss"
+	}
+	return "sd"
+}
+
+// x87指令的内存操作数大小
+func memWidth(typ lexical.TokenType) string {
+	if typ == lexical.KW_FLOAT {
+		return "dword"
+	}
+	return "qword"
+}
+
+/*
+比较xmm0和xmm1，结果放在ecx中。ucomiss/ucomisd按无符号数的方式设置CF和ZF，
+有NaN时ZF、PF和CF都是1: a > b和a >= b用seta/setae，a < b和a <= b交换两个操作数，
+这样有NaN时结果都是0；==还要求PF是0，!=在PF是1时也成立
+*/
+func (i *InterInst) cmpFloat() {
+	typ := i.Arg1.Typ
+	if !i.Arg1.IsFloat() {
+		typ = i.Arg2.Typ
+	}
+	LoadFloat("xmm0", typ, i.Arg1)
+	LoadFloat("xmm1", typ, i.Arg2)
+	if i.Op == OP_LT || i.Op == OP_LE {
+		Emit(fmt.Sprintf("ucomi%s xmm1, xmm0", sfx(typ)))
+	} else {
+		Emit(fmt.Sprintf("ucomi%s xmm0, xmm1", sfx(typ)))
+	}
+	Emit("mov ecx, 0")
+	Emit("mov ebx, 0")
+	switch i.Op {
+	case OP_GT, OP_LT:
+		Emit("seta cl")
+	case OP_GE, OP_LE:
+		Emit("setae cl")
+	case OP_EQU:
+		Emit("sete cl")
+		Emit("setnp bl")
+		Emit("and ecx, ebx")
+	case OP_NEQU:
+		Emit("setne cl")
+		Emit("setp bl")
+		Emit("or ecx, ebx")
+	}
+}
+
+// v != 0的结果放在ecx中，NaN不等于0
+func isNonZero(v *Var) {
+	LoadFloat("xmm0", v.Typ, v)
+	Emit("xorps xmm1, xmm1")
+	Emit(fmt.Sprintf("ucomi%s xmm0, xmm1", sfx(v.Typ)))
+	Emit("mov ecx, 0")
+	Emit("mov ebx, 0")
+	Emit("setne cl")
+	Emit("setp bl")
+	Emit("or ecx, ebx")
+}
+
+/*
+把v转换为typ(float或double)装入xmm。v是浮点数时用movss/movsd装入，类型不同时用cvtss2sd/cvtsd2ss转换；
+int及更小的整数扩展到eax后用cvtsi2ss/cvtsi2sd转换；unsigned int和long long扩展为64位后用x87的fild转换，
+fild把64位整数当作有符号数，unsigned long long的最高位是1时要加上2^64
+*/
+func LoadFloat(xmm string, typ lexical.TokenType, v *Var) {
+	if v.IsFloat() {
+		Emit(fmt.Sprintf("mov%s %s, %s", sfx(v.Typ), xmm, varMem(v, "eax")))
+		if v.Typ != typ {
+			Emit(fmt.Sprintf("cvt%s2%s %s, %s", sfx(v.Typ), sfx(typ), xmm, xmm))
+		}
+		return
+	}
+	if v.Typ == lexical.KW_INT || TypeSize(v.Typ) < 4 {
+		LoadVar("eax", "al", v)
+		Emit(fmt.Sprintf("cvtsi2%s %s, eax", sfx(typ), xmm))
+		return
+	}
+	LoadVar64("eax", "edx", v)
+	Emit("push edx")
+	Emit("push eax")
+	Emit("fild qword [esp]")
+	if v.Typ == lexical.KW_ULLONG {
+		positive := GenLb()
+		Emit("cmp edx, 2147483648")
+		Emit("jb " + positive)
+		Emit("push 1602224128") //2^64的float编码0x5f800000
+		Emit("fadd dword [esp]")
+		Emit("add esp, 4")
+		Emit(positive + ":")
+	}
+	Emit(fmt.Sprintf("fstp %s [esp]", memWidth(typ)))
+	Emit(fmt.Sprintf("mov%s %s, [esp]", sfx(typ), xmm))
+	Emit("add esp, 8")
+}
+
+/*
+把xmm中typ类型的值转换为v的类型保存到v。转换为整数时向0截断: int及更小的整数用cvttss2si/cvttsd2si，
+unsigned int和long long用x87的fisttp得到64位整数，不小于2^63的值不能转换为unsigned long long
+*/
+func StoreFloat(xmm string, typ lexical.TokenType, v *Var) {
+	if v.IsFloat() {
+		if v.Typ != typ {
+			Emit(fmt.Sprintf("cvt%s2%s %s, %s", sfx(typ), sfx(v.Typ), xmm, xmm))
+		}
+		Emit(fmt.Sprintf("mov%s %s, %s", sfx(v.Typ), varMem(v, "ecx"), xmm))
+		return
+	}
+	if v.Typ == lexical.KW_INT || TypeSize(v.Typ) < 4 {
+		Emit(fmt.Sprintf("cvtt%s2si eax, %s", sfx(typ), xmm))
+		StoreVar("eax", "al", v)
+		return
+	}
+	Emit("sub esp, 8")
+	Emit(fmt.Sprintf("mov%s [esp], %s", sfx(typ), xmm))
+	Emit(fmt.Sprintf("fld %s [esp]", memWidth(typ)))
+	Emit("fisttp qword [esp]")
+	Emit("pop eax")
+	Emit("pop edx")
+	StoreVar64("eax", "edx", v)
+}
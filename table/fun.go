@@ -9,14 +9,19 @@ type Fun struct {
 	Externed    bool
 	Typ         lexical.TokenType
 	ParaVar     []*Var
+	Variadic    bool //参数表以...结束
 	MaxDepth    int
 	CurEsp      int
 	ScopeEsp    []int
 	Intercode   []*InterInst `json:"-"`
 	returnPoint *InterInst
+	saved       []string //函数体用到的被调用者保存的寄存器，在入口保存、出口恢复
 }
 
 func NewFun(ext bool, typ lexical.TokenType, name string, paralist []*Var) *Fun {
+	if typ == lexical.KW_VA_LIST {
+		Error("返回值的类型不能是va_list")
+	}
 	fun := &Fun{
 		Externed: ext,
 		Typ:      typ,
@@ -25,17 +30,25 @@ func NewFun(ext bool, typ lexical.TokenType, name string, paralist []*Var) *Fun
 		CurEsp:   0,
 		MaxDepth: 0,
 	}
-	//参数从8字节位置开始存放, 固定4字节大小，
+	//参数从8字节位置开始存放, long long和double占8字节，其他固定4字节大小，
 	offset := 8
 	for _, f := range fun.ParaVar {
 		f.Offset = int64(offset)
-		offset = offset + 4
+		offset = offset + argSize(f)
 	}
 	return fun
 }
 
+// 参数在栈中占的字节数
+func argSize(v *Var) int {
+	if v.IsBase() && TypeSize(v.Typ) == 8 {
+		return 8
+	}
+	return 4
+}
+
 func (f *Fun) Match(fun *Fun) bool {
-	if f.Name != fun.Name || len(f.ParaVar) != len(fun.ParaVar) {
+	if f.Name != fun.Name || len(f.ParaVar) != len(fun.ParaVar) || f.Variadic != fun.Variadic {
 		return false
 	}
 	if len(fun.ParaVar) <= len(f.ParaVar) {
@@ -53,16 +66,32 @@ func (f *Fun) Match(fun *Fun) bool {
 	return true
 }
 
+// 返回值类型和参数类型都相同，用于函数指针的类型检查
+func (f *Fun) SameType(fun *Fun) bool {
+	if f.Typ != fun.Typ || len(f.ParaVar) != len(fun.ParaVar) || f.Variadic != fun.Variadic {
+		return false
+	}
+	for i := range f.ParaVar {
+		p1, p2 := f.ParaVar[i], fun.ParaVar[i]
+		if p1.IsBase() != p2.IsBase() || p1.Typ != p2.Typ || !TypeCheck(p1, p2) {
+			return false
+		}
+	}
+	return true
+}
+
+// 可变参数的函数的实参可以多于形参，多出的实参不做类型检查
 func (f *Fun) MatchArgs(args []*Var) bool {
-	if len(f.ParaVar) != len(args) {
+	if len(args) < len(f.ParaVar) || len(args) > len(f.ParaVar) && !f.Variadic {
 		return false
 	}
-	if len(args) <= len(f.ParaVar) {
-		for i := range f.ParaVar {
-			p1, p2 := f.ParaVar[i], args[i]
-			if !TypeCheck(p1, p2) {
-				return false
-			}
+	for i := range f.ParaVar {
+		p1, p2 := f.ParaVar[i], args[i]
+		if !TypeCheck(p1, p2) {
+			return false
+		}
+		if dropsConst(p1, p2) {
+			Error("实参丢弃了指针所指类型的const限定")
 		}
 	}
 	return true
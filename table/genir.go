@@ -41,6 +41,9 @@ func GenReturn(retv *Var) {
 		if retv.IsRef() {
 			retv = GenAssign1(retv)
 		}
+		if retv.IsBase() {
+			retv = GenCast(retv, fun.Typ)
+		}
 		Symtab.AddInst(NewRetvInst(retv, fun.GetReturnPoint()))
 	}
 }
@@ -49,7 +52,21 @@ func GenPtr(v *Var) *Var {
 	if v.IsBase() {
 		Error("基本类型不支持*操作")
 	}
-	tmp := NewTmpVar(Symtab.ScopePath, v.Typ, false)
+	if v.FunPtr != nil { //*fp是fp指向的函数，作为值使用时还是函数的地址
+		return v
+	}
+	if v.IsRef() || v.IsArray && len(v.Dims) > 1 { //**p，多维数组m作为值使用时是指向m[0]的指针
+		v = GenAssign1(v)
+	}
+	if !v.IsArray && len(v.Dims) > 0 { //*p是数组，作为值使用时是指向它的第一个元素的指针，地址和p相同
+		tmp := CopyVar(Symtab.ScopePath, v)
+		tmp.Dims = tmp.Dims[1:]
+		Symtab.AddVar(tmp)
+		Symtab.AddInst(NewInst(OP_AS, tmp, v, nil))
+		return tmp
+	}
+	tmp := NewTmpVar(Symtab.ScopePath, v.Typ, v.PtrElem)
+	tmp.ConstTyp = v.ConstTyp
 	tmp.IsLeft = true
 	tmp.Ptr = v
 	Symtab.AddVar(tmp)
@@ -59,6 +76,12 @@ func GenPtr(v *Var) *Var {
 // tmp = &v
 // if v is *p, then p is what we want
 func GenLea(v *Var) *Var {
+	if v.FunPtr != nil && v.Literal { //&f和f一样是函数f的地址
+		return v
+	}
+	if v.FunPtr != nil {
+		Error("不支持函数指针的指针")
+	}
 	if !v.IsLeft {
 		Error("右值不支持&操作")
 	}
@@ -66,6 +89,13 @@ func GenLea(v *Var) *Var {
 		return v.Ptr
 	}
 	tmp := NewTmpVar(Symtab.ScopePath, v.Typ, true)
+	tmp.ConstTyp = v.ConstTyp
+	if v.IsPtr { //&p是指向指针的指针
+		if v.PtrElem || len(v.Dims) > 0 {
+			Error("不支持多级指针")
+		}
+		tmp.PtrElem = true
+	}
 	Symtab.AddVar(tmp)
 	Symtab.AddInst(NewInst(OP_LEA, tmp, v, nil))
 	return tmp
@@ -80,7 +110,7 @@ func GenAssign1(v *Var) *Var {
 	if !v.IsRef() {
 		Symtab.AddInst(NewInst(OP_AS, tmp, v, nil))
 	} else {
-		Symtab.AddInst(NewInst(OP_GET, tmp, v, nil))
+		Symtab.AddInst(NewInst(OP_GET, tmp, v.Ptr, nil))
 	}
 	return tmp
 }
@@ -89,13 +119,27 @@ func GenAssign2(lval *Var, rval *Var) *Var {
 	if !lval.IsLeft {
 		Error("不可以对右值赋值")
 	}
+	if lval.IsConst() {
+		Error("不可以对const变量赋值")
+	}
+	return genAssign(lval, rval)
+}
+
+// 赋值和局部变量的初始化，const变量可以初始化
+func genAssign(lval *Var, rval *Var) *Var {
 	if !TypeCheck(lval, rval) {
 		Error("类型不兼容，不可赋值")
 	}
+	if dropsConst(lval, rval) {
+		Error("赋值丢弃了指针所指类型的const限定")
+	}
 	if rval.IsRef() {
-		rval = GenPtr(rval)
+		rval = GenAssign1(rval)
 	}
 	if lval.IsRef() {
+		if lval.IsBase() && rval.IsBase() { //*p = v按*p的类型保存v
+			rval = GenCast(rval, lval.Typ)
+		}
 		Symtab.AddInst(NewInst(OP_SET, rval, lval.Ptr, nil))
 	} else {
 		Symtab.AddInst(NewInst(OP_AS, lval, rval, nil))
@@ -104,12 +148,16 @@ func GenAssign2(lval *Var, rval *Var) *Var {
 }
 
 func GenTwoOp(op lexical.TokenType, lvar, rvar *Var) *Var {
+	lvar, rvar = GenBool(lvar), GenBool(rvar)
 	if lvar.IsVoid() || rvar.IsVoid() {
 		Error("参与表达式运算的变量类型不能为void")
 	}
 	if op == lexical.ASSIGN {
 		return GenAssign2(lvar, rvar)
 	}
+	if base, ok := opAssigns[op]; ok {
+		return GenOpAssign(base, lvar, rvar)
+	}
 	if lvar.IsRef() {
 		lvar = GenAssign1(lvar)
 	}
@@ -117,10 +165,6 @@ func GenTwoOp(op lexical.TokenType, lvar, rvar *Var) *Var {
 		rvar = GenAssign1(rvar)
 	}
 	switch op {
-	case lexical.OR:
-		return GenOr(lvar, rvar)
-	case lexical.AND:
-		return GenAnd(lvar, rvar)
 	case lexical.EQU:
 		return GenEQU(lvar, rvar)
 	case lexical.NEQU:
@@ -133,6 +177,9 @@ func GenTwoOp(op lexical.TokenType, lvar, rvar *Var) *Var {
 	if !lvar.IsBase() || !rvar.IsBase() {
 		Error(fmt.Sprintf("该类型不支持这种运算:%d", op))
 	}
+	if _, ok := intOps[op]; ok && (lvar.IsFloat() || rvar.IsFloat()) {
+		Error(fmt.Sprintf("浮点数不支持这种运算:%d", op))
+	}
 	switch op {
 	case lexical.GT:
 		return GenGT(lvar, rvar)
@@ -148,11 +195,111 @@ func GenTwoOp(op lexical.TokenType, lvar, rvar *Var) *Var {
 		return GenDiv(lvar, rvar)
 	case lexical.MOD:
 		return GenMod(lvar, rvar)
+	case lexical.LEA: //双目的&是按位与
+		return GenBand(lvar, rvar)
+	case lexical.BOR:
+		return GenBor(lvar, rvar)
+	case lexical.XOR:
+		return GenBxor(lvar, rvar)
+	case lexical.SHL:
+		return GenShl(lvar, rvar)
+	case lexical.SHR:
+		return GenShr(lvar, rvar)
 	}
 	Error(fmt.Sprintf("不支持的双目运算:%d", op))
 	return nil
 }
 
+// 只能用于整数的双目运算
+var intOps = map[lexical.TokenType]struct{}{
+	lexical.MOD: {},
+	lexical.LEA: {},
+	lexical.BOR: {},
+	lexical.XOR: {},
+	lexical.SHL: {},
+	lexical.SHR: {},
+}
+
+// 复合赋值运算符对应的双目运算
+var opAssigns = map[lexical.TokenType]lexical.TokenType{
+	lexical.ADD_ASSIGN: lexical.ADD,
+	lexical.SUB_ASSIGN: lexical.SUB,
+	lexical.MUL_ASSIGN: lexical.MUL,
+	lexical.DIV_ASSIGN: lexical.DIV,
+	lexical.MOD_ASSIGN: lexical.MOD,
+	lexical.AND_ASSIGN: lexical.LEA,
+	lexical.OR_ASSIGN:  lexical.BOR,
+	lexical.XOR_ASSIGN: lexical.XOR,
+	lexical.SHL_ASSIGN: lexical.SHL,
+	lexical.SHR_ASSIGN: lexical.SHR,
+}
+
+/*
+复合赋值: lval op= rval 翻译为 lval = lval op rval。
+lval是*p或a[i]时，p和a + i已经计算在临时变量中，只求值一次
+*/
+func GenOpAssign(op lexical.TokenType, lval, rval *Var) *Var {
+	if !lval.IsLeft {
+		Error("不可以对右值赋值")
+	}
+	return GenAssign2(lval, GenTwoOp(op, lval, rval))
+}
+
+// 整型提升: char和short参与运算前转换为int
+func promote(typ lexical.TokenType) lexical.TokenType {
+	if TypeSize(typ) < 4 {
+		return lexical.KW_INT
+	}
+	return typ
+}
+
+/*
+寻常算术转换: 有一个是double时结果是double，否则有一个是float时结果是float。
+整数先做整型提升，有一个是long long时结果是long long，否则是int。
+和结果一样大的操作数中有无符号数时，结果是对应的无符号类型
+*/
+func arithType(lvar, rvar *Var) lexical.TokenType {
+	if lvar.Typ == lexical.KW_DOUBLE || rvar.Typ == lexical.KW_DOUBLE {
+		return lexical.KW_DOUBLE
+	} else if lvar.Typ == lexical.KW_FLOAT || rvar.Typ == lexical.KW_FLOAT {
+		return lexical.KW_FLOAT
+	}
+	l, r := promote(lvar.Typ), promote(rvar.Typ)
+	typ := lexical.KW_INT
+	if TypeSize(l) == 8 || TypeSize(r) == 8 {
+		typ = lexical.KW_LLONG
+	}
+	for _, t := range []lexical.TokenType{l, r} {
+		if TypeSize(t) == TypeSize(typ) && IsUnsignedType(t) {
+			typ = UnsignedType(typ)
+		}
+	}
+	return typ
+}
+
+// 双目运算的两个操作数按寻常算术转换变为同一类型
+func usualConv(lvar, rvar *Var) (*Var, *Var, lexical.TokenType) {
+	typ := arithType(lvar, rvar)
+	return GenCast(lvar, typ), GenCast(rvar, typ), typ
+}
+
+/*
+把基本类型的v转换为typ: tmp = v。OP_AS装入v时按v的类型扩展，保存时按tmp的类型截断。
+提升后类型相同的不需要转换，装入寄存器时已经扩展为32位
+*/
+func GenCast(v *Var, typ lexical.TokenType) *Var {
+	if promote(v.Typ) == promote(typ) {
+		return v
+	}
+	if v.IsRef() {
+		v = GenAssign1(v)
+	}
+	tmp := NewTmpVar(Symtab.ScopePath, typ, false)
+	Symtab.AddVar(tmp)
+	Symtab.AddInst(NewInst(OP_AS, tmp, v, nil))
+	return tmp
+}
+
 /*
 翻译加法表达式
 指针和int相加: p + 1, 1 + p, p + i， 翻译为:
@@ -160,14 +307,19 @@ func GenTwoOp(op lexical.TokenType, lvar, rvar *Var) *Var {
 */
 func GenAdd(lvar, rvar *Var) *Var {
 	var tmp *Var
-	if lvar.IsPtr && rvar.IsBase() {
+	if !lvar.IsBase() && rvar.IsFloat() || lvar.IsFloat() && !rvar.IsBase() {
+		Error("GenAdd:指针只能加整数")
+	}
+	if !lvar.IsBase() && rvar.IsBase() {
 		tmp = CopyVar(Symtab.ScopePath, lvar)
 		rvar = GenMul(rvar, GetStep(lvar))
-	} else if lvar.IsBase() && rvar.IsPtr {
+	} else if lvar.IsBase() && !rvar.IsBase() {
 		tmp = CopyVar(Symtab.ScopePath, rvar)
-		lvar = GenMul(rvar, GetStep(rvar))
+		lvar = GenMul(lvar, GetStep(rvar))
 	} else if lvar.IsBase() && rvar.IsBase() {
-		tmp = NewTmpVar(Symtab.ScopePath, lexical.KW_INT, false)
+		var typ lexical.TokenType
+		lvar, rvar, typ = usualConv(lvar, rvar)
+		tmp = NewTmpVar(Symtab.ScopePath, typ, false)
 	} else {
 		Error("GenAdd:类型不支持")
 	}
@@ -183,13 +335,18 @@ func GenAdd(lvar, rvar *Var) *Var {
 */
 func GenSub(lvar, rvar *Var) *Var {
 	var tmp *Var
-	if lvar.IsPtr && rvar.IsBase() {
+	if !lvar.IsBase() && rvar.IsFloat() {
+		Error("GenSub:指针只能减整数")
+	}
+	if !lvar.IsBase() && rvar.IsBase() {
 		tmp = CopyVar(Symtab.ScopePath, lvar)
 		rvar = GenMul(rvar, GetStep(lvar))
-	} else if lvar.IsBase() && rvar.IsPtr {
+	} else if lvar.IsBase() && !rvar.IsBase() {
 		Error("不支持 i - p")
 	} else if lvar.IsBase() && rvar.IsBase() {
-		tmp = NewTmpVar(Symtab.ScopePath, lexical.KW_INT, false)
+		var typ lexical.TokenType
+		lvar, rvar, typ = usualConv(lvar, rvar)
+		tmp = NewTmpVar(Symtab.ScopePath, typ, false)
 	} else {
 		Error("GenAdd:类型不支持")
 	}
@@ -203,27 +360,240 @@ func GenSub(lvar, rvar *Var) *Var {
 注意，GenMul只在GenTwoOp中被调用，调用前已经确保lvar，rvar为基本类型
 */
 func GenMul(lvar, rvar *Var) *Var {
-	tmp := NewTmpVar(Symtab.ScopePath, lexical.KW_INT, false)
+	lvar, rvar, typ := usualConv(lvar, rvar)
+	tmp := NewTmpVar(Symtab.ScopePath, typ, false)
 	Symtab.AddVar(tmp)
 	Symtab.AddInst(NewInst(OP_MUL, tmp, lvar, rvar))
 	return tmp
 }
 
-func GenOr(lvar, rvar *Var) *Var {
+/*
+&&和||短路求值，翻译为条件跳转: 结果不保存在变量中，而是用两组目标还没有确定的跳转指令表示，
+值为真时执行True中的一条跳转，为假时执行False中的一条。条件语句直接使用这些跳转，
+需要值的地方由GenBool转换为0或1。!作用于跳转形式时交换两组跳转
+*/
+type Jumps struct {
+	True  []*InterInst
+	False []*InterInst
+}
+
+// v的跳转形式。v是值时生成 jt v 和 jmp(jf v 和 jmp)，后面紧跟着放置jmp的目标时删除这条jmp
+func toJumps(v *Var, jt bool) *Jumps {
+	if v.Jumps != nil {
+		return v.Jumps
+	}
+	if v.IsRef() {
+		v = GenAssign1(v)
+	}
+	j := &Jumps{}
+	if jt {
+		cj, jmp := NewCondJmpInst(OP_JT, nil, v), NewJmpInst(nil)
+		j.True, j.False = []*InterInst{cj}, []*InterInst{jmp}
+		Symtab.AddInst(cj)
+		Symtab.AddInst(jmp)
+	} else {
+		cj, jmp := NewCondJmpInst(OP_JF, nil, v), NewJmpInst(nil)
+		j.True, j.False = []*InterInst{jmp}, []*InterInst{cj}
+		Symtab.AddInst(cj)
+		Symtab.AddInst(jmp)
+	}
+	return j
+}
+
+func jumpVar(j *Jumps) *Var {
 	tmp := NewTmpVar(Symtab.ScopePath, lexical.KW_INT, false)
-	Symtab.AddVar(tmp)
-	Symtab.AddInst(NewInst(OP_OR, tmp, lvar, rvar))
+	tmp.Jumps = j
 	return tmp
 }
 
+// 在当前位置放置list中跳转的目标。最后一条指令是跳到这里的jmp时删除它
+func backpatch(list []*InterInst) {
+	if len(list) == 0 {
+		return
+	}
+	if f := Symtab.Curfun; f != nil && len(f.Intercode) != 0 {
+		last := f.Intercode[len(f.Intercode)-1]
+		for _, inst := range list {
+			if inst == last && inst.Op == OP_JMP {
+				f.Intercode = f.Intercode[:len(f.Intercode)-1]
+			}
+		}
+	}
+	lb := NewLabelInst()
+	for _, inst := range list {
+		inst.Target = lb
+	}
+	Symtab.AddInst(lb)
+}
+
+func setTarget(list []*InterInst, label *InterInst) {
+	for _, inst := range list {
+		inst.Target = label
+	}
+}
+
+// a && b: 在计算b之前，a为假时跳过b
+func GenAndLeft(lvar *Var) *Var {
+	j := toJumps(lvar, false)
+	backpatch(j.True)
+	return jumpVar(&Jumps{False: j.False})
+}
+
 func GenAnd(lvar, rvar *Var) *Var {
-	tmp := NewTmpVar(Symtab.ScopePath, lexical.KW_INT, false)
-	Symtab.AddVar(tmp)
-	Symtab.AddInst(NewInst(OP_AND, tmp, lvar, rvar))
-	return tmp
+	j := toJumps(rvar, false)
+	return jumpVar(&Jumps{True: j.True, False: append(lvar.Jumps.False, j.False...)})
+}
+
+// a || b: 在计算b之前，a为真时跳过b
+func GenOrLeft(lvar *Var) *Var {
+	j := toJumps(lvar, true)
+	backpatch(j.False)
+	return jumpVar(&Jumps{True: j.True})
+}
+
+func GenOr(lvar, rvar *Var) *Var {
+	j := toJumps(rvar, true)
+	return jumpVar(&Jumps{True: append(lvar.Jumps.True, j.True...), False: j.False})
+}
+
+/*
+跳转形式转换为值:
+tmp = 1; jmp _exit; tmp = 0; _exit:
+*/
+func GenBool(v *Var) *Var {
+	j := v.Jumps
+	if j == nil {
+		return v
+	}
+	v.Jumps = nil
+	Symtab.AddVar(v)
+	_exit := NewLabelInst()
+	backpatch(j.True)
+	Symtab.AddInst(NewInst(OP_AS, v, One, nil))
+	Symtab.AddInst(NewJmpInst(_exit))
+	backpatch(j.False)
+	Symtab.AddInst(NewInst(OP_AS, v, Zero, nil))
+	Symtab.AddInst(_exit)
+	return v
+}
+
+// 条件刚由!x计算出来时删除这条指令，返回x
+func popNot(cond *Var) *Var {
+	f := Symtab.Curfun
+	if f == nil || len(f.Intercode) == 0 {
+		return nil
+	}
+	last := f.Intercode[len(f.Intercode)-1]
+	if last.Op != OP_NOT || last.Result != cond {
+		return nil
+	}
+	f.Intercode = f.Intercode[:len(f.Intercode)-1]
+	return last.Arg1
+}
+
+// 条件为假时跳转到label，为真时执行后面的指令
+func GenJumpFalse(cond *Var, label *InterInst) {
+	if cond.Jumps != nil {
+		setTarget(cond.Jumps.False, label)
+		backpatch(cond.Jumps.True)
+		return
+	}
+	if x := popNot(cond); x != nil {
+		Symtab.AddInst(NewCondJmpInst(OP_JT, label, x))
+		return
+	}
+	if cond.IsRef() {
+		cond = GenAssign1(cond)
+	}
+	Symtab.AddInst(NewCondJmpInst(OP_JF, label, cond))
+}
+
+// 条件为真时跳转到label，为假时执行后面的指令
+func GenJumpTrue(cond *Var, label *InterInst) {
+	if cond.Jumps != nil {
+		setTarget(cond.Jumps.True, label)
+		backpatch(cond.Jumps.False)
+		return
+	}
+	if x := popNot(cond); x != nil {
+		Symtab.AddInst(NewCondJmpInst(OP_JF, label, x))
+		return
+	}
+	if cond.IsRef() {
+		cond = GenAssign1(cond)
+	}
+	Symtab.AddInst(NewCondJmpInst(OP_JT, label, cond))
+}
+
+/*
+c ? a : b翻译为:
+jf c _else; tmp = a; jmp _exit; _else: tmp = b; _exit:
+两个分支共用结果tmp。基本类型的结果是两个分支按寻常算术转换后的类型，
+在计算完b之后才能确定，所以tmp总是分配long long的8个字节；指针和数组的结果是指针
+*/
+func GenCondHead(cond *Var, _else *InterInst) {
+	GenJumpFalse(cond, _else)
+}
+
+func GenCondTrue(tval *Var, _else, _exit *InterInst) *Var {
+	tval = GenBool(tval)
+	result := Void
+	if !tval.IsVoid() {
+		if tval.IsBase() {
+			result = NewTmpVar(Symtab.ScopePath, promote(tval.Typ), false)
+			result.Size = 8
+		} else { //指针，函数指针
+			result = CopyVar(Symtab.ScopePath, tval)
+		}
+		Symtab.AddVar(result)
+		genCondValue(result, tval)
+	}
+	Symtab.AddInst(NewJmpInst(_exit))
+	Symtab.AddInst(_else)
+	return result
+}
+
+func GenCondFalse(result, fval *Var, _exit *InterInst) *Var {
+	fval = GenBool(fval)
+	if !TypeCheck(result, fval) {
+		Error("?:两个分支的类型不兼容")
+	}
+	if result.IsBase() && fval.IsBase() {
+		result.Typ = arithType(result, fval)
+	}
+	if !result.IsVoid() {
+		genCondValue(result, fval)
+	}
+	Symtab.AddInst(_exit)
+	return result
+}
+
+func genCondValue(result, v *Var) {
+	if v.IsRef() {
+		v = GenAssign1(v)
+	}
+	Symtab.AddInst(NewInst(OP_AS, result, v, nil))
+}
+
+// a, b: 在计算b之前结束a的跳转，a的值不再使用
+func GenCommaLeft(lvar *Var) {
+	if j := lvar.Jumps; j != nil {
+		backpatch(append(j.True, j.False...))
+	}
+}
+
+// a, b的结果是b的值，不是左值
+func GenComma(rvar *Var) *Var {
+	if rvar.IsLeft {
+		return GenAssign1(rvar)
+	}
+	return rvar
 }
 
 func GenEQU(lvar, rvar *Var) *Var {
+	if lvar.IsBase() && rvar.IsBase() {
+		lvar, rvar, _ = usualConv(lvar, rvar)
+	}
 	tmp := NewTmpVar(Symtab.ScopePath, lexical.KW_INT, false)
 	Symtab.AddVar(tmp)
 	Symtab.AddInst(NewInst(OP_EQU, tmp, lvar, rvar))
@@ -231,6 +601,9 @@ func GenEQU(lvar, rvar *Var) *Var {
 }
 
 func GenNEQU(lvar, rvar *Var) *Var {
+	if lvar.IsBase() && rvar.IsBase() {
+		lvar, rvar, _ = usualConv(lvar, rvar)
+	}
 	tmp := NewTmpVar(Symtab.ScopePath, lexical.KW_INT, false)
 	Symtab.AddVar(tmp)
 	Symtab.AddInst(NewInst(OP_NEQU, tmp, lvar, rvar))
@@ -238,6 +611,9 @@ func GenNEQU(lvar, rvar *Var) *Var {
 }
 
 func GenGT(lvar, rvar *Var) *Var {
+	if lvar.IsBase() && rvar.IsBase() {
+		lvar, rvar, _ = usualConv(lvar, rvar)
+	}
 	tmp := NewTmpVar(Symtab.ScopePath, lexical.KW_INT, false)
 	Symtab.AddVar(tmp)
 	Symtab.AddInst(NewInst(OP_GT, tmp, lvar, rvar))
@@ -245,6 +621,9 @@ func GenGT(lvar, rvar *Var) *Var {
 }
 
 func GenGE(lvar, rvar *Var) *Var {
+	if lvar.IsBase() && rvar.IsBase() {
+		lvar, rvar, _ = usualConv(lvar, rvar)
+	}
 	tmp := NewTmpVar(Symtab.ScopePath, lexical.KW_INT, false)
 	Symtab.AddVar(tmp)
 	Symtab.AddInst(NewInst(OP_GE, tmp, lvar, rvar))
@@ -252,6 +631,9 @@ func GenGE(lvar, rvar *Var) *Var {
 }
 
 func GenLT(lvar, rvar *Var) *Var {
+	if lvar.IsBase() && rvar.IsBase() {
+		lvar, rvar, _ = usualConv(lvar, rvar)
+	}
 	tmp := NewTmpVar(Symtab.ScopePath, lexical.KW_INT, false)
 	Symtab.AddVar(tmp)
 	Symtab.AddInst(NewInst(OP_LT, tmp, lvar, rvar))
@@ -259,6 +641,9 @@ func GenLT(lvar, rvar *Var) *Var {
 }
 
 func GenLE(lvar, rvar *Var) *Var {
+	if lvar.IsBase() && rvar.IsBase() {
+		lvar, rvar, _ = usualConv(lvar, rvar)
+	}
 	tmp := NewTmpVar(Symtab.ScopePath, lexical.KW_INT, false)
 	Symtab.AddVar(tmp)
 	Symtab.AddInst(NewInst(OP_LE, tmp, lvar, rvar))
@@ -266,39 +651,92 @@ func GenLE(lvar, rvar *Var) *Var {
 }
 
 func GenDiv(lvar, rvar *Var) *Var {
-	tmp := NewTmpVar(Symtab.ScopePath, lexical.KW_INT, false)
+	lvar, rvar, typ := usualConv(lvar, rvar)
+	tmp := NewTmpVar(Symtab.ScopePath, typ, false)
 	Symtab.AddVar(tmp)
 	Symtab.AddInst(NewInst(OP_DIV, tmp, lvar, rvar))
 	return tmp
 }
 
 func GenMod(lvar, rvar *Var) *Var {
-	tmp := NewTmpVar(Symtab.ScopePath, lexical.KW_INT, false)
+	lvar, rvar, typ := usualConv(lvar, rvar)
+	tmp := NewTmpVar(Symtab.ScopePath, typ, false)
 	Symtab.AddVar(tmp)
 	Symtab.AddInst(NewInst(OP_MOD, tmp, lvar, rvar))
 	return tmp
 }
 
+func GenBand(lvar, rvar *Var) *Var {
+	lvar, rvar, typ := usualConv(lvar, rvar)
+	tmp := NewTmpVar(Symtab.ScopePath, typ, false)
+	Symtab.AddVar(tmp)
+	Symtab.AddInst(NewInst(OP_BAND, tmp, lvar, rvar))
+	return tmp
+}
+
+func GenBor(lvar, rvar *Var) *Var {
+	lvar, rvar, typ := usualConv(lvar, rvar)
+	tmp := NewTmpVar(Symtab.ScopePath, typ, false)
+	Symtab.AddVar(tmp)
+	Symtab.AddInst(NewInst(OP_BOR, tmp, lvar, rvar))
+	return tmp
+}
+
+func GenBxor(lvar, rvar *Var) *Var {
+	lvar, rvar, typ := usualConv(lvar, rvar)
+	tmp := NewTmpVar(Symtab.ScopePath, typ, false)
+	Symtab.AddVar(tmp)
+	Symtab.AddInst(NewInst(OP_BXOR, tmp, lvar, rvar))
+	return tmp
+}
+
+func GenShl(lvar, rvar *Var) *Var {
+	tmp := NewTmpVar(Symtab.ScopePath, promote(lvar.Typ), false)
+	Symtab.AddVar(tmp)
+	Symtab.AddInst(NewInst(OP_SHL, tmp, lvar, rvar))
+	return tmp
+}
+
+// 有符号数右移，高位补符号位；无符号数右移，高位补0
+func GenShr(lvar, rvar *Var) *Var {
+	tmp := NewTmpVar(Symtab.ScopePath, promote(lvar.Typ), false)
+	Symtab.AddVar(tmp)
+	Symtab.AddInst(NewInst(OP_SHR, tmp, lvar, rvar))
+	return tmp
+}
+
 func GetStep(v *Var) *Var {
 	if v.IsBase() {
 		return One
 	}
-	if v.Typ == lexical.KW_CHAR {
+	if v.IsVoid() {
+		Error("GetStep:void不能参与加法运算")
+	}
+	if v.FunPtr != nil {
+		Error("GetStep:函数指针不能参与加减运算")
+	}
+	switch v.elemSize() { //元素是数组时步长是整个数组的大小，如int m[3][4]的一行是16
+	case 1:
 		return One
-	} else if v.Typ == lexical.KW_INT {
+	case 4:
 		return Four
 	}
-	Error("GetStep:void不能参与加法运算")
-	return nil
+	return NewIntVar(int(v.elemSize()))
 }
 
 /*
-++v, --v, &v, *v, !v, -v
+++v, --v, &v, *v, !v, -v, ~v
 */
 func GenOneOpLeft(op lexical.TokenType, v *Var) *Var {
+	if op != lexical.NOT {
+		v = GenBool(v)
+	}
 	if v.IsVoid() {
 		Error("GenOneOpLeft:不支持void类型")
 	}
+	if v.FunPtr != nil && (op == lexical.INC || op == lexical.DEC) {
+		Error("GenOneOpLeft:函数指针不能参与加减运算")
+	}
 	switch op {
 	case lexical.INC:
 		return GenIncL(v)
@@ -312,26 +750,31 @@ func GenOneOpLeft(op lexical.TokenType, v *Var) *Var {
 		return GenNot(v)
 	case lexical.SUB:
 		return GenMinus(v)
+	case lexical.BNOT:
+		return GenBnot(v)
 	}
 	Error("GenOneOpLeft：不支持的运算符")
 	return nil
 }
 
 /*
-if v is ref, then tmp = v + step
-else tmp = v + 1
+if v is ref, then tmp = *v + 1
+else v = v + step
 */
 //TODO:这里++v不会产生临时变量，相当于提前做了优化。 思考：为什么？
 func GenIncL(v *Var) *Var {
 	if !v.IsLeft {
 		Error("GenIncL: 变量不是左值")
 	}
+	if v.IsConst() {
+		Error("GenIncL: 不可以修改const变量")
+	}
 	if v.IsRef() {
-		t1 := GenAssign1(v)           //t1 = *p
-		t2 := GenAdd(t1, GetStep(t1)) //t2 = t1 + 1,
-		GenAssign2(v, t2)             //*p = t2
+		t1 := GenAssign1(v)   //t1 = *p
+		t2 := GenAdd(t1, One) //t2 = t1 + 1, GenAdd按t1的步长计算
+		GenAssign2(v, t2)     //*p = t2
 	} else {
-		Symtab.AddInst(NewInst(OP_ADD, v, v, One))
+		Symtab.AddInst(NewInst(OP_ADD, v, v, GetStep(v)))
 	}
 	return v
 }
@@ -340,12 +783,15 @@ func GenDecL(v *Var) *Var {
 	if !v.IsLeft {
 		Error("GenIncL: 变量不是左值")
 	}
+	if v.IsConst() {
+		Error("GenDecL: 不可以修改const变量")
+	}
 	if v.IsRef() {
-		t1 := GenAssign1(v)           //t1 = *p
-		t2 := GenSub(t1, GetStep(t1)) //t2 = t1 - 1,
-		GenAssign2(v, t2)             //*p = t2
+		t1 := GenAssign1(v)   //t1 = *p
+		t2 := GenSub(t1, One) //t2 = t1 - 1,
+		GenAssign2(v, t2)     //*p = t2
 	} else {
-		Symtab.AddInst(NewInst(OP_SUB, v, v, One))
+		Symtab.AddInst(NewInst(OP_SUB, v, v, GetStep(v)))
 	}
 	return v
 }
@@ -355,13 +801,37 @@ func GenMinus(v *Var) *Var {
 	if !v.IsBase() {
 		Error("GenMinus:不支持的变量类型")
 	}
-	tmp := NewTmpVar(Symtab.ScopePath, lexical.KW_INT, false)
+	if v.IsRef() {
+		v = GenAssign1(v)
+	}
+	tmp := NewTmpVar(Symtab.ScopePath, promote(v.Typ), false)
 	Symtab.AddVar(tmp)
 	Symtab.AddInst(NewInst(OP_NEG, tmp, v, nil))
 	return tmp
 }
 
+// ~v
+func GenBnot(v *Var) *Var {
+	if !v.IsBase() || v.IsFloat() {
+		Error("GenBnot:不支持的变量类型")
+	}
+	if v.IsRef() {
+		v = GenAssign1(v)
+	}
+	tmp := NewTmpVar(Symtab.ScopePath, promote(v.Typ), false)
+	Symtab.AddVar(tmp)
+	Symtab.AddInst(NewInst(OP_BNOT, tmp, v, nil))
+	return tmp
+}
+
+// !v，v是跳转形式时交换两组跳转
 func GenNot(v *Var) *Var {
+	if v.Jumps != nil {
+		return jumpVar(&Jumps{True: v.Jumps.False, False: v.Jumps.True})
+	}
+	if v.IsRef() {
+		v = GenAssign1(v)
+	}
 	tmp := NewTmpVar(Symtab.ScopePath, lexical.KW_INT, false)
 	Symtab.AddVar(tmp)
 	Symtab.AddInst(NewInst(OP_NOT, tmp, v, nil))
@@ -369,9 +839,13 @@ func GenNot(v *Var) *Var {
 }
 
 func GenOneOpRight(op lexical.TokenType, v *Var) *Var {
-	if v.IsVoid() || !v.IsLeft {
+	v = GenBool(v)
+	if v.IsVoid() || !v.IsLeft || v.FunPtr != nil {
 		Error("GenOneOpRight:不支持的变量类型")
 	}
+	if v.IsConst() {
+		Error("GenOneOpRight:不可以修改const变量")
+	}
 	if op == lexical.INC {
 		return GenIncR(v)
 	} else if op == lexical.DEC {
@@ -391,7 +865,7 @@ func GenIncR(v *Var) *Var {
 		t2 := GenAdd(t1, One)
 		GenAssign2(v, t2)
 	} else {
-		Symtab.AddInst(NewInst(OP_ADD, v, v, One))
+		Symtab.AddInst(NewInst(OP_ADD, v, v, GetStep(v)))
 	}
 	return t1
 }
@@ -402,16 +876,22 @@ func GenDecR(v *Var) *Var {
 		t2 := GenSub(t1, One)
 		GenAssign2(v, t2)
 	} else {
-		Symtab.AddInst(NewInst(OP_SUB, v, v, One))
+		Symtab.AddInst(NewInst(OP_SUB, v, v, GetStep(v)))
 	}
 	return t1
 }
 
 func GenArray(arr *Var, idx *Var) *Var {
-	if arr.IsVoid() || arr.IsBase() || !idx.IsBase() || idx.IsVoid() {
+	if arr.IsVoid() || arr.IsBase() || !idx.IsBase() || idx.IsVoid() || idx.IsFloat() {
 		Error("GenArray: 不支持的变量类型")
 	}
-	return GenPtr(GenAdd(arr, idx))
+	if arr.IsRef() { //a[i][j]中a[i]是指针数组的元素
+		arr = GenAssign1(arr)
+	}
+	if idx.IsRef() {
+		idx = GenAssign1(idx)
+	}
+	return GenPtr(GenAdd(arr, idx)) //a[i]是*(a + i)，a[i]是数组时GenPtr得到指向a[i][0]的指针
 	/*TODO：思考
 	这里只产生了一条中间代码： t1 = arr + idx，
 	并产生了一个临时对象t2，t2是*t1的结果。后面该怎么使用呢？为什么这就是数组索引的翻译结果了？
@@ -426,26 +906,118 @@ func GenPara(arg *Var) {
 }
 
 func GenCall(fun *Fun, args []*Var) *Var {
-	for i := len(args) - 1; i >= 0; i-- {
-		GenPara(args[i])
-	}
+	size := genArgs(fun, args)
 	if fun.Typ == lexical.KW_VOID {
-		Symtab.AddInst(NewProcInst(fun))
+		Symtab.AddInst(NewProcInst(fun, size))
 		return Void
 	} else {
 		tmp := NewTmpVar(Symtab.ScopePath, fun.Typ, false)
 		Symtab.AddVar(tmp)
-		Symtab.AddInst(NewCallInst(fun, tmp))
+		Symtab.AddInst(NewCallInst(fun, tmp, size))
 		return tmp
 	}
 	return nil
 }
 
-func GenIfHead(cond *Var, _else *InterInst) {
-	if cond.IsRef() { //TODO:这里是为什么?
-		cond = GenAssign1(cond)
+// 通过函数指针调用: fp(args)，(*fp)(args)
+func GenCallPtr(fp *Var, args []*Var) *Var {
+	if fp.FunPtr == nil {
+		Error(fmt.Sprintf("<%s>:不是函数或函数指针", fp.Name))
 	}
-	Symtab.AddInst(NewCondJmpInst(OP_JF, _else, cond))
+	if !fp.FunPtr.MatchArgs(args) {
+		Error(fmt.Sprintf("<%s>:形参与实参不匹配", fp.Name))
+	}
+	if fp.IsRef() {
+		fp = GenAssign1(fp)
+	}
+	sig := fp.FunPtr
+	size := genArgs(sig, args)
+	if sig.Typ == lexical.KW_VOID {
+		Symtab.AddInst(NewICallInst(fp, sig, nil, size))
+		return Void
+	}
+	tmp := NewTmpVar(Symtab.ScopePath, sig.Typ, false)
+	Symtab.AddVar(tmp)
+	Symtab.AddInst(NewICallInst(fp, sig, tmp, size))
+	return tmp
+}
+
+/*
+实参按形参的类型转换后从右向左入栈，返回实参占的字节数。
+...对应的实参做默认实参提升: float提升为double，char和short装入寄存器时已经扩展为int
+*/
+func genArgs(fun *Fun, args []*Var) int {
+	size := 0
+	for i := len(args) - 1; i >= 0; i-- {
+		arg := args[i]
+		if arg.IsRef() {
+			arg = GenAssign1(arg)
+		}
+		if i >= len(fun.ParaVar) {
+			if arg.IsBase() && arg.Typ == lexical.KW_FLOAT {
+				arg = GenCast(arg, lexical.KW_DOUBLE)
+			}
+		} else if para := fun.ParaVar[i]; arg.IsBase() && para.IsBase() {
+			arg = GenCast(arg, para.Typ)
+		}
+		GenPara(arg)
+		size += argSize(arg)
+	}
+	return size
+}
+
+// va_list是char *，见NewVar
+func checkVaList(ap *Var, name string) {
+	if !ap.IsPtr || ap.Typ != lexical.KW_CHAR || !ap.IsLeft {
+		Error(fmt.Sprintf("%s的第一个参数必须是va_list变量", name))
+	}
+}
+
+// va_start(ap, last): ap = (char *)&last + last在栈中占的字节数，即第一个可变参数的地址
+func GenVaStart(ap, last *Var) *Var {
+	checkVaList(ap, "va_start")
+	fun := Symtab.Curfun
+	if fun == nil || !fun.Variadic {
+		Error("va_start只能用在可变参数的函数中")
+	}
+	if last != fun.ParaVar[len(fun.ParaVar)-1] {
+		Error("va_start的第二个参数必须是...前面的参数")
+	}
+	addr := NewTmpVar(Symtab.ScopePath, lexical.KW_CHAR, true)
+	Symtab.AddVar(addr)
+	Symtab.AddInst(NewInst(OP_LEA, addr, last, nil))
+	GenAssign2(ap, GenAdd(addr, NewIntVar(argSize(last))))
+	return Void
+}
+
+/*
+va_arg(ap, type): 按type读出ap指向的参数，ap指向下一个参数。
+char、short和float的实参已经提升为int和double，不能用va_arg读出
+*/
+func GenVaArg(ap *Var, typ lexical.TokenType, isPtr bool) *Var {
+	checkVaList(ap, "va_arg")
+	if !isPtr && (TypeSize(typ) < 4 || typ == lexical.KW_FLOAT) {
+		Error("va_arg的类型不能是char、short或float")
+	}
+	res := NewTmpVar(Symtab.ScopePath, typ, isPtr)
+	Symtab.AddVar(res)
+	cur := ap
+	if cur.IsRef() {
+		cur = GenAssign1(cur)
+	}
+	Symtab.AddInst(NewInst(OP_GET, res, cur, nil))
+	GenAssign2(ap, GenAdd(cur, NewIntVar(argSize(res))))
+	return res
+}
+
+// va_end(ap)不需要做什么
+func GenVaEnd(ap *Var) *Var {
+	checkVaList(ap, "va_end")
+	return Void
+}
+
+func GenIfHead(cond *Var, _else *InterInst) {
+	GenJumpFalse(cond, _else)
 }
 
 // 即使没有else, 也需要_else标签，当cond为假时，跳到_else，即跳过此if语句
@@ -473,6 +1045,9 @@ func GenSwitchTail(_exit *InterInst) {
 }
 
 func GenCaseHead(_case_exit *InterInst, cond *Var, v *Var) {
+	if cond.IsFloat() {
+		Error("switch的条件不能是浮点数")
+	}
 	Symtab.AddInst(NewJNEInst(_case_exit, cond, v))
 }
 
@@ -482,7 +1057,7 @@ func GenCaseTail(_case_exit *InterInst) {
 
 // TODO:思考：这里我简化了，是否会有问题
 func GenWhileCond(cond *Var, _exit *InterInst) {
-	Symtab.AddInst(NewCondJmpInst(OP_JF, _exit, cond))
+	GenJumpFalse(cond, _exit)
 }
 
 func GenWhileHead(_while, _exit *InterInst) {
@@ -501,7 +1076,7 @@ func GenDoWhileHead(_do *InterInst) {
 }
 
 func GenDoWhileTail(_do, _exit *InterInst, cond *Var) {
-	Symtab.AddInst(NewCondJmpInst(OP_JT, _do, cond))
+	GenJumpTrue(cond, _do)
 	Symtab.AddInst(_exit)
 	Pop()
 }
@@ -513,7 +1088,7 @@ func GenForHead(_for *InterInst) {
 
 // cond_end
 func GenForCondBegin(_exit, _block, _step *InterInst, cond *Var) {
-	Symtab.AddInst(NewCondJmpInst(OP_JF, _exit, cond))
+	GenJumpFalse(cond, _exit)
 	Symtab.AddInst(NewJmpInst(_block))
 	Symtab.AddInst(_step)
 }
@@ -563,7 +1138,11 @@ func GenVarInit(v *Var) bool {
 	}
 	Symtab.AddInst(NewDecInst(v))
 	if v.SetInit() {
-		GenTwoOp(lexical.ASSIGN, v, v.initData)
+		init := GenBool(v.initData)
+		if init.IsVoid() {
+			Error("参与表达式运算的变量类型不能为void")
+		}
+		genAssign(v, init)
 	}
 	return true
 }
@@ -0,0 +1,275 @@
+package table
+
+import "fmt"
+
+/*
+long long的运算: 第一个操作数在edx:eax中，第二个在ecx:ebx中，结果在edx:eax中。
+i不涉及long long时返回false，由ToX86Asm按32位翻译
+*/
+func (i *InterInst) toX86Asm64() bool {
+	switch i.Op {
+	case OP_AS, OP_ADD, OP_SUB, OP_MUL, OP_DIV, OP_MOD, OP_NEG, OP_BAND, OP_BOR, OP_BXOR,
+		OP_BNOT, OP_SHL, OP_SHR, OP_CALL, OP_GET:
+		if !i.Result.IsLongLong() {
+			return false
+		}
+	case OP_GT, OP_GE, OP_LT, OP_LE, OP_EQU, OP_NEQU, OP_JNE:
+		if !i.Arg1.IsLongLong() && !i.Arg2.IsLongLong() {
+			return false
+		}
+	case OP_NOT, OP_JT, OP_JF, OP_ARG, OP_RETV:
+		if !i.Arg1.IsLongLong() {
+			return false
+		}
+	case OP_ICALL:
+		if i.Result == nil || !i.Result.IsLongLong() {
+			return false
+		}
+	case OP_SET:
+		if !i.Arg1.elemIsBase() || !isLongLongType(i.Arg1.Typ) {
+			return false
+		}
+	default:
+		return false
+	}
+	switch i.Op {
+	case OP_AS:
+		LoadVar64("eax", "edx", i.Arg1)
+	case OP_ADD:
+		i.load64()
+		Emit("add eax, ebx")
+		Emit("adc edx, ecx")
+	case OP_SUB:
+		i.load64()
+		Emit("sub eax, ebx")
+		Emit("sbb edx, ecx")
+	case OP_BAND:
+		i.load64()
+		Emit("and eax, ebx")
+		Emit("and edx, ecx")
+	case OP_BOR:
+		i.load64()
+		Emit("or eax, ebx")
+		Emit("or edx, ecx")
+	case OP_BXOR:
+		i.load64()
+		Emit("xor eax, ebx")
+		Emit("xor edx, ecx")
+	case OP_MUL:
+		i.load64()
+		mul64()
+	case OP_DIV, OP_MOD:
+		i.load64()
+		divmod64(!i.Result.IsUnsigned(), i.Op == OP_MOD)
+	case OP_NEG:
+		LoadVar64("eax", "edx", i.Arg1)
+		Emit("neg eax")
+		Emit("adc edx, 0")
+		Emit("neg edx")
+	case OP_BNOT:
+		LoadVar64("eax", "edx", i.Arg1)
+		Emit("not eax")
+		Emit("not edx")
+	case OP_SHL, OP_SHR:
+		LoadVar64("eax", "edx", i.Arg1)
+		LoadVar("ecx", "cl", i.Arg2)
+		i.shift64()
+	case OP_GT, OP_GE, OP_LT, OP_LE, OP_EQU, OP_NEQU:
+		i.load64()
+		Emit(i.cmp64() + " cl")
+		StoreVar("ecx", "cl", i.Result)
+		return true
+	case OP_JNE:
+		i.load64()
+		Emit("xor eax, ebx")
+		Emit("xor edx, ecx")
+		Emit("or eax, edx")
+		Emit(fmt.Sprintf("jne %s", i.Target.Label))
+		return true
+	case OP_NOT:
+		LoadVar64("eax", "edx", i.Arg1)
+		Emit("mov ebx, 0")
+		Emit("or eax, edx")
+		Emit("sete bl")
+		StoreVar("ebx", "bl", i.Result)
+		return true
+	case OP_JT, OP_JF:
+		LoadVar64("eax", "edx", i.Arg1)
+		Emit("or eax, edx")
+		if i.Op == OP_JT {
+			Emit(fmt.Sprintf("jne %s", i.Target.Label))
+		} else {
+			Emit(fmt.Sprintf("je %s", i.Target.Label))
+		}
+		return true
+	case OP_ARG: //高32位先入栈，低32位在低地址
+		LoadVar64("eax", "edx", i.Arg1)
+		Emit("push edx")
+		Emit("push eax")
+		return true
+	case OP_RETV:
+		LoadVar64("eax", "edx", i.Arg1)
+		Emit(fmt.Sprintf("jmp %s", i.Target.Label))
+		return true
+	case OP_CALL, OP_ICALL:
+		i.call()
+	case OP_SET:
+		LoadVar64("eax", "edx", i.Result)
+		LoadVar("ebx", "bl", i.Arg1)
+		Emit("mov [ebx], eax")
+		Emit("mov [ebx+4], edx")
+		return true
+	case OP_GET:
+		LoadVar("ecx", "cl", i.Arg1)
+		Emit("mov eax, [ecx]")
+		Emit("mov edx, [ecx+4]")
+	}
+	StoreVar64("eax", "edx", i.Result)
+	return true
+}
+
+func (i *InterInst) load64() {
+	LoadVar64("eax", "edx", i.Arg1)
+	LoadVar64("ebx", "ecx", i.Arg2)
+}
+
+/*
+比较edx:eax和ecx:ebx，结果放在ecx中，返回setcc指令。
+a < b和a >= b用cmp低32位、sbb高32位的借位和符号判断；a > b和a <= b交换两个操作数。
+mov不影响标志位，所以可以在setcc之前清0 ecx
+*/
+func (i *InterInst) cmp64() string {
+	switch i.Op {
+	case OP_EQU, OP_NEQU:
+		Emit("xor eax, ebx")
+		Emit("xor edx, ecx")
+		Emit("or eax, edx")
+		Emit("mov ecx, 0")
+		if i.Op == OP_EQU {
+			return "sete"
+		}
+		return "setne"
+	case OP_LT, OP_GE:
+		Emit("cmp eax, ebx")
+		Emit("sbb edx, ecx")
+	default:
+		Emit("cmp ebx, eax")
+		Emit("sbb ecx, edx")
+	}
+	Emit("mov ecx, 0")
+	if i.Op == OP_LT || i.Op == OP_GT {
+		return i.cmpInst("setl", "setb")
+	}
+	return i.cmpInst("setge", "setae")
+}
+
+// a * b的低64位: alo * blo + (alo * bhi + ahi * blo) << 32
+func mul64() {
+	Emit("push edx")
+	Emit("push eax")
+	Emit("mul ecx")
+	Emit("mov ecx, eax")
+	Emit("mov eax, [esp+4]")
+	Emit("mul ebx")
+	Emit("add ecx, eax")
+	Emit("pop eax")
+	Emit("add esp, 4")
+	Emit("mul ebx")
+	Emit("add edx, ecx")
+}
+
+/*
+64位除法用移位相减: 被除数edx:eax每次左移一位，移出的位进入余数edi:esi，
+余数不小于除数时减去除数，商的这一位为1，从eax的最低位移入。循环64次。
+有符号数先取绝对值，商的符号是两个操作数符号的异或，余数的符号和被除数相同
+*/
+func divmod64(signed, mod bool) {
+	Emit("push esi")
+	Emit("push edi")
+	if signed { //x的符号s为0或-1，(x ^ s) - s是x的绝对值
+		Emit("mov esi, edx")
+		Emit("sar esi, 31")
+		Emit("xor eax, esi")
+		Emit("xor edx, esi")
+		Emit("sub eax, esi")
+		Emit("sbb edx, esi")
+		Emit("mov edi, ecx")
+		Emit("sar edi, 31")
+		Emit("xor ebx, edi")
+		Emit("xor ecx, edi")
+		Emit("sub ebx, edi")
+		Emit("sbb ecx, edi")
+		Emit("xor edi, esi")
+		Emit("push esi") //余数的符号
+		Emit("push edi") //商的符号
+	}
+	Emit("push ecx") //除数在[esp]和[esp+4]
+	Emit("push ebx")
+	Emit("mov esi, 0")
+	Emit("mov edi, 0")
+	Emit("mov ecx, 64")
+	loop, sub, inc, restore, next := GenLb(), GenLb(), GenLb(), GenLb(), GenLb()
+	Emit(loop + ":")
+	Emit("add eax, eax")
+	Emit("adc edx, edx")
+	Emit("adc esi, esi")
+	Emit("adc edi, edi")
+	Emit("jb " + sub) //余数超过64位，一定不小于除数
+	Emit("sub esi, [esp]")
+	Emit("sbb edi, [esp+4]")
+	Emit("jb " + restore)
+	Emit(inc + ":")
+	Emit("inc eax")
+	Emit("jmp " + next)
+	Emit(sub + ":")
+	Emit("sub esi, [esp]")
+	Emit("sbb edi, [esp+4]")
+	Emit("jmp " + inc)
+	Emit(restore + ":")
+	Emit("add esi, [esp]")
+	Emit("adc edi, [esp+4]")
+	Emit(next + ":")
+	Emit("dec ecx")
+	Emit("jne " + loop)
+	Emit("add esp, 8")
+	if mod {
+		Emit("mov eax, esi")
+		Emit("mov edx, edi")
+	}
+	if signed {
+		Emit("pop ecx")
+		Emit("pop ebx")
+		sign := "ecx"
+		if mod {
+			sign = "ebx"
+		}
+		Emit(fmt.Sprintf("xor eax, %s", sign))
+		Emit(fmt.Sprintf("xor edx, %s", sign))
+		Emit(fmt.Sprintf("sub eax, %s", sign))
+		Emit(fmt.Sprintf("sbb edx, %s", sign))
+	}
+	Emit("pop edi")
+	Emit("pop esi")
+}
+
+// 64位移位每次移一位，移位的位数在ecx中，和32位一样只取低6位
+func (i *InterInst) shift64() {
+	loop, exit := GenLb(), GenLb()
+	Emit("and ecx, 63")
+	Emit(loop + ":")
+	Emit("cmp ecx, 0")
+	Emit("je " + exit)
+	if i.Op == OP_SHL {
+		Emit("add eax, eax")
+		Emit("adc edx, edx")
+	} else if i.Result.IsUnsigned() {
+		Emit("shr edx, 1")
+		Emit("rcr eax, 1")
+	} else {
+		Emit("sar edx, 1")
+		Emit("rcr eax, 1")
+	}
+	Emit("dec ecx")
+	Emit("jmp " + loop)
+	Emit(exit + ":")
+}
@@ -1,10 +1,19 @@
 package table
 
 import (
+	"calgo/asm"
 	"fmt"
+	"strings"
 )
 
+// 不为nil时Emit写到这里，用来先生成函数体
+var asmBuf *strings.Builder
+
 func Emit(s string) {
+	if asmBuf != nil {
+		asmBuf.WriteString(s + "\n")
+		return
+	}
 	_, err := AsmFile.WriteString(s + "\n")
 	if err != nil {
 		fmt.Println(err)
@@ -12,18 +21,110 @@ func Emit(s string) {
 	}
 }
 
-func LoadVar(reg32, reg8 string, v *Var) {
-	reg := ""
-	if v.IsChar() {
-		reg = reg8
+// 被调用者保存的寄存器和它们的8位、16位部分
+var calleeSaved = map[string]string{
+	"ebx": "ebx", "bx": "ebx", "bl": "ebx", "bh": "ebx",
+	"esi": "esi", "si": "esi",
+	"edi": "edi", "di": "edi",
+}
+
+/*
+汇编代码code中用到的被调用者保存的寄存器，按ebx、esi、edi的顺序。
+i386的调用约定要求函数返回时这些寄存器不变，例如glibc用ebx保存GOT的地址
+*/
+func savedRegs(code string) []string {
+	used := map[string]bool{}
+	for _, w := range strings.FieldsFunc(code, func(c rune) bool {
+		return !(c == '$' || c == '@' || c == '.' || c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z')
+	}) {
+		if r, ok := calleeSaved[w]; ok {
+			used[r] = true
+		}
+	}
+	regs := []string{}
+	for _, r := range []string{"ebx", "esi", "edi"} {
+		if used[r] {
+			regs = append(regs, r)
+		}
+	}
+	return regs
+}
+
+// 生成位置无关代码(-fPIC)
+var PIC = false
+var picNum = 0
+
+/*
+位置无关代码中把全局变量或字符串常量name的地址装入reg32:
+call/pop取得当前指令的地址，加上它到GOT的距离得到GOT的地址。
+本文件定义的符号相对GOT的偏移在链接时确定(@GOTOFF)，外部符号的地址从GOT中读出(@GOT)
+*/
+func PicAddr(reg32, name string, externed bool) {
+	label := fmt.Sprintf(".LPIC%d", picNum)
+	picNum++
+	Emit(fmt.Sprintf("call %s", label))
+	Emit(fmt.Sprintf("%s:", label))
+	Emit(fmt.Sprintf("pop %s", reg32))
+	Emit(fmt.Sprintf("add %s, _GLOBAL_OFFSET_TABLE_+($-%s)", reg32, label))
+	if externed {
+		Emit(fmt.Sprintf("mov %s, [%s+%s@GOT]", reg32, reg32, name))
 	} else {
-		reg = reg32
+		Emit(fmt.Sprintf("lea %s, [%s+%s@GOTOFF]", reg32, reg32, name))
 	}
-	name := v.Name
+}
+
+// 位置无关代码通过PLT调用函数
+func CallFun(name string) {
+	if PIC {
+		Emit(fmt.Sprintf("call %s@PLT", name))
+	} else {
+		Emit(fmt.Sprintf("call %s", name))
+	}
+}
+
+// 符号name在汇编中的写法。和汇编器关键字同名的符号(如dx、qword)写成$dx，否则会被当成寄存器或关键字
+func asmName(name string) string {
+	if asm.IsKeyword(name) {
+		return "$" + name
+	}
+	return name
+}
+
+// v的值的大小，数组和指针的值是地址。char数组和char指针也要用32位寄存器装入和保存
+func valSize(v *Var) int64 {
+	if v.IsBase() {
+		return TypeSize(v.Typ)
+	}
+	return 4
+}
+
+// reg32对应的8位或16位寄存器
+func subReg(reg32, reg8 string, size int64) string {
+	switch size {
+	case 1:
+		return reg8
+	case 2:
+		return reg32[1:]
+	}
+	return reg32
+}
+
+/*
+把v的值装入reg32。char和short按v是否无符号做零扩展或符号扩展，long long只装入低32位
+*/
+func LoadVar(reg32, reg8 string, v *Var) {
+	size := valSize(v)
+	reg := subReg(reg32, reg8, size)
+	name := asmName(v.Name)
 	if !v.Literal {
 		off := v.Offset
 		if off == 0 { //全局变量
-			if !v.IsArray { //非数组
+			if PIC {
+				PicAddr(reg32, name, v.Externed)
+				if !v.IsArray {
+					Emit(fmt.Sprintf("mov %s, [%s]", reg, reg32))
+				}
+			} else if !v.IsArray { //非数组
 				Emit(fmt.Sprintf("mov %s, [%s]", reg, name))
 			} else {
 				Emit(fmt.Sprintf("mov %s, %s", reg, name))
@@ -35,46 +136,130 @@ func LoadVar(reg32, reg8 string, v *Var) {
 				Emit(fmt.Sprintf("lea %s, [ebp%+d]", reg, off))
 			}
 		}
-	} else {
-		if v.IsBase() { //数字，字符
-			var val int64
-			if v.IsChar() {
-				val = int64(v.CharVal)
+		if size < 4 && !v.IsArray {
+			if v.IsUnsigned() {
+				Emit(fmt.Sprintf("movzx %s, %s", reg32, reg))
 			} else {
-				val = v.IntVal
+				Emit(fmt.Sprintf("movsx %s, %s", reg32, reg))
 			}
-			Emit(fmt.Sprintf("mov %s, %d", reg, val))
-		} else { //字符串
+		}
+	} else {
+		if v.IsBase() { //数字，字符，常量已经按类型转换过
+			Emit(fmt.Sprintf("mov %s, %d", reg32, v.GetVal()))
+		} else if PIC { //字符串，函数的地址
+			PicAddr(reg32, name, isExternFun(v))
+		} else {
 			Emit(fmt.Sprintf("mov %s, %s", reg, name))
 		}
 	}
 }
 
+/*
+把v的值装入lo和hi组成的64位寄存器对。v不是long long时先装入lo，再按v是否无符号扩展到hi。
+lo和hi是eax，ebx，ecx，edx中的两个
+*/
+func LoadVar64(lo, hi string, v *Var) {
+	if !v.IsLongLong() {
+		LoadVar(lo, lo[1:2]+"l", v)
+		if v.IsUnsigned() || !v.IsBase() {
+			Emit(fmt.Sprintf("mov %s, 0", hi))
+		} else {
+			Emit(fmt.Sprintf("mov %s, %s", hi, lo))
+			Emit(fmt.Sprintf("sar %s, 31", hi))
+		}
+		return
+	}
+	if v.Literal {
+		Emit(fmt.Sprintf("mov %s, %d", lo, int32(v.IntVal)))
+		Emit(fmt.Sprintf("mov %s, %d", hi, int32(v.IntVal>>32)))
+		return
+	}
+	name := asmName(v.Name)
+	if v.Offset == 0 && PIC { //地址先放在hi中
+		PicAddr(hi, name, v.Externed)
+		Emit(fmt.Sprintf("mov %s, [%s]", lo, hi))
+		Emit(fmt.Sprintf("mov %s, [%s+4]", hi, hi))
+	} else if v.Offset == 0 {
+		Emit(fmt.Sprintf("mov %s, [%s]", lo, name))
+		Emit(fmt.Sprintf("mov %s, [%s+4]", hi, name))
+	} else {
+		Emit(fmt.Sprintf("mov %s, [ebp%+d]", lo, v.Offset))
+		Emit(fmt.Sprintf("mov %s, [ebp%+d]", hi, v.Offset+4))
+	}
+}
+
+// v是只声明没有定义的函数的地址，位置无关代码中从GOT中取得
+func isExternFun(v *Var) bool {
+	return v.FunPtr != nil && v.FunPtr.Externed
+}
+
+// v的内存操作数。位置无关代码中全局变量的地址先装入reg32
+func varMem(v *Var, reg32 string) string {
+	if v.Offset != 0 {
+		return fmt.Sprintf("[ebp%+d]", v.Offset)
+	} else if PIC {
+		PicAddr(reg32, asmName(v.Name), v.Externed)
+		return fmt.Sprintf("[%s]", reg32)
+	}
+	return fmt.Sprintf("[%s]", asmName(v.Name))
+}
+
 func LeaVar(reg32 string, v *Var) {
-	name := v.Name
-	if v.Offset == 0 {
+	name := asmName(v.Name)
+	if v.Offset == 0 && PIC {
+		PicAddr(reg32, name, v.Externed)
+	} else if v.Offset == 0 {
 		Emit(fmt.Sprintf("mov %s, %s", reg32, name))
 	} else {
-		Emit(fmt.Sprintf("mov %s, [ebp%+d]", reg32, v.Offset))
+		Emit(fmt.Sprintf("lea %s, [ebp%+d]", reg32, v.Offset))
 	}
 }
 
-/* Store 'reg32' or 'reg8' based on type of 'v' into 'v'*/
+/* Store 'reg32' or its low 8/16 bits based on type of 'v' into 'v'*/
 func StoreVar(reg32, reg8 string, v *Var) {
-	var reg string
-	if v.IsChar() {
-		reg = reg8
-	} else {
-		reg = reg32
-	}
-	name := v.Name
-	if v.Offset == 0 {
+	reg := subReg(reg32, reg8, valSize(v))
+	name := asmName(v.Name)
+	if v.Offset == 0 && PIC { //用另一个寄存器保存全局变量的地址
+		addr := "edx"
+		if reg32 == "edx" {
+			addr = "ecx"
+		}
+		PicAddr(addr, name, v.Externed)
+		Emit(fmt.Sprintf("mov [%s], %s", addr, reg))
+	} else if v.Offset == 0 {
 		Emit(fmt.Sprintf("mov [%s], %s", name, reg))
 	} else {
 		Emit(fmt.Sprintf("mov [ebp%+d], %s", v.Offset, reg))
 	}
 }
 
+// 把lo和hi组成的64位值保存到v，v不是long long或double时只保存lo
+func StoreVar64(lo, hi string, v *Var) {
+	if valSize(v) != 8 {
+		StoreVar(lo, lo[1:2]+"l", v)
+		return
+	}
+	name := asmName(v.Name)
+	if v.Offset == 0 && PIC {
+		addr := "ecx"
+		for _, r := range []string{"ecx", "ebx", "esi"} {
+			if r != lo && r != hi {
+				addr = r
+				break
+			}
+		}
+		PicAddr(addr, name, v.Externed)
+		Emit(fmt.Sprintf("mov [%s], %s", addr, lo))
+		Emit(fmt.Sprintf("mov [%s+4], %s", addr, hi))
+	} else if v.Offset == 0 {
+		Emit(fmt.Sprintf("mov [%s], %s", name, lo))
+		Emit(fmt.Sprintf("mov [%s+4], %s", name, hi))
+	} else {
+		Emit(fmt.Sprintf("mov [ebp%+d], %s", v.Offset, lo))
+		Emit(fmt.Sprintf("mov [ebp%+d], %s", v.Offset+4, hi))
+	}
+}
+
 /*
 int a;
 int *a;
@@ -84,23 +269,26 @@ char *b;
 char b[3];
 void c; ❌
 数组不允许初始化。
-int类型初值为v.intval
+int，short，long long和无符号类型初值为v.intval
+float和double初值为v.FloatVal
 char类型初值为v.charval
 char*类型初值为v.Ptrval.
 */
 func InitVar(v *Var) {
 	if v.inited {
-		var val int64
-		if v.IsChar() {
-			val = int64(v.CharVal)
-		} else {
-			val = v.IntVal
-		}
-		if v.IsBase() { //int, char
+		val := v.rawVal()
+		if v.IsBase() && TypeSize(v.Typ) == 8 { //long long, double
+			Emit(fmt.Sprintf("mov eax, %d", int32(val)))
+			Emit(fmt.Sprintf("mov edx, %d", int32(val>>32)))
+			StoreVar64("eax", "edx", v)
+			return
+		} else if v.IsBase() { //int, char, float
 			Emit(fmt.Sprintf("mov eax, %d", val))
+		} else if PIC {
+			PicAddr("eax", asmName(v.PtrVal), isExternFun(v.initData))
 		} else { //int*, char*, int arr[],
-			Emit(fmt.Sprintf("mov eax, %s", v.PtrVal)) //TODO:整数指针不考虑了???
+			Emit(fmt.Sprintf("mov eax, %s", asmName(v.PtrVal))) //TODO:整数指针不考虑了???
 		}
-		StoreVar("eax", "ax", v)
+		StoreVar("eax", "al", v)
 	}
 }
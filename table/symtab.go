@@ -1,12 +1,12 @@
 package table
 
 import (
-	"calgo/lexical"
 	"fmt"
 	jsoniter "github.com/json-iterator/go"
 	"github.com/olekukonko/tablewriter"
 	"log"
 	"os"
+	"strings"
 )
 
 type SymTable struct {
@@ -37,13 +37,7 @@ func (s *SymTable) AddVar(varr *Var) {
 	if varr == nil {
 		return
 	}
-	/* 是否重复声明或定义 */
-	for _, v := range s.Vartab[varr.Name] {
-		if varr.Name[0] != '<' && v.ScopeID() == varr.ScopeID() {
-			Error(fmt.Sprintf("同一作用域下存在同名变量: %s", v.Name))
-		}
-	}
-	s.Vartab[varr.Name] = append(s.Vartab[varr.Name], varr)
+	s.AddName(varr)
 	//是否需要产生初始化指令
 	if !varr.Externed {
 		/* 如果不是常量，生成'OP_DEC varr' */
@@ -58,7 +52,38 @@ func (s *SymTable) AddVar(varr *Var) {
 	}
 }
 
+// typedef定义的类型名和枚举常量和变量在同一个名字空间，不生成数据定义和初始化指令
+func (s *SymTable) AddName(varr *Var) {
+	/* 是否重复声明或定义 */
+	for _, v := range s.Vartab[varr.Name] {
+		if varr.Name[0] != '<' && v.ScopeID() == varr.ScopeID() {
+			Error(fmt.Sprintf("同一作用域下存在同名变量: %s", v.Name))
+		}
+	}
+	s.Vartab[varr.Name] = append(s.Vartab[varr.Name], varr)
+}
+
 func (s *SymTable) GetVar(name string) *Var {
+	rs := s.FindVar(name)
+	if rs == nil {
+		Error("变量未声明（定义）")
+	}
+	if rs.IsType {
+		Error(fmt.Sprintf("<%s>:类型名不能作为表达式", name))
+	}
+	return rs
+}
+
+// 当前作用域可见的typedef类型名，name是变量或者没有定义时返回nil
+func (s *SymTable) FindType(name string) *Var {
+	if v := s.FindVar(name); v != nil && v.IsType {
+		return v
+	}
+	return nil
+}
+
+// 查找当前作用域可见的变量，内层的优先，没有时返回nil
+func (s *SymTable) FindVar(name string) *Var {
 	list := s.Vartab[name]
 	maxl := 0
 	var rs *Var
@@ -69,12 +94,21 @@ func (s *SymTable) GetVar(name string) *Var {
 			rs = v
 		}
 	}
-	if rs == nil {
-		Error("变量未声明（定义）")
-	}
 	return rs
 }
 
+// 标识符表达式: 变量，或者作为值使用的函数名，即函数的地址
+func (s *SymTable) GetIdent(name string) *Var {
+	if v := s.FindVar(name); v != nil {
+		return s.GetVar(name) //类型名不是表达式
+	}
+	if f, ok := s.Funtab[name]; ok {
+		return NewFunVar(f)
+	}
+	Error("变量未声明（定义）")
+	return nil
+}
+
 func (s *SymTable) AddStr(varr *Var) {
 	name := varr.Name
 	if _, ok := s.Strtab[name]; !ok {
@@ -250,7 +284,7 @@ func (s *SymTable) GetGlbVars() []*Var {
 			continue
 		}
 		for _, v := range vars {
-			if len(v.ScopePath) == 1 {
+			if len(v.ScopePath) == 1 && !v.IsType && !v.Literal { //类型名和枚举常量不是变量
 				res = append(res, v)
 			}
 		}
@@ -268,58 +302,126 @@ func (s *SymTable) GetGlbVars() []*Var {
  5. 如果是char且不是指针，输出db，否则输出dd
  6. 如果有初始化：如果是基本类型，输出value；如果是指针类型，输出ptrval。
  7. 没有初始化，默认值为0
+
+初值非0的变量放在.data段，没有初始化或初值为0的变量放在.bss段，字符串和浮点数常量放在.rodata段
 */
 func (s *SymTable) GenData() {
 	glbvars := s.GetGlbVars()
+	EmitAsm("section .data")
 	for _, v := range glbvars {
-		EmitAsm(fmt.Sprintf("global %s", v.Name))
-		if v.Externed { //extern声明的变量，只需要生成global声明
+		EmitAsm(fmt.Sprintf("global %s", asmName(v.Name)))
+		if v.Externed || v.IsZeroInit() { //extern声明的变量，只需要生成global声明
 			continue
 		}
-		s := ""
-		s += fmt.Sprintf("\t%s ", v.Name)
-		typsize := 4
-		if v.Typ == lexical.KW_CHAR && !v.IsPtr {
-			typsize = 1
-		}
-		if v.IsArray {
-			s += fmt.Sprintf("times %d ", v.Size/int64(typsize))
-		}
-		if typsize == 1 {
-			s += "db "
+		EmitAsm(v.GenDef())
+	}
+	EmitAsm("section .rodata")
+	for _, strvar := range s.Strtab {
+		if strvar.IsFloat() {
+			EmitAsm(fmt.Sprintf("%s dd %s", strvar.Name, strvar.dataVal()))
 		} else {
-			s += "dd "
+			EmitAsm(fmt.Sprintf("%s db %s", strvar.Name, strvar.GenRawStr()))
 		}
-		if v.inited {
-			if v.IsBase() {
-				s += fmt.Sprintf("%d", v.GetVal())
-			} else { //字符指针
-				s += v.PtrVal
-			}
-		} else {
-			s += "0"
+	}
+	EmitAsm("section .bss")
+	for _, v := range glbvars {
+		if v.Externed || !v.IsZeroInit() {
+			continue
 		}
-		EmitAsm(s)
+		EmitAsm(v.GenDef())
 	}
-	for _, strvar := range s.Strtab {
-		EmitAsm(fmt.Sprintf("%s db %s", strvar.Name, strvar.GenRawStr()))
+}
+
+// 没有初始化或初值为0
+func (v *Var) IsZeroInit() bool {
+	if !v.inited {
+		return true
+	}
+	if v.IsBase() {
+		return v.rawVal() == 0
+	}
+	return v.PtrVal == ""
+}
+
+// 基本类型的常量作为db/dw/dd的操作数，8字节的值是低32位和高32位两个dd
+func (v *Var) dataVal() string {
+	val := v.rawVal()
+	if TypeSize(v.Typ) == 8 {
+		return fmt.Sprintf("%d, %d", int32(val), int32(val>>32))
 	}
+	return fmt.Sprintf("%d", val)
+}
+
+// 生成全局变量的数据定义
+func (v *Var) GenDef() string {
+	s := ""
+	s += fmt.Sprintf("\t%s ", asmName(v.Name))
+	typsize := int64(4)
+	if !v.IsPtr && !v.PtrElem {
+		typsize = TypeSize(v.Typ)
+	}
+	width := typsize
+	if width == 8 { //long long和double用两个dd定义，低32位在前
+		width = 4
+	}
+	if v.IsArray {
+		s += fmt.Sprintf("times %d ", v.Size/width)
+	}
+	if width == 1 {
+		s += "db "
+	} else if width == 2 {
+		s += "dw "
+	} else {
+		s += "dd "
+	}
+	if v.inited {
+		if v.IsBase() {
+			s += v.dataVal()
+		} else { //字符指针
+			s += asmName(v.PtrVal)
+		}
+	} else if v.IsBase() && typsize == 8 {
+		s += "0, 0"
+	} else {
+		s += "0"
+	}
+	return s
 }
 
 func (s *SymTable) GenAsm() {
-	EmitAsm("section .data")
 	s.GenData()
 	Emit("section .text")
 	for _, f := range s.Funtab {
-		EmitAsm(fmt.Sprintf("global %s", f.Name))
+		EmitAsm(fmt.Sprintf("global %s", asmName(f.Name)))
 		if f.Externed { //没有函数定义，只需要生成global声明
 			continue
 		}
-		EmitAsm(fmt.Sprintf("%s:", f.Name))
-		for _, inst := range f.Intercode {
+		EmitAsm(fmt.Sprintf("%s:", asmName(f.Name)))
+		f.genAsm()
+	}
+}
+
+// 先生成函数体，知道了要保存哪些寄存器之后再生成入口和出口
+func (f *Fun) genAsm() {
+	var entry, exit *InterInst
+	body := &strings.Builder{}
+	asmBuf = body
+	for _, inst := range f.Intercode {
+		if inst.Label == "" && inst.Op == OP_ENTRY {
+			entry = inst
+		} else if inst.Label == "" && inst.Op == OP_EXIT {
+			exit = inst
+		} else {
 			inst.ToX86Asm()
 		}
 	}
+	asmBuf = nil
+	f.saved = savedRegs(body.String())
+	entry.ToX86Asm()
+	if body.Len() > 0 {
+		Emit(strings.TrimSuffix(body.String(), "\n"))
+	}
+	exit.ToX86Asm()
 }
 
 func EmitAsm(s string) {
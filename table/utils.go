@@ -13,15 +13,35 @@ import (
 - 其他都不兼容
 */
 func TypeCheck(p1, p2 *Var) bool {
+	if p1.FunPtr != nil || p2.FunPtr != nil { //函数指针只和指向同类型函数的函数指针兼容
+		return p1.FunPtr != nil && p2.FunPtr != nil && p1.FunPtr.SameType(p2.FunPtr)
+	}
 	if p1.IsBase() && p2.IsBase() {
 		return true
 	}
-	if !p1.IsBase() && !p2.IsBase() {
-		return p1.Typ == p2.Typ
+	if !p1.IsBase() && !p2.IsBase() { //数组按指向第一个元素的指针比较
+		return p1.Typ == p2.Typ && p1.PtrElem == p2.PtrElem && sameDims(p1.ptrDims(), p2.ptrDims())
 	}
 	return false
 }
 
+// 指针之间的转换不能丢掉所指类型的const限定，如把const char *赋给char *
+func dropsConst(to, from *Var) bool {
+	return !to.IsBase() && !from.IsBase() && from.ConstTyp && !to.ConstTyp
+}
+
+func sameDims(d1, d2 []int64) bool {
+	if len(d1) != len(d2) {
+		return false
+	}
+	for i := range d1 {
+		if d1[i] != d2[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func Warning(info string) {
 	fmt.Printf("警告:%s\n", info)
 	os.Exit(0)
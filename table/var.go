@@ -3,6 +3,7 @@ package table
 import (
 	"calgo/lexical"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 )
@@ -16,20 +17,34 @@ type Var struct {
 	IsPtr     bool
 	IsArray   bool
 	ArraySize int64
+	Dims      []int64 //数组各维的长度，Dims[0]是ArraySize；指针指向数组时是所指数组的各维长度
+	PtrElem   bool    //元素是指向Typ的指针，如char *a[3]，以及a作为值使用时的char **
+	ConstTyp  bool    //Typ带const限定，如const char *p中*p不能赋值
+	Const     bool    //变量本身不能赋值，如char *const p
+	IsType    bool    //typedef定义的类型名，不是变量，Typ、IsPtr、Dims和Const是它代表的类型
 	IsLeft    bool
 	initData  *Var
 	inited    bool /* 表示初始化表达式为常量。 */
 	IntVal    int64
 	CharVal   byte
-	StrVal    string //字符串常量值
-	PtrVal    string //字符指针值
-	Ptr       *Var   //Ptr是指针变量，指向当前变量
+	FloatVal  float64 //float和double的值，float也按float64保存
+	StrVal    string  //字符串常量值
+	PtrVal    string  //字符指针值
+	Ptr       *Var    //Ptr是指针变量，指向当前变量
+	FunPtr    *Fun    //函数指针指向的函数，只用到返回值类型和参数
 	Size      int64
 	Offset    int64
+	Jumps     *Jumps `json:"-"` //&&、||的结果是跳转形式，见GenBool
 }
 
 // 非数组、非指针
 func NewVar(sp []int, ext bool, t lexical.TokenType, ptr bool, name string, init *Var) *Var {
+	if t == lexical.KW_VA_LIST { //va_list是char *，指向下一个可变参数
+		if ptr {
+			Error("不支持va_list的指针")
+		}
+		t, ptr = lexical.KW_CHAR, true
+	}
 	v := &Var{
 		ScopePath: sp,
 		Externed:  ext,
@@ -49,14 +64,99 @@ func (v *Var) setPtr(ptr bool) {
 	}
 }
 
-func NewArrayVar(sp []int, ext bool, typ lexical.TokenType, name string, len int64) *Var {
+// 函数指针，Typ是sig的返回值类型，可以是void
+func NewFunPtrVar(sp []int, ext bool, sig *Fun, name string, init *Var) *Var {
+	v := &Var{
+		ScopePath: sp,
+		Externed:  ext,
+		IsLeft:    true,
+	}
+	v.setFunPtr(sig)
+	v.setName(name)
+	v.initData = init
+	return v
+}
+
+// 作为值使用的函数名，值是函数的地址，和字符串字面量一样是常量
+func NewFunVar(f *Fun) *Var {
+	v := &Var{
+		Literal: true,
+		Name:    f.Name,
+	}
+	v.setFunPtr(f)
+	return v
+}
+
+func (v *Var) setFunPtr(sig *Fun) {
+	v.FunPtr = sig
+	v.Typ = sig.Typ
+	v.setPtr(true)
+}
+
+// 数组，ptr表示元素是指针，dims是各维的长度，如int m[3][4]的dims是[3 4]
+func NewArrayVar(sp []int, ext bool, typ lexical.TokenType, ptr bool, name string, dims []int64) *Var {
+	if typ == lexical.KW_VA_LIST {
+		Error("不支持va_list的数组")
+	}
 	v := &Var{
 		ScopePath: sp,
 		Externed:  ext,
 		Name:      name,
 	}
 	v.setType(typ)
-	v.setArray(len)
+	v.PtrElem = ptr
+	v.setArray(dims)
+	return v
+}
+
+// 数组参数是指向第一个元素的指针，保留内层的维数用于计算下标，第一维的长度不起作用
+func NewArrayParaVar(sp []int, typ lexical.TokenType, ptr bool, name string, dims []int64) *Var {
+	return NewArrayPtrVar(sp, false, typ, ptr, name, dims[1:], nil)
+}
+
+// 指向数组的指针，如int (*p)[4]，dims是所指数组的各维长度
+func NewArrayPtrVar(sp []int, ext bool, typ lexical.TokenType, ptr bool, name string, dims []int64, init *Var) *Var {
+	if typ == lexical.KW_VA_LIST {
+		Error("不支持va_list的数组")
+	}
+	for _, d := range dims {
+		if d <= 0 {
+			Error("array len <= 0")
+		}
+	}
+	v := NewVar(sp, ext, typ, true, name, init)
+	v.PtrElem = ptr
+	v.Dims = dims
+	return v
+}
+
+// typedef定义的类型名。ptr表示指针类型，如typedef char *string；dims是数组类型的各维长度，如typedef int vec[4]
+func NewTypeVar(sp []int, typ lexical.TokenType, cst bool, ptr bool, name string, dims []int64) *Var {
+	for _, d := range dims {
+		if d <= 0 {
+			Error("array len <= 0")
+		}
+	}
+	return &Var{
+		ScopePath: sp,
+		Typ:       typ,
+		ConstTyp:  cst,
+		IsPtr:     ptr,
+		Dims:      dims,
+		Name:      name,
+		IsType:    true,
+	}
+}
+
+// 枚举常量，和整数字面量一样使用
+func NewEnumVar(sp []int, name string, val int64) *Var {
+	v := &Var{
+		ScopePath: sp,
+		Literal:   true,
+		Name:      name,
+	}
+	v.setType(lexical.KW_INT)
+	v.IntVal = ConvConst(val, lexical.KW_INT)
 	return v
 }
 
@@ -67,9 +167,13 @@ func NewLiteralVar(tk lexical.Token) *Var {
 	v.IsLeft = false
 	switch tk.TokenTyp() {
 	case lexical.NUM:
-		v.setType(lexical.KW_INT)
+		v.setType(tk.(*lexical.TNUM).Typ)
 		v.setName("<int>")
-		v.IntVal = tk.(*lexical.TNUM).Value
+		v.IntVal = ConvConst(tk.(*lexical.TNUM).Value, v.Typ)
+	case lexical.FNUM: //和字符串一样放在.rodata段，通过标签访问
+		v.setType(tk.(*lexical.TFNUM).Typ)
+		v.setName(GenLb())
+		v.FloatVal = tk.(*lexical.TFNUM).Value
 	case lexical.CHAR:
 		v.setType(lexical.KW_CHAR)
 		v.setName("<char>")
@@ -78,7 +182,7 @@ func NewLiteralVar(tk lexical.Token) *Var {
 		v.setType(lexical.KW_CHAR) //???
 		v.setName(GenLb())
 		v.StrVal = tk.(*lexical.TSTR).Value
-		v.setArray(int64(len(v.StrVal) + 1))
+		v.setArray([]int64{int64(len(v.StrVal) + 1)})
 	}
 	return v
 }
@@ -105,8 +209,14 @@ func NewTmpVar(sp []int, typ lexical.TokenType, isptr bool) *Var {
 
 func CopyVar(sp []int, v *Var) *Var {
 	tmp := &Var{ScopePath: sp}
-	tmp.setType(v.Typ)
-	tmp.setPtr(v.IsPtr || v.IsArray)
+	if v.FunPtr != nil {
+		tmp.setFunPtr(v.FunPtr)
+	} else {
+		tmp.setType(v.Typ)
+		tmp.setPtr(v.IsPtr || v.IsArray)
+		tmp.Dims, tmp.PtrElem = v.ptrDims(), v.PtrElem
+	}
+	tmp.ConstTyp = v.ConstTyp
 	tmp.setName("")
 	tmp.IsLeft = false
 	return tmp
@@ -121,19 +231,47 @@ func NewVoidVar() *Var {
 }
 
 func (v *Var) IsVoid() bool {
-	return v.Typ == lexical.KW_VOID
+	return v.Typ == lexical.KW_VOID && v.FunPtr == nil
 }
 
-func (v *Var) setArray(length int64) {
-	if length <= 0 {
-		Error("array len <= 0")
+func (v *Var) setArray(dims []int64) {
+	for _, d := range dims {
+		if d <= 0 {
+			Error("array len <= 0")
+		}
 	}
 	v.IsArray = true
 	v.IsLeft = false
-	v.ArraySize = length
+	v.ArraySize = dims[0]
+	v.Dims = dims
 	if !v.Externed {
-		v.Size = v.ArraySize * v.Size
+		v.Size = v.ArraySize * v.elemSize()
+	}
+}
+
+// 数组作为值使用时是指向第一个元素的指针，返回指针所指数组的各维长度
+func (v *Var) ptrDims() []int64 {
+	if v.IsArray {
+		return v.Dims[1:]
 	}
+	return v.Dims
+}
+
+// 指针或数组的元素的大小，元素是数组时是整个数组的大小
+func (v *Var) elemSize() int64 {
+	size := TypeSize(v.Typ)
+	if v.PtrElem {
+		size = 4
+	}
+	for _, d := range v.ptrDims() {
+		size *= d
+	}
+	return size
+}
+
+// 指针或数组的元素是基本类型，*p = v按它的类型保存
+func (v *Var) elemIsBase() bool {
+	return !v.PtrElem && len(v.ptrDims()) == 0
 }
 
 func (v *Var) setType(typ lexical.TokenType) {
@@ -142,12 +280,71 @@ func (v *Var) setType(typ lexical.TokenType) {
 		Error("变量类型不能是void")
 	}
 	if !v.Externed {
-		if v.Typ == lexical.KW_INT {
-			v.Size = 4
-		} else if typ == lexical.KW_CHAR {
-			v.Size = 1
-		}
+		v.Size = TypeSize(typ)
+	}
+}
+
+// 基本类型的大小
+func TypeSize(typ lexical.TokenType) int64 {
+	switch typ {
+	case lexical.KW_CHAR, lexical.KW_UCHAR:
+		return 1
+	case lexical.KW_SHORT, lexical.KW_USHORT:
+		return 2
+	case lexical.KW_LLONG, lexical.KW_ULLONG, lexical.KW_DOUBLE:
+		return 8
+	}
+	return 4
+}
+
+func IsFloatType(typ lexical.TokenType) bool {
+	return typ == lexical.KW_FLOAT || typ == lexical.KW_DOUBLE
+}
+
+func isLongLongType(typ lexical.TokenType) bool {
+	return typ == lexical.KW_LLONG || typ == lexical.KW_ULLONG
+}
+
+func IsUnsignedType(typ lexical.TokenType) bool {
+	switch typ {
+	case lexical.KW_UCHAR, lexical.KW_USHORT, lexical.KW_UINT, lexical.KW_ULLONG:
+		return true
 	}
+	return false
+}
+
+// typ对应的无符号类型
+func UnsignedType(typ lexical.TokenType) lexical.TokenType {
+	switch typ {
+	case lexical.KW_CHAR:
+		return lexical.KW_UCHAR
+	case lexical.KW_SHORT:
+		return lexical.KW_USHORT
+	case lexical.KW_INT:
+		return lexical.KW_UINT
+	case lexical.KW_LLONG:
+		return lexical.KW_ULLONG
+	}
+	return typ
+}
+
+// 整数常量转换为typ类型时的值: 截断到typ的大小，再按typ做符号扩展或零扩展
+func ConvConst(val int64, typ lexical.TokenType) int64 {
+	switch typ {
+	case lexical.KW_CHAR:
+		return int64(int8(val))
+	case lexical.KW_UCHAR:
+		return int64(uint8(val))
+	case lexical.KW_SHORT:
+		return int64(int16(val))
+	case lexical.KW_USHORT:
+		return int64(uint16(val))
+	case lexical.KW_INT:
+		return int64(int32(val))
+	case lexical.KW_UINT:
+		return int64(uint32(val))
+	}
+	return val
 }
 
 func (v *Var) setName(name string) {
@@ -200,6 +397,11 @@ func (v *Var) IsRef() bool {
 	return v.Ptr != nil
 }
 
+// 不能赋值的左值: char *const p这样的变量，或者类型是const的基本类型，包括通过指针访问的*p
+func (v *Var) IsConst() bool {
+	return v.Const || v.IsBase() && v.ConstTyp
+}
+
 // 变量声明的初始化的语义分析由setInit处理
 // 只有局部变量需要生成初始化指令。全局变量的初始化表达式只能是常量
 // 如果显式初始化，则使用初始化表达式的值作为初始值；否则，使用默认值
@@ -215,18 +417,30 @@ func (v *Var) SetInit() bool {
 		Error("声明不允许初始化")
 	} else if !TypeCheck(v, vinit) {
 		Error("类型不兼容")
+	} else if dropsConst(v, vinit) {
+		Error("初始化丢弃了指针所指类型的const限定")
 	} else if vinit.Literal {
 		v.inited = true
-		if vinit.IsArray { //数组字面量，只能是字符串字面量，如"abc"
+		if vinit.IsArray || vinit.FunPtr != nil { //字符串字面量，如"abc"，或者函数的地址
 			v.PtrVal = vinit.Name
-		} else { //整数，字符
-			var s int64
-			if vinit.Typ == lexical.CHAR {
-				s = int64(vinit.CharVal)
+		} else if v.IsFloat() { //浮点数
+			if vinit.IsFloat() {
+				v.FloatVal = vinit.FloatVal
+			} else if vinit.Typ == lexical.KW_ULLONG {
+				v.FloatVal = float64(uint64(vinit.GetVal()))
 			} else {
-				s = vinit.IntVal
+				v.FloatVal = float64(vinit.GetVal())
+			}
+			if v.Typ == lexical.KW_FLOAT {
+				v.FloatVal = float64(float32(v.FloatVal))
 			}
-			if v.Typ == lexical.CHAR {
+		} else { //整数，字符
+			s := vinit.GetVal()
+			if vinit.IsFloat() {
+				s = int64(vinit.FloatVal)
+			}
+			s = ConvConst(s, v.Typ)
+			if v.IsChar() {
 				v.CharVal = byte(s)
 			} else {
 				v.IntVal = s
@@ -244,9 +458,34 @@ func (v *Var) SetInit() bool {
 }
 
 func (v *Var) IsChar() bool {
-	return v.Typ == lexical.KW_CHAR
+	return v.Typ == lexical.KW_CHAR || v.Typ == lexical.KW_UCHAR
+}
+
+func (v *Var) IsUnsigned() bool {
+	return IsUnsignedType(v.Typ)
+}
+
+// 64位的long long，在寄存器中用两个32位寄存器表示
+func (v *Var) IsLongLong() bool {
+	return v.IsBase() && isLongLongType(v.Typ)
+}
+
+// float和double，在SSE寄存器中运算
+func (v *Var) IsFloat() bool {
+	return v.IsBase() && IsFloatType(v.Typ)
+}
+
+// 基本类型的常量在内存中的值，浮点数是IEEE 754编码
+func (v *Var) rawVal() int64 {
+	if v.Typ == lexical.KW_FLOAT {
+		return int64(math.Float32bits(float32(v.FloatVal)))
+	} else if v.Typ == lexical.KW_DOUBLE {
+		return int64(math.Float64bits(v.FloatVal))
+	}
+	return v.GetVal()
 }
 
+// 字符串常量在db中的写法，例如"ab",10,0。末尾总是加上结束符0，空串也是
 func (v *Var) GenRawStr() string {
 	builder := strings.Builder{}
 	chpass := false
@@ -272,19 +511,20 @@ func (v *Var) GenRawStr() string {
 		}
 	}
 	ret := builder.String()
-	if ret[len(ret)-1] == ',' { //如果最后一个字符是逗号，去掉它
-		ret = ret[:len(ret)-1]
+	if ret != "" && ret[len(ret)-1] != ',' {
+		ret += ","
 	}
-	return ret
+	return ret + "0" //字符串以0结尾
 }
 
 func (v *Var) GetVal() int64 {
-	if v.Typ == lexical.KW_CHAR {
+	if v.IsChar() {
 		return int64(v.CharVal)
 	}
 	return v.IntVal
 }
 
 var Void = NewVoidVar()
+var Zero = NewIntVar(0)
 var One = NewIntVar(1)
-var Four = NewIntVar(1)
+var Four = NewIntVar(4)